@@ -0,0 +1,329 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package admin implements the imperative management API, which lets clients such as CI pipelines
+// publish extensions to the xDS server without creating WasmExtension resources.
+package admin
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/go-logr/logr"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/validation"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	"github.com/tetratelabs/wasmxds/manifest"
+	"github.com/tetratelabs/wasmxds/wasmxds"
+)
+
+// MaxRevisions is the number of revisions kept for each extension for rollbacks
+const MaxRevisions = 10
+
+// NamespacePrefix is prepended to the namespaces of the extensions served to Envoy, e.g. admin/default/filter,
+// which keeps them apart from the WasmExtension resources and the manifest files as namespaces can't contain "/"
+const NamespacePrefix = "admin/"
+
+var (
+	// ErrNotFound is returned when the extension or the revision doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrInvalid is returned when the request is invalid
+	ErrInvalid = errors.New("invalid request")
+	// ErrUnauthenticated is returned when the request doesn't have a valid token
+	ErrUnauthenticated = errors.New("unauthenticated")
+)
+
+// Extension is a revision of an extension managed by the admin API
+type Extension struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	// Revision starts from 1 and is incremented on every apply and rollback
+	Revision int64                             `json:"revision,omitempty"`
+	Spec     wasmxdsv1alpha1.WasmExtensionSpec `json:"spec"`
+}
+
+// WasmExtension returns the WasmExtension passed to the EventHandler, whose namespace has NamespacePrefix
+func (e *Extension) WasmExtension() *wasmxdsv1alpha1.WasmExtension {
+	ext := &wasmxdsv1alpha1.WasmExtension{
+		ObjectMeta: metav1.ObjectMeta{Namespace: NamespacePrefix + e.Namespace, Name: e.Name, Generation: e.Revision},
+	}
+	e.Spec.DeepCopyInto(&ext.Spec)
+	return ext
+}
+
+// History is the revisions of an extension in the oldest first order
+type History struct {
+	Revisions []*Extension `json:"revisions"`
+}
+
+// Current returns the latest revision
+func (h *History) Current() *Extension {
+	if len(h.Revisions) == 0 {
+		return nil
+	}
+	return h.Revisions[len(h.Revisions)-1]
+}
+
+// Admin serves the admin API. The changes are persisted in the Store and relayed to the EventHandler.
+type Admin struct {
+	store     Store
+	handler   wasmxds.EventHandler
+	token     []byte
+	configDir string
+	readiness wasmxds.ReadinessTracker
+	logger    logr.Logger
+
+	// serializes the modifications so that the store and the handler are kept consistent
+	mux sync.Mutex
+}
+
+// NewAdmin returns the Admin which accepts the requests with the given bearer token
+func NewAdmin(store Store, handler wasmxds.EventHandler, token string) *Admin {
+	return &Admin{
+		store:   store,
+		handler: handler,
+		token:   []byte(token),
+		logger:  ctrl.Log.WithName("Admin"),
+	}
+}
+
+// SetConfigDir sets the directory which the configurations referenced by valueFrom are read from
+// in the same layout as manifest.ResolveConfigs reads. The configurations are read on every apply, rollback and restore,
+// and valueFrom is rejected if the directory is not set.
+func (a *Admin) SetConfigDir(dir string) {
+	a.configDir = dir
+}
+
+// SetReadinessTracker sets the tracker told the extensions restored on startup
+func (a *Admin) SetReadinessTracker(tracker wasmxds.ReadinessTracker) {
+	a.readiness = tracker
+}
+
+// Authenticate checks the value of the authorization header
+func (a *Admin) Authenticate(authorization string) error {
+	const prefix = "Bearer "
+	if len(a.token) == 0 || !strings.HasPrefix(authorization, prefix) ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(authorization, prefix)), a.token) != 1 {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// Restore relays the stored extensions to the EventHandler. This is supposed to be called on startup,
+// and the requests modifying the extensions wait until it completes.
+func (a *Admin) Restore(ctx context.Context) error {
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	histories, err := a.store.List()
+	if err != nil {
+		return fmt.Errorf("failed to list extensions: %w", err)
+	}
+	var extensions []*Extension
+	for _, h := range histories {
+		if ext := h.Current(); ext != nil {
+			extensions = append(extensions, ext)
+		}
+	}
+	if a.readiness != nil {
+		names := make([]string, 0, len(extensions))
+		for _, ext := range extensions {
+			names = append(names, ext.WasmExtension().Namespaced())
+		}
+		a.readiness.ExpectExtensions(names)
+	}
+
+	for _, ext := range extensions {
+		name := ext.WasmExtension().Namespaced()
+		// keep restoring the others, and let the client re-apply the failed one
+		if err := a.update(ctx, ext); err != nil {
+			a.logger.Error(err, "failed to restore extension", "name", name)
+		}
+		if a.readiness != nil {
+			a.readiness.Attempted(name)
+		}
+	}
+	return nil
+}
+
+// List returns the current revisions of the extensions in the namespace, or in all namespaces if empty
+func (a *Admin) List(namespace string) ([]*Extension, error) {
+	histories, err := a.store.List()
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*Extension, 0, len(histories))
+	for _, h := range histories {
+		if ext := h.Current(); ext != nil && (namespace == "" || ext.Namespace == namespace) {
+			ret = append(ret, ext)
+		}
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i].Namespace != ret[j].Namespace {
+			return ret[i].Namespace < ret[j].Namespace
+		}
+		return ret[i].Name < ret[j].Name
+	})
+	return ret, nil
+}
+
+// Get returns the history of the extension
+func (a *Admin) Get(namespace, name string) (*History, error) {
+	if err := validateName(namespace, name); err != nil {
+		return nil, err
+	}
+	return a.store.Get(namespace, name)
+}
+
+// Apply validates the extension, and creates or updates it as a new revision
+func (a *Admin) Apply(ctx context.Context, ext *Extension) (*Extension, error) {
+	if err := validateName(ext.Namespace, ext.Name); err != nil {
+		return nil, err
+	}
+
+	wx := ext.WasmExtension()
+	wx.Default()
+	if err := wx.Validate(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	for _, cv := range []*wasmxdsv1alpha1.WasmExtensionConfigValue{wx.Spec.PluginConfiguration, wx.Spec.VMConfiguration} {
+		if cv != nil && cv.ValueFrom != nil && a.configDir == "" {
+			return nil, fmt.Errorf("%w: valueFrom is not supported without the config directory", ErrInvalid)
+		}
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+	return a.push(ctx, &Extension{Namespace: ext.Namespace, Name: ext.Name, Spec: wx.Spec})
+}
+
+// Rollback applies the spec of the revision as a new revision. Rolls back to the previous revision if revision is 0.
+func (a *Admin) Rollback(ctx context.Context, namespace, name string, revision int64) (*Extension, error) {
+	if err := validateName(namespace, name); err != nil {
+		return nil, err
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	h, err := a.store.Get(namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	var target *Extension
+	if revision == 0 {
+		if len(h.Revisions) < 2 {
+			return nil, fmt.Errorf("no previous revision of %s/%s: %w", namespace, name, ErrNotFound)
+		}
+		target = h.Revisions[len(h.Revisions)-2]
+	} else {
+		for _, r := range h.Revisions {
+			if r.Revision == revision {
+				target = r
+			}
+		}
+		if target == nil {
+			return nil, fmt.Errorf("revision %d of %s/%s: %w", revision, namespace, name, ErrNotFound)
+		}
+	}
+	return a.push(ctx, &Extension{Namespace: namespace, Name: name, Spec: target.Spec})
+}
+
+// Delete stops serving the extension and removes its history
+func (a *Admin) Delete(namespace, name string) error {
+	if err := validateName(namespace, name); err != nil {
+		return err
+	}
+
+	a.mux.Lock()
+	defer a.mux.Unlock()
+
+	h, err := a.store.Get(namespace, name)
+	if err != nil {
+		return err
+	}
+	if err := a.store.Delete(namespace, name); err != nil {
+		return fmt.Errorf("failed to delete extension: %w", err)
+	}
+	a.handler.Delete(h.Current().WasmExtension())
+	return nil
+}
+
+// push appends the revision to the history and relays it to the EventHandler.
+// The history is restored if the handler fails so that the stored state matches the served one.
+func (a *Admin) push(ctx context.Context, ext *Extension) (*Extension, error) {
+	h, err := a.store.Get(ext.Namespace, ext.Name)
+	if errors.Is(err, ErrNotFound) {
+		h = &History{}
+	} else if err != nil {
+		return nil, err
+	}
+
+	previous := &History{Revisions: h.Revisions}
+	ext.Revision = 1
+	if current := h.Current(); current != nil {
+		ext.Revision = current.Revision + 1
+	}
+	revisions := append(append([]*Extension{}, h.Revisions...), ext)
+	if len(revisions) > MaxRevisions {
+		revisions = revisions[len(revisions)-MaxRevisions:]
+	}
+
+	if err := a.store.Put(&History{Revisions: revisions}); err != nil {
+		return nil, fmt.Errorf("failed to store extension: %w", err)
+	}
+	if err := a.update(ctx, ext); err != nil {
+		var rerr error
+		if len(previous.Revisions) == 0 {
+			rerr = a.store.Delete(ext.Namespace, ext.Name)
+		} else {
+			rerr = a.store.Put(previous)
+		}
+		if rerr != nil {
+			a.logger.Error(rerr, "failed to restore history", "name", ext.WasmExtension().Namespaced())
+		}
+		return nil, err
+	}
+	return ext, nil
+}
+
+func (a *Admin) update(ctx context.Context, ext *Extension) error {
+	wx := ext.WasmExtension()
+	pc, vc, err := manifest.ResolveConfigs(a.configDir, wx)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrInvalid, err)
+	}
+	_, err = a.handler.Update(ctx, wx, pc, vc)
+	return err
+}
+
+// validateName rejects the names which are not valid in Kubernetes, which also keeps the store paths safe
+func validateName(namespace, name string) error {
+	if errs := validation.IsDNS1123Label(namespace); len(errs) > 0 {
+		return fmt.Errorf("%w: namespace %q: %s", ErrInvalid, namespace, strings.Join(errs, ", "))
+	}
+	if errs := validation.IsDNS1123Subdomain(name); len(errs) > 0 {
+		return fmt.Errorf("%w: name %q: %s", ErrInvalid, name, strings.Join(errs, ", "))
+	}
+	return nil
+}
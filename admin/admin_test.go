@@ -0,0 +1,304 @@
+package admin
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/grpc/test/bufconn"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+const token = "secret"
+
+type fakeHandler struct {
+	served       map[string]*wasmxdsv1alpha1.WasmExtension
+	pluginConfig string
+	fail         bool
+}
+
+func (h *fakeHandler) Update(_ context.Context, ext *wasmxdsv1alpha1.WasmExtension, pc, _ string) (ctrl.Result, error) {
+	if h.fail {
+		return ctrl.Result{}, errors.New("failed")
+	}
+	h.served[ext.Namespaced()] = ext
+	h.pluginConfig = pc
+	return ctrl.Result{}, nil
+}
+
+type fakeTracker struct {
+	expected, attempted []string
+}
+
+func (f *fakeTracker) ExpectExtensions(names []string) {
+	f.expected = names
+}
+
+func (f *fakeTracker) Attempted(name string) {
+	f.attempted = append(f.attempted, name)
+}
+
+func (h *fakeHandler) Delete(ext *wasmxdsv1alpha1.WasmExtension) {
+	delete(h.served, ext.Namespaced())
+}
+
+func newAdmin(t *testing.T) (*Admin, *fakeHandler, func()) {
+	dir, err := ioutil.TempDir("", "admin")
+	require.NoError(t, err)
+	store, err := NewFileStore(dir)
+	require.NoError(t, err)
+	h := &fakeHandler{served: map[string]*wasmxdsv1alpha1.WasmExtension{}}
+	return NewAdmin(store, h, token), h, func() { os.RemoveAll(dir) }
+}
+
+func newExtension(uri string) *Extension {
+	return &Extension{
+		Namespace: "default",
+		Name:      "ext",
+		Spec: wasmxdsv1alpha1.WasmExtensionSpec{
+			Image: wasmxdsv1alpha1.WasmExtensionSpecImage{URI: uri},
+			VMID:  "vm", RootID: "root",
+		},
+	}
+}
+
+func TestAdmin(t *testing.T) {
+	a, h, cleanup := newAdmin(t)
+	defer cleanup()
+
+	t.Run("apply", func(t *testing.T) {
+		ext, err := a.Apply(context.Background(), newExtension("webassemblyhub.io/foo/bar:v1"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(1), ext.Revision)
+		// defaulted
+		assert.Equal(t, wasmxdsv1alpha1.ProtocolOCIImageRegistry, ext.Spec.Image.Protocol)
+		assert.Equal(t, "webassemblyhub.io/foo/bar:v1", h.served["admin/default/ext"].Spec.Image.URI)
+
+		ext, err = a.Apply(context.Background(), newExtension("webassemblyhub.io/foo/bar:v2"))
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), ext.Revision)
+		assert.Equal(t, "webassemblyhub.io/foo/bar:v2", h.served["admin/default/ext"].Spec.Image.URI)
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		ext := newExtension("webassemblyhub.io/foo/bar:v3")
+		ext.Spec.Runtime = "v9"
+		_, err := a.Apply(context.Background(), ext)
+		assert.True(t, errors.Is(err, ErrInvalid), err)
+
+		ext = newExtension("webassemblyhub.io/foo/bar:v3")
+		ext.Spec.PluginConfiguration = &wasmxdsv1alpha1.WasmExtensionConfigValue{
+			ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{
+				SecretKeyRef: &wasmxdsv1alpha1.WasmExtensionConfigValueRefAttribute{Namespace: "default", Name: "n", Key: "k"},
+			},
+		}
+		_, err = a.Apply(context.Background(), ext)
+		assert.True(t, errors.Is(err, ErrInvalid), err)
+
+		ext = newExtension("webassemblyhub.io/foo/bar:v3")
+		ext.Namespace = "../etc"
+		_, err = a.Apply(context.Background(), ext)
+		assert.True(t, errors.Is(err, ErrInvalid), err)
+	})
+
+	t.Run("handler failure", func(t *testing.T) {
+		h.fail = true
+		defer func() { h.fail = false }()
+		_, err := a.Apply(context.Background(), newExtension("webassemblyhub.io/foo/bar:broken"))
+		require.Error(t, err)
+
+		hist, err := a.Get("default", "ext")
+		require.NoError(t, err)
+		assert.Equal(t, int64(2), hist.Current().Revision)
+	})
+
+	t.Run("rollback", func(t *testing.T) {
+		ext, err := a.Rollback(context.Background(), "default", "ext", 0)
+		require.NoError(t, err)
+		assert.Equal(t, int64(3), ext.Revision)
+		assert.Equal(t, "webassemblyhub.io/foo/bar:v1", h.served["admin/default/ext"].Spec.Image.URI)
+
+		ext, err = a.Rollback(context.Background(), "default", "ext", 2)
+		require.NoError(t, err)
+		assert.Equal(t, int64(4), ext.Revision)
+		assert.Equal(t, "webassemblyhub.io/foo/bar:v2", h.served["admin/default/ext"].Spec.Image.URI)
+
+		_, err = a.Rollback(context.Background(), "default", "ext", 100)
+		assert.True(t, errors.Is(err, ErrNotFound), err)
+	})
+
+	t.Run("restore", func(t *testing.T) {
+		restored := NewAdmin(a.store, &fakeHandler{served: map[string]*wasmxdsv1alpha1.WasmExtension{}}, token)
+		tracker := &fakeTracker{}
+		restored.SetReadinessTracker(tracker)
+		require.NoError(t, restored.Restore(context.Background()))
+		assert.Equal(t, "webassemblyhub.io/foo/bar:v2",
+			restored.handler.(*fakeHandler).served["admin/default/ext"].Spec.Image.URI)
+		assert.Equal(t, []string{"admin/default/ext"}, tracker.expected)
+		assert.Equal(t, []string{"admin/default/ext"}, tracker.attempted)
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		require.NoError(t, a.Delete("default", "ext"))
+		assert.Empty(t, h.served)
+		assert.True(t, errors.Is(a.Delete("default", "ext"), ErrNotFound))
+
+		exts, err := a.List("")
+		require.NoError(t, err)
+		assert.Empty(t, exts)
+	})
+}
+
+func TestAdmin_valueFrom(t *testing.T) {
+	a, h, cleanup := newAdmin(t)
+	defer cleanup()
+	dir, err := ioutil.TempDir("", "config")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "default", "config"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "default", "config", "plugin"), []byte(`{"foo":"bar"}`), 0644))
+	a.SetConfigDir(dir)
+
+	ext := newExtension("webassemblyhub.io/foo/bar:v1")
+	ext.Spec.PluginConfiguration = &wasmxdsv1alpha1.WasmExtensionConfigValue{
+		ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{
+			ConfigMapKeyRef: &wasmxdsv1alpha1.WasmExtensionConfigValueRefAttribute{Namespace: "default", Name: "config", Key: "plugin"},
+		},
+	}
+	_, err = a.Apply(context.Background(), ext)
+	require.NoError(t, err)
+	assert.Equal(t, `{"foo":"bar"}`, h.pluginConfig)
+
+	ext.Spec.PluginConfiguration.ValueFrom.ConfigMapKeyRef.Key = "missing"
+	_, err = a.Apply(context.Background(), ext)
+	assert.True(t, errors.Is(err, ErrInvalid), err)
+	hist, err := a.Get("default", "ext")
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), hist.Current().Revision)
+}
+
+func TestAdmin_MaxRevisions(t *testing.T) {
+	a, _, cleanup := newAdmin(t)
+	defer cleanup()
+
+	for i := 0; i < MaxRevisions+5; i++ {
+		_, err := a.Apply(context.Background(), newExtension("webassemblyhub.io/foo/bar:v1"))
+		require.NoError(t, err)
+	}
+	hist, err := a.Get("default", "ext")
+	require.NoError(t, err)
+	require.Len(t, hist.Revisions, MaxRevisions)
+	assert.Equal(t, int64(6), hist.Revisions[0].Revision)
+	assert.Equal(t, int64(MaxRevisions+5), hist.Current().Revision)
+}
+
+func TestAdmin_Authenticate(t *testing.T) {
+	a := NewAdmin(nil, nil, token)
+	assert.NoError(t, a.Authenticate("Bearer secret"))
+	assert.Error(t, a.Authenticate("Bearer wrong"))
+	assert.Error(t, a.Authenticate("secret"))
+	assert.Error(t, a.Authenticate(""))
+	assert.Error(t, NewAdmin(nil, nil, "").Authenticate("Bearer "))
+}
+
+func TestAdmin_ServeHTTP(t *testing.T) {
+	a, h, cleanup := newAdmin(t)
+	defer cleanup()
+	s := httptest.NewServer(a)
+	defer s.Close()
+
+	do := func(t *testing.T, method, path, auth, body string) (int, string) {
+		req, err := http.NewRequest(method, s.URL+path, strings.NewReader(body))
+		require.NoError(t, err)
+		req.Header.Set("Authorization", auth)
+		resp, err := http.DefaultClient.Do(req)
+		require.NoError(t, err)
+		defer resp.Body.Close()
+		raw, err := ioutil.ReadAll(resp.Body)
+		require.NoError(t, err)
+		return resp.StatusCode, string(raw)
+	}
+
+	spec := `{"spec":{"image":{"uri":"webassemblyhub.io/foo/bar:v1"},"vm_id":"vm","root_id":"root"}}`
+	code, _ := do(t, http.MethodPut, "/v1alpha1/extensions/default/ext", "Bearer wrong", spec)
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	code, body := do(t, http.MethodPut, "/v1alpha1/extensions/default/ext", "Bearer "+token, spec)
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, `"revision":1`)
+	assert.Contains(t, h.served, "admin/default/ext")
+
+	code, body = do(t, http.MethodPut, "/v1alpha1/extensions/default/ext", "Bearer "+token, `{"spec":{}}`)
+	assert.Equal(t, http.StatusBadRequest, code, body)
+
+	code, body = do(t, http.MethodGet, "/v1alpha1/extensions?namespace=default", "Bearer "+token, "")
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Contains(t, body, `"name":"ext"`)
+
+	code, body = do(t, http.MethodPost, "/v1alpha1/extensions/default/ext/rollback", "Bearer "+token, "")
+	assert.Equal(t, http.StatusNotFound, code, body)
+
+	code, body = do(t, http.MethodDelete, "/v1alpha1/extensions/default/ext", "Bearer "+token, "")
+	assert.Equal(t, http.StatusOK, code, body)
+	assert.Empty(t, h.served)
+
+	code, body = do(t, http.MethodGet, "/v1alpha1/extensions/default/ext", "Bearer "+token, "")
+	assert.Equal(t, http.StatusNotFound, code, body)
+}
+
+func TestAdmin_RegisterGRPC(t *testing.T) {
+	a, h, cleanup := newAdmin(t)
+	defer cleanup()
+
+	lis := bufconn.Listen(1 << 20)
+	s := grpc.NewServer()
+	a.RegisterGRPC(s)
+	go func() { _ = s.Serve(lis) }()
+	defer s.Stop()
+
+	conn, err := grpc.Dial("bufnet", grpc.WithInsecure(),
+		grpc.WithContextDialer(func(context.Context, string) (net.Conn, error) { return lis.Dial() }))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx := context.Background()
+	_, err = NewClient(conn, "wrong").List(ctx, "")
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+
+	c := NewClient(conn, token)
+	ext, err := c.Apply(ctx, newExtension("webassemblyhub.io/foo/bar:v1"))
+	require.NoError(t, err)
+	assert.Equal(t, int64(1), ext.Revision)
+	assert.Contains(t, h.served, "admin/default/ext")
+
+	_, err = c.Apply(ctx, newExtension(""))
+	assert.Equal(t, codes.InvalidArgument, status.Code(err))
+
+	exts, err := c.List(ctx, "default")
+	require.NoError(t, err)
+	require.Len(t, exts, 1)
+
+	hist, err := c.Get(ctx, "default", "ext")
+	require.NoError(t, err)
+	assert.Len(t, hist.Revisions, 1)
+
+	_, err = c.Rollback(ctx, "default", "ext", 0)
+	assert.Equal(t, codes.NotFound, status.Code(err))
+
+	require.NoError(t, c.Delete(ctx, "default", "ext"))
+	assert.Empty(t, h.served)
+}
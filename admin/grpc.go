@@ -0,0 +1,215 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/encoding"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+// ServiceName is the name of the gRPC service. The messages are encoded in JSON
+// as WasmExtensionSpec has no protobuf definition, so clients must use the "json" content subtype,
+// i.e. the content type "application/grpc+json".
+const ServiceName = "wasmxds.admin.v1alpha1.Admin"
+
+const codecName = "json"
+
+func init() {
+	encoding.RegisterCodec(jsonCodec{})
+}
+
+type jsonCodec struct{}
+
+func (jsonCodec) Marshal(v interface{}) ([]byte, error)      { return json.Marshal(v) }
+func (jsonCodec) Unmarshal(data []byte, v interface{}) error { return json.Unmarshal(data, v) }
+func (jsonCodec) Name() string                               { return codecName }
+
+type ListRequest struct {
+	Namespace string `json:"namespace,omitempty"`
+}
+
+type ListResponse struct {
+	Extensions []*Extension `json:"extensions"`
+}
+
+type GetRequest struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+type ApplyRequest struct {
+	// Namespace and Name are given in the path of the REST API
+	Namespace string                            `json:"namespace,omitempty"`
+	Name      string                            `json:"name,omitempty"`
+	Spec      wasmxdsv1alpha1.WasmExtensionSpec `json:"spec"`
+}
+
+type DeleteRequest struct {
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+}
+
+type DeleteResponse struct{}
+
+type RollbackRequest struct {
+	// Namespace and Name are given in the path of the REST API
+	Namespace string `json:"namespace,omitempty"`
+	Name      string `json:"name,omitempty"`
+	// Revision to roll back to. Rolls back to the previous revision if omitted.
+	Revision int64 `json:"revision,omitempty"`
+}
+
+// ErrorResponse is the body of the REST API responses on errors
+type ErrorResponse struct {
+	Error string `json:"error"`
+}
+
+// RegisterGRPC registers the admin service to the gRPC server
+func (a *Admin) RegisterGRPC(s *grpc.Server) {
+	s.RegisterService(&grpc.ServiceDesc{
+		ServiceName: ServiceName,
+		HandlerType: (*interface{})(nil),
+		Methods: []grpc.MethodDesc{
+			{MethodName: "List", Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &ListRequest{}
+				return a.handleGRPC(ctx, "List", req, dec, interceptor, func(ctx context.Context) (interface{}, error) {
+					exts, err := a.List(req.Namespace)
+					return &ListResponse{Extensions: exts}, err
+				})
+			}},
+			{MethodName: "Get", Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &GetRequest{}
+				return a.handleGRPC(ctx, "Get", req, dec, interceptor, func(ctx context.Context) (interface{}, error) {
+					return a.Get(req.Namespace, req.Name)
+				})
+			}},
+			{MethodName: "Apply", Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &ApplyRequest{}
+				return a.handleGRPC(ctx, "Apply", req, dec, interceptor, func(ctx context.Context) (interface{}, error) {
+					return a.Apply(ctx, &Extension{Namespace: req.Namespace, Name: req.Name, Spec: req.Spec})
+				})
+			}},
+			{MethodName: "Delete", Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &DeleteRequest{}
+				return a.handleGRPC(ctx, "Delete", req, dec, interceptor, func(ctx context.Context) (interface{}, error) {
+					return &DeleteResponse{}, a.Delete(req.Namespace, req.Name)
+				})
+			}},
+			{MethodName: "Rollback", Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				req := &RollbackRequest{}
+				return a.handleGRPC(ctx, "Rollback", req, dec, interceptor, func(ctx context.Context) (interface{}, error) {
+					return a.Rollback(ctx, req.Namespace, req.Name, req.Revision)
+				})
+			}},
+		},
+	}, a)
+}
+
+// handleGRPC decodes the request into req, authenticates it and calls f through the interceptor if any
+func (a *Admin) handleGRPC(ctx context.Context, method string, req interface{}, dec func(interface{}) error,
+	interceptor grpc.UnaryServerInterceptor, f func(ctx context.Context) (interface{}, error)) (interface{}, error) {
+	if err := dec(req); err != nil {
+		return nil, err
+	}
+
+	handler := func(ctx context.Context, _ interface{}) (interface{}, error) {
+		var auth string
+		if md, ok := metadata.FromIncomingContext(ctx); ok && len(md.Get("authorization")) > 0 {
+			auth = md.Get("authorization")[0]
+		}
+		if err := a.Authenticate(auth); err != nil {
+			return nil, grpcError(err)
+		}
+		resp, err := f(ctx)
+		if err != nil {
+			return nil, grpcError(err)
+		}
+		return resp, nil
+	}
+	if interceptor == nil {
+		return handler(ctx, req)
+	}
+	return interceptor(ctx, req, &grpc.UnaryServerInfo{Server: a, FullMethod: "/" + ServiceName + "/" + method}, handler)
+}
+
+func grpcError(err error) error {
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		return status.Error(codes.Unauthenticated, err.Error())
+	case errors.Is(err, ErrInvalid):
+		return status.Error(codes.InvalidArgument, err.Error())
+	case errors.Is(err, ErrNotFound):
+		return status.Error(codes.NotFound, err.Error())
+	}
+	return status.Error(codes.Internal, err.Error())
+}
+
+// Client is the gRPC client of the admin API
+type Client struct {
+	conn  grpc.ClientConnInterface
+	token string
+}
+
+func NewClient(conn grpc.ClientConnInterface, token string) *Client {
+	return &Client{conn: conn, token: token}
+}
+
+func (c *Client) invoke(ctx context.Context, method string, req, resp interface{}) error {
+	ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+c.token)
+	return c.conn.Invoke(ctx, "/"+ServiceName+"/"+method, req, resp, grpc.CallContentSubtype(codecName))
+}
+
+func (c *Client) List(ctx context.Context, namespace string) ([]*Extension, error) {
+	resp := &ListResponse{}
+	err := c.invoke(ctx, "List", &ListRequest{Namespace: namespace}, resp)
+	return resp.Extensions, err
+}
+
+func (c *Client) Get(ctx context.Context, namespace, name string) (*History, error) {
+	resp := &History{}
+	if err := c.invoke(ctx, "Get", &GetRequest{Namespace: namespace, Name: name}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) Apply(ctx context.Context, ext *Extension) (*Extension, error) {
+	resp := &Extension{}
+	if err := c.invoke(ctx, "Apply", &ApplyRequest{Namespace: ext.Namespace, Name: ext.Name, Spec: ext.Spec}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *Client) Delete(ctx context.Context, namespace, name string) error {
+	return c.invoke(ctx, "Delete", &DeleteRequest{Namespace: namespace, Name: name}, &DeleteResponse{})
+}
+
+func (c *Client) Rollback(ctx context.Context, namespace, name string, revision int64) (*Extension, error) {
+	resp := &Extension{}
+	if err := c.invoke(ctx, "Rollback", &RollbackRequest{Namespace: namespace, Name: name, Revision: revision}, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
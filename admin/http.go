@@ -0,0 +1,127 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// PathPrefix is the prefix of the REST API paths:
+//
+//	GET    /v1alpha1/extensions[?namespace=<namespace>]          List
+//	GET    /v1alpha1/extensions/<namespace>/<name>               Get
+//	PUT    /v1alpha1/extensions/<namespace>/<name>               Apply with {"spec": {...}}
+//	DELETE /v1alpha1/extensions/<namespace>/<name>               Delete
+//	POST   /v1alpha1/extensions/<namespace>/<name>/rollback      Rollback with {"revision": <revision>}
+const PathPrefix = "/v1alpha1/extensions"
+
+// maxRequestBodySize limits the size of request bodies as configurations are embedded in them
+const maxRequestBodySize = 4 << 20
+
+// ServeHTTP implements http.Handler for the REST API
+func (a *Admin) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := a.Authenticate(r.Header.Get("Authorization")); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if r.URL.Path == PathPrefix || r.URL.Path == PathPrefix+"/" {
+		if r.Method != http.MethodGet {
+			writeError(w, errMethodNotAllowed)
+			return
+		}
+		exts, err := a.List(r.URL.Query().Get("namespace"))
+		writeResponse(w, &ListResponse{Extensions: exts}, err)
+		return
+	}
+
+	parts := strings.Split(strings.TrimPrefix(r.URL.Path, PathPrefix+"/"), "/")
+	switch {
+	case len(parts) == 2:
+		namespace, name := parts[0], parts[1]
+		switch r.Method {
+		case http.MethodGet:
+			h, err := a.Get(namespace, name)
+			writeResponse(w, h, err)
+		case http.MethodPut:
+			var req ApplyRequest
+			if err := readRequest(r, &req); err != nil {
+				writeError(w, err)
+				return
+			}
+			ext, err := a.Apply(r.Context(), &Extension{Namespace: namespace, Name: name, Spec: req.Spec})
+			writeResponse(w, ext, err)
+		case http.MethodDelete:
+			writeResponse(w, &DeleteResponse{}, a.Delete(namespace, name))
+		default:
+			writeError(w, errMethodNotAllowed)
+		}
+	case len(parts) == 3 && parts[2] == "rollback":
+		if r.Method != http.MethodPost {
+			writeError(w, errMethodNotAllowed)
+			return
+		}
+		var req RollbackRequest
+		if err := readRequest(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+		ext, err := a.Rollback(r.Context(), parts[0], parts[1], req.Revision)
+		writeResponse(w, ext, err)
+	default:
+		writeError(w, fmt.Errorf("path %s: %w", r.URL.Path, ErrNotFound))
+	}
+}
+
+var errMethodNotAllowed = errors.New("method not allowed")
+
+func readRequest(r *http.Request, v interface{}) error {
+	// an empty body is allowed for e.g. rolling back to the previous revision
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestBodySize)).Decode(v); err != nil && err != io.EOF {
+		return fmt.Errorf("%w: failed to decode body: %v", ErrInvalid, err)
+	}
+	return nil
+}
+
+func writeResponse(w http.ResponseWriter, v interface{}, err error) {
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	code := http.StatusInternalServerError
+	switch {
+	case errors.Is(err, ErrUnauthenticated):
+		code = http.StatusUnauthorized
+	case errors.Is(err, ErrInvalid):
+		code = http.StatusBadRequest
+	case errors.Is(err, ErrNotFound):
+		code = http.StatusNotFound
+	case errors.Is(err, errMethodNotAllowed):
+		code = http.StatusMethodNotAllowed
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(&ErrorResponse{Error: err.Error()})
+}
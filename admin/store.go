@@ -0,0 +1,133 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// Store persists the histories of the extensions managed by the admin API
+type Store interface {
+	// List returns all the histories
+	List() ([]*History, error)
+	// Get returns the history of the extension, or an error wrapping ErrNotFound if not exists
+	Get(namespace, name string) (*History, error)
+	// Put creates or replaces the history
+	Put(history *History) error
+	// Delete removes the history. Deleting a non-existent history is not an error.
+	Delete(namespace, name string) error
+}
+
+// FileStore is the Store which keeps each history in "<dir>/<namespace>/<name>.json"
+type FileStore struct {
+	dir string
+}
+
+var _ Store = &FileStore{}
+
+func NewFileStore(dir string) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %w", dir, err)
+	}
+	return &FileStore{dir: dir}, nil
+}
+
+func (s *FileStore) path(namespace, name string) string {
+	return filepath.Join(s.dir, namespace, name+".json")
+}
+
+func (s *FileStore) List() ([]*History, error) {
+	var ret []*History
+	err := filepath.Walk(s.dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() || filepath.Ext(path) != ".json" {
+			return nil
+		}
+		h, err := s.read(path)
+		if err != nil {
+			return err
+		}
+		ret = append(ret, h)
+		return nil
+	})
+	return ret, err
+}
+
+func (s *FileStore) Get(namespace, name string) (*History, error) {
+	h, err := s.read(s.path(namespace, name))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("extension %s/%s: %w", namespace, name, ErrNotFound)
+	}
+	return h, err
+}
+
+func (s *FileStore) read(path string) (*History, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var h History
+	if err := json.Unmarshal(raw, &h); err != nil {
+		return nil, fmt.Errorf("failed to decode %s: %w", path, err)
+	}
+	return &h, nil
+}
+
+func (s *FileStore) Put(h *History) error {
+	current := h.Current()
+	if current == nil {
+		return fmt.Errorf("empty history")
+	}
+	raw, err := json.Marshal(h)
+	if err != nil {
+		return err
+	}
+
+	path := s.path(current.Namespace, current.Name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	// write to a temporary file and rename it so that a crash doesn't leave a partially written history
+	tmp, err := ioutil.TempFile(filepath.Dir(path), "."+filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(raw); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *FileStore) Delete(namespace, name string) error {
+	err := os.Remove(s.path(namespace, name))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	// clean up the namespace directory, which fails if it's not empty
+	_ = os.Remove(filepath.Join(s.dir, namespace))
+	return nil
+}
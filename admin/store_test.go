@@ -0,0 +1,54 @@
+package admin
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "store")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	s, err := NewFileStore(dir)
+	require.NoError(t, err)
+
+	_, err = s.Get("default", "ext")
+	assert.True(t, errors.Is(err, ErrNotFound), err)
+	require.Error(t, s.Put(&History{}))
+
+	for _, ext := range []*Extension{
+		{Namespace: "default", Name: "ext", Revision: 1},
+		{Namespace: "default", Name: "ext", Revision: 2},
+		{Namespace: "foo", Name: "ext", Revision: 1},
+	} {
+		h, err := s.Get(ext.Namespace, ext.Name)
+		if err != nil {
+			h = &History{}
+		}
+		h.Revisions = append(h.Revisions, ext)
+		require.NoError(t, s.Put(h))
+	}
+
+	h, err := s.Get("default", "ext")
+	require.NoError(t, err)
+	require.Len(t, h.Revisions, 2)
+	assert.Equal(t, int64(2), h.Current().Revision)
+
+	hs, err := s.List()
+	require.NoError(t, err)
+	assert.Len(t, hs, 2)
+
+	require.NoError(t, s.Delete("foo", "ext"))
+	require.NoError(t, s.Delete("foo", "ext"))
+	hs, err = s.List()
+	require.NoError(t, err)
+	assert.Len(t, hs, 1)
+	_, err = os.Stat(dir + "/foo")
+	assert.True(t, os.IsNotExist(err))
+}
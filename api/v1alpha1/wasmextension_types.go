@@ -16,30 +16,170 @@ package v1alpha1
 
 import (
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/containerd/containerd/reference"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 // WasmExtensionSpec defines the desired state of WasmExtension
 type WasmExtensionSpec struct {
-	Image               WasmExtensionSpecImage    `json:"image"`
-	VMID                string                    `json:"vm_id"`
-	RootID              string                    `json:"root_id"`
-	VMConfiguration     *WasmExtensionConfigValue `json:"vm_configuration,omitempty"`
+	// Image is where the Wasm binary is fetched from, and must be empty for the null runtime
+	// +optional
+	Image WasmExtensionSpecImage `json:"image"`
+	// VMID defaults to the one in the image metadata, and is required for the null runtime
+	// +optional
+	VMID string `json:"vm_id"`
+	// RootID defaults to the one in the image metadata, and is required for the null runtime
+	// +optional
+	RootID          string                    `json:"root_id"`
+	VMConfiguration *WasmExtensionConfigValue `json:"vm_configuration,omitempty"`
+	// PluginConfiguration is merged into the base configuration in the image metadata if both are JSON objects,
+	// and replaces it otherwise
 	PluginConfiguration *WasmExtensionConfigValue `json:"plugin_configuration,omitempty"`
-	Runtime             string                    `json:"runtime"`
+	// Runtime defaults to the one in the image metadata, or v8
+	// +optional
+	Runtime string `json:"runtime,omitempty"`
+
+	// BuiltinPlugin is the name of the plugin compiled into Envoy, and is required for the null runtime
+	// +optional
+	BuiltinPlugin string `json:"builtin_plugin,omitempty"`
+	// PluginName is the name of the plugin which is independent of vm_id, and is used in Envoy's logs and stats
+	// +optional
+	PluginName string `json:"plugin_name,omitempty"`
+	// FailOpen lets requests pass through instead of being rejected when the plugin fails
+	// +optional
+	FailOpen bool `json:"fail_open,omitempty"`
+	// AllowPrecompiled allows Envoy to use the precompiled code embedded in the binary. Defaults to true.
+	// +optional
+	AllowPrecompiled *bool `json:"allow_precompiled,omitempty"`
+	// NackOnCodeCacheMiss makes Envoy reject the configuration instead of fetching the code asynchronously
+	// +optional
+	NackOnCodeCacheMiss bool `json:"nack_on_code_cache_miss,omitempty"`
+	// +optional
+	EnvironmentVariables *WasmExtensionEnvironmentVariables `json:"environment_variables,omitempty"`
+	// +optional
+	CapabilityRestrictionConfig *WasmExtensionCapabilityRestrictionConfig `json:"capability_restriction_config,omitempty"`
+}
+
+// WasmExtensionEnvironmentVariables are exposed to the VM via WASI
+type WasmExtensionEnvironmentVariables struct {
+	// HostEnvKeys are the names of Envoy's environment variables passed to the VM
+	// +optional
+	HostEnvKeys []string `json:"host_env_keys,omitempty"`
+	// KeyValues are the explicitly given environment variables
+	// +optional
+	KeyValues map[string]string `json:"key_values,omitempty"`
+}
+
+type WasmExtensionCapabilityRestrictionConfig struct {
+	// AllowedCapabilities is the list of ABI functions which the plugin is allowed to call.
+	// All capabilities are allowed when this is empty.
+	// +optional
+	AllowedCapabilities []string `json:"allowed_capabilities,omitempty"`
 }
 
 type WasmExtensionSpecImage struct {
-	URI      string  `json:"uri"`
-	Protocol string  `json:"protocol"`
+	// URI can pin the manifest digest of OCI images as "<repository>@sha256:<digest>"
+	URI string `json:"uri"`
+	// +optional
+	Protocol string  `json:"protocol,omitempty"`
 	Sha256   *string `json:"sha256,omitempty"`
+	// Signature is the base64 encoded ed25519 signature of the Wasm binary,
+	// verified with the signatureKeys of the WasmExtensionPolicies applying to the extension
+	// +optional
+	Signature *string `json:"signature,omitempty"`
+	// DigestPolicy is either "Follow" (default), which follows the tag moves of OCI images,
+	// or "Lock", which pins the manifest digest first resolved until the policy is changed or the uri is updated
+	// +kubebuilder:validation:Enum=Follow;Lock
+	// +optional
+	DigestPolicy string `json:"digestPolicy,omitempty"`
+	// VersionConstraint is the semantic version range of OCI images, e.g. "~1.4" or ">= 1.2, < 2.0",
+	// for which the highest satisfying tag of the repository in uri is served and periodically checked again.
+	// The uri must not have a tag or digest then. The range can also be given as the tag of uri, e.g. "ghcr.io/foo/bar:~1.4".
+	// +optional
+	VersionConstraint string `json:"versionConstraint,omitempty"`
+	// Sources are the fallbacks tried in order when fetching from uri fails or times out, e.g. the mirrors
+	// in other registries or S3 regions. sha256 is required with sources as they all must serve the same binary.
+	// +optional
+	Sources []WasmExtensionImageSource `json:"sources,omitempty"`
+	// Retry is the backoff between the retries of the transient failures such as network errors.
+	// The missing images and the rejected credentials are retried with a backoff of at least 1m up to 30m,
+	// and the permanent failures such as a wrong sha256 are not retried until the extension changes.
+	// +optional
+	Retry *WasmExtensionImageRetry `json:"retry,omitempty"`
+	// FetchTimeout is how long fetching the image from one source can take before falling back to the next,
+	// e.g. for large binaries. Defaults to the -fetch-timeout of the server
+	// +optional
+	FetchTimeout *metav1.Duration `json:"fetchTimeout,omitempty"`
+}
+
+// WasmExtensionImageRetry is the exponential backoff, which doubles the interval on every failed attempt
+type WasmExtensionImageRetry struct {
+	// InitialInterval is the interval before the first retry. Defaults to 1s
+	// +optional
+	InitialInterval *metav1.Duration `json:"initialInterval,omitempty"`
+	// MaxInterval caps the interval. Defaults to 5m
+	// +optional
+	MaxInterval *metav1.Duration `json:"maxInterval,omitempty"`
+	// MaxAttempts is the number of the attempts after which the failure is regarded as permanent. Unlimited by default
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxAttempts *int32 `json:"maxAttempts,omitempty"`
+}
+
+// default backoff of the retries
+const (
+	DefaultRetryInitialInterval = time.Second
+	DefaultRetryMaxInterval     = 5 * time.Minute
+)
+
+// Backoff returns the interval before the next attempt after the given number of failed attempts,
+// and false if the attempts are exhausted. The defaults apply to the nil retry.
+func (in *WasmExtensionImageRetry) Backoff(failures int) (time.Duration, bool) {
+	initial, max := DefaultRetryInitialInterval, DefaultRetryMaxInterval
+	if in != nil {
+		if in.MaxAttempts != nil && failures >= int(*in.MaxAttempts) {
+			return 0, false
+		}
+		if in.InitialInterval != nil {
+			initial = in.InitialInterval.Duration
+		}
+		if in.MaxInterval != nil {
+			max = in.MaxInterval.Duration
+		}
+	}
+
+	interval := initial
+	for i := 1; i < failures && interval < max; i++ {
+		interval *= 2
+	}
+	if interval > max {
+		interval = max
+	}
+	return interval, true
+}
+
+type WasmExtensionImageSource struct {
+	URI string `json:"uri"`
+	// +optional
+	Protocol string `json:"protocol,omitempty"`
 }
 
 type WasmExtensionConfigValue struct {
-	Value     *string                      `json:"value,omitempty"`
+	Value *string `json:"value,omitempty"`
+	// Object is the configuration given as a native YAML/JSON object, and is passed as JSON
+	Object    *apiextensionsv1.JSON        `json:"object,omitempty"`
 	ValueFrom *WasmExtensionConfigValueRef `json:"valueFrom,omitempty"`
+	// Encoding specifies how the configuration is encoded in Envoy's configuration field:
+	// "string" for google.protobuf.StringValue (default), "struct" for google.protobuf.Struct
+	// which requires the configuration to be a JSON object, and "bytes" for google.protobuf.BytesValue.
+	// +kubebuilder:validation:Enum=string;struct;bytes
+	// +optional
+	Encoding string `json:"encoding,omitempty"`
 }
 
 type WasmExtensionConfigValueRef struct {
@@ -54,10 +194,86 @@ type WasmExtensionConfigValueRefAttribute struct {
 }
 
 // WasmExtensionStatus defines the observed state of WasmExtension
-type WasmExtensionStatus struct{}
+type WasmExtensionStatus struct {
+	// ObservedGeneration is the generation of the spec most recently reconciled
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Sha256 is the sha256 value of the Wasm binary currently served
+	Sha256 string `json:"sha256,omitempty"`
+	// PluginConfigurationVersion is the resource version of the ConfigMap or Secret
+	// which the plugin configuration was last resolved from
+	PluginConfigurationVersion string `json:"pluginConfigurationVersion,omitempty"`
+	// VMConfigurationVersion is the resource version of the ConfigMap or Secret
+	// which the vm configuration was last resolved from
+	VMConfigurationVersion string `json:"vmConfigurationVersion,omitempty"`
+	// ImageDigest is the manifest digest of the OCI image currently served
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// LockedImage is the digest pinned by the Lock digest policy
+	LockedImage *WasmExtensionLockedImage `json:"lockedImage,omitempty"`
+	// ResolvedTag is the tag currently served which the version constraint was resolved to
+	ResolvedTag string `json:"resolvedTag,omitempty"`
+	// ServedSource is where the binary currently served was fetched from,
+	// which is either uri, one of the sources or the registry mirror of them
+	ServedSource *WasmExtensionImageSource `json:"servedSource,omitempty"`
+	// Effective is the values currently served after the defaults in the image metadata are applied
+	Effective  *WasmExtensionEffectiveValues `json:"effective,omitempty"`
+	Conditions []WasmExtensionCondition      `json:"conditions,omitempty"`
+}
+
+type WasmExtensionLockedImage struct {
+	// URI is spec.image.uri when the digest was locked. The lock is released when the uri changes.
+	URI    string `json:"uri"`
+	Digest string `json:"digest"`
+}
+
+type WasmExtensionEffectiveValues struct {
+	VMID    string `json:"vmID,omitempty"`
+	RootID  string `json:"rootID,omitempty"`
+	Runtime string `json:"runtime,omitempty"`
+	// PluginConfigurationSource is where the plugin configuration came from: "spec", "image" or "merged"
+	PluginConfigurationSource string `json:"pluginConfigurationSource,omitempty"`
+}
+
+// sources of the effective plugin configuration
+const (
+	PluginConfigurationSourceSpec   = "spec"
+	PluginConfigurationSourceImage  = "image"
+	PluginConfigurationSourceMerged = "merged"
+)
+
+// ImageMetadata is published along with the image by its publisher, and provides the defaults of the spec
+type ImageMetadata struct {
+	VMID    string `json:"vm_id,omitempty"`
+	RootID  string `json:"root_id,omitempty"`
+	Runtime string `json:"runtime,omitempty"`
+	// PluginConfiguration is the base configuration which the one in the spec is merged into
+	PluginConfiguration string `json:"plugin_configuration,omitempty"`
+}
+
+type WasmExtensionConditionType string
+
+const (
+	// ConditionReady indicates that the extension has been served to Envoy via ECDS
+	ConditionReady WasmExtensionConditionType = "Ready"
+	// ConditionStalled indicates that the update has failed permanently, e.g. because of a wrong sha256,
+	// and is not retried until the extension or its configurations change
+	ConditionStalled WasmExtensionConditionType = "Stalled"
+)
+
+type WasmExtensionCondition struct {
+	Type               WasmExtensionConditionType `json:"type"`
+	Status             corev1.ConditionStatus     `json:"status"`
+	LastTransitionTime metav1.Time                `json:"lastTransitionTime,omitempty"`
+	Reason             string                     `json:"reason,omitempty"`
+	Message            string                     `json:"message,omitempty"`
+}
 
 // +kubebuilder:object:root=true
 // +kubebuilder:subresource:status
+// +kubebuilder:storageversion
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Sha256",type=string,JSONPath=`.status.sha256`,priority=1
+// +kubebuilder:printcolumn:name="Digest",type=string,JSONPath=`.status.imageDigest`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
 
 // WasmExtension is the Schema for the wasmextensions API
 type WasmExtension struct {
@@ -98,10 +314,66 @@ func (in *WasmExtensionSpecImage) ProviderKey() (string, error) {
 	case ProtocolLocalFileSystem, ProtocolS3, ProtocolHttp, ProtocolHttps:
 		return protocol, nil
 	default:
-		return "", fmt.Errorf("unsupported protoco: %s", protocol)
+		return "", fmt.Errorf("unsupported protocol: %s", protocol)
+	}
+}
+
+// ApplyImageMetadata fills in the fields not set in the spec with the image metadata.
+// The plugin configuration is resolved separately as it may come from ConfigMaps or Secrets.
+func (in *WasmExtensionSpec) ApplyImageMetadata(m *ImageMetadata) {
+	if m == nil {
+		return
+	}
+	if in.VMID == "" {
+		in.VMID = m.VMID
+	}
+	if in.RootID == "" {
+		in.RootID = m.RootID
+	}
+	if in.Runtime == "" {
+		in.Runtime = strings.ToLower(m.Runtime)
 	}
 }
 
+// PinDigest returns the copy of the OCI image with the uri pointing to the manifest of the digest instead of the tag
+func (in *WasmExtensionSpecImage) PinDigest(digest string) (*WasmExtensionSpecImage, error) {
+	ref, err := reference.Parse(in.URI)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse URI as OCI ref %s: %w", in.URI, err)
+	}
+	ret := *in
+	ret.URI = ref.Locator + "@" + digest
+	return &ret, nil
+}
+
+// VersionRange returns the repository and the semantic version constraint given either in versionConstraint
+// or as the tag of the uri, and false if the image is not an OCI image referred to by a version range
+func (in *WasmExtensionSpecImage) VersionRange() (repository, constraint string, ok bool) {
+	if in.Protocol != ProtocolOCIImageRegistry && in.Protocol != "" {
+		return "", "", false
+	}
+	ref, err := reference.Parse(in.URI)
+	if err != nil {
+		return "", "", false
+	}
+	if in.VersionConstraint != "" {
+		return ref.Locator, in.VersionConstraint, true
+	}
+	// the constraints such as "~1.4" and "^1.4" are distinguished from the tags by the characters not allowed in tags
+	if ref.Object == "" || strings.Contains(ref.Object, "@") || tagRegexp.MatchString(ref.Object) {
+		return "", "", false
+	}
+	return ref.Locator, ref.Object, true
+}
+
+// WithTag returns the copy of the OCI image with the uri pointing to the tag of the repository
+func (in *WasmExtensionSpecImage) WithTag(repository, tag string) *WasmExtensionSpecImage {
+	ret := *in
+	ret.URI = repository + ":" + tag
+	ret.VersionConstraint = ""
+	return &ret
+}
+
 func (in *WasmExtensionSpecImage) ID() string {
 	return fmt.Sprintf("%s://%s", in.Protocol, in.URI)
 }
@@ -110,6 +382,40 @@ func (in *WasmExtension) Namespaced() string {
 	return fmt.Sprintf("%s/%s", in.Namespace, in.Name)
 }
 
+// GetCondition returns the condition of the given type if exists, otherwise nil
+func (in *WasmExtensionStatus) GetCondition(t WasmExtensionConditionType) *WasmExtensionCondition {
+	for i := range in.Conditions {
+		if in.Conditions[i].Type == t {
+			return &in.Conditions[i]
+		}
+	}
+	return nil
+}
+
+// SetCondition adds or updates the condition of the given type.
+// LastTransitionTime is only updated when the status changes.
+func (in *WasmExtensionStatus) SetCondition(t WasmExtensionConditionType,
+	status corev1.ConditionStatus, reason, message string) {
+	c := in.GetCondition(t)
+	if c == nil {
+		in.Conditions = append(in.Conditions, WasmExtensionCondition{Type: t})
+		c = &in.Conditions[len(in.Conditions)-1]
+	}
+	if c.Status != status {
+		c.Status = status
+		c.LastTransitionTime = metav1.Now()
+	}
+	c.Reason = reason
+	c.Message = message
+}
+
+const (
+	DigestPolicyFollow = "Follow"
+	DigestPolicyLock   = "Lock"
+)
+
+var SupportedDigestPolicies = []string{DigestPolicyFollow, DigestPolicyLock}
+
 const (
 	ProtocolOCIImageRegistry = "oci"
 	ProtocolLocalFileSystem  = "local_fs"
@@ -118,3 +424,25 @@ const (
 	ProtocolHttps            = "https"
 	// TODO: add more protocol: e.g. gcs, ...
 )
+
+var SupportedProtocols = []string{
+	ProtocolOCIImageRegistry, ProtocolLocalFileSystem, ProtocolS3, ProtocolHttp, ProtocolHttps,
+}
+
+const (
+	RuntimeV8       = "v8"
+	RuntimeWAVM     = "wavm"
+	RuntimeWasmtime = "wasmtime"
+	// RuntimeNull is for the plugins compiled into Envoy, which are specified by builtin_plugin
+	RuntimeNull = "null"
+)
+
+var SupportedRuntimes = []string{RuntimeV8, RuntimeWAVM, RuntimeWasmtime, RuntimeNull}
+
+const (
+	ConfigEncodingString = "string"
+	ConfigEncodingStruct = "struct"
+	ConfigEncodingBytes  = "bytes"
+)
+
+var SupportedConfigEncodings = []string{ConfigEncodingString, ConfigEncodingStruct, ConfigEncodingBytes}
@@ -0,0 +1,443 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/containerd/containerd/reference"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+)
+
+var webhookLog = logf.Log.WithName("webhook").WithName("WasmExtension")
+
+func (in *WasmExtension) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(in).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-wasmxds-tetrate-io-v1alpha1-wasmextension,mutating=true,failurePolicy=fail,groups=wasmxds.tetrate.io,resources=wasmextensions,verbs=create;update,versions=v1alpha1,name=mwasmextension.wasmxds.tetrate.io
+
+var _ webhook.Defaulter = &WasmExtension{}
+
+// Default implements webhook.Defaulter
+func (in *WasmExtension) Default() {
+	webhookLog.Info("default", "name", in.Namespaced())
+	in.Spec.Default()
+}
+
+// Default fills in the optional fields of the spec
+func (in *WasmExtensionSpec) Default() {
+	// the runtime is left empty so that the one in the image metadata can apply
+	in.Runtime = strings.ToLower(in.Runtime)
+	if in.Image.Protocol == "" && in.Runtime != RuntimeNull {
+		in.Image.Protocol = ProtocolOCIImageRegistry
+	}
+	for i := range in.Image.Sources {
+		if in.Image.Sources[i].Protocol == "" {
+			in.Image.Sources[i].Protocol = ProtocolOCIImageRegistry
+		}
+	}
+	if in.Image.Sha256 != nil {
+		sha := strings.ToLower(*in.Image.Sha256)
+		in.Image.Sha256 = &sha
+	}
+}
+
+// +kubebuilder:webhook:path=/validate-wasmxds-tetrate-io-v1alpha1-wasmextension,mutating=false,failurePolicy=fail,groups=wasmxds.tetrate.io,resources=wasmextensions,verbs=create;update,versions=v1alpha1,name=vwasmextension.wasmxds.tetrate.io
+
+var _ webhook.Validator = &WasmExtension{}
+
+// ValidateCreate implements webhook.Validator
+func (in *WasmExtension) ValidateCreate() error {
+	webhookLog.Info("validate create", "name", in.Namespaced())
+	return in.Validate()
+}
+
+// ValidateUpdate implements webhook.Validator
+func (in *WasmExtension) ValidateUpdate(_ runtime.Object) error {
+	webhookLog.Info("validate update", "name", in.Namespaced())
+	return in.Validate()
+}
+
+// ValidateDelete implements webhook.Validator
+func (in *WasmExtension) ValidateDelete() error {
+	return nil
+}
+
+// Validate returns an Invalid error which aggregates all the violations found in the spec
+func (in *WasmExtension) Validate() error {
+	errs := in.Spec.Validate(field.NewPath("spec"))
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("WasmExtension").GroupKind(), in.Name, errs)
+}
+
+var (
+	sha256Regexp   = regexp.MustCompile(`^[a-f0-9]{64}$`)
+	s3BucketRegexp = regexp.MustCompile(`^[a-z0-9][a-z0-9.-]{1,61}[a-z0-9]$`)
+	tagRegexp      = regexp.MustCompile(`^[\w][\w.-]{0,127}$`)
+)
+
+// Validate checks the spec assuming that it has been defaulted
+func (in *WasmExtensionSpec) Validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if in.Runtime != "" && !containsString(SupportedRuntimes, strings.ToLower(in.Runtime)) {
+		errs = append(errs, field.NotSupported(path.Child("runtime"), in.Runtime, SupportedRuntimes))
+	}
+
+	if strings.ToLower(in.Runtime) == RuntimeNull {
+		// vm_id and root_id can be omitted otherwise as the image metadata may provide them
+		if in.VMID == "" {
+			errs = append(errs, field.Required(path.Child("vm_id"), "required for the null runtime"))
+		}
+		if in.RootID == "" {
+			errs = append(errs, field.Required(path.Child("root_id"), "required for the null runtime"))
+		}
+		if in.BuiltinPlugin == "" {
+			errs = append(errs, field.Required(path.Child("builtin_plugin"), "required for the null runtime"))
+		}
+		if !reflect.DeepEqual(in.Image, WasmExtensionSpecImage{}) {
+			errs = append(errs, field.Forbidden(path.Child("image"), "must be empty for the null runtime"))
+		}
+	} else {
+		if in.BuiltinPlugin != "" {
+			errs = append(errs, field.Forbidden(path.Child("builtin_plugin"), "only allowed for the null runtime"))
+		}
+		errs = append(errs, in.Image.Validate(path.Child("image"))...)
+	}
+
+	if in.EnvironmentVariables != nil {
+		errs = append(errs, in.EnvironmentVariables.Validate(path.Child("environment_variables"))...)
+	}
+	if in.VMConfiguration != nil {
+		errs = append(errs, in.VMConfiguration.Validate(path.Child("vm_configuration"))...)
+	}
+	if in.PluginConfiguration != nil {
+		errs = append(errs, in.PluginConfiguration.Validate(path.Child("plugin_configuration"))...)
+	}
+	return errs
+}
+
+func (in *WasmExtensionEnvironmentVariables) Validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	for i, key := range in.HostEnvKeys {
+		if key == "" {
+			errs = append(errs, field.Required(path.Child("host_env_keys").Index(i), ""))
+		} else if _, ok := in.KeyValues[key]; ok {
+			errs = append(errs, field.Duplicate(path.Child("key_values").Key(key), key))
+		}
+	}
+	return errs
+}
+
+func (in *WasmExtensionSpecImage) Validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if in.Sha256 != nil {
+		if err := ValidateSha256(*in.Sha256); err != nil {
+			errs = append(errs, field.Invalid(path.Child("sha256"), *in.Sha256, err.Error()))
+		}
+	}
+	if in.Signature != nil {
+		if err := ValidateSignature(*in.Signature); err != nil {
+			errs = append(errs, field.Invalid(path.Child("signature"), *in.Signature, err.Error()))
+		}
+	}
+
+	if in.DigestPolicy != "" {
+		if !containsString(SupportedDigestPolicies, in.DigestPolicy) {
+			errs = append(errs, field.NotSupported(path.Child("digestPolicy"), in.DigestPolicy, SupportedDigestPolicies))
+		} else if in.Protocol != ProtocolOCIImageRegistry {
+			errs = append(errs, field.Forbidden(path.Child("digestPolicy"), "only allowed for the oci protocol"))
+		}
+	}
+
+	if !containsString(SupportedProtocols, in.Protocol) {
+		return append(errs, field.NotSupported(path.Child("protocol"), in.Protocol, SupportedProtocols))
+	}
+
+	uriPath := path.Child("uri")
+	if in.URI == "" {
+		return append(errs, field.Required(uriPath, ""))
+	}
+
+	if in.VersionConstraint != "" {
+		vcPath := path.Child("versionConstraint")
+		if in.Protocol != ProtocolOCIImageRegistry {
+			return append(errs, field.Forbidden(vcPath, "only allowed for the oci protocol"))
+		}
+		if err := ValidateVersionConstraint(in.VersionConstraint); err != nil {
+			errs = append(errs, field.Invalid(vcPath, in.VersionConstraint, err.Error()))
+		}
+		if err := ValidateOCIRepository(in.URI); err != nil {
+			errs = append(errs, field.Invalid(uriPath, in.URI, err.Error()))
+		}
+	} else if err := ValidateImageURI(in.Protocol, in.URI); err != nil {
+		errs = append(errs, field.Invalid(uriPath, in.URI, err.Error()))
+	}
+
+	_, _, ranged := in.VersionRange()
+	if ranged && in.DigestPolicy == DigestPolicyLock {
+		errs = append(errs, field.Forbidden(path.Child("digestPolicy"), "Lock cannot be used with a version constraint"))
+	}
+
+	if len(in.Sources) > 0 {
+		if in.Sha256 == nil {
+			errs = append(errs, field.Required(path.Child("sha256"), "required with sources"))
+		}
+		if ranged {
+			errs = append(errs, field.Forbidden(path.Child("sources"), "cannot be used with a version constraint"))
+		}
+	}
+	for i := range in.Sources {
+		errs = append(errs, in.Sources[i].Validate(path.Child("sources").Index(i))...)
+	}
+	if in.Retry != nil {
+		errs = append(errs, in.Retry.Validate(path.Child("retry"))...)
+	}
+	if in.FetchTimeout != nil && in.FetchTimeout.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("fetchTimeout"), in.FetchTimeout.Duration.String(), "must be positive"))
+	}
+	return errs
+}
+
+func (in *WasmExtensionImageRetry) Validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if in.InitialInterval != nil && in.InitialInterval.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("initialInterval"), in.InitialInterval.Duration.String(), "must be positive"))
+	}
+	if in.MaxInterval != nil && in.MaxInterval.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("maxInterval"), in.MaxInterval.Duration.String(), "must be positive"))
+	}
+	if in.InitialInterval != nil && in.MaxInterval != nil && in.InitialInterval.Duration > in.MaxInterval.Duration {
+		errs = append(errs, field.Invalid(path.Child("maxInterval"), in.MaxInterval.Duration.String(),
+			"must not be less than initialInterval"))
+	}
+	if in.MaxAttempts != nil && *in.MaxAttempts < 1 {
+		errs = append(errs, field.Invalid(path.Child("maxAttempts"), *in.MaxAttempts, "must be at least 1"))
+	}
+	return errs
+}
+
+func (in *WasmExtensionImageSource) Validate(path *field.Path) field.ErrorList {
+	if !containsString(SupportedProtocols, in.Protocol) {
+		return field.ErrorList{field.NotSupported(path.Child("protocol"), in.Protocol, SupportedProtocols)}
+	}
+	uriPath := path.Child("uri")
+	if in.URI == "" {
+		return field.ErrorList{field.Required(uriPath, "")}
+	}
+	if err := ValidateImageURI(in.Protocol, in.URI); err != nil {
+		return field.ErrorList{field.Invalid(uriPath, in.URI, err.Error())}
+	}
+	if _, _, ok := (&WasmExtensionSpecImage{URI: in.URI, Protocol: in.Protocol}).VersionRange(); ok {
+		return field.ErrorList{field.Invalid(uriPath, in.URI, "version constraints are not allowed in sources")}
+	}
+	return nil
+}
+
+// ValidateVersionConstraint checks if the given string is a well-formed semantic version range
+func ValidateVersionConstraint(constraint string) error {
+	if _, err := semver.NewConstraint(constraint); err != nil {
+		return fmt.Errorf("malformed version constraint: %v", err)
+	}
+	return nil
+}
+
+// ValidateOCIRepository checks if the given uri is the OCI repository without a tag or digest
+func ValidateOCIRepository(uri string) error {
+	ref, err := reference.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("malformed OCI reference: %v", err)
+	}
+	if !strings.Contains(ref.Locator, "/") {
+		return fmt.Errorf("repository must be specified after the registry host")
+	}
+	if ref.Object != "" {
+		return fmt.Errorf("tag or digest must not be specified along with the version constraint")
+	}
+	return nil
+}
+
+// ValidateSha256 checks if the given string is a well-formed sha256 value
+func ValidateSha256(sha string) error {
+	if !sha256Regexp.MatchString(sha) {
+		return fmt.Errorf("must be 64 lowercase hexadecimal characters")
+	}
+	return nil
+}
+
+// ValidateSignature checks if the given string is a base64 encoded ed25519 signature
+func ValidateSignature(sig string) error {
+	raw, err := base64.StdEncoding.DecodeString(sig)
+	if err != nil {
+		return fmt.Errorf("must be base64 encoded: %v", err)
+	}
+	if len(raw) != ed25519.SignatureSize {
+		return fmt.Errorf("must be %d bytes of an ed25519 signature", ed25519.SignatureSize)
+	}
+	return nil
+}
+
+// ValidateImageURI checks if the given uri is well-formed for the protocol
+func ValidateImageURI(protocol, uri string) error {
+	switch protocol {
+	case ProtocolOCIImageRegistry:
+		return validateOCIReference(uri)
+	case ProtocolS3:
+		return validateS3URI(uri)
+	case ProtocolHttp, ProtocolHttps:
+		return validateHttpURI(uri)
+	case ProtocolLocalFileSystem:
+		return nil
+	default:
+		return fmt.Errorf("unsupported protocol: %s", protocol)
+	}
+}
+
+func validateOCIReference(uri string) error {
+	ref, err := reference.Parse(uri)
+	if err != nil {
+		return fmt.Errorf("malformed OCI reference: %v", err)
+	}
+	if !strings.Contains(ref.Locator, "/") {
+		return fmt.Errorf("repository must be specified after the registry host")
+	}
+	if ref.Object == "" {
+		return fmt.Errorf("tag or digest must be specified")
+	}
+	if strings.Contains(ref.Object, "@") {
+		if err := ref.Digest().Validate(); err != nil {
+			return fmt.Errorf("malformed digest: %v", err)
+		}
+	} else if !tagRegexp.MatchString(ref.Object) {
+		// not a tag but a version constraint such as "~1.4"
+		if err := ValidateVersionConstraint(ref.Object); err != nil {
+			return fmt.Errorf("malformed tag: %v", err)
+		}
+	}
+	return nil
+}
+
+func validateS3URI(uri string) error {
+	u := strings.SplitN(uri, "/", 2)
+	if len(u) != 2 || u[1] == "" {
+		return fmt.Errorf("must be in '<s3_bucket_name>/path/to/wasm/binary'")
+	}
+	if !s3BucketRegexp.MatchString(u[0]) {
+		return fmt.Errorf("invalid bucket name %q", u[0])
+	}
+	return nil
+}
+
+func validateHttpURI(uri string) error {
+	if strings.Contains(uri, "://") {
+		return fmt.Errorf("must not contain the scheme")
+	}
+	u, err := url.Parse("http://" + uri)
+	if err != nil {
+		return err
+	}
+	if u.Host == "" {
+		return fmt.Errorf("host must be specified")
+	}
+	return nil
+}
+
+func (in *WasmExtensionConfigValue) Validate(path *field.Path) field.ErrorList {
+	var set int
+	for _, isSet := range []bool{in.Value != nil, in.Object != nil, in.ValueFrom != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set == 0 {
+		return field.ErrorList{field.Required(path, "one of value, object and valueFrom must be set")}
+	} else if set > 1 {
+		return field.ErrorList{field.Forbidden(path, "only one of value, object and valueFrom can be set")}
+	}
+
+	var errs field.ErrorList
+	if in.Encoding != "" && !containsString(SupportedConfigEncodings, in.Encoding) {
+		errs = append(errs, field.NotSupported(path.Child("encoding"), in.Encoding, SupportedConfigEncodings))
+	} else if in.Encoding == ConfigEncodingStruct {
+		if in.Value != nil && !IsJSONObject([]byte(*in.Value)) {
+			errs = append(errs, field.Invalid(path.Child("value"), *in.Value,
+				"must be a JSON object for the struct encoding"))
+		} else if in.Object != nil && !IsJSONObject(in.Object.Raw) {
+			errs = append(errs, field.Invalid(path.Child("object"), string(in.Object.Raw),
+				"must be an object for the struct encoding"))
+		}
+	}
+
+	if in.ValueFrom == nil {
+		return errs
+	}
+
+	path = path.Child("valueFrom")
+	from := in.ValueFrom
+	if from.SecretKeyRef != nil && from.ConfigMapKeyRef != nil {
+		return append(errs, field.Forbidden(path, "only one of secretKeyRef and configMapKeyRef can be set"))
+	} else if from.SecretKeyRef != nil {
+		return append(errs, from.SecretKeyRef.Validate(path.Child("secretKeyRef"))...)
+	} else if from.ConfigMapKeyRef != nil {
+		return append(errs, from.ConfigMapKeyRef.Validate(path.Child("configMapKeyRef"))...)
+	}
+	return append(errs, field.Required(path, "one of secretKeyRef and configMapKeyRef must be set"))
+}
+
+// IsJSONObject returns true if the given bytes are a JSON object
+func IsJSONObject(raw []byte) bool {
+	var obj map[string]interface{}
+	return json.Unmarshal(raw, &obj) == nil && obj != nil
+}
+
+func (in *WasmExtensionConfigValueRefAttribute) Validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if in.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), ""))
+	}
+	if in.Namespace == "" {
+		errs = append(errs, field.Required(path.Child("namespace"), ""))
+	}
+	if in.Key == "" {
+		errs = append(errs, field.Required(path.Child("key"), ""))
+	}
+	return errs
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
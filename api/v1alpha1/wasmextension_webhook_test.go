@@ -0,0 +1,476 @@
+package v1alpha1
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func int32Ptr(i int32) *int32 {
+	return &i
+}
+
+func TestWasmExtension_Default(t *testing.T) {
+	ext := &WasmExtension{}
+	ext.Spec.Image.Sha256 = strPtr("039058C6F2C0CB492C533B0A4D14EF77CC0F78ABCCCED5287D84A1A2011CFB81")
+	ext.Default()
+	assert.Equal(t, ProtocolOCIImageRegistry, ext.Spec.Image.Protocol)
+	// left empty for the image metadata
+	assert.Equal(t, "", ext.Spec.Runtime)
+	assert.Equal(t, "039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81", *ext.Spec.Image.Sha256)
+
+	ext.Spec.Image.Protocol = ProtocolS3
+	ext.Spec.Runtime = "WAVM"
+	ext.Default()
+	assert.Equal(t, ProtocolS3, ext.Spec.Image.Protocol)
+	assert.Equal(t, RuntimeWAVM, ext.Spec.Runtime)
+}
+
+func TestWasmExtension_Validate(t *testing.T) {
+	valid := func() *WasmExtension {
+		return &WasmExtension{
+			ObjectMeta: metav1.ObjectMeta{Name: "ext", Namespace: "default"},
+			Spec: WasmExtensionSpec{
+				Image: WasmExtensionSpecImage{
+					URI:      "webassemblyhub.io/mathetake/example:v0.1",
+					Protocol: ProtocolOCIImageRegistry,
+				},
+				VMID:    "vm",
+				RootID:  "root",
+				Runtime: RuntimeV8,
+			},
+		}
+	}
+
+	require.NoError(t, valid().Validate())
+
+	t.Run("null runtime", func(t *testing.T) {
+		ext := valid()
+		ext.Spec.Image = WasmExtensionSpecImage{}
+		ext.Spec.Runtime = RuntimeNull
+		ext.Spec.BuiltinPlugin = "envoy.wasm.stats"
+		ext.Default()
+		require.NoError(t, ext.Validate())
+	})
+
+	t.Run("version constraint", func(t *testing.T) {
+		for _, image := range []WasmExtensionSpecImage{
+			{URI: "webassemblyhub.io/mathetake/example", VersionConstraint: ">= 1.2, < 2.0"},
+			{URI: "webassemblyhub.io/mathetake/example:~1.4"},
+			{URI: "webassemblyhub.io/mathetake/example:^1.4", DigestPolicy: DigestPolicyFollow},
+		} {
+			ext := valid()
+			ext.Spec.Image = image
+			ext.Default()
+			require.NoError(t, ext.Validate(), image.URI)
+		}
+	})
+
+	t.Run("sources", func(t *testing.T) {
+		ext := valid()
+		ext.Spec.Image.Sha256 = strPtr("039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81")
+		ext.Spec.Image.Sources = []WasmExtensionImageSource{
+			{URI: "ghcr.io/mathetake/example:v0.1"},
+			{URI: "bucket/example.wasm", Protocol: ProtocolS3},
+		}
+		ext.Default()
+		assert.Equal(t, ProtocolOCIImageRegistry, ext.Spec.Image.Sources[0].Protocol)
+		require.NoError(t, ext.Validate())
+	})
+
+	t.Run("defaults from image metadata", func(t *testing.T) {
+		ext := valid()
+		ext.Spec.VMID, ext.Spec.RootID, ext.Spec.Runtime = "", "", ""
+		require.NoError(t, ext.Validate())
+	})
+
+	t.Run("structured configurations", func(t *testing.T) {
+		ext := valid()
+		ext.Spec.PluginConfiguration = &WasmExtensionConfigValue{
+			Object:   &apiextensionsv1.JSON{Raw: []byte(`{"foo":"bar"}`)},
+			Encoding: ConfigEncodingStruct,
+		}
+		ext.Spec.VMConfiguration = &WasmExtensionConfigValue{Value: strPtr("binary"), Encoding: ConfigEncodingBytes}
+		require.NoError(t, ext.Validate())
+	})
+
+	for _, c := range []struct {
+		name   string
+		mutate func(ext *WasmExtension)
+		field  string
+	}{
+		{
+			name: "null runtime without vm_id",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Runtime, ext.Spec.Image, ext.Spec.BuiltinPlugin = RuntimeNull, WasmExtensionSpecImage{}, "envoy.wasm.stats"
+				ext.Spec.VMID = ""
+			},
+			field: "spec.vm_id",
+		},
+		{
+			name: "null runtime without root_id",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Runtime, ext.Spec.Image, ext.Spec.BuiltinPlugin = RuntimeNull, WasmExtensionSpecImage{}, "envoy.wasm.stats"
+				ext.Spec.RootID = ""
+			},
+			field: "spec.root_id",
+		},
+		{name: "unknown runtime", mutate: func(ext *WasmExtension) { ext.Spec.Runtime = "v9" }, field: "spec.runtime"},
+		{
+			name:   "unknown protocol",
+			mutate: func(ext *WasmExtension) { ext.Spec.Image.Protocol = "ftp" },
+			field:  "spec.image.protocol",
+		},
+		{
+			name:   "oci without tag",
+			mutate: func(ext *WasmExtension) { ext.Spec.Image.URI = "webassemblyhub.io/mathetake/example" },
+			field:  "spec.image.uri",
+		},
+		{
+			name:   "oci without repository",
+			mutate: func(ext *WasmExtension) { ext.Spec.Image.URI = "webassemblyhub.io:v0.1" },
+			field:  "spec.image.uri",
+		},
+		{
+			name:   "oci with malformed digest",
+			mutate: func(ext *WasmExtension) { ext.Spec.Image.URI = "webassemblyhub.io/mathetake/example@sha256:abc" },
+			field:  "spec.image.uri",
+		},
+		{
+			name: "s3 without key",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.Protocol = ProtocolS3
+				ext.Spec.Image.URI = "my-bucket"
+			},
+			field: "spec.image.uri",
+		},
+		{
+			name: "s3 with invalid bucket",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.Protocol = ProtocolS3
+				ext.Spec.Image.URI = "My_Bucket/filter.wasm"
+			},
+			field: "spec.image.uri",
+		},
+		{
+			name: "http with scheme",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.Protocol = ProtocolHttp
+				ext.Spec.Image.URI = "http://example.com/filter.wasm"
+			},
+			field: "spec.image.uri",
+		},
+		{
+			name:   "unknown digestPolicy",
+			mutate: func(ext *WasmExtension) { ext.Spec.Image.DigestPolicy = "Pin" },
+			field:  "spec.image.digestPolicy",
+		},
+		{
+			name: "digestPolicy for non-oci",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image = WasmExtensionSpecImage{URI: "filter.wasm", Protocol: ProtocolLocalFileSystem, DigestPolicy: DigestPolicyLock}
+			},
+			field: "spec.image.digestPolicy",
+		},
+		{
+			name:   "malformed versionConstraint",
+			mutate: func(ext *WasmExtension) { ext.Spec.Image.VersionConstraint = "~one" },
+			field:  "spec.image.versionConstraint",
+		},
+		{
+			name: "versionConstraint with tag",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.VersionConstraint = "~1.4"
+			},
+			field: "spec.image.uri",
+		},
+		{
+			name: "versionConstraint for non-oci",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image = WasmExtensionSpecImage{URI: "filter.wasm", Protocol: ProtocolLocalFileSystem, VersionConstraint: "~1.4"}
+			},
+			field: "spec.image.versionConstraint",
+		},
+		{
+			name:   "malformed version constraint in uri",
+			mutate: func(ext *WasmExtension) { ext.Spec.Image.URI = "webassemblyhub.io/mathetake/example:~one" },
+			field:  "spec.image.uri",
+		},
+		{
+			name: "Lock with version constraint",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.URI = "webassemblyhub.io/mathetake/example:~1.4"
+				ext.Spec.Image.DigestPolicy = DigestPolicyLock
+			},
+			field: "spec.image.digestPolicy",
+		},
+		{
+			name: "sources without sha256",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.Sources = []WasmExtensionImageSource{{URI: "ghcr.io/mathetake/example:v0.1", Protocol: ProtocolOCIImageRegistry}}
+			},
+			field: "spec.image.sha256",
+		},
+		{
+			name: "malformed source",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.Sha256 = strPtr("039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81")
+				ext.Spec.Image.Sources = []WasmExtensionImageSource{{URI: "bucket", Protocol: ProtocolS3}}
+			},
+			field: "spec.image.sources[0].uri",
+		},
+		{
+			name: "version constraint in source",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.Sha256 = strPtr("039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81")
+				ext.Spec.Image.Sources = []WasmExtensionImageSource{{URI: "ghcr.io/mathetake/example:~0.1", Protocol: ProtocolOCIImageRegistry}}
+			},
+			field: "spec.image.sources[0].uri",
+		},
+		{
+			name: "sources with version constraint",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.URI = "webassemblyhub.io/mathetake/example:~0.1"
+				ext.Spec.Image.Sha256 = strPtr("039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81")
+				ext.Spec.Image.Sources = []WasmExtensionImageSource{{URI: "ghcr.io/mathetake/example:v0.1", Protocol: ProtocolOCIImageRegistry}}
+			},
+			field: "spec.image.sources",
+		},
+		{
+			name:   "malformed sha256",
+			mutate: func(ext *WasmExtension) { ext.Spec.Image.Sha256 = strPtr("not-a-sha") },
+			field:  "spec.image.sha256",
+		},
+		{
+			name:   "malformed signature",
+			mutate: func(ext *WasmExtension) { ext.Spec.Image.Signature = strPtr("c2lnbmF0dXJl") },
+			field:  "spec.image.signature",
+		},
+		{
+			name: "null runtime without builtin_plugin",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Runtime = RuntimeNull
+				ext.Spec.Image = WasmExtensionSpecImage{}
+			},
+			field: "spec.builtin_plugin",
+		},
+		{
+			name: "null runtime with image",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Runtime = RuntimeNull
+				ext.Spec.BuiltinPlugin = "envoy.wasm.stats"
+			},
+			field: "spec.image",
+		},
+		{
+			name:   "builtin_plugin without null runtime",
+			mutate: func(ext *WasmExtension) { ext.Spec.BuiltinPlugin = "envoy.wasm.stats" },
+			field:  "spec.builtin_plugin",
+		},
+		{
+			name: "duplicated environment variable",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.EnvironmentVariables = &WasmExtensionEnvironmentVariables{
+					HostEnvKeys: []string{"FOO"},
+					KeyValues:   map[string]string{"FOO": "bar"},
+				}
+			},
+			field: "spec.environment_variables.key_values[FOO]",
+		},
+		{
+			name: "value and valueFrom",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.PluginConfiguration = &WasmExtensionConfigValue{
+					Value:     strPtr("value"),
+					ValueFrom: &WasmExtensionConfigValueRef{},
+				}
+			},
+			field: "spec.plugin_configuration",
+		},
+		{
+			name: "value and object",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.PluginConfiguration = &WasmExtensionConfigValue{
+					Value:  strPtr("value"),
+					Object: &apiextensionsv1.JSON{Raw: []byte(`{"foo":"bar"}`)},
+				}
+			},
+			field: "spec.plugin_configuration",
+		},
+		{
+			name: "unsupported encoding",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.PluginConfiguration = &WasmExtensionConfigValue{Value: strPtr("value"), Encoding: "yaml"}
+			},
+			field: "spec.plugin_configuration.encoding",
+		},
+		{
+			name: "struct encoding with non-object value",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.PluginConfiguration = &WasmExtensionConfigValue{
+					Value:    strPtr(`"foo"`),
+					Encoding: ConfigEncodingStruct,
+				}
+			},
+			field: "spec.plugin_configuration.value",
+		},
+		{
+			name: "neither value nor valueFrom",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.VMConfiguration = &WasmExtensionConfigValue{}
+			},
+			field: "spec.vm_configuration",
+		},
+		{
+			name: "both secretKeyRef and configMapKeyRef",
+			mutate: func(ext *WasmExtension) {
+				attr := &WasmExtensionConfigValueRefAttribute{Name: "name", Namespace: "default", Key: "key"}
+				ext.Spec.VMConfiguration = &WasmExtensionConfigValue{
+					ValueFrom: &WasmExtensionConfigValueRef{SecretKeyRef: attr, ConfigMapKeyRef: attr},
+				}
+			},
+			field: "spec.vm_configuration.valueFrom",
+		},
+		{
+			name: "secretKeyRef without key",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.VMConfiguration = &WasmExtensionConfigValue{
+					ValueFrom: &WasmExtensionConfigValueRef{
+						SecretKeyRef: &WasmExtensionConfigValueRefAttribute{Name: "name", Namespace: "default"},
+					},
+				}
+			},
+			field: "spec.vm_configuration.valueFrom.secretKeyRef.key",
+		},
+		{
+			name: "non-positive retry interval",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.Retry = &WasmExtensionImageRetry{InitialInterval: &metav1.Duration{}}
+			},
+			field: "spec.image.retry.initialInterval",
+		},
+		{
+			name: "retry interval exceeding max",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.Retry = &WasmExtensionImageRetry{
+					InitialInterval: &metav1.Duration{Duration: time.Minute},
+					MaxInterval:     &metav1.Duration{Duration: time.Second},
+				}
+			},
+			field: "spec.image.retry.maxInterval",
+		},
+		{
+			name: "zero retry attempts",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.Retry = &WasmExtensionImageRetry{MaxAttempts: int32Ptr(0)}
+			},
+			field: "spec.image.retry.maxAttempts",
+		},
+		{
+			name: "non-positive fetch timeout",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.FetchTimeout = &metav1.Duration{Duration: -time.Second}
+			},
+			field: "spec.image.fetchTimeout",
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			ext := valid()
+			c.mutate(ext)
+			err := ext.Validate()
+			require.Error(t, err)
+			require.True(t, apierrors.IsInvalid(err))
+			assert.Contains(t, err.Error(), c.field)
+			t.Log(err)
+		})
+	}
+}
+
+func TestWasmExtensionImageRetry_Backoff(t *testing.T) {
+	var retry *WasmExtensionImageRetry
+	for failures, exp := range map[int]time.Duration{
+		1: time.Second, 2: 2 * time.Second, 3: 4 * time.Second, 9: 256 * time.Second, 10: 5 * time.Minute, 100: 5 * time.Minute,
+	} {
+		backoff, ok := retry.Backoff(failures)
+		assert.True(t, ok)
+		assert.Equal(t, exp, backoff, failures)
+	}
+
+	retry = &WasmExtensionImageRetry{
+		InitialInterval: &metav1.Duration{Duration: 100 * time.Millisecond},
+		MaxInterval:     &metav1.Duration{Duration: 300 * time.Millisecond},
+		MaxAttempts:     int32Ptr(3),
+	}
+	for failures, exp := range map[int]time.Duration{1: 100 * time.Millisecond, 2: 200 * time.Millisecond} {
+		backoff, ok := retry.Backoff(failures)
+		assert.True(t, ok)
+		assert.Equal(t, exp, backoff, failures)
+	}
+	_, ok := retry.Backoff(3)
+	assert.False(t, ok)
+}
+
+func TestWasmExtensionSpecImage_ProviderKey(t *testing.T) {
+	_, err := (&WasmExtensionSpecImage{Protocol: "ftp"}).ProviderKey()
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unsupported protocol")
+
+	key, err := (&WasmExtensionSpecImage{URI: "localhost:5000/foo:v1"}).ProviderKey()
+	require.NoError(t, err)
+	assert.Equal(t, "oci||localhost:5000", key)
+}
+
+func TestWasmExtensionSpecImage_PinDigest(t *testing.T) {
+	const digest = "sha256:039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81"
+	for _, uri := range []string{
+		"webassemblyhub.io/mathetake/example:v0.1",
+		"webassemblyhub.io/mathetake/example@sha256:0000000000000000000000000000000000000000000000000000000000000000",
+	} {
+		image := &WasmExtensionSpecImage{URI: uri, Protocol: ProtocolOCIImageRegistry, DigestPolicy: DigestPolicyLock}
+		pinned, err := image.PinDigest(digest)
+		require.NoError(t, err)
+		assert.Equal(t, "webassemblyhub.io/mathetake/example@"+digest, pinned.URI)
+		assert.Equal(t, uri, image.URI)
+	}
+}
+
+func TestWasmExtensionSpecImage_VersionRange(t *testing.T) {
+	for _, c := range []struct {
+		image                  WasmExtensionSpecImage
+		repository, constraint string
+		ok                     bool
+	}{
+		{
+			image:      WasmExtensionSpecImage{URI: "ghcr.io/foo/bar", Protocol: ProtocolOCIImageRegistry, VersionConstraint: "1.4.x"},
+			repository: "ghcr.io/foo/bar", constraint: "1.4.x", ok: true,
+		},
+		{
+			image:      WasmExtensionSpecImage{URI: "ghcr.io/foo/bar:~1.4", Protocol: ProtocolOCIImageRegistry},
+			repository: "ghcr.io/foo/bar", constraint: "~1.4", ok: true,
+		},
+		{image: WasmExtensionSpecImage{URI: "ghcr.io/foo/bar:1.4.x", Protocol: ProtocolOCIImageRegistry}},
+		{image: WasmExtensionSpecImage{URI: "ghcr.io/foo/bar:v1.4.2", Protocol: ProtocolOCIImageRegistry}},
+		{image: WasmExtensionSpecImage{URI: "ghcr.io/foo/bar@sha256:0000000000000000000000000000000000000000000000000000000000000000"}},
+		{image: WasmExtensionSpecImage{URI: "bucket/~1.4", Protocol: ProtocolS3}},
+	} {
+		repository, constraint, ok := c.image.VersionRange()
+		assert.Equal(t, c.ok, ok, c.image.URI)
+		assert.Equal(t, c.repository, repository, c.image.URI)
+		assert.Equal(t, c.constraint, constraint, c.image.URI)
+	}
+
+	image := &WasmExtensionSpecImage{URI: "ghcr.io/foo/bar:~1.4", Protocol: ProtocolOCIImageRegistry}
+	resolved := image.WithTag("ghcr.io/foo/bar", "1.4.2")
+	assert.Equal(t, "ghcr.io/foo/bar:1.4.2", resolved.URI)
+	assert.Equal(t, "ghcr.io/foo/bar:~1.4", image.URI)
+}
@@ -0,0 +1,243 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"fmt"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/reference"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+)
+
+// AppliesTo returns true if the policy applies to the extensions in the namespace
+func (in *WasmExtensionPolicy) AppliesTo(namespace string) bool {
+	return len(in.Spec.Namespaces) == 0 || containsString(in.Spec.Namespaces, namespace)
+}
+
+// CheckPolicies returns a Forbidden error which aggregates the violations of all the policies
+// applying to the extension, or nil if there's none
+func CheckPolicies(policies []WasmExtensionPolicy, ext *WasmExtension) error {
+	var errs field.ErrorList
+	for i := range policies {
+		if p := &policies[i]; p.AppliesTo(ext.Namespace) {
+			errs = append(errs, p.Check(ext)...)
+		}
+	}
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewForbidden(GroupVersion.WithResource("wasmextensions").GroupResource(),
+		ext.Name, errs.ToAggregate())
+}
+
+// Check returns the violations of the policy found in the spec of the extension
+func (in *WasmExtensionPolicy) Check(ext *WasmExtension) field.ErrorList {
+	var errs field.ErrorList
+	specPath := field.NewPath("spec")
+	imagePath := specPath.Child("image")
+	image := &ext.Spec.Image
+	policy := fmt.Sprintf("forbidden by WasmExtensionPolicy %s", in.Name)
+
+	// the null runtime doesn't fetch images
+	if strings.ToLower(ext.Spec.Runtime) != RuntimeNull {
+		if in.Spec.RequireSha256 && image.Sha256 == nil {
+			errs = append(errs, field.Required(imagePath.Child("sha256"), policy))
+		}
+		if len(in.Spec.SignatureKeys) > 0 && image.Signature == nil {
+			errs = append(errs, field.Required(imagePath.Child("signature"), policy))
+		}
+
+		errs = append(errs, in.checkSource(imagePath, image.Protocol, image.URI, policy)...)
+		// the fallbacks are checked as well as they can serve the binary too
+		for i, s := range image.Sources {
+			errs = append(errs, in.checkSource(imagePath.Child("sources").Index(i), s.Protocol, s.URI, policy)...)
+		}
+	}
+
+	for _, c := range []struct {
+		name string
+		cv   *WasmExtensionConfigValue
+	}{
+		{name: "plugin_configuration", cv: ext.Spec.PluginConfiguration},
+		{name: "vm_configuration", cv: ext.Spec.VMConfiguration},
+	} {
+		if c.cv == nil || c.cv.ValueFrom == nil {
+			continue
+		}
+		path := specPath.Child(c.name, "valueFrom")
+		if ref := c.cv.ValueFrom.SecretKeyRef; ref != nil && !in.allowsConfigNamespace(ext.Namespace, ref.Namespace) {
+			errs = append(errs, field.Forbidden(path.Child("secretKeyRef", "namespace"), policy))
+		}
+		if ref := c.cv.ValueFrom.ConfigMapKeyRef; ref != nil && !in.allowsConfigNamespace(ext.Namespace, ref.Namespace) {
+			errs = append(errs, field.Forbidden(path.Child("configMapKeyRef", "namespace"), policy))
+		}
+	}
+	return errs
+}
+
+func (in *WasmExtensionPolicy) checkSource(path *field.Path, protocol, uri, policy string) field.ErrorList {
+	if protocol == "" {
+		protocol = ProtocolOCIImageRegistry
+	}
+	if len(in.Spec.AllowedProtocols) > 0 && !containsString(in.Spec.AllowedProtocols, protocol) {
+		return field.ErrorList{field.Forbidden(path.Child("protocol"), policy)}
+	} else if source, ok := imageSource(protocol, uri); !ok || !in.allowsSource(protocol, source) {
+		return field.ErrorList{field.Forbidden(path.Child("uri"), policy)}
+	}
+	return nil
+}
+
+// CheckBinarySize returns an error if the size of the binary exceeds the limit of the policy
+func (in *WasmExtensionPolicy) CheckBinarySize(size int) error {
+	if in.Spec.MaxBinarySize != nil && int64(size) > in.Spec.MaxBinarySize.Value() {
+		return fmt.Errorf("the binary size %d exceeds the limit %s of WasmExtensionPolicy %s",
+			size, in.Spec.MaxBinarySize.String(), in.Name)
+	}
+	return nil
+}
+
+// CheckSignature returns an error if the policy requires signatures and none of the keys verifies the signature of the binary
+func (in *WasmExtensionPolicy) CheckSignature(binary []byte, signature *string) error {
+	if len(in.Spec.SignatureKeys) == 0 {
+		return nil
+	}
+	if signature == nil {
+		return fmt.Errorf("the signature is required by WasmExtensionPolicy %s", in.Name)
+	}
+	sig, err := base64.StdEncoding.DecodeString(*signature)
+	if err != nil {
+		return fmt.Errorf("malformed signature: %w", err)
+	}
+	for i, raw := range in.Spec.SignatureKeys {
+		key, err := parsePublicKey([]byte(raw))
+		if err != nil {
+			return fmt.Errorf("invalid signatureKeys[%d] of WasmExtensionPolicy %s: %w", i, in.Name, err)
+		}
+		if ed25519.Verify(key, binary, sig) {
+			return nil
+		}
+	}
+	return fmt.Errorf("the signature is not verified by the keys of WasmExtensionPolicy %s", in.Name)
+}
+
+// parsePublicKey parses the PEM encoded PKIX ed25519 public key
+func parsePublicKey(raw []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM block found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ret, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T: must be ed25519", key)
+	}
+	return ret, nil
+}
+
+func (in *WasmExtensionPolicy) allowsConfigNamespace(extNamespace, namespace string) bool {
+	return namespace == extNamespace ||
+		containsString(in.Spec.AllowedConfigNamespaces, "*") ||
+		containsString(in.Spec.AllowedConfigNamespaces, namespace)
+}
+
+func (in *WasmExtensionPolicy) allowsSource(protocol, source string) bool {
+	var allowed []string
+	switch protocol {
+	case ProtocolOCIImageRegistry:
+		allowed = in.Spec.AllowedRegistries
+	case ProtocolHttp, ProtocolHttps:
+		allowed = in.Spec.AllowedHosts
+	case ProtocolS3:
+		allowed = in.Spec.AllowedS3Buckets
+	case ProtocolLocalFileSystem:
+		if len(in.Spec.AllowedLocalDirectories) == 0 {
+			return true
+		}
+		for _, dir := range in.Spec.AllowedLocalDirectories {
+			dir, err := resolvePath(dir)
+			if err != nil {
+				continue
+			}
+			if dir == string(filepath.Separator) || strings.HasPrefix(source, dir+string(filepath.Separator)) {
+				return true
+			}
+		}
+		return false
+	}
+	return len(allowed) == 0 || containsString(allowed, source)
+}
+
+// imageSource returns what the policy restricts for the protocol: the registry or http host,
+// the s3 bucket or the resolved path of the local file
+func imageSource(protocol, uri string) (string, bool) {
+	switch protocol {
+	case ProtocolOCIImageRegistry:
+		ref, err := reference.Parse(uri)
+		if err != nil {
+			return "", false
+		}
+		return ref.Hostname(), true
+	case ProtocolHttp, ProtocolHttps:
+		u, err := url.Parse("http://" + uri)
+		if err != nil {
+			return "", false
+		}
+		return u.Host, true
+	case ProtocolS3:
+		return strings.SplitN(uri, "/", 2)[0], true
+	case ProtocolLocalFileSystem:
+		resolved, err := resolvePath(uri)
+		if err != nil {
+			return "", false
+		}
+		return resolved, true
+	}
+	return "", false
+}
+
+// resolvePath returns the absolute path with the symbolic links resolved, so that the links cannot escape
+// the allowed directories. The missing part of the path, e.g. of the file not created yet, is kept as is.
+func resolvePath(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	resolved, err := filepath.EvalSymlinks(abs)
+	if err == nil {
+		return resolved, nil
+	} else if !os.IsNotExist(err) {
+		return "", err
+	}
+	dir, file := filepath.Split(abs)
+	if dir = filepath.Clean(dir); dir == abs {
+		return abs, nil
+	}
+	if dir, err = resolvePath(dir); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, file), nil
+}
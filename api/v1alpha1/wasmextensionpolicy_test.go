@@ -0,0 +1,280 @@
+package v1alpha1
+
+import (
+	"crypto/ed25519"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestWasmExtensionPolicy_AppliesTo(t *testing.T) {
+	p := &WasmExtensionPolicy{}
+	assert.True(t, p.AppliesTo("default"))
+
+	p.Spec.Namespaces = []string{"foo"}
+	assert.True(t, p.AppliesTo("foo"))
+	assert.False(t, p.AppliesTo("default"))
+}
+
+func TestWasmExtensionPolicy_Check(t *testing.T) {
+	newExt := func(protocol, uri string) *WasmExtension {
+		return &WasmExtension{
+			ObjectMeta: metav1.ObjectMeta{Name: "ext", Namespace: "default"},
+			Spec: WasmExtensionSpec{
+				Image:   WasmExtensionSpecImage{URI: uri, Protocol: protocol},
+				Runtime: RuntimeV8,
+			},
+		}
+	}
+	configRef := func(namespace string) *WasmExtensionConfigValue {
+		return &WasmExtensionConfigValue{ValueFrom: &WasmExtensionConfigValueRef{
+			SecretKeyRef: &WasmExtensionConfigValueRefAttribute{Name: "secret", Namespace: namespace, Key: "key"},
+		}}
+	}
+
+	for _, c := range []struct {
+		name   string
+		policy WasmExtensionPolicySpec
+		ext    *WasmExtension
+		fields []string
+	}{
+		{
+			name:   "empty policy",
+			policy: WasmExtensionPolicySpec{},
+			ext:    newExt(ProtocolLocalFileSystem, "/var/run/secrets/kubernetes.io/serviceaccount/token"),
+		},
+		{
+			name:   "forbidden protocol",
+			policy: WasmExtensionPolicySpec{AllowedProtocols: []string{ProtocolOCIImageRegistry}},
+			ext:    newExt(ProtocolLocalFileSystem, "filter.wasm"),
+			fields: []string{"spec.image.protocol"},
+		},
+		{
+			name:   "defaulted protocol",
+			policy: WasmExtensionPolicySpec{AllowedProtocols: []string{ProtocolOCIImageRegistry}},
+			ext:    newExt("", "webassemblyhub.io/mathetake/example:v0.1"),
+		},
+		{
+			name:   "allowed registry",
+			policy: WasmExtensionPolicySpec{AllowedRegistries: []string{"webassemblyhub.io"}},
+			ext:    newExt(ProtocolOCIImageRegistry, "webassemblyhub.io/mathetake/example:v0.1"),
+		},
+		{
+			name:   "forbidden registry",
+			policy: WasmExtensionPolicySpec{AllowedRegistries: []string{"webassemblyhub.io"}},
+			ext:    newExt(ProtocolOCIImageRegistry, "ghcr.io/mathetake/example:v0.1"),
+			fields: []string{"spec.image.uri"},
+		},
+		{
+			name:   "forbidden source",
+			policy: WasmExtensionPolicySpec{AllowedProtocols: []string{ProtocolOCIImageRegistry}},
+			ext: func() *WasmExtension {
+				ext := newExt(ProtocolOCIImageRegistry, "webassemblyhub.io/mathetake/example:v0.1")
+				ext.Spec.Image.Sources = []WasmExtensionImageSource{
+					{URI: "ghcr.io/mathetake/example:v0.1", Protocol: ProtocolOCIImageRegistry},
+					{URI: "bucket/filter.wasm", Protocol: ProtocolS3},
+				}
+				return ext
+			}(),
+			fields: []string{"spec.image.sources[1].protocol"},
+		},
+		{
+			name:   "allowed host",
+			policy: WasmExtensionPolicySpec{AllowedHosts: []string{"example.com"}},
+			ext:    newExt(ProtocolHttps, "example.com/filter.wasm"),
+		},
+		{
+			name:   "forbidden host",
+			policy: WasmExtensionPolicySpec{AllowedHosts: []string{"example.com"}},
+			ext:    newExt(ProtocolHttp, "169.254.169.254/latest/meta-data"),
+			fields: []string{"spec.image.uri"},
+		},
+		{
+			name:   "forbidden bucket",
+			policy: WasmExtensionPolicySpec{AllowedS3Buckets: []string{"bucket"}},
+			ext:    newExt(ProtocolS3, "another/filter.wasm"),
+			fields: []string{"spec.image.uri"},
+		},
+		{
+			name:   "allowed local directory",
+			policy: WasmExtensionPolicySpec{AllowedLocalDirectories: []string{"/wasm/"}},
+			ext:    newExt(ProtocolLocalFileSystem, "/wasm/filter.wasm"),
+		},
+		{
+			name:   "escaping local directory",
+			policy: WasmExtensionPolicySpec{AllowedLocalDirectories: []string{"/wasm"}},
+			ext:    newExt(ProtocolLocalFileSystem, "/wasm/../var/run/secrets/kubernetes.io/serviceaccount/token"),
+			fields: []string{"spec.image.uri"},
+		},
+		{
+			name:   "sha256 required",
+			policy: WasmExtensionPolicySpec{RequireSha256: true},
+			ext:    newExt(ProtocolHttps, "example.com/filter.wasm"),
+			fields: []string{"spec.image.sha256"},
+		},
+		{
+			name:   "signature required",
+			policy: WasmExtensionPolicySpec{SignatureKeys: []string{"key"}},
+			ext:    newExt(ProtocolHttps, "example.com/filter.wasm"),
+			fields: []string{"spec.image.signature"},
+		},
+		{
+			name:   "null runtime",
+			policy: WasmExtensionPolicySpec{RequireSha256: true, AllowedProtocols: []string{ProtocolOCIImageRegistry}},
+			ext: func() *WasmExtension {
+				ext := newExt("", "")
+				ext.Spec.Runtime = RuntimeNull
+				ext.Spec.BuiltinPlugin = "envoy.wasm.stats"
+				return ext
+			}(),
+		},
+		{
+			name:   "same namespace reference",
+			policy: WasmExtensionPolicySpec{},
+			ext: func() *WasmExtension {
+				ext := newExt(ProtocolHttps, "example.com/filter.wasm")
+				ext.Spec.PluginConfiguration = configRef("default")
+				return ext
+			}(),
+		},
+		{
+			name:   "cross namespace reference",
+			policy: WasmExtensionPolicySpec{AllowedConfigNamespaces: []string{"shared"}},
+			ext: func() *WasmExtension {
+				ext := newExt(ProtocolHttps, "example.com/filter.wasm")
+				ext.Spec.PluginConfiguration = configRef("shared")
+				ext.Spec.VMConfiguration = configRef("kube-system")
+				return ext
+			}(),
+			fields: []string{"spec.vm_configuration.valueFrom.secretKeyRef.namespace"},
+		},
+		{
+			name:   "all namespaces allowed",
+			policy: WasmExtensionPolicySpec{AllowedConfigNamespaces: []string{"*"}},
+			ext: func() *WasmExtension {
+				ext := newExt(ProtocolHttps, "example.com/filter.wasm")
+				ext.Spec.VMConfiguration = configRef("kube-system")
+				return ext
+			}(),
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			p := &WasmExtensionPolicy{ObjectMeta: metav1.ObjectMeta{Name: "policy"}, Spec: c.policy}
+			errs := p.Check(c.ext)
+			require.Len(t, errs, len(c.fields), errs)
+			for i, f := range c.fields {
+				assert.Equal(t, f, errs[i].Field)
+			}
+		})
+	}
+}
+
+func TestCheckPolicies(t *testing.T) {
+	ext := &WasmExtension{
+		ObjectMeta: metav1.ObjectMeta{Name: "ext", Namespace: "default"},
+		Spec: WasmExtensionSpec{
+			Image: WasmExtensionSpecImage{URI: "filter.wasm", Protocol: ProtocolLocalFileSystem},
+		},
+	}
+	policies := []WasmExtensionPolicy{
+		{
+			ObjectMeta: metav1.ObjectMeta{Name: "other-namespace"},
+			Spec: WasmExtensionPolicySpec{
+				Namespaces:       []string{"other"},
+				AllowedProtocols: []string{ProtocolOCIImageRegistry},
+			},
+		},
+	}
+	require.NoError(t, CheckPolicies(policies, ext))
+
+	policies = append(policies, WasmExtensionPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "cluster-wide"},
+		Spec:       WasmExtensionPolicySpec{AllowedProtocols: []string{ProtocolOCIImageRegistry}},
+	})
+	err := CheckPolicies(policies, ext)
+	require.Error(t, err)
+	assert.True(t, apierrors.IsForbidden(err))
+	assert.Contains(t, err.Error(), "cluster-wide")
+}
+
+func TestWasmExtensionPolicy_Check_symlink(t *testing.T) {
+	dir, err := ioutil.TempDir("", "policy")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	allowed := filepath.Join(dir, "wasm")
+	require.NoError(t, os.Mkdir(allowed, 0755))
+	secret := filepath.Join(dir, "token")
+	require.NoError(t, ioutil.WriteFile(secret, []byte("secret"), 0600))
+	require.NoError(t, os.Symlink(secret, filepath.Join(allowed, "filter.wasm")))
+	require.NoError(t, os.Symlink(dir, filepath.Join(allowed, "parent")))
+	require.NoError(t, os.Symlink(allowed, filepath.Join(dir, "link")))
+
+	p := &WasmExtensionPolicy{Spec: WasmExtensionPolicySpec{AllowedLocalDirectories: []string{allowed}}}
+	for _, c := range []struct {
+		uri     string
+		allowed bool
+	}{
+		{uri: filepath.Join(allowed, "filter.wasm")},
+		{uri: filepath.Join(allowed, "parent", "token")},
+		{uri: filepath.Join(allowed, "parent", "missing.wasm")},
+		// the files not created yet are allowed as long as the existing directories are
+		{uri: filepath.Join(allowed, "missing.wasm"), allowed: true},
+		{uri: filepath.Join(dir, "link", "missing.wasm"), allowed: true},
+	} {
+		ext := &WasmExtension{Spec: WasmExtensionSpec{
+			Image: WasmExtensionSpecImage{URI: c.uri, Protocol: ProtocolLocalFileSystem},
+		}}
+		assert.Equal(t, c.allowed, len(p.Check(ext)) == 0, c.uri)
+	}
+}
+
+func TestWasmExtensionPolicy_CheckSignature(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	key := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+	otherPub, _, err := ed25519.GenerateKey(nil)
+	require.NoError(t, err)
+	der, err = x509.MarshalPKIXPublicKey(otherPub)
+	require.NoError(t, err)
+	otherKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	binary := []byte{0x00, 0x61, 0x73, 0x6d}
+	sig := base64.StdEncoding.EncodeToString(ed25519.Sign(priv, binary))
+
+	p := &WasmExtensionPolicy{ObjectMeta: metav1.ObjectMeta{Name: "policy"}}
+	require.NoError(t, p.CheckSignature(binary, nil))
+
+	p.Spec.SignatureKeys = []string{otherKey, key}
+	require.NoError(t, p.CheckSignature(binary, &sig))
+	require.Error(t, p.CheckSignature(binary, nil))
+	require.Error(t, p.CheckSignature([]byte{0x00}, &sig))
+
+	p.Spec.SignatureKeys = []string{otherKey}
+	require.Error(t, p.CheckSignature(binary, &sig))
+
+	p.Spec.SignatureKeys = []string{"not a key"}
+	assert.Contains(t, p.CheckSignature(binary, &sig).Error(), "invalid signatureKeys[0]")
+}
+
+func TestWasmExtensionPolicy_CheckBinarySize(t *testing.T) {
+	p := &WasmExtensionPolicy{}
+	require.NoError(t, p.CheckBinarySize(1<<30))
+
+	limit := resource.MustParse("1Ki")
+	p.Spec.MaxBinarySize = &limit
+	require.NoError(t, p.CheckBinarySize(1024))
+	require.Error(t, p.CheckBinarySize(1025))
+}
@@ -0,0 +1,88 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha1
+
+import (
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WasmExtensionPolicySpec restricts what WasmExtensions may use.
+// Empty allow lists of the image sources leave the corresponding aspect unrestricted,
+// while the configurations are restricted to the extension's own namespace unless AllowedConfigNamespaces is set.
+type WasmExtensionPolicySpec struct {
+	// Namespaces are the namespaces of the extensions this policy applies to. Applies to all namespaces if empty.
+	// +optional
+	Namespaces []string `json:"namespaces,omitempty"`
+
+	// AllowedProtocols are the image protocols the extensions may use
+	// +optional
+	AllowedProtocols []string `json:"allowedProtocols,omitempty"`
+	// AllowedRegistries are the hosts of OCI registries the images may be pulled from
+	// +optional
+	AllowedRegistries []string `json:"allowedRegistries,omitempty"`
+	// AllowedHosts are the hosts the images may be fetched from via http or https
+	// +optional
+	AllowedHosts []string `json:"allowedHosts,omitempty"`
+	// AllowedS3Buckets are the S3 buckets the images may be fetched from
+	// +optional
+	AllowedS3Buckets []string `json:"allowedS3Buckets,omitempty"`
+	// AllowedLocalDirectories are the directories in the controller container the images may be read from.
+	// Symbolic links are resolved before the paths are compared.
+	// +optional
+	AllowedLocalDirectories []string `json:"allowedLocalDirectories,omitempty"`
+
+	// AllowedConfigNamespaces are the namespaces of ConfigMaps and Secrets the extensions may reference
+	// in addition to their own namespace. References to other namespaces are rejected.
+	// Set "*" to allow all namespaces.
+	// +optional
+	AllowedConfigNamespaces []string `json:"allowedConfigNamespaces,omitempty"`
+
+	// MaxBinarySize is the maximum size of Wasm binaries
+	// +optional
+	MaxBinarySize *resource.Quantity `json:"maxBinarySize,omitempty"`
+	// RequireSha256 rejects the extensions without spec.image.sha256
+	// +optional
+	RequireSha256 bool `json:"requireSha256,omitempty"`
+	// SignatureKeys are the PEM encoded ed25519 public keys, e.g. generated by `openssl pkey -pubout`.
+	// If set, the extensions must have spec.image.signature, and the binary must be signed by one of the keys.
+	// +optional
+	SignatureKeys []string `json:"signatureKeys,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:resource:scope=Cluster
+
+// WasmExtensionPolicy is the Schema for the wasmextensionpolicies API.
+// An extension must satisfy all the policies which apply to its namespace.
+type WasmExtensionPolicy struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec WasmExtensionPolicySpec `json:"spec,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WasmExtensionPolicyList contains a list of WasmExtensionPolicy
+type WasmExtensionPolicyList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WasmExtensionPolicy `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WasmExtensionPolicy{}, &WasmExtensionPolicyList{})
+}
@@ -1,3 +1,4 @@
+//go:build !ignore_autogenerated
 // +build !ignore_autogenerated
 
 // Copyright 2020 Tetrate
@@ -19,16 +20,33 @@
 package v1alpha1
 
 import (
-	runtime "k8s.io/apimachinery/pkg/runtime"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
 )
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageMetadata) DeepCopyInto(out *ImageMetadata) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageMetadata.
+func (in *ImageMetadata) DeepCopy() *ImageMetadata {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageMetadata)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WasmExtension) DeepCopyInto(out *WasmExtension) {
 	*out = *in
 	out.TypeMeta = in.TypeMeta
 	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
 	in.Spec.DeepCopyInto(&out.Spec)
-	out.Status = in.Status
+	in.Status.DeepCopyInto(&out.Status)
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtension.
@@ -49,6 +67,42 @@ func (in *WasmExtension) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionCapabilityRestrictionConfig) DeepCopyInto(out *WasmExtensionCapabilityRestrictionConfig) {
+	*out = *in
+	if in.AllowedCapabilities != nil {
+		in, out := &in.AllowedCapabilities, &out.AllowedCapabilities
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionCapabilityRestrictionConfig.
+func (in *WasmExtensionCapabilityRestrictionConfig) DeepCopy() *WasmExtensionCapabilityRestrictionConfig {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionCapabilityRestrictionConfig)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionCondition) DeepCopyInto(out *WasmExtensionCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionCondition.
+func (in *WasmExtensionCondition) DeepCopy() *WasmExtensionCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WasmExtensionConfigValue) DeepCopyInto(out *WasmExtensionConfigValue) {
 	*out = *in
@@ -57,6 +111,11 @@ func (in *WasmExtensionConfigValue) DeepCopyInto(out *WasmExtensionConfigValue)
 		*out = new(string)
 		**out = **in
 	}
+	if in.Object != nil {
+		in, out := &in.Object, &out.Object
+		*out = new(apiextensionsv1.JSON)
+		(*in).DeepCopyInto(*out)
+	}
 	if in.ValueFrom != nil {
 		in, out := &in.ValueFrom, &out.ValueFrom
 		*out = new(WasmExtensionConfigValueRef)
@@ -114,6 +173,93 @@ func (in *WasmExtensionConfigValueRefAttribute) DeepCopy() *WasmExtensionConfigV
 	return out
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionEffectiveValues) DeepCopyInto(out *WasmExtensionEffectiveValues) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionEffectiveValues.
+func (in *WasmExtensionEffectiveValues) DeepCopy() *WasmExtensionEffectiveValues {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionEffectiveValues)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionEnvironmentVariables) DeepCopyInto(out *WasmExtensionEnvironmentVariables) {
+	*out = *in
+	if in.HostEnvKeys != nil {
+		in, out := &in.HostEnvKeys, &out.HostEnvKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.KeyValues != nil {
+		in, out := &in.KeyValues, &out.KeyValues
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionEnvironmentVariables.
+func (in *WasmExtensionEnvironmentVariables) DeepCopy() *WasmExtensionEnvironmentVariables {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionEnvironmentVariables)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionImageRetry) DeepCopyInto(out *WasmExtensionImageRetry) {
+	*out = *in
+	if in.InitialInterval != nil {
+		in, out := &in.InitialInterval, &out.InitialInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxInterval != nil {
+		in, out := &in.MaxInterval, &out.MaxInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxAttempts != nil {
+		in, out := &in.MaxAttempts, &out.MaxAttempts
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionImageRetry.
+func (in *WasmExtensionImageRetry) DeepCopy() *WasmExtensionImageRetry {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionImageRetry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionImageSource) DeepCopyInto(out *WasmExtensionImageSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionImageSource.
+func (in *WasmExtensionImageSource) DeepCopy() *WasmExtensionImageSource {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionImageSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WasmExtensionList) DeepCopyInto(out *WasmExtensionList) {
 	*out = *in
@@ -146,6 +292,139 @@ func (in *WasmExtensionList) DeepCopyObject() runtime.Object {
 	return nil
 }
 
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionLockedImage) DeepCopyInto(out *WasmExtensionLockedImage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionLockedImage.
+func (in *WasmExtensionLockedImage) DeepCopy() *WasmExtensionLockedImage {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionLockedImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionPolicy) DeepCopyInto(out *WasmExtensionPolicy) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionPolicy.
+func (in *WasmExtensionPolicy) DeepCopy() *WasmExtensionPolicy {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionPolicy)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WasmExtensionPolicy) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionPolicyList) DeepCopyInto(out *WasmExtensionPolicyList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WasmExtensionPolicy, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionPolicyList.
+func (in *WasmExtensionPolicyList) DeepCopy() *WasmExtensionPolicyList {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionPolicyList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WasmExtensionPolicyList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionPolicySpec) DeepCopyInto(out *WasmExtensionPolicySpec) {
+	*out = *in
+	if in.Namespaces != nil {
+		in, out := &in.Namespaces, &out.Namespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedProtocols != nil {
+		in, out := &in.AllowedProtocols, &out.AllowedProtocols
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedRegistries != nil {
+		in, out := &in.AllowedRegistries, &out.AllowedRegistries
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedHosts != nil {
+		in, out := &in.AllowedHosts, &out.AllowedHosts
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedS3Buckets != nil {
+		in, out := &in.AllowedS3Buckets, &out.AllowedS3Buckets
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedLocalDirectories != nil {
+		in, out := &in.AllowedLocalDirectories, &out.AllowedLocalDirectories
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.AllowedConfigNamespaces != nil {
+		in, out := &in.AllowedConfigNamespaces, &out.AllowedConfigNamespaces
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.MaxBinarySize != nil {
+		in, out := &in.MaxBinarySize, &out.MaxBinarySize
+		x := (*in).DeepCopy()
+		*out = &x
+	}
+	if in.SignatureKeys != nil {
+		in, out := &in.SignatureKeys, &out.SignatureKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionPolicySpec.
+func (in *WasmExtensionPolicySpec) DeepCopy() *WasmExtensionPolicySpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionPolicySpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WasmExtensionSpec) DeepCopyInto(out *WasmExtensionSpec) {
 	*out = *in
@@ -160,6 +439,21 @@ func (in *WasmExtensionSpec) DeepCopyInto(out *WasmExtensionSpec) {
 		*out = new(WasmExtensionConfigValue)
 		(*in).DeepCopyInto(*out)
 	}
+	if in.AllowPrecompiled != nil {
+		in, out := &in.AllowPrecompiled, &out.AllowPrecompiled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.EnvironmentVariables != nil {
+		in, out := &in.EnvironmentVariables, &out.EnvironmentVariables
+		*out = new(WasmExtensionEnvironmentVariables)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CapabilityRestrictionConfig != nil {
+		in, out := &in.CapabilityRestrictionConfig, &out.CapabilityRestrictionConfig
+		*out = new(WasmExtensionCapabilityRestrictionConfig)
+		(*in).DeepCopyInto(*out)
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionSpec.
@@ -180,6 +474,26 @@ func (in *WasmExtensionSpecImage) DeepCopyInto(out *WasmExtensionSpecImage) {
 		*out = new(string)
 		**out = **in
 	}
+	if in.Signature != nil {
+		in, out := &in.Signature, &out.Signature
+		*out = new(string)
+		**out = **in
+	}
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make([]WasmExtensionImageSource, len(*in))
+		copy(*out, *in)
+	}
+	if in.Retry != nil {
+		in, out := &in.Retry, &out.Retry
+		*out = new(WasmExtensionImageRetry)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.FetchTimeout != nil {
+		in, out := &in.FetchTimeout, &out.FetchTimeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionSpecImage.
@@ -195,6 +509,28 @@ func (in *WasmExtensionSpecImage) DeepCopy() *WasmExtensionSpecImage {
 // DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
 func (in *WasmExtensionStatus) DeepCopyInto(out *WasmExtensionStatus) {
 	*out = *in
+	if in.LockedImage != nil {
+		in, out := &in.LockedImage, &out.LockedImage
+		*out = new(WasmExtensionLockedImage)
+		**out = **in
+	}
+	if in.ServedSource != nil {
+		in, out := &in.ServedSource, &out.ServedSource
+		*out = new(WasmExtensionImageSource)
+		**out = **in
+	}
+	if in.Effective != nil {
+		in, out := &in.Effective, &out.Effective
+		*out = new(WasmExtensionEffectiveValues)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]WasmExtensionCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
 }
 
 // DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionStatus.
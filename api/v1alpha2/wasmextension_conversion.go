@@ -0,0 +1,297 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha2
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"sigs.k8s.io/controller-runtime/pkg/conversion"
+
+	"github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+var _ conversion.Convertible = &WasmExtension{}
+
+// ConvertTo converts this WasmExtension to the Hub version (v1alpha1)
+func (in *WasmExtension) ConvertTo(hub conversion.Hub) error {
+	dst := hub.(*v1alpha1.WasmExtension)
+	in.ObjectMeta.DeepCopyInto(&dst.ObjectMeta)
+
+	dst.Spec.BuiltinPlugin = ""
+	if in.Spec.Image.Builtin != nil {
+		dst.Spec.BuiltinPlugin = in.Spec.Image.Builtin.Name
+	}
+	if err := convertImageTo(&in.Spec.Image, &dst.Spec.Image); err != nil {
+		return err
+	}
+	dst.Spec.VMID = in.Spec.VM.ID
+	dst.Spec.Runtime = in.Spec.VM.Runtime
+	dst.Spec.RootID = in.Spec.RootID
+	dst.Spec.PluginName = in.Spec.PluginName
+	dst.Spec.FailOpen = in.Spec.FailOpen
+	dst.Spec.AllowPrecompiled = copyBool(in.Spec.VM.AllowPrecompiled)
+	dst.Spec.NackOnCodeCacheMiss = in.Spec.VM.NackOnCodeCacheMiss
+	dst.Spec.EnvironmentVariables = nil
+	if env := in.Spec.VM.EnvironmentVariables; env != nil {
+		dst.Spec.EnvironmentVariables = &v1alpha1.WasmExtensionEnvironmentVariables{}
+		(*v1alpha1.WasmExtensionEnvironmentVariables)(env).DeepCopyInto(dst.Spec.EnvironmentVariables)
+	}
+	dst.Spec.CapabilityRestrictionConfig = nil
+	if cr := in.Spec.CapabilityRestriction; cr != nil {
+		dst.Spec.CapabilityRestrictionConfig = &v1alpha1.WasmExtensionCapabilityRestrictionConfig{}
+		(*v1alpha1.WasmExtensionCapabilityRestrictionConfig)(cr).DeepCopyInto(dst.Spec.CapabilityRestrictionConfig)
+	}
+
+	dst.Spec.PluginConfiguration = convertConfigurationTo(in.Spec.PluginConfiguration)
+	dst.Spec.VMConfiguration = convertConfigurationTo(in.Spec.VM.Configuration)
+	dst.Status = v1alpha1.WasmExtensionStatus{}
+	return convertStatus(&in.Status, &dst.Status)
+}
+
+// ConvertFrom converts from the Hub version (v1alpha1) to this version
+func (in *WasmExtension) ConvertFrom(hub conversion.Hub) error {
+	src := hub.(*v1alpha1.WasmExtension)
+	src.ObjectMeta.DeepCopyInto(&in.ObjectMeta)
+
+	if err := convertImageFrom(&src.Spec.Image, src.Spec.BuiltinPlugin, &in.Spec.Image); err != nil {
+		return err
+	}
+	in.Spec.VM.ID = src.Spec.VMID
+	in.Spec.VM.Runtime = src.Spec.Runtime
+	in.Spec.RootID = src.Spec.RootID
+	in.Spec.PluginName = src.Spec.PluginName
+	in.Spec.FailOpen = src.Spec.FailOpen
+	in.Spec.VM.AllowPrecompiled = copyBool(src.Spec.AllowPrecompiled)
+	in.Spec.VM.NackOnCodeCacheMiss = src.Spec.NackOnCodeCacheMiss
+	in.Spec.VM.EnvironmentVariables = nil
+	if env := src.Spec.EnvironmentVariables; env != nil {
+		in.Spec.VM.EnvironmentVariables = &EnvironmentVariables{}
+		(*EnvironmentVariables)(env).DeepCopyInto(in.Spec.VM.EnvironmentVariables)
+	}
+	in.Spec.CapabilityRestriction = nil
+	if cr := src.Spec.CapabilityRestrictionConfig; cr != nil {
+		in.Spec.CapabilityRestriction = &CapabilityRestriction{}
+		(*CapabilityRestriction)(cr).DeepCopyInto(in.Spec.CapabilityRestriction)
+	}
+	in.Spec.PluginConfiguration = convertConfigurationFrom(src.Spec.PluginConfiguration)
+	in.Spec.VM.Configuration = convertConfigurationFrom(src.Spec.VMConfiguration)
+	in.Status = WasmExtensionStatus{}
+	return convertStatus(&src.Status, &in.Status)
+}
+
+// convertImageTo converts the image, whose location is left empty for the builtin plugins unless also specified
+func convertImageTo(src *WasmExtensionImage, dst *v1alpha1.WasmExtensionSpecImage) error {
+	*dst = v1alpha1.WasmExtensionSpecImage{}
+	if src.OCI != nil || src.S3 != nil || src.HTTP != nil || src.LocalFS != nil || src.Builtin == nil {
+		if err := convertLocationTo(src.OCI, src.S3, src.HTTP, src.LocalFS, dst); err != nil {
+			return err
+		}
+	}
+
+	if src.Sha256 != nil {
+		sha := *src.Sha256
+		dst.Sha256 = &sha
+	}
+	if src.Signature != nil {
+		sig := *src.Signature
+		dst.Signature = &sig
+	}
+
+	for _, s := range src.Sources {
+		var image v1alpha1.WasmExtensionSpecImage
+		if err := convertLocationTo(s.OCI, s.S3, s.HTTP, s.LocalFS, &image); err != nil {
+			return err
+		}
+		dst.Sources = append(dst.Sources, v1alpha1.WasmExtensionImageSource{URI: image.URI, Protocol: image.Protocol})
+	}
+
+	dst.Retry = (*v1alpha1.WasmExtensionImageRetry)(src.Retry.DeepCopy())
+	if src.FetchTimeout != nil {
+		timeout := *src.FetchTimeout
+		dst.FetchTimeout = &timeout
+	}
+	return nil
+}
+
+func convertLocationTo(oci *OCIImageSource, s3 *S3ImageSource, http *HTTPImageSource, localFS *LocalFSImageSource,
+	dst *v1alpha1.WasmExtensionSpecImage) error {
+	switch {
+	case oci != nil:
+		dst.Protocol = v1alpha1.ProtocolOCIImageRegistry
+		dst.URI = oci.Reference
+		dst.DigestPolicy = oci.DigestPolicy
+		dst.VersionConstraint = oci.VersionConstraint
+	case s3 != nil:
+		dst.Protocol = v1alpha1.ProtocolS3
+		dst.URI = fmt.Sprintf("%s/%s", s3.Bucket, s3.Key)
+	case http != nil:
+		if strings.HasPrefix(http.URL, "https://") {
+			dst.Protocol = v1alpha1.ProtocolHttps
+			dst.URI = strings.TrimPrefix(http.URL, "https://")
+		} else if strings.HasPrefix(http.URL, "http://") {
+			dst.Protocol = v1alpha1.ProtocolHttp
+			dst.URI = strings.TrimPrefix(http.URL, "http://")
+		} else {
+			return fmt.Errorf("the scheme of %s must be either http or https", http.URL)
+		}
+	case localFS != nil:
+		dst.Protocol = v1alpha1.ProtocolLocalFileSystem
+		dst.URI = localFS.Path
+	default:
+		return fmt.Errorf("no image source specified")
+	}
+	return nil
+}
+
+// convertImageFrom converts the image along with the builtin plugin, if not empty,
+// whose image has no location unless the uri is also specified
+func convertImageFrom(src *v1alpha1.WasmExtensionSpecImage, builtin string, dst *WasmExtensionImage) error {
+	*dst = WasmExtensionImage{}
+	if builtin != "" {
+		dst.Builtin = &BuiltinImageSource{Name: builtin}
+	}
+	if builtin == "" || src.URI != "" {
+		if err := convertLocationFrom(src, dst); err != nil {
+			return err
+		}
+	}
+
+	if src.Sha256 != nil {
+		sha := *src.Sha256
+		dst.Sha256 = &sha
+	}
+	if src.Signature != nil {
+		sig := *src.Signature
+		dst.Signature = &sig
+	}
+
+	for _, s := range src.Sources {
+		var image WasmExtensionImage
+		if err := convertLocationFrom(&v1alpha1.WasmExtensionSpecImage{URI: s.URI, Protocol: s.Protocol}, &image); err != nil {
+			return err
+		}
+		dst.Sources = append(dst.Sources, ImageSource{OCI: image.OCI, S3: image.S3, HTTP: image.HTTP, LocalFS: image.LocalFS})
+	}
+
+	dst.Retry = (*ImageRetry)(src.Retry.DeepCopy())
+	if src.FetchTimeout != nil {
+		timeout := *src.FetchTimeout
+		dst.FetchTimeout = &timeout
+	}
+	return nil
+}
+
+func convertLocationFrom(src *v1alpha1.WasmExtensionSpecImage, dst *WasmExtensionImage) error {
+	switch src.Protocol {
+	case v1alpha1.ProtocolOCIImageRegistry, "":
+		dst.OCI = &OCIImageSource{
+			Reference: src.URI, DigestPolicy: src.DigestPolicy, VersionConstraint: src.VersionConstraint,
+		}
+	case v1alpha1.ProtocolS3:
+		u := strings.SplitN(src.URI, "/", 2)
+		dst.S3 = &S3ImageSource{Bucket: u[0]}
+		if len(u) == 2 {
+			dst.S3.Key = u[1]
+		}
+	case v1alpha1.ProtocolHttp, v1alpha1.ProtocolHttps:
+		dst.HTTP = &HTTPImageSource{URL: fmt.Sprintf("%s://%s", src.Protocol, src.URI)}
+	case v1alpha1.ProtocolLocalFileSystem:
+		dst.LocalFS = &LocalFSImageSource{Path: src.URI}
+	default:
+		return fmt.Errorf("unsupported protocol: %s", src.Protocol)
+	}
+	return nil
+}
+
+func convertConfigurationTo(src *WasmExtensionConfiguration) *v1alpha1.WasmExtensionConfigValue {
+	if src == nil {
+		return nil
+	}
+
+	dst := &v1alpha1.WasmExtensionConfigValue{Encoding: src.Encoding}
+	if src.Value != nil {
+		v := *src.Value
+		dst.Value = &v
+	}
+	if src.Object != nil {
+		dst.Object = src.Object.DeepCopy()
+	}
+	if from := src.ValueFrom; from != nil {
+		dst.ValueFrom = &v1alpha1.WasmExtensionConfigValueRef{
+			SecretKeyRef:    convertKeyReferenceTo(from.SecretKeyRef),
+			ConfigMapKeyRef: convertKeyReferenceTo(from.ConfigMapKeyRef),
+		}
+	}
+	return dst
+}
+
+func convertConfigurationFrom(src *v1alpha1.WasmExtensionConfigValue) *WasmExtensionConfiguration {
+	if src == nil {
+		return nil
+	}
+
+	dst := &WasmExtensionConfiguration{Encoding: src.Encoding}
+	if src.Value != nil {
+		v := *src.Value
+		dst.Value = &v
+	}
+	if src.Object != nil {
+		dst.Object = src.Object.DeepCopy()
+	}
+	if from := src.ValueFrom; from != nil {
+		dst.ValueFrom = &WasmExtensionConfigurationSource{
+			SecretKeyRef:    convertKeyReferenceFrom(from.SecretKeyRef),
+			ConfigMapKeyRef: convertKeyReferenceFrom(from.ConfigMapKeyRef),
+		}
+	}
+	return dst
+}
+
+func copyBool(b *bool) *bool {
+	if b == nil {
+		return nil
+	}
+	v := *b
+	return &v
+}
+
+func convertKeyReferenceTo(src *KeyReference) *v1alpha1.WasmExtensionConfigValueRefAttribute {
+	if src == nil {
+		return nil
+	}
+	return &v1alpha1.WasmExtensionConfigValueRefAttribute{Name: src.Name, Namespace: src.Namespace, Key: src.Key}
+}
+
+func convertKeyReferenceFrom(src *v1alpha1.WasmExtensionConfigValueRefAttribute) *KeyReference {
+	if src == nil {
+		return nil
+	}
+	return &KeyReference{Name: src.Name, Namespace: src.Namespace, Key: src.Key}
+}
+
+// convertStatus converts the status between versions into the zero dst. The status has the same shape in all versions,
+// so this goes through JSON rather than copying each field.
+func convertStatus(src, dst interface{}) error {
+	raw, err := json.Marshal(src)
+	if err != nil {
+		return fmt.Errorf("failed to marshal status: %w", err)
+	}
+	if err := json.Unmarshal(raw, dst); err != nil {
+		return fmt.Errorf("failed to unmarshal status: %w", err)
+	}
+	return nil
+}
@@ -0,0 +1,292 @@
+package v1alpha2
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+func strPtr(s string) *string {
+	return &s
+}
+
+func TestWasmExtension_ConvertTo(t *testing.T) {
+	for _, c := range []struct {
+		name     string
+		image    WasmExtensionImage
+		protocol string
+		uri      string
+	}{
+		{
+			name:     "oci",
+			image:    WasmExtensionImage{OCI: &OCIImageSource{Reference: "webassemblyhub.io/mathetake/example:v0.1"}},
+			protocol: v1alpha1.ProtocolOCIImageRegistry,
+			uri:      "webassemblyhub.io/mathetake/example:v0.1",
+		},
+		{
+			name: "oci with version constraint",
+			image: WasmExtensionImage{OCI: &OCIImageSource{
+				Reference: "webassemblyhub.io/mathetake/example", VersionConstraint: "~0.1",
+			}},
+			protocol: v1alpha1.ProtocolOCIImageRegistry,
+			uri:      "webassemblyhub.io/mathetake/example",
+		},
+		{
+			name: "sources",
+			image: WasmExtensionImage{
+				OCI:    &OCIImageSource{Reference: "webassemblyhub.io/mathetake/example:v0.1"},
+				Sha256: strPtr("039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81"),
+				Sources: []ImageSource{
+					{OCI: &OCIImageSource{Reference: "ghcr.io/mathetake/example:v0.1"}},
+					{HTTP: &HTTPImageSource{URL: "https://example.com/filter.wasm"}},
+				},
+			},
+			protocol: v1alpha1.ProtocolOCIImageRegistry,
+			uri:      "webassemblyhub.io/mathetake/example:v0.1",
+		},
+		{
+			name:     "s3",
+			image:    WasmExtensionImage{S3: &S3ImageSource{Bucket: "bucket", Key: "path/to/filter.wasm"}},
+			protocol: v1alpha1.ProtocolS3,
+			uri:      "bucket/path/to/filter.wasm",
+		},
+		{
+			name:     "http",
+			image:    WasmExtensionImage{HTTP: &HTTPImageSource{URL: "http://example.com/filter.wasm"}},
+			protocol: v1alpha1.ProtocolHttp,
+			uri:      "example.com/filter.wasm",
+		},
+		{
+			name:     "https",
+			image:    WasmExtensionImage{HTTP: &HTTPImageSource{URL: "https://example.com/filter.wasm"}},
+			protocol: v1alpha1.ProtocolHttps,
+			uri:      "example.com/filter.wasm",
+		},
+		{
+			name:     "local_fs",
+			image:    WasmExtensionImage{LocalFS: &LocalFSImageSource{Path: "filter.wasm"}},
+			protocol: v1alpha1.ProtocolLocalFileSystem,
+			uri:      "filter.wasm",
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			src := &WasmExtension{Spec: WasmExtensionSpec{Image: c.image}}
+			hub := &v1alpha1.WasmExtension{}
+			require.NoError(t, src.ConvertTo(hub))
+			assert.Equal(t, c.protocol, hub.Spec.Image.Protocol)
+			assert.Equal(t, c.uri, hub.Spec.Image.URI)
+
+			dst := &WasmExtension{}
+			require.NoError(t, dst.ConvertFrom(hub))
+			assert.Equal(t, src, dst)
+		})
+	}
+
+	t.Run("invalid http scheme", func(t *testing.T) {
+		src := &WasmExtension{Spec: WasmExtensionSpec{Image: WasmExtensionImage{
+			HTTP: &HTTPImageSource{URL: "ftp://example.com/filter.wasm"},
+		}}}
+		assert.Error(t, src.ConvertTo(&v1alpha1.WasmExtension{}))
+	})
+}
+
+func TestWasmExtension_RoundTrip(t *testing.T) {
+	allowPrecompiled := false
+	src := &WasmExtension{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "ext",
+			Namespace:   "default",
+			Annotations: map[string]string{"foo": "bar"},
+		},
+		Spec: WasmExtensionSpec{
+			Image: WasmExtensionImage{
+				OCI:          &OCIImageSource{Reference: "webassemblyhub.io/mathetake/example:v0.1"},
+				Sha256:       strPtr("039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81"),
+				Signature:    strPtr("c2lnbmF0dXJl"),
+				Retry:        &ImageRetry{MaxInterval: &metav1.Duration{Duration: time.Minute}},
+				FetchTimeout: &metav1.Duration{Duration: 5 * time.Minute},
+			},
+			VM: WasmExtensionVM{
+				ID:                  "vm",
+				Runtime:             v1alpha1.RuntimeV8,
+				AllowPrecompiled:    &allowPrecompiled,
+				NackOnCodeCacheMiss: true,
+				EnvironmentVariables: &EnvironmentVariables{
+					HostEnvKeys: []string{"HOSTNAME"},
+					KeyValues:   map[string]string{"FOO": "bar"},
+				},
+				Configuration: &WasmExtensionConfiguration{
+					Object:   &apiextensionsv1.JSON{Raw: []byte(`{"foo":["bar",1]}`)},
+					Encoding: v1alpha1.ConfigEncodingStruct,
+				},
+			},
+			RootID:     "root",
+			PluginName: "plugin",
+			FailOpen:   true,
+			CapabilityRestriction: &CapabilityRestriction{
+				AllowedCapabilities: []string{"proxy_log"},
+			},
+			PluginConfiguration: &WasmExtensionConfiguration{
+				ValueFrom: &WasmExtensionConfigurationSource{
+					SecretKeyRef: &KeyReference{Name: "secret", Namespace: "default", Key: "key"},
+				},
+			},
+		},
+		Status: WasmExtensionStatus{
+			ObservedGeneration: 3,
+			Sha256:             "039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81",
+			Conditions: []WasmExtensionCondition{{
+				Type:    ConditionReady,
+				Status:  corev1.ConditionTrue,
+				Reason:  "Published",
+				Message: "published",
+			}},
+		},
+	}
+
+	hub := &v1alpha1.WasmExtension{}
+	require.NoError(t, src.ConvertTo(hub))
+	assert.Equal(t, `{"foo":["bar",1]}`, string(hub.Spec.VMConfiguration.Object.Raw))
+	assert.Equal(t, v1alpha1.ConfigEncodingStruct, hub.Spec.VMConfiguration.Encoding)
+	assert.Equal(t, "secret", hub.Spec.PluginConfiguration.ValueFrom.SecretKeyRef.Name)
+	assert.Equal(t, src.Status.Sha256, hub.Status.Sha256)
+	assert.Equal(t, map[string]string{"FOO": "bar"}, hub.Spec.EnvironmentVariables.KeyValues)
+	assert.Equal(t, []string{"proxy_log"}, hub.Spec.CapabilityRestrictionConfig.AllowedCapabilities)
+	assert.False(t, *hub.Spec.AllowPrecompiled)
+	assert.Equal(t, time.Minute, hub.Spec.Image.Retry.MaxInterval.Duration)
+	assert.Equal(t, 5*time.Minute, hub.Spec.Image.FetchTimeout.Duration)
+	assert.Equal(t, "c2lnbmF0dXJl", *hub.Spec.Image.Signature)
+
+	dst := &WasmExtension{}
+	require.NoError(t, dst.ConvertFrom(hub))
+	assert.Equal(t, src, dst)
+
+	t.Run("from v1alpha1", func(t *testing.T) {
+		hub := &v1alpha1.WasmExtension{
+			ObjectMeta: metav1.ObjectMeta{Name: "ext", Namespace: "default"},
+			Spec: v1alpha1.WasmExtensionSpec{
+				Image:               v1alpha1.WasmExtensionSpecImage{URI: "bucket/filter.wasm", Protocol: v1alpha1.ProtocolS3},
+				VMID:                "vm",
+				RootID:              "root",
+				PluginConfiguration: &v1alpha1.WasmExtensionConfigValue{Value: strPtr(`{"not":"structured"}`)},
+				Runtime:             v1alpha1.RuntimeWasmtime,
+			},
+		}
+
+		spoke := &WasmExtension{}
+		require.NoError(t, spoke.ConvertFrom(hub))
+		assert.Nil(t, spoke.Spec.PluginConfiguration.Object)
+		assert.Equal(t, `{"not":"structured"}`, *spoke.Spec.PluginConfiguration.Value)
+
+		actual := &v1alpha1.WasmExtension{}
+		require.NoError(t, spoke.ConvertTo(actual))
+		assert.Equal(t, hub, actual)
+	})
+
+	t.Run("builtin", func(t *testing.T) {
+		src := &WasmExtension{Spec: WasmExtensionSpec{
+			Image: WasmExtensionImage{Builtin: &BuiltinImageSource{Name: "envoy.wasm.stats"}},
+			VM:    WasmExtensionVM{ID: "vm", Runtime: v1alpha1.RuntimeNull},
+		}}
+		hub := &v1alpha1.WasmExtension{}
+		require.NoError(t, src.ConvertTo(hub))
+		assert.Equal(t, "envoy.wasm.stats", hub.Spec.BuiltinPlugin)
+		assert.Equal(t, v1alpha1.WasmExtensionSpecImage{}, hub.Spec.Image)
+
+		dst := &WasmExtension{}
+		require.NoError(t, dst.ConvertFrom(hub))
+		assert.Equal(t, src, dst)
+	})
+
+	t.Run("builtin with image", func(t *testing.T) {
+		for _, image := range []WasmExtensionImage{
+			{
+				Builtin:      &BuiltinImageSource{Name: "envoy.wasm.stats"},
+				Sha256:       strPtr("039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81"),
+				Sources:      []ImageSource{{HTTP: &HTTPImageSource{URL: "https://example.com/filter.wasm"}}},
+				Retry:        &ImageRetry{MaxInterval: &metav1.Duration{Duration: time.Minute}},
+				FetchTimeout: &metav1.Duration{Duration: 5 * time.Minute},
+			},
+			{
+				Builtin: &BuiltinImageSource{Name: "envoy.wasm.stats"},
+				OCI:     &OCIImageSource{Reference: "webassemblyhub.io/mathetake/example:v0.1"},
+				Sha256:  strPtr("039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81"),
+			},
+		} {
+			src := &WasmExtension{Spec: WasmExtensionSpec{Image: image}}
+			hub := &v1alpha1.WasmExtension{}
+			require.NoError(t, src.ConvertTo(hub))
+			assert.Equal(t, "envoy.wasm.stats", hub.Spec.BuiltinPlugin)
+			assert.Equal(t, *image.Sha256, *hub.Spec.Image.Sha256)
+
+			dst := &WasmExtension{}
+			require.NoError(t, dst.ConvertFrom(hub))
+			assert.Equal(t, src, dst)
+		}
+	})
+
+	t.Run("status", func(t *testing.T) {
+		src := &WasmExtension{
+			Spec: WasmExtensionSpec{Image: WasmExtensionImage{LocalFS: &LocalFSImageSource{Path: "filter.wasm"}}},
+			Status: WasmExtensionStatus{
+				ObservedGeneration:         2,
+				Sha256:                     "039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81",
+				PluginConfigurationVersion: "10",
+				VMConfigurationVersion:     "11",
+				ImageDigest:                "sha256:2a7bd3e2b0a6b1d7e0e1f0f9b4c5a3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b6",
+				LockedImage: &WasmExtensionLockedImage{
+					URI:    "webassemblyhub.io/mathetake/example:v0.1",
+					Digest: "sha256:2a7bd3e2b0a6b1d7e0e1f0f9b4c5a3d2e1f0a9b8c7d6e5f4a3b2c1d0e9f8a7b6",
+				},
+				ResolvedTag:  "v0.1",
+				ServedSource: &WasmExtensionServedSource{URI: "filter.wasm", Protocol: v1alpha1.ProtocolLocalFileSystem},
+				Effective: &WasmExtensionEffectiveValues{
+					VMID: "vm", RootID: "root", Runtime: v1alpha1.RuntimeV8, PluginConfigurationSource: "merged",
+				},
+				Conditions: []WasmExtensionCondition{{
+					Type:               ConditionReady,
+					Status:             corev1.ConditionFalse,
+					LastTransitionTime: metav1.NewTime(time.Unix(1600000000, 0)),
+					Reason:             "FetchFailed",
+					Message:            "not found",
+				}},
+			},
+		}
+
+		// the stale fields of the destinations are not carried over
+		hub := &v1alpha1.WasmExtension{Status: v1alpha1.WasmExtensionStatus{
+			ResolvedTag:  "v0.0",
+			ServedSource: &v1alpha1.WasmExtensionImageSource{URI: "stale.wasm"},
+			Conditions:   []v1alpha1.WasmExtensionCondition{{Type: v1alpha1.ConditionReady}, {Type: v1alpha1.ConditionReady}},
+		}}
+		require.NoError(t, src.ConvertTo(hub))
+		assert.Equal(t, "v0.1", hub.Status.ResolvedTag)
+		assert.Equal(t, "filter.wasm", hub.Status.ServedSource.URI)
+		assert.Len(t, hub.Status.Conditions, 1)
+
+		dst := &WasmExtension{Status: WasmExtensionStatus{
+			Effective: &WasmExtensionEffectiveValues{VMID: "stale"},
+		}}
+		require.NoError(t, dst.ConvertFrom(hub))
+		assert.Equal(t, src.Status.Effective, dst.Status.Effective)
+		assert.Equal(t, src, dst)
+
+		hub.Status.LockedImage = nil
+		require.NoError(t, dst.ConvertFrom(hub))
+		assert.Nil(t, dst.Status.LockedImage)
+	})
+
+	t.Run("unsupported protocol", func(t *testing.T) {
+		hub := &v1alpha1.WasmExtension{}
+		hub.Spec.Image.Protocol = "ftp"
+		assert.Error(t, (&WasmExtension{}).ConvertFrom(hub))
+	})
+}
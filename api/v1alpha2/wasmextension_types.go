@@ -0,0 +1,303 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha2
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// WasmExtensionSpec defines the desired state of WasmExtension
+type WasmExtensionSpec struct {
+	Image WasmExtensionImage `json:"image"`
+	VM    WasmExtensionVM    `json:"vm"`
+	// RootID defaults to the one in the image metadata, and is required for the null runtime
+	// +optional
+	RootID string `json:"rootID,omitempty"`
+	// +optional
+	PluginConfiguration *WasmExtensionConfiguration `json:"pluginConfiguration,omitempty"`
+	// PluginName is the name of the plugin which is independent of vm.id, and is used in Envoy's logs and stats
+	// +optional
+	PluginName string `json:"pluginName,omitempty"`
+	// FailOpen lets requests pass through instead of being rejected when the plugin fails
+	// +optional
+	FailOpen bool `json:"failOpen,omitempty"`
+	// +optional
+	CapabilityRestriction *CapabilityRestriction `json:"capabilityRestriction,omitempty"`
+}
+
+type CapabilityRestriction struct {
+	// AllowedCapabilities is the list of ABI functions which the plugin is allowed to call.
+	// All capabilities are allowed when this is empty.
+	// +optional
+	AllowedCapabilities []string `json:"allowedCapabilities,omitempty"`
+}
+
+// WasmExtensionImage specifies where to fetch the Wasm binary. Exactly one of the sources must be set.
+type WasmExtensionImage struct {
+	// +optional
+	OCI *OCIImageSource `json:"oci,omitempty"`
+	// +optional
+	S3 *S3ImageSource `json:"s3,omitempty"`
+	// +optional
+	HTTP *HTTPImageSource `json:"http,omitempty"`
+	// +optional
+	LocalFS *LocalFSImageSource `json:"localFS,omitempty"`
+	// Builtin is the plugin compiled into Envoy, and can only be used with the null runtime
+	// +optional
+	Builtin *BuiltinImageSource `json:"builtin,omitempty"`
+	// Sha256 is the expected sha256 value of the Wasm binary
+	// +optional
+	Sha256 *string `json:"sha256,omitempty"`
+	// Signature is the base64 encoded ed25519 signature of the Wasm binary,
+	// verified with the signatureKeys of the WasmExtensionPolicies applying to the extension
+	// +optional
+	Signature *string `json:"signature,omitempty"`
+	// Sources are the fallbacks tried in order when fetching from the source above fails or times out,
+	// e.g. the mirrors in other registries or S3 regions. sha256 is required with sources as they all must serve the same binary.
+	// +optional
+	Sources []ImageSource `json:"sources,omitempty"`
+	// Retry is the backoff between the retries of the transient failures such as network errors.
+	// The missing images and the rejected credentials are retried with a backoff of at least 1m up to 30m,
+	// and the permanent failures such as a wrong sha256 are not retried until the extension changes.
+	// +optional
+	Retry *ImageRetry `json:"retry,omitempty"`
+	// FetchTimeout is how long fetching the image from one source can take before falling back to the next,
+	// e.g. for large binaries. Defaults to the -fetch-timeout of the server
+	// +optional
+	FetchTimeout *metav1.Duration `json:"fetchTimeout,omitempty"`
+}
+
+// ImageRetry is the exponential backoff, which doubles the interval on every failed attempt
+type ImageRetry struct {
+	// InitialInterval is the interval before the first retry. Defaults to 1s
+	// +optional
+	InitialInterval *metav1.Duration `json:"initialInterval,omitempty"`
+	// MaxInterval caps the interval. Defaults to 5m
+	// +optional
+	MaxInterval *metav1.Duration `json:"maxInterval,omitempty"`
+	// MaxAttempts is the number of the attempts after which the failure is regarded as permanent. Unlimited by default
+	// +kubebuilder:validation:Minimum=1
+	// +optional
+	MaxAttempts *int32 `json:"maxAttempts,omitempty"`
+}
+
+// ImageSource is the fallback of WasmExtensionImage. Exactly one of the sources must be set.
+type ImageSource struct {
+	// +optional
+	OCI *OCIImageSource `json:"oci,omitempty"`
+	// +optional
+	S3 *S3ImageSource `json:"s3,omitempty"`
+	// +optional
+	HTTP *HTTPImageSource `json:"http,omitempty"`
+	// +optional
+	LocalFS *LocalFSImageSource `json:"localFS,omitempty"`
+}
+
+type OCIImageSource struct {
+	// Reference is the image reference such as "webassemblyhub.io/mathetake/example:v0.1",
+	// or "webassemblyhub.io/mathetake/example@sha256:<digest>" to pin the manifest digest
+	Reference string `json:"reference"`
+	// DigestPolicy is either "Follow" (default), which follows the tag moves,
+	// or "Lock", which pins the manifest digest first resolved until the policy is changed or the reference is updated
+	// +kubebuilder:validation:Enum=Follow;Lock
+	// +optional
+	DigestPolicy string `json:"digestPolicy,omitempty"`
+	// VersionConstraint is the semantic version range, e.g. "~1.4" or ">= 1.2, < 2.0", for which the highest
+	// satisfying tag of the repository in reference is served and periodically checked again.
+	// The reference must not have a tag or digest then. The range can also be given as the tag, e.g. "ghcr.io/foo/bar:~1.4".
+	// +optional
+	VersionConstraint string `json:"versionConstraint,omitempty"`
+}
+
+type S3ImageSource struct {
+	Bucket string `json:"bucket"`
+	Key    string `json:"key"`
+}
+
+type HTTPImageSource struct {
+	// URL must start with either "http://" or "https://"
+	URL string `json:"url"`
+}
+
+type LocalFSImageSource struct {
+	Path string `json:"path"`
+}
+
+type BuiltinImageSource struct {
+	// Name is the name under which the plugin is registered in Envoy
+	Name string `json:"name"`
+}
+
+type WasmExtensionVM struct {
+	// ID defaults to the one in the image metadata, and is required for the null runtime
+	// +optional
+	ID string `json:"id,omitempty"`
+	// Runtime defaults to the one in the image metadata, or v8
+	// +optional
+	Runtime string `json:"runtime,omitempty"`
+	// +optional
+	Configuration *WasmExtensionConfiguration `json:"configuration,omitempty"`
+	// AllowPrecompiled allows Envoy to use the precompiled code embedded in the binary. Defaults to true.
+	// +optional
+	AllowPrecompiled *bool `json:"allowPrecompiled,omitempty"`
+	// NackOnCodeCacheMiss makes Envoy reject the configuration instead of fetching the code asynchronously
+	// +optional
+	NackOnCodeCacheMiss bool `json:"nackOnCodeCacheMiss,omitempty"`
+	// +optional
+	EnvironmentVariables *EnvironmentVariables `json:"environmentVariables,omitempty"`
+}
+
+// EnvironmentVariables are exposed to the VM via WASI
+type EnvironmentVariables struct {
+	// HostEnvKeys are the names of Envoy's environment variables passed to the VM
+	// +optional
+	HostEnvKeys []string `json:"hostEnvKeys,omitempty"`
+	// KeyValues are the explicitly given environment variables
+	// +optional
+	KeyValues map[string]string `json:"keyValues,omitempty"`
+}
+
+// WasmExtensionConfiguration holds a configuration passed to the Wasm VM or plugin.
+// Exactly one of the fields must be set.
+type WasmExtensionConfiguration struct {
+	// Value is the configuration as is
+	// +optional
+	Value *string `json:"value,omitempty"`
+	// Object is the structured configuration which is passed as JSON
+	// +optional
+	Object *apiextensionsv1.JSON `json:"object,omitempty"`
+	// +optional
+	ValueFrom *WasmExtensionConfigurationSource `json:"valueFrom,omitempty"`
+	// Encoding specifies how the configuration is encoded in Envoy's configuration field:
+	// "string" for google.protobuf.StringValue (default), "struct" for google.protobuf.Struct
+	// which requires the configuration to be a JSON object, and "bytes" for google.protobuf.BytesValue.
+	// +kubebuilder:validation:Enum=string;struct;bytes
+	// +optional
+	Encoding string `json:"encoding,omitempty"`
+}
+
+type WasmExtensionConfigurationSource struct {
+	// +optional
+	SecretKeyRef *KeyReference `json:"secretKeyRef,omitempty"`
+	// +optional
+	ConfigMapKeyRef *KeyReference `json:"configMapKeyRef,omitempty"`
+}
+
+type KeyReference struct {
+	Name      string `json:"name"`
+	Namespace string `json:"namespace"`
+	Key       string `json:"key"`
+}
+
+// WasmExtensionStatus defines the observed state of WasmExtension
+type WasmExtensionStatus struct {
+	// ObservedGeneration is the generation of the spec most recently reconciled
+	ObservedGeneration int64 `json:"observedGeneration,omitempty"`
+	// Sha256 is the sha256 value of the Wasm binary currently served
+	Sha256 string `json:"sha256,omitempty"`
+	// PluginConfigurationVersion is the resource version of the ConfigMap or Secret
+	// which the plugin configuration was last resolved from
+	PluginConfigurationVersion string `json:"pluginConfigurationVersion,omitempty"`
+	// VMConfigurationVersion is the resource version of the ConfigMap or Secret
+	// which the vm configuration was last resolved from
+	VMConfigurationVersion string `json:"vmConfigurationVersion,omitempty"`
+	// ImageDigest is the manifest digest of the OCI image currently served
+	ImageDigest string `json:"imageDigest,omitempty"`
+	// LockedImage is the digest pinned by the Lock digest policy
+	LockedImage *WasmExtensionLockedImage `json:"lockedImage,omitempty"`
+	// ResolvedTag is the tag currently served which the version constraint was resolved to
+	ResolvedTag string `json:"resolvedTag,omitempty"`
+	// ServedSource is where the binary currently served was fetched from,
+	// which is either the image, one of the sources or the registry mirror of them
+	ServedSource *WasmExtensionServedSource `json:"servedSource,omitempty"`
+	// Effective is the values currently served after the defaults in the image metadata are applied
+	Effective  *WasmExtensionEffectiveValues `json:"effective,omitempty"`
+	Conditions []WasmExtensionCondition      `json:"conditions,omitempty"`
+}
+
+// WasmExtensionServedSource is in the same form as v1alpha1, where the uri is the reference of OCI images,
+// the "<bucket>/<key>" of S3 objects, the url without the scheme of HTTP sources, or the path of local files
+type WasmExtensionServedSource struct {
+	URI      string `json:"uri"`
+	Protocol string `json:"protocol,omitempty"`
+}
+
+type WasmExtensionLockedImage struct {
+	// URI is the reference when the digest was locked. The lock is released when the reference changes.
+	URI    string `json:"uri"`
+	Digest string `json:"digest"`
+}
+
+type WasmExtensionEffectiveValues struct {
+	VMID    string `json:"vmID,omitempty"`
+	RootID  string `json:"rootID,omitempty"`
+	Runtime string `json:"runtime,omitempty"`
+	// PluginConfigurationSource is where the plugin configuration came from: "spec", "image" or "merged"
+	PluginConfigurationSource string `json:"pluginConfigurationSource,omitempty"`
+}
+
+type WasmExtensionConditionType string
+
+const (
+	// ConditionReady indicates that the extension has been served to Envoy via ECDS
+	ConditionReady WasmExtensionConditionType = "Ready"
+)
+
+type WasmExtensionCondition struct {
+	Type               WasmExtensionConditionType `json:"type"`
+	Status             corev1.ConditionStatus     `json:"status"`
+	LastTransitionTime metav1.Time                `json:"lastTransitionTime,omitempty"`
+	Reason             string                     `json:"reason,omitempty"`
+	Message            string                     `json:"message,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+// +kubebuilder:subresource:status
+// served only along with the conversion webhook, which manifests/with-webhook patches in
+// +kubebuilder:unservedversion
+// +kubebuilder:printcolumn:name="Ready",type=string,JSONPath=`.status.conditions[?(@.type=="Ready")].status`
+// +kubebuilder:printcolumn:name="Sha256",type=string,JSONPath=`.status.sha256`,priority=1
+// +kubebuilder:printcolumn:name="Digest",type=string,JSONPath=`.status.imageDigest`,priority=1
+// +kubebuilder:printcolumn:name="Age",type=date,JSONPath=`.metadata.creationTimestamp`
+
+// WasmExtension is the Schema for the wasmextensions API
+type WasmExtension struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+
+	Spec   WasmExtensionSpec   `json:"spec,omitempty"`
+	Status WasmExtensionStatus `json:"status,omitempty"`
+}
+
+// +kubebuilder:object:root=true
+
+// WasmExtensionList contains a list of WasmExtension
+type WasmExtensionList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []WasmExtension `json:"items"`
+}
+
+func init() {
+	SchemeBuilder.Register(&WasmExtension{}, &WasmExtensionList{})
+}
+
+func (in *WasmExtension) Namespaced() string {
+	return fmt.Sprintf("%s/%s", in.Namespace, in.Name)
+}
@@ -0,0 +1,318 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha2
+
+import (
+	"fmt"
+	"strings"
+
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	ctrl "sigs.k8s.io/controller-runtime"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
+
+	"github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+var webhookLog = logf.Log.WithName("webhook").WithName("WasmExtension").WithName("v1alpha2")
+
+// SetupWebhookWithManager registers the admission webhooks for v1alpha2 as well as
+// the conversion webhook between v1alpha2 and the hub (v1alpha1)
+func (in *WasmExtension) SetupWebhookWithManager(mgr ctrl.Manager) error {
+	return ctrl.NewWebhookManagedBy(mgr).
+		For(in).
+		Complete()
+}
+
+// +kubebuilder:webhook:path=/mutate-wasmxds-tetrate-io-v1alpha2-wasmextension,mutating=true,failurePolicy=fail,groups=wasmxds.tetrate.io,resources=wasmextensions,verbs=create;update,versions=v1alpha2,name=mwasmextension.v1alpha2.wasmxds.tetrate.io
+
+var _ webhook.Defaulter = &WasmExtension{}
+
+// Default implements webhook.Defaulter
+func (in *WasmExtension) Default() {
+	webhookLog.Info("default", "name", in.Namespaced())
+	if in.Spec.Image.Sha256 != nil {
+		sha := strings.ToLower(*in.Spec.Image.Sha256)
+		in.Spec.Image.Sha256 = &sha
+	}
+	// the runtime is left empty so that the one in the image metadata can apply
+	in.Spec.VM.Runtime = strings.ToLower(in.Spec.VM.Runtime)
+}
+
+// +kubebuilder:webhook:path=/validate-wasmxds-tetrate-io-v1alpha2-wasmextension,mutating=false,failurePolicy=fail,groups=wasmxds.tetrate.io,resources=wasmextensions,verbs=create;update,versions=v1alpha2,name=vwasmextension.v1alpha2.wasmxds.tetrate.io
+
+var _ webhook.Validator = &WasmExtension{}
+
+// ValidateCreate implements webhook.Validator
+func (in *WasmExtension) ValidateCreate() error {
+	webhookLog.Info("validate create", "name", in.Namespaced())
+	return in.Validate()
+}
+
+// ValidateUpdate implements webhook.Validator
+func (in *WasmExtension) ValidateUpdate(_ runtime.Object) error {
+	webhookLog.Info("validate update", "name", in.Namespaced())
+	return in.Validate()
+}
+
+// ValidateDelete implements webhook.Validator
+func (in *WasmExtension) ValidateDelete() error {
+	return nil
+}
+
+// Validate returns an Invalid error which aggregates all the violations found in the spec
+func (in *WasmExtension) Validate() error {
+	errs := in.Spec.Validate(field.NewPath("spec"))
+	if len(errs) == 0 {
+		return nil
+	}
+	return apierrors.NewInvalid(GroupVersion.WithKind("WasmExtension").GroupKind(), in.Name, errs)
+}
+
+// Validate checks the spec assuming that it has been defaulted
+func (in *WasmExtensionSpec) Validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	vmPath := path.Child("vm")
+	if in.VM.Runtime != "" && !containsString(v1alpha1.SupportedRuntimes, strings.ToLower(in.VM.Runtime)) {
+		errs = append(errs, field.NotSupported(vmPath.Child("runtime"), in.VM.Runtime, v1alpha1.SupportedRuntimes))
+	}
+	// vm.id and rootID can be omitted otherwise as the image metadata may provide them
+	if strings.ToLower(in.VM.Runtime) == v1alpha1.RuntimeNull {
+		if in.RootID == "" {
+			errs = append(errs, field.Required(path.Child("rootID"), "required for the null runtime"))
+		}
+		if in.VM.ID == "" {
+			errs = append(errs, field.Required(vmPath.Child("id"), "required for the null runtime"))
+		}
+	}
+
+	imagePath := path.Child("image")
+	errs = append(errs, in.Image.Validate(imagePath)...)
+	if isNull := strings.ToLower(in.VM.Runtime) == v1alpha1.RuntimeNull; isNull && in.Image.Builtin == nil {
+		errs = append(errs, field.Required(imagePath.Child("builtin"), "required for the null runtime"))
+	} else if !isNull && in.Image.Builtin != nil {
+		errs = append(errs, field.Forbidden(imagePath.Child("builtin"), "only allowed for the null runtime"))
+	}
+
+	if env := in.VM.EnvironmentVariables; env != nil {
+		envPath := vmPath.Child("environmentVariables")
+		for i, key := range env.HostEnvKeys {
+			if key == "" {
+				errs = append(errs, field.Required(envPath.Child("hostEnvKeys").Index(i), ""))
+			} else if _, ok := env.KeyValues[key]; ok {
+				errs = append(errs, field.Duplicate(envPath.Child("keyValues").Key(key), key))
+			}
+		}
+	}
+
+	if in.VM.Configuration != nil {
+		errs = append(errs, in.VM.Configuration.Validate(vmPath.Child("configuration"))...)
+	}
+	if in.PluginConfiguration != nil {
+		errs = append(errs, in.PluginConfiguration.Validate(path.Child("pluginConfiguration"))...)
+	}
+	return errs
+}
+
+func (in *WasmExtensionImage) Validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if in.Sha256 != nil {
+		if err := v1alpha1.ValidateSha256(*in.Sha256); err != nil {
+			errs = append(errs, field.Invalid(path.Child("sha256"), *in.Sha256, err.Error()))
+		}
+	}
+	if in.Signature != nil {
+		if err := v1alpha1.ValidateSignature(*in.Signature); err != nil {
+			errs = append(errs, field.Invalid(path.Child("signature"), *in.Signature, err.Error()))
+		}
+	}
+
+	var sources int
+	if in.OCI != nil {
+		sources++
+		refPath := path.Child("oci", "reference")
+		if vc := in.OCI.VersionConstraint; vc != "" {
+			if err := v1alpha1.ValidateVersionConstraint(vc); err != nil {
+				errs = append(errs, field.Invalid(path.Child("oci", "versionConstraint"), vc, err.Error()))
+			}
+			if err := v1alpha1.ValidateOCIRepository(in.OCI.Reference); err != nil {
+				errs = append(errs, field.Invalid(refPath, in.OCI.Reference, err.Error()))
+			}
+		} else if err := v1alpha1.ValidateImageURI(v1alpha1.ProtocolOCIImageRegistry, in.OCI.Reference); err != nil {
+			errs = append(errs, field.Invalid(refPath, in.OCI.Reference, err.Error()))
+		}
+		if p := in.OCI.DigestPolicy; p != "" && !containsString(v1alpha1.SupportedDigestPolicies, p) {
+			errs = append(errs, field.NotSupported(path.Child("oci", "digestPolicy"), p, v1alpha1.SupportedDigestPolicies))
+		} else if p == v1alpha1.DigestPolicyLock && isVersionRange(in.OCI) {
+			errs = append(errs, field.Forbidden(path.Child("oci", "digestPolicy"),
+				"Lock cannot be used with a version constraint"))
+		}
+	}
+	if in.S3 != nil {
+		sources++
+		uri := fmt.Sprintf("%s/%s", in.S3.Bucket, in.S3.Key)
+		if err := v1alpha1.ValidateImageURI(v1alpha1.ProtocolS3, uri); err != nil {
+			errs = append(errs, field.Invalid(path.Child("s3"), uri, err.Error()))
+		}
+	}
+	if in.HTTP != nil {
+		sources++
+		if err := validateHTTPURL(in.HTTP.URL); err != nil {
+			errs = append(errs, field.Invalid(path.Child("http", "url"), in.HTTP.URL, err.Error()))
+		}
+	}
+	if in.LocalFS != nil {
+		sources++
+		if in.LocalFS.Path == "" {
+			errs = append(errs, field.Required(path.Child("localFS", "path"), ""))
+		}
+	}
+
+	if in.Builtin != nil {
+		sources++
+		if in.Builtin.Name == "" {
+			errs = append(errs, field.Required(path.Child("builtin", "name"), ""))
+		}
+		if in.Sha256 != nil {
+			errs = append(errs, field.Forbidden(path.Child("sha256"), "cannot be set for builtin plugins"))
+		}
+		if in.Signature != nil {
+			errs = append(errs, field.Forbidden(path.Child("signature"), "cannot be set for builtin plugins"))
+		}
+	}
+
+	if sources == 0 {
+		errs = append(errs, field.Required(path, "one of oci, s3, http, localFS and builtin must be set"))
+	} else if sources > 1 {
+		errs = append(errs, field.Forbidden(path, "only one of oci, s3, http, localFS and builtin can be set"))
+	}
+
+	if len(in.Sources) > 0 {
+		if in.Sha256 == nil && in.Builtin == nil {
+			errs = append(errs, field.Required(path.Child("sha256"), "required with sources"))
+		}
+		if in.Builtin != nil {
+			errs = append(errs, field.Forbidden(path.Child("sources"), "cannot be set for builtin plugins"))
+		} else if in.OCI != nil && isVersionRange(in.OCI) {
+			errs = append(errs, field.Forbidden(path.Child("sources"), "cannot be used with a version constraint"))
+		}
+	}
+	for i, s := range in.Sources {
+		errs = append(errs, s.Validate(path.Child("sources").Index(i))...)
+	}
+	if in.Retry != nil {
+		errs = append(errs, (*v1alpha1.WasmExtensionImageRetry)(in.Retry).Validate(path.Child("retry"))...)
+	}
+	if in.FetchTimeout != nil && in.FetchTimeout.Duration <= 0 {
+		errs = append(errs, field.Invalid(path.Child("fetchTimeout"), in.FetchTimeout.Duration.String(), "must be positive"))
+	}
+	return errs
+}
+
+func (in *ImageSource) Validate(path *field.Path) field.ErrorList {
+	image := WasmExtensionImage{OCI: in.OCI, S3: in.S3, HTTP: in.HTTP, LocalFS: in.LocalFS}
+	errs := image.Validate(path)
+	if in.OCI != nil {
+		if in.OCI.DigestPolicy != "" {
+			errs = append(errs, field.Forbidden(path.Child("oci", "digestPolicy"), "not allowed in sources"))
+		}
+		if isVersionRange(in.OCI) {
+			errs = append(errs, field.Forbidden(path.Child("oci", "reference"), "version constraints are not allowed in sources"))
+		}
+	}
+	return errs
+}
+
+func isVersionRange(oci *OCIImageSource) bool {
+	image := v1alpha1.WasmExtensionSpecImage{URI: oci.Reference, VersionConstraint: oci.VersionConstraint}
+	_, _, ok := image.VersionRange()
+	return ok
+}
+
+func validateHTTPURL(url string) error {
+	if strings.HasPrefix(url, "https://") {
+		return v1alpha1.ValidateImageURI(v1alpha1.ProtocolHttps, strings.TrimPrefix(url, "https://"))
+	} else if strings.HasPrefix(url, "http://") {
+		return v1alpha1.ValidateImageURI(v1alpha1.ProtocolHttp, strings.TrimPrefix(url, "http://"))
+	}
+	return fmt.Errorf("the scheme must be either http or https")
+}
+
+func (in *WasmExtensionConfiguration) Validate(path *field.Path) field.ErrorList {
+	var set int
+	for _, isSet := range []bool{in.Value != nil, in.Object != nil, in.ValueFrom != nil} {
+		if isSet {
+			set++
+		}
+	}
+	if set == 0 {
+		return field.ErrorList{field.Required(path, "one of value, object and valueFrom must be set")}
+	} else if set > 1 {
+		return field.ErrorList{field.Forbidden(path, "only one of value, object and valueFrom can be set")}
+	}
+
+	var errs field.ErrorList
+	if in.Encoding != "" && !containsString(v1alpha1.SupportedConfigEncodings, in.Encoding) {
+		errs = append(errs, field.NotSupported(path.Child("encoding"), in.Encoding, v1alpha1.SupportedConfigEncodings))
+	} else if in.Encoding == v1alpha1.ConfigEncodingStruct {
+		if in.Value != nil && !v1alpha1.IsJSONObject([]byte(*in.Value)) {
+			errs = append(errs, field.Invalid(path.Child("value"), *in.Value,
+				"must be a JSON object for the struct encoding"))
+		} else if in.Object != nil && !v1alpha1.IsJSONObject(in.Object.Raw) {
+			errs = append(errs, field.Invalid(path.Child("object"), string(in.Object.Raw),
+				"must be an object for the struct encoding"))
+		}
+	}
+
+	if in.ValueFrom == nil {
+		return errs
+	}
+
+	path = path.Child("valueFrom")
+	from := in.ValueFrom
+	if from.SecretKeyRef != nil && from.ConfigMapKeyRef != nil {
+		return append(errs, field.Forbidden(path, "only one of secretKeyRef and configMapKeyRef can be set"))
+	} else if from.SecretKeyRef != nil {
+		return append(errs, from.SecretKeyRef.Validate(path.Child("secretKeyRef"))...)
+	} else if from.ConfigMapKeyRef != nil {
+		return append(errs, from.ConfigMapKeyRef.Validate(path.Child("configMapKeyRef"))...)
+	}
+	return append(errs, field.Required(path, "one of secretKeyRef and configMapKeyRef must be set"))
+}
+
+func (in *KeyReference) Validate(path *field.Path) field.ErrorList {
+	var errs field.ErrorList
+	if in.Name == "" {
+		errs = append(errs, field.Required(path.Child("name"), ""))
+	}
+	if in.Namespace == "" {
+		errs = append(errs, field.Required(path.Child("namespace"), ""))
+	}
+	if in.Key == "" {
+		errs = append(errs, field.Required(path.Child("key"), ""))
+	}
+	return errs
+}
+
+func containsString(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
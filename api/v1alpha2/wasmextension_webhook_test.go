@@ -0,0 +1,199 @@
+package v1alpha2
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+func TestWasmExtension_Default(t *testing.T) {
+	ext := &WasmExtension{}
+	ext.Default()
+	// left empty for the image metadata
+	assert.Equal(t, "", ext.Spec.VM.Runtime)
+
+	ext.Spec.VM.Runtime = "Wasmtime"
+	ext.Default()
+	assert.Equal(t, v1alpha1.RuntimeWasmtime, ext.Spec.VM.Runtime)
+}
+
+func TestWasmExtension_Validate(t *testing.T) {
+	valid := func() *WasmExtension {
+		return &WasmExtension{
+			ObjectMeta: metav1.ObjectMeta{Name: "ext", Namespace: "default"},
+			Spec: WasmExtensionSpec{
+				Image: WasmExtensionImage{
+					HTTP: &HTTPImageSource{URL: "https://example.com/filter.wasm"},
+				},
+				VM:     WasmExtensionVM{ID: "vm", Runtime: v1alpha1.RuntimeV8},
+				RootID: "root",
+				PluginConfiguration: &WasmExtensionConfiguration{
+					Object: &apiextensionsv1.JSON{Raw: []byte(`{"foo":"bar"}`)},
+				},
+			},
+		}
+	}
+
+	require.NoError(t, valid().Validate())
+
+	t.Run("defaults from image metadata", func(t *testing.T) {
+		ext := valid()
+		ext.Spec.VM.ID, ext.Spec.VM.Runtime, ext.Spec.RootID = "", "", ""
+		require.NoError(t, ext.Validate())
+	})
+
+	nullRuntime := func(ext *WasmExtension) {
+		ext.Spec.VM.Runtime = v1alpha1.RuntimeNull
+		ext.Spec.Image = WasmExtensionImage{Builtin: &BuiltinImageSource{Name: "envoy.wasm.stats"}}
+	}
+
+	for _, c := range []struct {
+		name   string
+		mutate func(ext *WasmExtension)
+		field  string
+	}{
+		{
+			name:   "null runtime without vm.id",
+			mutate: func(ext *WasmExtension) { nullRuntime(ext); ext.Spec.VM.ID = "" },
+			field:  "spec.vm.id",
+		},
+		{
+			name:   "null runtime without rootID",
+			mutate: func(ext *WasmExtension) { nullRuntime(ext); ext.Spec.RootID = "" },
+			field:  "spec.rootID",
+		},
+		{name: "unknown runtime", mutate: func(ext *WasmExtension) { ext.Spec.VM.Runtime = "v9" }, field: "spec.vm.runtime"},
+		{
+			name:   "no image source",
+			mutate: func(ext *WasmExtension) { ext.Spec.Image.HTTP = nil },
+			field:  "spec.image",
+		},
+		{
+			name: "multiple image sources",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.LocalFS = &LocalFSImageSource{Path: "filter.wasm"}
+			},
+			field: "spec.image",
+		},
+		{
+			name: "http without scheme",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.HTTP.URL = "example.com/filter.wasm"
+			},
+			field: "spec.image.http.url",
+		},
+		{
+			name: "malformed oci reference",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.HTTP = nil
+				ext.Spec.Image.OCI = &OCIImageSource{Reference: "webassemblyhub.io/mathetake/example"}
+			},
+			field: "spec.image.oci.reference",
+		},
+		{
+			name: "sources without sha256",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.Sources = []ImageSource{{HTTP: &HTTPImageSource{URL: "https://example.com/filter.wasm"}}}
+			},
+			field: "spec.image.sha256",
+		},
+		{
+			name: "multiple sources in one",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.Sha256 = strPtr("039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81")
+				ext.Spec.Image.Sources = []ImageSource{{
+					HTTP:    &HTTPImageSource{URL: "https://example.com/filter.wasm"},
+					LocalFS: &LocalFSImageSource{Path: "filter.wasm"},
+				}}
+			},
+			field: "spec.image.sources[0]",
+		},
+		{
+			name: "malformed version constraint",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.HTTP = nil
+				ext.Spec.Image.OCI = &OCIImageSource{Reference: "webassemblyhub.io/mathetake/example", VersionConstraint: "~one"}
+			},
+			field: "spec.image.oci.versionConstraint",
+		},
+		{
+			name: "Lock with version constraint",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.HTTP = nil
+				ext.Spec.Image.OCI = &OCIImageSource{
+					Reference: "webassemblyhub.io/mathetake/example:~0.1", DigestPolicy: v1alpha1.DigestPolicyLock,
+				}
+			},
+			field: "spec.image.oci.digestPolicy",
+		},
+		{
+			name: "builtin without null runtime",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.Image.HTTP = nil
+				ext.Spec.Image.Builtin = &BuiltinImageSource{Name: "envoy.wasm.stats"}
+			},
+			field: "spec.image.builtin",
+		},
+		{
+			name:   "null runtime without builtin",
+			mutate: func(ext *WasmExtension) { ext.Spec.VM.Runtime = v1alpha1.RuntimeNull },
+			field:  "spec.image.builtin",
+		},
+		{
+			name: "duplicated environment variable",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.VM.EnvironmentVariables = &EnvironmentVariables{
+					HostEnvKeys: []string{"FOO"},
+					KeyValues:   map[string]string{"FOO": "bar"},
+				}
+			},
+			field: "spec.vm.environmentVariables.keyValues[FOO]",
+		},
+		{
+			name: "object and value",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.PluginConfiguration.Value = strPtr("value")
+			},
+			field: "spec.pluginConfiguration",
+		},
+		{
+			name: "unsupported encoding",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.PluginConfiguration.Encoding = "yaml"
+			},
+			field: "spec.pluginConfiguration.encoding",
+		},
+		{
+			name: "struct encoding with non-object",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.PluginConfiguration.Object.Raw = []byte(`["foo"]`)
+				ext.Spec.PluginConfiguration.Encoding = v1alpha1.ConfigEncodingStruct
+			},
+			field: "spec.pluginConfiguration.object",
+		},
+		{
+			name: "empty vm configuration",
+			mutate: func(ext *WasmExtension) {
+				ext.Spec.VM.Configuration = &WasmExtensionConfiguration{}
+			},
+			field: "spec.vm.configuration",
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			ext := valid()
+			c.mutate(ext)
+			err := ext.Validate()
+			require.Error(t, err)
+			require.True(t, apierrors.IsInvalid(err))
+			assert.Contains(t, err.Error(), c.field)
+			t.Log(err)
+		})
+	}
+}
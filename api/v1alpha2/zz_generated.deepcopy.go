@@ -0,0 +1,564 @@
+//go:build !ignore_autogenerated
+// +build !ignore_autogenerated
+
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Code generated by controller-gen. DO NOT EDIT.
+
+package v1alpha2
+
+import (
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+)
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *BuiltinImageSource) DeepCopyInto(out *BuiltinImageSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new BuiltinImageSource.
+func (in *BuiltinImageSource) DeepCopy() *BuiltinImageSource {
+	if in == nil {
+		return nil
+	}
+	out := new(BuiltinImageSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *CapabilityRestriction) DeepCopyInto(out *CapabilityRestriction) {
+	*out = *in
+	if in.AllowedCapabilities != nil {
+		in, out := &in.AllowedCapabilities, &out.AllowedCapabilities
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new CapabilityRestriction.
+func (in *CapabilityRestriction) DeepCopy() *CapabilityRestriction {
+	if in == nil {
+		return nil
+	}
+	out := new(CapabilityRestriction)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *EnvironmentVariables) DeepCopyInto(out *EnvironmentVariables) {
+	*out = *in
+	if in.HostEnvKeys != nil {
+		in, out := &in.HostEnvKeys, &out.HostEnvKeys
+		*out = make([]string, len(*in))
+		copy(*out, *in)
+	}
+	if in.KeyValues != nil {
+		in, out := &in.KeyValues, &out.KeyValues
+		*out = make(map[string]string, len(*in))
+		for key, val := range *in {
+			(*out)[key] = val
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new EnvironmentVariables.
+func (in *EnvironmentVariables) DeepCopy() *EnvironmentVariables {
+	if in == nil {
+		return nil
+	}
+	out := new(EnvironmentVariables)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *HTTPImageSource) DeepCopyInto(out *HTTPImageSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new HTTPImageSource.
+func (in *HTTPImageSource) DeepCopy() *HTTPImageSource {
+	if in == nil {
+		return nil
+	}
+	out := new(HTTPImageSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageRetry) DeepCopyInto(out *ImageRetry) {
+	*out = *in
+	if in.InitialInterval != nil {
+		in, out := &in.InitialInterval, &out.InitialInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxInterval != nil {
+		in, out := &in.MaxInterval, &out.MaxInterval
+		*out = new(v1.Duration)
+		**out = **in
+	}
+	if in.MaxAttempts != nil {
+		in, out := &in.MaxAttempts, &out.MaxAttempts
+		*out = new(int32)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageRetry.
+func (in *ImageRetry) DeepCopy() *ImageRetry {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageRetry)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *ImageSource) DeepCopyInto(out *ImageSource) {
+	*out = *in
+	if in.OCI != nil {
+		in, out := &in.OCI, &out.OCI
+		*out = new(OCIImageSource)
+		**out = **in
+	}
+	if in.S3 != nil {
+		in, out := &in.S3, &out.S3
+		*out = new(S3ImageSource)
+		**out = **in
+	}
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(HTTPImageSource)
+		**out = **in
+	}
+	if in.LocalFS != nil {
+		in, out := &in.LocalFS, &out.LocalFS
+		*out = new(LocalFSImageSource)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new ImageSource.
+func (in *ImageSource) DeepCopy() *ImageSource {
+	if in == nil {
+		return nil
+	}
+	out := new(ImageSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *KeyReference) DeepCopyInto(out *KeyReference) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new KeyReference.
+func (in *KeyReference) DeepCopy() *KeyReference {
+	if in == nil {
+		return nil
+	}
+	out := new(KeyReference)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *LocalFSImageSource) DeepCopyInto(out *LocalFSImageSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new LocalFSImageSource.
+func (in *LocalFSImageSource) DeepCopy() *LocalFSImageSource {
+	if in == nil {
+		return nil
+	}
+	out := new(LocalFSImageSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *OCIImageSource) DeepCopyInto(out *OCIImageSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new OCIImageSource.
+func (in *OCIImageSource) DeepCopy() *OCIImageSource {
+	if in == nil {
+		return nil
+	}
+	out := new(OCIImageSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *S3ImageSource) DeepCopyInto(out *S3ImageSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new S3ImageSource.
+func (in *S3ImageSource) DeepCopy() *S3ImageSource {
+	if in == nil {
+		return nil
+	}
+	out := new(S3ImageSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtension) DeepCopyInto(out *WasmExtension) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ObjectMeta.DeepCopyInto(&out.ObjectMeta)
+	in.Spec.DeepCopyInto(&out.Spec)
+	in.Status.DeepCopyInto(&out.Status)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtension.
+func (in *WasmExtension) DeepCopy() *WasmExtension {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtension)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WasmExtension) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionCondition) DeepCopyInto(out *WasmExtensionCondition) {
+	*out = *in
+	in.LastTransitionTime.DeepCopyInto(&out.LastTransitionTime)
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionCondition.
+func (in *WasmExtensionCondition) DeepCopy() *WasmExtensionCondition {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionCondition)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionConfiguration) DeepCopyInto(out *WasmExtensionConfiguration) {
+	*out = *in
+	if in.Value != nil {
+		in, out := &in.Value, &out.Value
+		*out = new(string)
+		**out = **in
+	}
+	if in.Object != nil {
+		in, out := &in.Object, &out.Object
+		*out = new(apiextensionsv1.JSON)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.ValueFrom != nil {
+		in, out := &in.ValueFrom, &out.ValueFrom
+		*out = new(WasmExtensionConfigurationSource)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionConfiguration.
+func (in *WasmExtensionConfiguration) DeepCopy() *WasmExtensionConfiguration {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionConfiguration)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionConfigurationSource) DeepCopyInto(out *WasmExtensionConfigurationSource) {
+	*out = *in
+	if in.SecretKeyRef != nil {
+		in, out := &in.SecretKeyRef, &out.SecretKeyRef
+		*out = new(KeyReference)
+		**out = **in
+	}
+	if in.ConfigMapKeyRef != nil {
+		in, out := &in.ConfigMapKeyRef, &out.ConfigMapKeyRef
+		*out = new(KeyReference)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionConfigurationSource.
+func (in *WasmExtensionConfigurationSource) DeepCopy() *WasmExtensionConfigurationSource {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionConfigurationSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionEffectiveValues) DeepCopyInto(out *WasmExtensionEffectiveValues) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionEffectiveValues.
+func (in *WasmExtensionEffectiveValues) DeepCopy() *WasmExtensionEffectiveValues {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionEffectiveValues)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionImage) DeepCopyInto(out *WasmExtensionImage) {
+	*out = *in
+	if in.OCI != nil {
+		in, out := &in.OCI, &out.OCI
+		*out = new(OCIImageSource)
+		**out = **in
+	}
+	if in.S3 != nil {
+		in, out := &in.S3, &out.S3
+		*out = new(S3ImageSource)
+		**out = **in
+	}
+	if in.HTTP != nil {
+		in, out := &in.HTTP, &out.HTTP
+		*out = new(HTTPImageSource)
+		**out = **in
+	}
+	if in.LocalFS != nil {
+		in, out := &in.LocalFS, &out.LocalFS
+		*out = new(LocalFSImageSource)
+		**out = **in
+	}
+	if in.Builtin != nil {
+		in, out := &in.Builtin, &out.Builtin
+		*out = new(BuiltinImageSource)
+		**out = **in
+	}
+	if in.Sha256 != nil {
+		in, out := &in.Sha256, &out.Sha256
+		*out = new(string)
+		**out = **in
+	}
+	if in.Signature != nil {
+		in, out := &in.Signature, &out.Signature
+		*out = new(string)
+		**out = **in
+	}
+	if in.Sources != nil {
+		in, out := &in.Sources, &out.Sources
+		*out = make([]ImageSource, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+	if in.Retry != nil {
+		in, out := &in.Retry, &out.Retry
+		*out = new(ImageRetry)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.FetchTimeout != nil {
+		in, out := &in.FetchTimeout, &out.FetchTimeout
+		*out = new(v1.Duration)
+		**out = **in
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionImage.
+func (in *WasmExtensionImage) DeepCopy() *WasmExtensionImage {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionList) DeepCopyInto(out *WasmExtensionList) {
+	*out = *in
+	out.TypeMeta = in.TypeMeta
+	in.ListMeta.DeepCopyInto(&out.ListMeta)
+	if in.Items != nil {
+		in, out := &in.Items, &out.Items
+		*out = make([]WasmExtension, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionList.
+func (in *WasmExtensionList) DeepCopy() *WasmExtensionList {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionList)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyObject is an autogenerated deepcopy function, copying the receiver, creating a new runtime.Object.
+func (in *WasmExtensionList) DeepCopyObject() runtime.Object {
+	if c := in.DeepCopy(); c != nil {
+		return c
+	}
+	return nil
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionLockedImage) DeepCopyInto(out *WasmExtensionLockedImage) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionLockedImage.
+func (in *WasmExtensionLockedImage) DeepCopy() *WasmExtensionLockedImage {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionLockedImage)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionServedSource) DeepCopyInto(out *WasmExtensionServedSource) {
+	*out = *in
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionServedSource.
+func (in *WasmExtensionServedSource) DeepCopy() *WasmExtensionServedSource {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionServedSource)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionSpec) DeepCopyInto(out *WasmExtensionSpec) {
+	*out = *in
+	in.Image.DeepCopyInto(&out.Image)
+	in.VM.DeepCopyInto(&out.VM)
+	if in.PluginConfiguration != nil {
+		in, out := &in.PluginConfiguration, &out.PluginConfiguration
+		*out = new(WasmExtensionConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.CapabilityRestriction != nil {
+		in, out := &in.CapabilityRestriction, &out.CapabilityRestriction
+		*out = new(CapabilityRestriction)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionSpec.
+func (in *WasmExtensionSpec) DeepCopy() *WasmExtensionSpec {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionSpec)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionStatus) DeepCopyInto(out *WasmExtensionStatus) {
+	*out = *in
+	if in.LockedImage != nil {
+		in, out := &in.LockedImage, &out.LockedImage
+		*out = new(WasmExtensionLockedImage)
+		**out = **in
+	}
+	if in.ServedSource != nil {
+		in, out := &in.ServedSource, &out.ServedSource
+		*out = new(WasmExtensionServedSource)
+		**out = **in
+	}
+	if in.Effective != nil {
+		in, out := &in.Effective, &out.Effective
+		*out = new(WasmExtensionEffectiveValues)
+		**out = **in
+	}
+	if in.Conditions != nil {
+		in, out := &in.Conditions, &out.Conditions
+		*out = make([]WasmExtensionCondition, len(*in))
+		for i := range *in {
+			(*in)[i].DeepCopyInto(&(*out)[i])
+		}
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionStatus.
+func (in *WasmExtensionStatus) DeepCopy() *WasmExtensionStatus {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionStatus)
+	in.DeepCopyInto(out)
+	return out
+}
+
+// DeepCopyInto is an autogenerated deepcopy function, copying the receiver, writing into out. in must be non-nil.
+func (in *WasmExtensionVM) DeepCopyInto(out *WasmExtensionVM) {
+	*out = *in
+	if in.Configuration != nil {
+		in, out := &in.Configuration, &out.Configuration
+		*out = new(WasmExtensionConfiguration)
+		(*in).DeepCopyInto(*out)
+	}
+	if in.AllowPrecompiled != nil {
+		in, out := &in.AllowPrecompiled, &out.AllowPrecompiled
+		*out = new(bool)
+		**out = **in
+	}
+	if in.EnvironmentVariables != nil {
+		in, out := &in.EnvironmentVariables, &out.EnvironmentVariables
+		*out = new(EnvironmentVariables)
+		(*in).DeepCopyInto(*out)
+	}
+}
+
+// DeepCopy is an autogenerated deepcopy function, copying the receiver, creating a new WasmExtensionVM.
+func (in *WasmExtensionVM) DeepCopy() *WasmExtensionVM {
+	if in == nil {
+		return nil
+	}
+	out := new(WasmExtensionVM)
+	in.DeepCopyInto(out)
+	return out
+}
@@ -0,0 +1,378 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package bundle packs WasmExtensions, the binaries they resolve to and their resolved configurations into one archive
+// for the clusters which can't reach any registry. The archive is a gzipped tarball of
+//
+//	manifest.json          the sha256 of all the other files
+//	manifest.json.sig      the base64 encoded ed25519 signature of manifest.json, if signed
+//	extensions.yaml        the extensions pinned to the sha256 of the binaries, with the configurations inlined
+//	binaries/<sha256>.wasm the binaries
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	"github.com/tetratelabs/wasmxds/manifest"
+	"github.com/tetratelabs/wasmxds/wasmxds"
+)
+
+const (
+	ManifestFile   = "manifest.json"
+	SignatureFile  = "manifest.json.sig"
+	ExtensionsFile = "extensions.yaml"
+	BinariesDir    = "binaries"
+
+	// Version is the version of the bundle format
+	Version = 1
+)
+
+// the limits of each entry and of all the entries read, which apply before the signature is verified
+// so that the unverified bundles can't exhaust the memory. An entry can be as large as the binaries the server accepts.
+var (
+	maxEntrySize  int64 = wasmxds.MaxImageSize
+	maxBundleSize int64 = 1 << 30
+)
+
+var (
+	// ErrUnsigned is returned when the bundle is not signed although the key to verify it is given
+	ErrUnsigned = errors.New("the bundle is not signed")
+	// ErrInvalidSignature is returned when the signature doesn't match the key or the manifest
+	ErrInvalidSignature = errors.New("invalid signature of the bundle")
+)
+
+// Manifest lists the files in the bundle with their sha256, and is what the signature is for
+type Manifest struct {
+	Version int    `json:"version"`
+	Files   []File `json:"files"`
+}
+
+type File struct {
+	Path   string `json:"path"`
+	Sha256 string `json:"sha256"`
+	Size   int    `json:"size"`
+}
+
+// Fetcher returns the binary of the image and the metadata published along with it, which is nil if not found
+type Fetcher func(image *wasmxdsv1alpha1.WasmExtensionSpecImage) ([]byte, *wasmxdsv1alpha1.ImageMetadata, error)
+
+// Bundle is the extensions and the binaries they refer to
+type Bundle struct {
+	// Extensions are pinned to the sha256 of the binaries, and have the configurations and the image metadata resolved
+	Extensions []*wasmxdsv1alpha1.WasmExtension
+	// Binaries are keyed by their sha256
+	Binaries map[string][]byte
+}
+
+// Resolve fetches the binaries of the extensions, and inlines the configurations referenced by valueFrom
+// in configDir (see manifest.ResolveConfigs) and the defaults in the image metadata, which the bundle can't carry
+func Resolve(extensions []*wasmxdsv1alpha1.WasmExtension, fetch Fetcher, configDir string) (*Bundle, error) {
+	ret := &Bundle{Binaries: map[string][]byte{}}
+	for _, ext := range extensions {
+		resolved, err := resolve(ext, fetch, configDir, ret.Binaries)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ext.Namespaced(), err)
+		}
+		ret.Extensions = append(ret.Extensions, resolved)
+	}
+	return ret, nil
+}
+
+func resolve(ext *wasmxdsv1alpha1.WasmExtension, fetch Fetcher, configDir string,
+	binaries map[string][]byte) (*wasmxdsv1alpha1.WasmExtension, error) {
+	pc, vc, err := manifest.ResolveConfigs(configDir, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &wasmxdsv1alpha1.WasmExtension{
+		TypeMeta: metav1.TypeMeta{APIVersion: wasmxdsv1alpha1.GroupVersion.String(), Kind: "WasmExtension"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name: ext.Name, Namespace: ext.Namespace, Labels: ext.Labels, Annotations: ext.Annotations,
+		},
+		Spec: *ext.Spec.DeepCopy(),
+	}
+	ret.Spec.VMConfiguration = inline(ext.Spec.VMConfiguration, vc)
+	ret.Spec.PluginConfiguration = inline(ext.Spec.PluginConfiguration, pc)
+	if strings.ToLower(ext.Spec.Runtime) == wasmxdsv1alpha1.RuntimeNull {
+		return ret, nil
+	}
+
+	binary, metadata, err := fetch(&ext.Spec.Image)
+	if err != nil {
+		return nil, err
+	}
+	sha := sha256Hex(binary)
+	binaries[sha] = binary
+	ret.Spec.Image.Sha256 = &sha
+
+	if ret, pc, err = wasmxds.ApplyImageMetadata(ret, metadata, pc); err != nil {
+		return nil, err
+	}
+	if pc != "" && ret.Spec.PluginConfiguration == nil {
+		// the configuration in the image metadata
+		ret.Spec.PluginConfiguration = &wasmxdsv1alpha1.WasmExtensionConfigValue{}
+	}
+	ret.Spec.PluginConfiguration = inline(ret.Spec.PluginConfiguration, pc)
+	return ret, nil
+}
+
+// inline returns the copy of the configuration with the resolved value in place of the object or the reference
+func inline(cv *wasmxdsv1alpha1.WasmExtensionConfigValue, resolved string) *wasmxdsv1alpha1.WasmExtensionConfigValue {
+	if cv == nil {
+		return nil
+	}
+	return &wasmxdsv1alpha1.WasmExtensionConfigValue{Value: &resolved, Encoding: cv.Encoding}
+}
+
+// Write writes the bundle as a gzipped tarball, signed with the key unless nil
+func (b *Bundle) Write(w io.Writer, key ed25519.PrivateKey) error {
+	files := map[string][]byte{}
+	var extensions bytes.Buffer
+	for _, ext := range b.Extensions {
+		raw, err := yaml.Marshal(ext)
+		if err != nil {
+			return err
+		}
+		extensions.WriteString("---\n")
+		extensions.Write(raw)
+	}
+	files[ExtensionsFile] = extensions.Bytes()
+	for sha, binary := range b.Binaries {
+		files[binaryPath(sha)] = binary
+	}
+
+	m := Manifest{Version: Version}
+	for p, content := range files {
+		m.Files = append(m.Files, File{Path: p, Sha256: sha256Hex(content), Size: len(content)})
+	}
+	sort.Slice(m.Files, func(i, j int) bool { return m.Files[i].Path < m.Files[j].Path })
+	raw, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	gw := gzip.NewWriter(w)
+	tw := tar.NewWriter(gw)
+	entries := []File{{Path: ManifestFile}}
+	files[ManifestFile] = raw
+	if key != nil {
+		entries = append(entries, File{Path: SignatureFile})
+		files[SignatureFile] = []byte(base64.StdEncoding.EncodeToString(ed25519.Sign(key, raw)))
+	}
+	// the manifest and the signature come first for the readers to find them without reading the binaries
+	for _, f := range append(entries, m.Files...) {
+		content := files[f.Path]
+		if err := tw.WriteHeader(&tar.Header{
+			Name: f.Path, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg,
+		}); err != nil {
+			return err
+		}
+		if _, err := tw.Write(content); err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gw.Close()
+}
+
+// Read reads the bundle after verifying the sha256 of all the files, and the signature unless the key is nil
+func Read(r io.Reader, key ed25519.PublicKey) (*Bundle, error) {
+	gr, err := gzip.NewReader(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read the bundle: %w", err)
+	}
+	files := map[string][]byte{}
+	var total int64
+	tr := tar.NewReader(gr)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("failed to read the bundle: %w", err)
+		}
+		if h.Typeflag != tar.TypeReg {
+			return nil, fmt.Errorf("unexpected entry %s in the bundle", h.Name)
+		} else if _, ok := files[h.Name]; ok {
+			return nil, fmt.Errorf("duplicated entry %s in the bundle", h.Name)
+		}
+		if h.Size > maxEntrySize {
+			return nil, fmt.Errorf("%s of %d bytes exceeds the limit of %d bytes", h.Name, h.Size, maxEntrySize)
+		} else if total += h.Size; total > maxBundleSize {
+			return nil, fmt.Errorf("the bundle exceeds the limit of %d bytes", maxBundleSize)
+		}
+		// the tar reader reads no more than the size in the header
+		if files[h.Name], err = ioutil.ReadAll(tr); err != nil {
+			return nil, fmt.Errorf("failed to read %s in the bundle: %w", h.Name, err)
+		}
+	}
+
+	raw, ok := files[ManifestFile]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in the bundle", ManifestFile)
+	}
+	if key != nil {
+		sig, ok := files[SignatureFile]
+		if !ok {
+			return nil, ErrUnsigned
+		}
+		decoded, err := base64.StdEncoding.DecodeString(string(sig))
+		if err != nil || !ed25519.Verify(key, raw, decoded) {
+			return nil, ErrInvalidSignature
+		}
+	}
+	var m Manifest
+	if err := json.Unmarshal(raw, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse %s: %w", ManifestFile, err)
+	} else if m.Version != Version {
+		return nil, fmt.Errorf("unsupported bundle version: %d", m.Version)
+	}
+
+	// only the files listed in the manifest are trusted
+	ret := &Bundle{Binaries: map[string][]byte{}}
+	listed := map[string]bool{ManifestFile: true, SignatureFile: true}
+	for _, f := range m.Files {
+		content, ok := files[f.Path]
+		if !ok {
+			return nil, fmt.Errorf("%s not found in the bundle", f.Path)
+		} else if actual := sha256Hex(content); actual != f.Sha256 {
+			return nil, fmt.Errorf("the sha256 value of %s differs from the one in the manifest: `%s` != `%s`", f.Path, actual, f.Sha256)
+		}
+		listed[f.Path] = true
+		if sha := strings.TrimSuffix(path.Base(f.Path), ".wasm"); f.Path == binaryPath(sha) {
+			if sha != f.Sha256 {
+				return nil, fmt.Errorf("%s is not named after its sha256 %s", f.Path, f.Sha256)
+			}
+			ret.Binaries[sha] = content
+		}
+	}
+	for p := range files {
+		if !listed[p] {
+			return nil, fmt.Errorf("%s is not listed in the manifest", p)
+		}
+	}
+
+	extensions, ok := files[ExtensionsFile]
+	if !ok {
+		return nil, fmt.Errorf("%s not found in the bundle", ExtensionsFile)
+	}
+	decoded, err := manifest.Decode(extensions)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", ExtensionsFile, err)
+	}
+	for _, ext := range decoded.Extensions {
+		if strings.ToLower(ext.Spec.Runtime) == wasmxdsv1alpha1.RuntimeNull {
+			continue
+		} else if ext.Spec.Image.Sha256 == nil {
+			return nil, fmt.Errorf("%s: not pinned to sha256", ext.Namespaced())
+		} else if _, ok := ret.Binaries[*ext.Spec.Image.Sha256]; !ok {
+			return nil, fmt.Errorf("%s: the binary of sha256 %s not found in the bundle", ext.Namespaced(), *ext.Spec.Image.Sha256)
+		}
+	}
+	ret.Extensions = decoded.Extensions
+	return ret, nil
+}
+
+// Locator returns the uri and the protocol of the binary of the extension at the destination of the import
+type Locator func(ext *wasmxdsv1alpha1.WasmExtension, sha256 string) (uri, protocol string, err error)
+
+// Rewrite returns the copies of the extensions with the images pointing to the binaries located at the destination.
+// The sha256 pins are kept, and the fallback sources and the version constraints are dropped as unreachable.
+func (b *Bundle) Rewrite(locate Locator) ([]*wasmxdsv1alpha1.WasmExtension, error) {
+	ret := make([]*wasmxdsv1alpha1.WasmExtension, 0, len(b.Extensions))
+	for _, ext := range b.Extensions {
+		ext = ext.DeepCopy()
+		ret = append(ret, ext)
+		if strings.ToLower(ext.Spec.Runtime) == wasmxdsv1alpha1.RuntimeNull {
+			continue
+		}
+
+		image := &ext.Spec.Image
+		uri, protocol, err := locate(ext, *image.Sha256)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ext.Namespaced(), err)
+		}
+		image.URI, image.Protocol, image.Sources, image.VersionConstraint = uri, protocol, nil, ""
+		if protocol != wasmxdsv1alpha1.ProtocolOCIImageRegistry {
+			image.DigestPolicy = ""
+		}
+	}
+	return ret, nil
+}
+
+// ParsePrivateKey parses the PEM encoded PKCS #8 ed25519 private key, e.g. generated by `openssl genpkey -algorithm ed25519`
+func ParsePrivateKey(raw []byte) (ed25519.PrivateKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+	key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ret, ok := key.(ed25519.PrivateKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T: must be ed25519", key)
+	}
+	return ret, nil
+}
+
+// ParsePublicKey parses the PEM encoded PKIX ed25519 public key, e.g. generated by `openssl pkey -pubout`
+func ParsePublicKey(raw []byte) (ed25519.PublicKey, error) {
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, errors.New("no PEM encoded key found")
+	}
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+	ret, ok := key.(ed25519.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("unsupported key type %T: must be ed25519", key)
+	}
+	return ret, nil
+}
+
+func binaryPath(sha string) string {
+	return path.Join(BinariesDir, sha+".wasm")
+}
+
+func sha256Hex(b []byte) string {
+	raw := sha256.Sum256(b)
+	return hex.EncodeToString(raw[:])
+}
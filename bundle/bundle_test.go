@@ -0,0 +1,192 @@
+package bundle
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+// the smallest valid module
+var binary = []byte{0x00, 0x61, 0x73, 0x6D, 0x01, 0x00, 0x00, 0x00}
+
+const binarySha256 = "93a44bbb96c751218e4c00d479e4c14358122a389acca16205b1e4d0dc5f9476"
+
+func newExtensions() []*wasmxdsv1alpha1.WasmExtension {
+	ext := &wasmxdsv1alpha1.WasmExtension{
+		Spec: wasmxdsv1alpha1.WasmExtensionSpec{
+			Image: wasmxdsv1alpha1.WasmExtensionSpecImage{
+				URI:     "webassemblyhub.io/foo/bar:v1",
+				Sources: []wasmxdsv1alpha1.WasmExtensionImageSource{{URI: "ghcr.io/foo/bar:v1"}},
+			},
+			VMID: "vm",
+			PluginConfiguration: &wasmxdsv1alpha1.WasmExtensionConfigValue{
+				ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{
+					SecretKeyRef: &wasmxdsv1alpha1.WasmExtensionConfigValueRefAttribute{Namespace: "default", Name: "secret", Key: "config"},
+				},
+			},
+		},
+	}
+	ext.Name, ext.Namespace = "ext", "default"
+
+	builtin := &wasmxdsv1alpha1.WasmExtension{
+		Spec: wasmxdsv1alpha1.WasmExtensionSpec{
+			Runtime: wasmxdsv1alpha1.RuntimeNull, BuiltinPlugin: "envoy.wasm.builtin", VMID: "vm", RootID: "root",
+		},
+	}
+	builtin.Name, builtin.Namespace = "builtin", "default"
+	ext.Default()
+	builtin.Default()
+	return []*wasmxdsv1alpha1.WasmExtension{ext, builtin}
+}
+
+func resolveExtensions(t *testing.T) *Bundle {
+	dir, err := ioutil.TempDir("", "bundle")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "default", "secret"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "default", "secret", "config"), []byte(`{"b":2}`), 0644))
+
+	fetch := func(*wasmxdsv1alpha1.WasmExtensionSpecImage) ([]byte, *wasmxdsv1alpha1.ImageMetadata, error) {
+		return binary, &wasmxdsv1alpha1.ImageMetadata{RootID: "root", PluginConfiguration: `{"a":1}`}, nil
+	}
+	b, err := Resolve(newExtensions(), fetch, dir)
+	require.NoError(t, err)
+	return b
+}
+
+func TestResolve(t *testing.T) {
+	b := resolveExtensions(t)
+	require.Len(t, b.Extensions, 2)
+	assert.Equal(t, map[string][]byte{binarySha256: binary}, b.Binaries)
+
+	ext := b.Extensions[0]
+	assert.Equal(t, binarySha256, *ext.Spec.Image.Sha256)
+	assert.Equal(t, "root", ext.Spec.RootID)
+	assert.Equal(t, `{"a":1,"b":2}`, *ext.Spec.PluginConfiguration.Value)
+	assert.Nil(t, ext.Spec.PluginConfiguration.ValueFrom)
+	assert.Nil(t, b.Extensions[1].Spec.Image.Sha256)
+
+	_, err := Resolve(newExtensions(), func(*wasmxdsv1alpha1.WasmExtensionSpecImage) ([]byte, *wasmxdsv1alpha1.ImageMetadata, error) {
+		return nil, nil, errors.New("unreachable")
+	}, "")
+	assert.Error(t, err)
+}
+
+func TestWriteAndRead(t *testing.T) {
+	b := resolveExtensions(t)
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	other, _, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+
+	var signed, unsigned bytes.Buffer
+	require.NoError(t, b.Write(&signed, priv))
+	require.NoError(t, b.Write(&unsigned, nil))
+
+	actual, err := Read(bytes.NewReader(signed.Bytes()), pub)
+	require.NoError(t, err)
+	assert.Equal(t, b.Binaries, actual.Binaries)
+	require.Len(t, actual.Extensions, 2)
+	assert.Equal(t, b.Extensions[0].Spec, actual.Extensions[0].Spec)
+
+	_, err = Read(bytes.NewReader(signed.Bytes()), other)
+	assert.Equal(t, ErrInvalidSignature, err)
+	_, err = Read(bytes.NewReader(unsigned.Bytes()), pub)
+	assert.Equal(t, ErrUnsigned, err)
+	_, err = Read(bytes.NewReader(unsigned.Bytes()), nil)
+	assert.NoError(t, err)
+
+	// the binary replaced after signing
+	tampered := rewriteEntries(t, signed.Bytes(), func(name string, content []byte) []byte {
+		if name == binaryPath(binarySha256) {
+			return []byte("\x00asm\x01\x00\x00\x00\x00")
+		}
+		return content
+	})
+	_, err = Read(bytes.NewReader(tampered), pub)
+	assert.Error(t, err)
+}
+
+func TestRead_limits(t *testing.T) {
+	var buf bytes.Buffer
+	require.NoError(t, resolveExtensions(t).Write(&buf, nil))
+	defer func(entry, bundle int64) {
+		maxEntrySize, maxBundleSize = entry, bundle
+	}(maxEntrySize, maxBundleSize)
+
+	_, err := Read(bytes.NewReader(buf.Bytes()), nil)
+	require.NoError(t, err)
+
+	maxEntrySize = 8
+	_, err = Read(bytes.NewReader(buf.Bytes()), nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "exceeds the limit of 8 bytes")
+
+	maxEntrySize, maxBundleSize = 1<<20, 16
+	_, err = Read(bytes.NewReader(buf.Bytes()), nil)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "the bundle exceeds the limit of 16 bytes")
+}
+
+// rewriteEntries returns the copy of the bundle with the content of the entries replaced
+func rewriteEntries(t *testing.T, raw []byte, replace func(name string, content []byte) []byte) []byte {
+	gr, err := gzip.NewReader(bytes.NewReader(raw))
+	require.NoError(t, err)
+	tr := tar.NewReader(gr)
+
+	var buf bytes.Buffer
+	gw := gzip.NewWriter(&buf)
+	tw := tar.NewWriter(gw)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(tr)
+		require.NoError(t, err)
+		content = replace(h.Name, content)
+		h.Size = int64(len(content))
+		require.NoError(t, tw.WriteHeader(h))
+		_, err = tw.Write(content)
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	require.NoError(t, gw.Close())
+	return buf.Bytes()
+}
+
+func TestRewrite(t *testing.T) {
+	b := resolveExtensions(t)
+	actual, err := b.Rewrite(func(ext *wasmxdsv1alpha1.WasmExtension, sha string) (string, string, error) {
+		return "/var/lib/wasmxds/" + sha + ".wasm", wasmxdsv1alpha1.ProtocolLocalFileSystem, nil
+	})
+	require.NoError(t, err)
+	require.Len(t, actual, 2)
+
+	image := actual[0].Spec.Image
+	assert.Equal(t, "/var/lib/wasmxds/"+binarySha256+".wasm", image.URI)
+	assert.Equal(t, wasmxdsv1alpha1.ProtocolLocalFileSystem, image.Protocol)
+	assert.Equal(t, binarySha256, *image.Sha256)
+	assert.Empty(t, image.Sources)
+	// the bundle is intact
+	assert.Equal(t, "webassemblyhub.io/foo/bar:v1", b.Extensions[0].Spec.Image.URI)
+
+	_, err = b.Rewrite(func(*wasmxdsv1alpha1.WasmExtension, string) (string, string, error) {
+		return "", "", errors.New("unreachable")
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,254 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/containerd/containerd/reference"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/yaml"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	"github.com/tetratelabs/wasmxds/bundle"
+	"github.com/tetratelabs/wasmxds/imageprovider/ociregistory"
+)
+
+// maxConfigMapSize is the limit of the data in a ConfigMap
+const maxConfigMapSize = 1 << 20
+
+func runBundle(args []string, stdout io.Writer) error {
+	if len(args) > 0 {
+		switch args[0] {
+		case "export":
+			return runBundleExport(args[1:], stdout)
+		case "import":
+			return runBundleImport(args[1:], stdout)
+		}
+	}
+	fmt.Fprintf(os.Stderr, "Usage: wasmxdsctl bundle (export | import) [flags]\n")
+	return errors.New("either export or import must be given")
+}
+
+func runBundleExport(args []string, stdout io.Writer) error {
+	fs := newFlagSet("bundle export", "FILE...")
+	var pf providerFlags
+	pf.register(fs)
+	configDir := fs.String("config-dir", "", "directory of the configurations referenced by valueFrom, laid out as <namespace>/<name>/<key>")
+	signingKey := fs.String("signing-key", "", "PEM encoded ed25519 private key to sign the bundle with")
+	output := fs.String("o", "", "file to write the bundle")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if *output == "" {
+		fs.Usage()
+		return errors.New("-o must be given")
+	}
+
+	m, err := readManifests(fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(m.Extensions) == 0 {
+		fs.Usage()
+		return errors.New("no extensions given")
+	}
+
+	var key ed25519.PrivateKey
+	if *signingKey != "" {
+		raw, err := ioutil.ReadFile(*signingKey)
+		if err != nil {
+			return err
+		}
+		if key, err = bundle.ParsePrivateKey(raw); err != nil {
+			return fmt.Errorf("%s: %w", *signingKey, err)
+		}
+	}
+
+	f, err := pf.fetcher()
+	if err != nil {
+		return err
+	}
+	b, err := bundle.Resolve(m.Extensions, f.fetchImage, *configDir)
+	if err != nil {
+		return err
+	}
+
+	var buf bytes.Buffer
+	if err := b.Write(&buf, key); err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(*output, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	for _, ext := range b.Extensions {
+		sha := "builtin"
+		if ext.Spec.Image.Sha256 != nil {
+			sha = *ext.Spec.Image.Sha256
+		}
+		fmt.Fprintf(stdout, "%s  %s\n", sha, ext.Namespaced())
+	}
+	return nil
+}
+
+func runBundleImport(args []string, stdout io.Writer) error {
+	fs := newFlagSet("bundle import", "BUNDLE")
+	verifyKey := fs.String("verify-key", "", "PEM encoded ed25519 public key to verify the signature of the bundle with")
+	skipVerify := fs.Bool("insecure-skip-verify", false, "import the bundle without verifying its signature")
+	binaryDir := fs.String("binary-dir", "", "directory to write the binaries to, which the extensions refer to with the local_fs protocol")
+	binaryPathPrefix := fs.String("binary-path-prefix", "", "path of -binary-dir seen from the server. Defaults to -binary-dir")
+	configMap := fs.String("configmap", "", "namespace/name of the ConfigMap to print with the binaries, which the extensions refer to with the local_fs protocol")
+	mountPath := fs.String("mount-path", "/var/lib/wasmxds/bundle", "path the ConfigMap of -configmap is mounted to in the server")
+	registry := fs.String("registry", "", "repository prefix in the OCI registry to push the binaries to as <prefix>/<namespace>/<name>:sha256-<sha256>")
+	username := fs.String("username", "", "username of -registry. The docker config is used if omitted")
+	password := fs.String("password", "", "password of -registry")
+	output := fs.String("o", "", "file to write the rewritten extensions instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 1 {
+		fs.Usage()
+		return errors.New("a bundle must be given")
+	}
+	var destinations int
+	for _, d := range []string{*binaryDir, *configMap, *registry} {
+		if d != "" {
+			destinations++
+		}
+	}
+	if destinations != 1 {
+		fs.Usage()
+		return errors.New("exactly one of -binary-dir, -configmap and -registry must be given")
+	}
+
+	var key ed25519.PublicKey
+	if *verifyKey != "" {
+		raw, err := ioutil.ReadFile(*verifyKey)
+		if err != nil {
+			return err
+		}
+		if key, err = bundle.ParsePublicKey(raw); err != nil {
+			return fmt.Errorf("%s: %w", *verifyKey, err)
+		}
+	} else if !*skipVerify {
+		return errors.New("either -verify-key or -insecure-skip-verify must be given")
+	}
+
+	raw, err := readFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	b, err := bundle.Read(bytes.NewReader(raw), key)
+	if err != nil {
+		return err
+	}
+
+	var objects []interface{}
+	var locate bundle.Locator
+	switch {
+	case *binaryDir != "":
+		prefix := *binaryPathPrefix
+		if prefix == "" {
+			prefix = *binaryDir
+		}
+		if err := os.MkdirAll(*binaryDir, 0755); err != nil {
+			return err
+		}
+		for sha, binary := range b.Binaries {
+			if err := ioutil.WriteFile(filepath.Join(*binaryDir, sha+".wasm"), binary, 0644); err != nil {
+				return err
+			}
+		}
+		locate = func(_ *wasmxdsv1alpha1.WasmExtension, sha string) (string, string, error) {
+			return filepath.Join(prefix, sha+".wasm"), wasmxdsv1alpha1.ProtocolLocalFileSystem, nil
+		}
+	case *configMap != "":
+		cm, err := binaryConfigMap(*configMap, b.Binaries)
+		if err != nil {
+			return err
+		}
+		objects = append(objects, cm)
+		locate = func(_ *wasmxdsv1alpha1.WasmExtension, sha string) (string, string, error) {
+			return filepath.Join(*mountPath, sha+".wasm"), wasmxdsv1alpha1.ProtocolLocalFileSystem, nil
+		}
+	default:
+		prefix := strings.TrimSuffix(*registry, "/")
+		ref, err := reference.Parse(prefix)
+		if err != nil || ref.Object != "" {
+			return fmt.Errorf("invalid -registry %s: must be the repository prefix without tag or digest", *registry)
+		}
+		r := ociregistory.NewRegistry(strings.SplitN(ref.Locator, "/", 2)[0], *username, *password)
+		locate = func(ext *wasmxdsv1alpha1.WasmExtension, sha string) (string, string, error) {
+			uri := fmt.Sprintf("%s/%s/%s:sha256-%s", prefix, ext.Namespace, ext.Name, sha)
+			if err := r.Push(b.Binaries[sha], uri, nil); err != nil {
+				return "", "", err
+			}
+			return uri, wasmxdsv1alpha1.ProtocolOCIImageRegistry, nil
+		}
+	}
+
+	extensions, err := b.Rewrite(locate)
+	if err != nil {
+		return err
+	}
+	for _, ext := range extensions {
+		objects = append(objects, ext)
+	}
+
+	var out bytes.Buffer
+	for _, obj := range objects {
+		raw, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+		out.WriteString("---\n")
+		out.Write(raw)
+	}
+	if *output != "" {
+		return ioutil.WriteFile(*output, out.Bytes(), 0644)
+	}
+	_, err = stdout.Write(out.Bytes())
+	return err
+}
+
+// binaryConfigMap returns the ConfigMap of the given "namespace/name" containing the binaries as "<sha256>.wasm"
+func binaryConfigMap(namespaced string, binaries map[string][]byte) (*corev1.ConfigMap, error) {
+	nn := strings.SplitN(namespaced, "/", 2)
+	if len(nn) != 2 || nn[0] == "" || nn[1] == "" {
+		return nil, fmt.Errorf("invalid -configmap %s: must be in the form of namespace/name", namespaced)
+	}
+
+	cm := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Namespace: nn[0], Name: nn[1]},
+		BinaryData: map[string][]byte{},
+	}
+	var size int
+	for sha, binary := range binaries {
+		cm.BinaryData[sha+".wasm"] = binary
+		size += len(binary)
+	}
+	if size > maxConfigMapSize {
+		return nil, fmt.Errorf("the binaries of %d bytes exceed the limit of ConfigMaps, use -binary-dir or -registry instead", size)
+	}
+	return cm, nil
+}
@@ -0,0 +1,344 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/containerd/containerd/reference"
+	"github.com/golang/protobuf/jsonpb"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	v1converter "github.com/tetratelabs/wasmxds/converter/v1alpha1"
+	"github.com/tetratelabs/wasmxds/imageprovider/ociregistory"
+	"github.com/tetratelabs/wasmxds/manifest"
+	"github.com/tetratelabs/wasmxds/staticconfig"
+	"github.com/tetratelabs/wasmxds/wasmbinary"
+)
+
+var errInvalid = errors.New("invalid extensions found")
+
+func newFlagSet(name, args string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: wasmxdsctl %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func runValidate(args []string, stdout io.Writer) error {
+	fs := newFlagSet("validate", "FILE...")
+	var policyFiles stringsFlag
+	fs.Var(&policyFiles, "policy", "file of WasmExtensionPolicies to check the extensions against. Can be specified multiple times")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() == 0 {
+		fs.Usage()
+		return errors.New("no files given")
+	}
+
+	policies, err := readManifests(policyFiles)
+	if err != nil {
+		return err
+	}
+
+	var invalid bool
+	for _, path := range fs.Args() {
+		m, err := readManifests([]string{path})
+		if err != nil {
+			fmt.Fprintf(stdout, "%v\n", err)
+			invalid = true
+			continue
+		}
+		// the policies in the same files apply as well
+		ps := make([]wasmxdsv1alpha1.WasmExtensionPolicy, 0, len(policies.Policies)+len(m.Policies))
+		for _, p := range append(policies.Policies, m.Policies...) {
+			ps = append(ps, *p)
+		}
+		for _, ext := range m.Extensions {
+			if err := wasmxdsv1alpha1.CheckPolicies(ps, ext); err != nil {
+				fmt.Fprintf(stdout, "%s: %s: %v\n", path, ext.Namespaced(), err)
+				invalid = true
+				continue
+			}
+			fmt.Fprintf(stdout, "%s: %s: valid\n", path, ext.Namespaced())
+		}
+	}
+	if invalid {
+		return errInvalid
+	}
+	return nil
+}
+
+func runFetch(args []string, stdout io.Writer) error {
+	fs := newFlagSet("fetch", "(FILE... | -uri URI)")
+	var pf providerFlags
+	pf.register(fs)
+	var image wasmxdsv1alpha1.WasmExtensionSpecImage
+	fs.StringVar(&image.URI, "uri", "", "URI of the image to fetch instead of the images of the extensions in the files")
+	fs.StringVar(&image.Protocol, "protocol", wasmxdsv1alpha1.ProtocolOCIImageRegistry, "protocol of -uri")
+	output := fs.String("o", "", "file to write the fetched binary. Only allowed with a single image")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var images []*wasmxdsv1alpha1.WasmExtensionSpecImage
+	if image.URI != "" {
+		images = append(images, &image)
+	} else {
+		m, err := readManifests(fs.Args())
+		if err != nil {
+			return err
+		}
+		for _, ext := range m.Extensions {
+			if strings.ToLower(ext.Spec.Runtime) != wasmxdsv1alpha1.RuntimeNull {
+				images = append(images, &ext.Spec.Image)
+			}
+		}
+	}
+	if len(images) == 0 {
+		fs.Usage()
+		return errors.New("no images given")
+	} else if *output != "" && len(images) > 1 {
+		return errors.New("-o is only allowed with a single image")
+	}
+
+	f, err := pf.fetcher()
+	if err != nil {
+		return err
+	}
+	for _, image := range images {
+		binary, err := f.fetch(image)
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(stdout, "%s  %d  %s\n", sha256Hex(binary), len(binary), image.ID())
+		if *output != "" {
+			if err := ioutil.WriteFile(*output, binary, 0644); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+func runInspect(args []string, stdout io.Writer) error {
+	fs := newFlagSet("inspect", "(FILE | -uri URI)")
+	var pf providerFlags
+	pf.register(fs)
+	var image wasmxdsv1alpha1.WasmExtensionSpecImage
+	fs.StringVar(&image.URI, "uri", "", "URI of the image to inspect instead of the local file")
+	fs.StringVar(&image.Protocol, "protocol", wasmxdsv1alpha1.ProtocolOCIImageRegistry, "protocol of -uri")
+	asJSON := fs.Bool("json", false, "print in JSON")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	var binary []byte
+	var err error
+	if image.URI != "" {
+		f, err := pf.fetcher()
+		if err != nil {
+			return err
+		}
+		if binary, err = f.fetch(&image); err != nil {
+			return err
+		}
+	} else if fs.NArg() == 1 {
+		if binary, err = readFile(fs.Arg(0)); err != nil {
+			return err
+		}
+	} else {
+		fs.Usage()
+		return errors.New("either a file or -uri must be given")
+	}
+
+	m, err := wasmbinary.Inspect(binary)
+	if err != nil {
+		return err
+	}
+
+	if *asJSON {
+		return json.NewEncoder(stdout).Encode(struct {
+			Sha256      string   `json:"sha256"`
+			Size        int      `json:"size"`
+			ABIVersions []string `json:"abiVersions"`
+			*wasmbinary.Module
+		}{sha256Hex(binary), len(binary), m.ABIVersions(), m})
+	}
+
+	fmt.Fprintf(stdout, "sha256: %s\nsize: %d\n", sha256Hex(binary), len(binary))
+	abi := m.ABIVersions()
+	if len(abi) == 0 {
+		abi = []string{"unknown (no proxy_abi_version_* export)"}
+	}
+	fmt.Fprintf(stdout, "abi versions: %s\n", strings.Join(abi, ", "))
+	fmt.Fprintf(stdout, "imports:\n")
+	for _, i := range m.Imports {
+		fmt.Fprintf(stdout, "  %s.%s (%s)\n", i.Module, i.Name, i.Kind)
+	}
+	fmt.Fprintf(stdout, "exports:\n")
+	for _, e := range m.Exports {
+		fmt.Fprintf(stdout, "  %s (%s)\n", e.Name, e.Kind)
+	}
+	fmt.Fprintf(stdout, "custom sections:\n")
+	for _, c := range m.CustomSections {
+		fmt.Fprintf(stdout, "  %s (%d bytes)\n", c.Name, c.Size)
+	}
+	return nil
+}
+
+func runPush(args []string, stdout io.Writer) error {
+	fs := newFlagSet("push", "FILE REF")
+	var annotations stringsFlag
+	fs.Var(&annotations, "annotation", "manifest annotation in the form of key=value. Can be specified multiple times")
+	username := fs.String("username", "", "username of the registry. The docker config is used if omitted")
+	password := fs.String("password", "", "password of the registry")
+	if err := fs.Parse(args); err != nil {
+		return err
+	} else if fs.NArg() != 2 {
+		fs.Usage()
+		return errors.New("a file and a reference must be given")
+	}
+
+	as := make(map[string]string, len(annotations))
+	for _, a := range annotations {
+		kv := strings.SplitN(a, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid annotation %q: must be in the form of key=value", a)
+		}
+		as[kv[0]] = kv[1]
+	}
+
+	binary, err := readFile(fs.Arg(0))
+	if err != nil {
+		return err
+	}
+	// reject the files which are obviously not Wasm binaries
+	if _, err := wasmbinary.Inspect(binary); err != nil {
+		return fmt.Errorf("%s: %w", fs.Arg(0), err)
+	}
+
+	ref, err := reference.Parse(fs.Arg(1))
+	if err != nil {
+		return fmt.Errorf("failed to parse %s as OCI ref: %w", fs.Arg(1), err)
+	}
+	if err := ociregistory.NewRegistry(ref.Hostname(), *username, *password).Push(binary, ref.String(), as); err != nil {
+		return err
+	}
+	fmt.Fprintf(stdout, "pushed %s (sha256: %s)\n", ref.String(), sha256Hex(binary))
+	return nil
+}
+
+func runRender(args []string, stdout io.Writer) error {
+	fs := newFlagSet("render", "FILE...")
+	var pf providerFlags
+	pf.register(fs)
+	configDir := fs.String("config-dir", "", "directory of the configurations referenced by valueFrom, laid out as <namespace>/<name>/<key>")
+	imageFile := fs.String("image", "", "local Wasm binary used instead of fetching the images")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	m, err := readManifests(fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(m.Extensions) == 0 {
+		fs.Usage()
+		return errors.New("no extensions given")
+	}
+
+	var local []byte
+	if *imageFile != "" {
+		if local, err = ioutil.ReadFile(*imageFile); err != nil {
+			return err
+		}
+	}
+	f, err := pf.fetcher()
+	if err != nil {
+		return err
+	}
+
+	marshaler := &jsonpb.Marshaler{Indent: "  "}
+	for _, ext := range m.Extensions {
+		pc, vc, err := manifest.ResolveConfigs(*configDir, ext)
+		if err != nil {
+			return fmt.Errorf("%s: %w", ext.Namespaced(), err)
+		}
+
+		binary := local
+		if binary == nil && strings.ToLower(ext.Spec.Runtime) != wasmxdsv1alpha1.RuntimeNull {
+			if binary, err = f.fetch(&ext.Spec.Image); err != nil {
+				return fmt.Errorf("%s: %w", ext.Namespaced(), err)
+			}
+		}
+
+		tc, err := v1converter.Convert(ext, binary, pc, vc)
+		if err != nil {
+			return fmt.Errorf("%s: %w", ext.Namespaced(), err)
+		}
+		if err := marshaler.Marshal(stdout, tc); err != nil {
+			return err
+		}
+		fmt.Fprintln(stdout)
+	}
+	return nil
+}
+
+func runStatic(args []string, stdout io.Writer) error {
+	fs := newFlagSet("static", "FILE...")
+	var pf providerFlags
+	pf.register(fs)
+	var r staticconfig.Renderer
+	fs.StringVar(&r.ConfigDir, "config-dir", "", "directory of the configurations referenced by valueFrom, laid out as <namespace>/<name>/<key>")
+	fs.StringVar(&r.BinaryDir, "binary-dir", "", "directory to write the binaries to. The binaries are inlined if omitted")
+	fs.StringVar(&r.BinaryPathPrefix, "binary-path-prefix", "", "path of -binary-dir seen from Envoy. Defaults to -binary-dir")
+	output := fs.String("o", "", "file to write the configuration instead of stdout")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	m, err := readManifests(fs.Args())
+	if err != nil {
+		return err
+	}
+	if len(m.Extensions) == 0 {
+		fs.Usage()
+		return errors.New("no extensions given")
+	}
+
+	f, err := pf.fetcher()
+	if err != nil {
+		return err
+	}
+	r.Fetcher = f.fetch
+	out, err := r.Render(m.Extensions)
+	if err != nil {
+		return err
+	}
+	if *output != "" {
+		return ioutil.WriteFile(*output, out, 0644)
+	}
+	_, err = stdout.Write(out)
+	return err
+}
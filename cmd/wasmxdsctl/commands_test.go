@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const extension = `
+apiVersion: wasmxds.tetrate.io/v1alpha1
+kind: WasmExtension
+metadata:
+  name: ext
+spec:
+  image:
+    uri: filter.wasm
+    protocol: local_fs
+  vm_id: vm
+  root_id: root
+  plugin_configuration:
+    value: config
+`
+
+func writeFiles(t *testing.T, files map[string]string) string {
+	dir, err := ioutil.TempDir("", "wasmxdsctl")
+	require.NoError(t, err)
+	for name, content := range files {
+		require.NoError(t, ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644))
+	}
+	return dir
+}
+
+func TestRunValidate(t *testing.T) {
+	dir := writeFiles(t, map[string]string{
+		"ext.yaml":     extension,
+		"invalid.yaml": `{"apiVersion": "wasmxds.tetrate.io/v1alpha1", "kind": "WasmExtension", "metadata": {"name": "v1"}, "spec": {}}`,
+		"policy.yaml": `
+apiVersion: wasmxds.tetrate.io/v1alpha1
+kind: WasmExtensionPolicy
+metadata:
+  name: oci-only
+spec:
+  allowedProtocols: ["oci"]
+`,
+	})
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	require.NoError(t, runValidate([]string{filepath.Join(dir, "ext.yaml")}, &out))
+	assert.Contains(t, out.String(), "default/ext: valid")
+
+	out.Reset()
+	assert.Equal(t, errInvalid, runValidate([]string{filepath.Join(dir, "invalid.yaml")}, &out))
+
+	out.Reset()
+	assert.Equal(t, errInvalid, runValidate([]string{"-policy", filepath.Join(dir, "policy.yaml"), filepath.Join(dir, "ext.yaml")}, &out))
+	assert.Contains(t, out.String(), "forbidden by WasmExtensionPolicy oci-only")
+}
+
+func TestRunInspectAndRender(t *testing.T) {
+	// a module only exporting "proxy_abi_version_0_2_0" as the function 0
+	binary := []byte{0x00, 0x61, 0x73, 0x6D, 0x01, 0x00, 0x00, 0x00, 0x07, 0x1b, 0x01, 0x17}
+	binary = append(binary, "proxy_abi_version_0_2_0"...)
+	binary = append(binary, 0x00, 0x00)
+	dir := writeFiles(t, map[string]string{"ext.yaml": extension, "filter.wasm": string(binary)})
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	require.NoError(t, runInspect([]string{filepath.Join(dir, "filter.wasm")}, &out))
+	assert.Contains(t, out.String(), "abi versions: 0_2_0")
+
+	out.Reset()
+	require.NoError(t, runRender([]string{"-image", filepath.Join(dir, "filter.wasm"), filepath.Join(dir, "ext.yaml")}, &out))
+	assert.Contains(t, out.String(), `"name": "default/ext"`)
+	assert.Contains(t, out.String(), `"value": "config"`)
+}
+
+func TestRunStatic(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"ext.yaml": extension, "filter.wasm": "\x00asm\x01\x00\x00\x00"})
+	defer os.RemoveAll(dir)
+	// the image is resolved relatively to the working directory
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	var out bytes.Buffer
+	require.NoError(t, runStatic([]string{"ext.yaml"}, &out))
+	assert.Contains(t, out.String(), "inline_bytes: AGFzbQEAAAA=")
+
+	out.Reset()
+	require.NoError(t, runStatic([]string{"-binary-dir", "bin", "-binary-path-prefix", "/etc/envoy/wasm", "-o", "static.yaml", "ext.yaml"}, &out))
+	assert.Empty(t, out.String())
+	static, err := ioutil.ReadFile("static.yaml")
+	require.NoError(t, err)
+	assert.Contains(t, string(static), "filename: /etc/envoy/wasm/93a44bbb96c751218e4c00d479e4c14358122a389acca16205b1e4d0dc5f9476.wasm")
+	assert.FileExists(t, filepath.Join("bin", "93a44bbb96c751218e4c00d479e4c14358122a389acca16205b1e4d0dc5f9476.wasm"))
+}
+
+func TestRunBundle(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	require.NoError(t, err)
+	rawPriv, err := x509.MarshalPKCS8PrivateKey(priv)
+	require.NoError(t, err)
+	rawPub, err := x509.MarshalPKIXPublicKey(pub)
+	require.NoError(t, err)
+	dir := writeFiles(t, map[string]string{
+		"ext.yaml":    extension,
+		"filter.wasm": "\x00asm\x01\x00\x00\x00",
+		"key.pem":     string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: rawPriv})),
+		"pub.pem":     string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rawPub})),
+	})
+	defer os.RemoveAll(dir)
+	wd, err := os.Getwd()
+	require.NoError(t, err)
+	require.NoError(t, os.Chdir(dir))
+	defer os.Chdir(wd)
+
+	var out bytes.Buffer
+	require.NoError(t, runBundle([]string{"export", "-signing-key", "key.pem", "-o", "bundle.tar.gz", "ext.yaml"}, &out))
+	assert.Equal(t, "93a44bbb96c751218e4c00d479e4c14358122a389acca16205b1e4d0dc5f9476  default/ext\n", out.String())
+
+	out.Reset()
+	assert.Error(t, runBundle([]string{"import", "-binary-dir", "bin", "bundle.tar.gz"}, &out))
+	assert.Error(t, runBundle([]string{"import", "-verify-key", "pub.pem", "-binary-dir", "bin", "-configmap", "default/wasm", "bundle.tar.gz"}, &out))
+
+	require.NoError(t, runBundle([]string{"import", "-verify-key", "pub.pem", "-binary-dir", "bin", "-binary-path-prefix", "/var/lib/wasm", "bundle.tar.gz"}, &out))
+	assert.Contains(t, out.String(), "uri: /var/lib/wasm/93a44bbb96c751218e4c00d479e4c14358122a389acca16205b1e4d0dc5f9476.wasm")
+	assert.Contains(t, out.String(), "sha256: 93a44bbb96c751218e4c00d479e4c14358122a389acca16205b1e4d0dc5f9476")
+	assert.FileExists(t, filepath.Join("bin", "93a44bbb96c751218e4c00d479e4c14358122a389acca16205b1e4d0dc5f9476.wasm"))
+
+	out.Reset()
+	require.NoError(t, runBundle([]string{"import", "-insecure-skip-verify", "-configmap", "default/wasm", "bundle.tar.gz"}, &out))
+	assert.Contains(t, out.String(), "kind: ConfigMap")
+	assert.Contains(t, out.String(), "93a44bbb96c751218e4c00d479e4c14358122a389acca16205b1e4d0dc5f9476.wasm: AGFzbQEAAAA=")
+	assert.Contains(t, out.String(), "uri: /var/lib/wasmxds/bundle/93a44bbb96c751218e4c00d479e4c14358122a389acca16205b1e4d0dc5f9476.wasm")
+}
+
+func TestRunPush(t *testing.T) {
+	dir := writeFiles(t, map[string]string{"filter.wasm": "not wasm"})
+	defer os.RemoveAll(dir)
+
+	var out bytes.Buffer
+	assert.Error(t, runPush([]string{filepath.Join(dir, "filter.wasm"), "localhost:5000/filter:v1"}, &out))
+	assert.Error(t, runPush([]string{"-annotation", "invalid", filepath.Join(dir, "filter.wasm"), "localhost:5000/filter:v1"}, &out))
+}
@@ -0,0 +1,249 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// wasmxdsctl validates, fetches, inspects, pushes, renders and bundles extensions, including as static Envoy configuration, without running the controller
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/containerd/containerd/reference"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	"github.com/tetratelabs/wasmxds/imageprovider"
+	"github.com/tetratelabs/wasmxds/imageprovider/ociregistory"
+	"github.com/tetratelabs/wasmxds/imageprovider/s3provider"
+	"github.com/tetratelabs/wasmxds/manifest"
+)
+
+type command struct {
+	usage string
+	run   func(args []string, stdout io.Writer) error
+}
+
+var commands = map[string]command{
+	"validate": {usage: "validate WasmExtension manifests against the schema and policies", run: runValidate},
+	"fetch":    {usage: "fetch images through the providers and print their sha256", run: runFetch},
+	"inspect":  {usage: "print the exports, imports, ABI versions and custom sections of a Wasm binary", run: runInspect},
+	"push":     {usage: "push a Wasm binary to an OCI registry", run: runPush},
+	"render":   {usage: "print the TypedExtensionConfig served to Envoy", run: runRender},
+	"static":   {usage: "print static Envoy configuration of the extensions for proxies without xDS", run: runStatic},
+	"bundle":   {usage: "export extensions with their binaries into a signed archive, or import it into air-gapped clusters", run: runBundle},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "Usage: wasmxdsctl <command> [flags]\n\nCommands:\n")
+	names := make([]string, 0, len(commands))
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-10s %s\n", name, commands[name].usage)
+	}
+	fmt.Fprintf(os.Stderr, "\nRun 'wasmxdsctl <command> -h' for the flags of each command.\n")
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+	if err := cmd.run(os.Args[2:], os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+// providerFlags are the flags to configure the image providers, shared by the commands fetching images
+type providerFlags struct {
+	enableAmazonECR, enableAmazonS3 bool
+	allowInsecureHttps              bool
+}
+
+func (f *providerFlags) register(fs *flag.FlagSet) {
+	fs.BoolVar(&f.enableAmazonECR, "ecr", false, "Enable Amazon ECR provider")
+	fs.BoolVar(&f.enableAmazonS3, "s3", false, "Enable Amazon S3 provider")
+	fs.BoolVar(&f.allowInsecureHttps, "insecure-https", false, "Skip verifying certificates of https servers")
+}
+
+// fetcher fetches images in the same way as the server does
+type fetcher struct {
+	providers map[string]imageprovider.WasmImageProvider
+}
+
+func (f *providerFlags) fetcher() (*fetcher, error) {
+	providers := imageprovider.NewDefaultProviders(f.allowInsecureHttps)
+	if f.enableAmazonECR || f.enableAmazonS3 {
+		sess, err := session.NewSession()
+		if err != nil {
+			return nil, err
+		}
+		if f.enableAmazonECR {
+			ecrs, err := ociregistory.NewAmazonECR(sess)
+			if err != nil {
+				return nil, err
+			}
+			for _, p := range ecrs {
+				providers = append(providers, p)
+			}
+		}
+		if f.enableAmazonS3 {
+			p, err := s3provider.NewAmazonS3(sess)
+			if err != nil {
+				return nil, err
+			}
+			providers = append(providers, p)
+		}
+	}
+
+	ret := &fetcher{providers: map[string]imageprovider.WasmImageProvider{}}
+	for _, p := range providers {
+		ret.providers[p.ProviderKey()] = p
+	}
+	return ret, nil
+}
+
+// fetch tries the image and then its sources in order as the server does
+func (f *fetcher) fetch(image *wasmxdsv1alpha1.WasmExtensionSpecImage) ([]byte, error) {
+	binary, _, err := f.fetchImage(image)
+	return binary, err
+}
+
+// fetchImage is fetch returning the image metadata as well, which is nil if not found
+func (f *fetcher) fetchImage(image *wasmxdsv1alpha1.WasmExtensionSpecImage) ([]byte, *wasmxdsv1alpha1.ImageMetadata, error) {
+	binary, metadata, err := f.fetchOne(image)
+	for _, s := range image.Sources {
+		if err == nil {
+			break
+		}
+		fmt.Fprintf(os.Stderr, "%v, trying the next source\n", err)
+		source := *image
+		source.URI, source.Protocol, source.Sources = s.URI, s.Protocol, nil
+		binary, metadata, err = f.fetchOne(&source)
+	}
+	return binary, metadata, err
+}
+
+func (f *fetcher) fetchOne(image *wasmxdsv1alpha1.WasmExtensionSpecImage) ([]byte, *wasmxdsv1alpha1.ImageMetadata, error) {
+	key, err := image.ProviderKey()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	p, ok := f.providers[key]
+	if !ok && image.Protocol == wasmxdsv1alpha1.ProtocolOCIImageRegistry {
+		// unlike the server, the registries are not known in advance. The credentials in the docker config are used.
+		ref, err := reference.Parse(image.URI)
+		if err != nil {
+			return nil, nil, err
+		}
+		p, ok = ociregistory.NewRegistry(ref.Hostname(), "", ""), true
+	}
+	if !ok {
+		return nil, nil, fmt.Errorf("the provider image spec not supported: [protocol: %s, uri: %s]", image.Protocol, image.URI)
+	}
+
+	if repository, constraint, ok := image.VersionRange(); ok {
+		op, ok := p.(imageprovider.OCIImageProvider)
+		if !ok {
+			return nil, nil, fmt.Errorf("no provider which can list the tags of %s", repository)
+		}
+		tags, err := op.ListTags(context.Background(), repository)
+		if err != nil {
+			return nil, nil, err
+		}
+		tag, err := ociregistory.HighestMatchingTag(tags, constraint)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to resolve version constraint %s of %s: %w", constraint, repository, err)
+		}
+		image = image.WithTag(repository, tag)
+	}
+
+	var binary []byte
+	var metadata *wasmxdsv1alpha1.ImageMetadata
+	if op, ok := p.(imageprovider.OCIImageProvider); ok {
+		var oci *ociregistory.Image
+		if oci, err = op.FetchImage(context.Background(), image.URI); err == nil {
+			binary, metadata = oci.Binary, oci.Metadata
+		}
+	} else {
+		binary, err = p.Fetch(context.Background(), image.URI)
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("error fetching image %s: %w", image.ID(), err)
+	}
+	if image.Sha256 != nil {
+		if actual := sha256Hex(binary); actual != *image.Sha256 {
+			return nil, nil, fmt.Errorf("the sha256 value of the fetched image %s "+
+				"differs from the one specified in spec.image.sha256: `%s` != `%s`", image.ID(), actual, *image.Sha256)
+		}
+	}
+	return binary, metadata, nil
+}
+
+func sha256Hex(b []byte) string {
+	raw := sha256.Sum256(b)
+	return hex.EncodeToString(raw[:])
+}
+
+// readManifests decodes all the manifests in the files. "-" reads from stdin.
+func readManifests(paths []string) (*manifest.Manifests, error) {
+	ret := &manifest.Manifests{}
+	for _, path := range paths {
+		raw, err := readFile(path)
+		if err != nil {
+			return nil, err
+		}
+		m, err := manifest.Decode(raw)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", path, err)
+		}
+		ret.Extensions = append(ret.Extensions, m.Extensions...)
+		ret.Policies = append(ret.Policies, m.Policies...)
+	}
+	return ret, nil
+}
+
+func readFile(path string) ([]byte, error) {
+	if path == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(path)
+}
+
+// stringsFlag is the flag which can be specified multiple times
+type stringsFlag []string
+
+func (s *stringsFlag) String() string { return strings.Join(*s, ",") }
+
+func (s *stringsFlag) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	wasmxdsv1alpha2 "github.com/tetratelabs/wasmxds/api/v1alpha2"
+)
+
+// PolicyWebhookPath is where PolicyValidator is served
+const PolicyWebhookPath = "/validate-wasmxds-tetrate-io-wasmextension-policy"
+
+// +kubebuilder:webhook:path=/validate-wasmxds-tetrate-io-wasmextension-policy,mutating=false,failurePolicy=fail,groups=wasmxds.tetrate.io,resources=wasmextensions,verbs=create;update,versions=v1alpha1;v1alpha2,name=pwasmextension.wasmxds.tetrate.io
+
+// PolicyValidator rejects the WasmExtensions violating the WasmExtensionPolicies at admission time
+type PolicyValidator struct {
+	Client  client.Client
+	decoder *admission.Decoder
+}
+
+var _ admission.DecoderInjector = &PolicyValidator{}
+
+// InjectDecoder implements admission.DecoderInjector
+func (v *PolicyValidator) InjectDecoder(d *admission.Decoder) error {
+	v.decoder = d
+	return nil
+}
+
+// Handle implements admission.Handler
+func (v *PolicyValidator) Handle(ctx context.Context, req admission.Request) admission.Response {
+	ext := &wasmxdsv1alpha1.WasmExtension{}
+	switch req.Kind.Version {
+	case wasmxdsv1alpha1.GroupVersion.Version:
+		if err := v.decoder.Decode(req, ext); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	case wasmxdsv1alpha2.GroupVersion.Version:
+		spoke := &wasmxdsv1alpha2.WasmExtension{}
+		if err := v.decoder.Decode(req, spoke); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+		if err := spoke.ConvertTo(ext); err != nil {
+			return admission.Errored(http.StatusBadRequest, err)
+		}
+	default:
+		return admission.Errored(http.StatusBadRequest, fmt.Errorf("unsupported version: %s", req.Kind.Version))
+	}
+	// the namespace may be omitted in the object itself
+	ext.Namespace = req.Namespace
+
+	var policies wasmxdsv1alpha1.WasmExtensionPolicyList
+	if err := v.Client.List(ctx, &policies); err != nil {
+		return admission.Errored(http.StatusInternalServerError, fmt.Errorf("failed to list policies: %w", err))
+	}
+	if err := wasmxdsv1alpha1.CheckPolicies(policies.Items, ext); err != nil {
+		return admission.Denied(err.Error())
+	}
+	return admission.Allowed("")
+}
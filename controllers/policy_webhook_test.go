@@ -0,0 +1,69 @@
+package controllers
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	admissionv1beta1 "k8s.io/api/admission/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/webhook/admission"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	wasmxdsv1alpha2 "github.com/tetratelabs/wasmxds/api/v1alpha2"
+)
+
+func TestPolicyValidator_Handle(t *testing.T) {
+	s := runtime.NewScheme()
+	require.NoError(t, wasmxdsv1alpha1.AddToScheme(s))
+	require.NoError(t, wasmxdsv1alpha2.AddToScheme(s))
+	decoder, err := admission.NewDecoder(s)
+	require.NoError(t, err)
+
+	policy := &wasmxdsv1alpha1.WasmExtensionPolicy{
+		ObjectMeta: metav1.ObjectMeta{Name: "oci-only"},
+		Spec: wasmxdsv1alpha1.WasmExtensionPolicySpec{
+			Namespaces:       []string{"restricted"},
+			AllowedProtocols: []string{wasmxdsv1alpha1.ProtocolOCIImageRegistry},
+		},
+	}
+	v := &PolicyValidator{Client: fake.NewFakeClientWithScheme(s, policy)}
+	require.NoError(t, v.InjectDecoder(decoder))
+
+	request := func(t *testing.T, namespace string, obj runtime.Object, version string) admission.Request {
+		raw, err := json.Marshal(obj)
+		require.NoError(t, err)
+		return admission.Request{AdmissionRequest: admissionv1beta1.AdmissionRequest{
+			Kind:      metav1.GroupVersionKind{Group: "wasmxds.tetrate.io", Version: version, Kind: "WasmExtension"},
+			Namespace: namespace,
+			Operation: admissionv1beta1.Create,
+			Object:    runtime.RawExtension{Raw: raw},
+		}}
+	}
+
+	v1 := &wasmxdsv1alpha1.WasmExtension{
+		Spec: wasmxdsv1alpha1.WasmExtensionSpec{
+			Image: wasmxdsv1alpha1.WasmExtensionSpecImage{
+				URI: "filter.wasm", Protocol: wasmxdsv1alpha1.ProtocolLocalFileSystem,
+			},
+		},
+	}
+	v2 := &wasmxdsv1alpha2.WasmExtension{
+		Spec: wasmxdsv1alpha2.WasmExtensionSpec{
+			Image: wasmxdsv1alpha2.WasmExtensionImage{
+				LocalFS: &wasmxdsv1alpha2.LocalFSImageSource{Path: "filter.wasm"},
+			},
+		},
+	}
+
+	ctx := context.Background()
+	assert.True(t, v.Handle(ctx, request(t, "default", v1, "v1alpha1")).Allowed)
+	assert.False(t, v.Handle(ctx, request(t, "restricted", v1, "v1alpha1")).Allowed)
+	assert.True(t, v.Handle(ctx, request(t, "default", v2, "v1alpha2")).Allowed)
+	assert.False(t, v.Handle(ctx, request(t, "restricted", v2, "v1alpha2")).Allowed)
+	assert.False(t, v.Handle(ctx, request(t, "default", v1, "v1")).Allowed)
+}
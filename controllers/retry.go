@@ -0,0 +1,113 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package controllers
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"k8s.io/apimachinery/pkg/types"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+// retryTracker keeps the failures of the extensions in a row, so that the transient failures are retried
+// with the backoff in spec.image.retry, the missing images and the rejected credentials with a longer one,
+// and the permanent ones are not retried until the extension changes.
+// The state is in memory as every replica fetches the images by itself.
+type retryTracker struct {
+	mu     sync.Mutex
+	states map[types.NamespacedName]*retryState
+	now    func() time.Time
+}
+
+type retryState struct {
+	// fingerprint identifies the generation of the extension and its configurations which have failed
+	fingerprint string
+	failures    int
+	next        time.Time
+	stalled     bool
+}
+
+func newRetryTracker() *retryTracker {
+	return &retryTracker{states: map[types.NamespacedName]*retryState{}, now: time.Now}
+}
+
+// retryFingerprint changes when the extension or the configurations it references change
+func retryFingerprint(ext *wasmxdsv1alpha1.WasmExtension) string {
+	return fmt.Sprintf("%d/%s/%s", ext.Generation,
+		ext.Status.PluginConfigurationVersion, ext.Status.VMConfigurationVersion)
+}
+
+// wait returns true if the update must not be attempted yet, along with how long to wait, which is zero if stalled.
+// The state is discarded if the extension has changed since the failure.
+func (t *retryTracker) wait(key types.NamespacedName, fingerprint string) (time.Duration, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.states[key]
+	if !ok {
+		return 0, false
+	}
+	if s.fingerprint != fingerprint {
+		delete(t.states, key)
+		return 0, false
+	}
+	if s.stalled {
+		return 0, true
+	}
+	if wait := s.next.Sub(t.now()); wait > 0 {
+		return wait, true
+	}
+	return 0, false
+}
+
+// failed records the failure, and returns the number of the failures in a row
+func (t *retryTracker) failed(key types.NamespacedName, fingerprint string) int {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.states[key]
+	if !ok || s.fingerprint != fingerprint {
+		s = &retryState{fingerprint: fingerprint}
+		t.states[key] = s
+	}
+	s.failures++
+	return s.failures
+}
+
+// retryAfter defers the next attempt by the delay
+func (t *retryTracker) retryAfter(key types.NamespacedName, delay time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.states[key]; ok {
+		s.next = t.now().Add(delay)
+	}
+}
+
+// stall stops the attempts until the extension changes
+func (t *retryTracker) stall(key types.NamespacedName) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if s, ok := t.states[key]; ok {
+		s.stalled = true
+	}
+}
+
+// reset forgets the failures on success or deletion
+func (t *retryTracker) reset(key types.NamespacedName) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	delete(t.states, key)
+}
@@ -0,0 +1,44 @@
+package controllers
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+func TestRetryTracker(t *testing.T) {
+	now := time.Unix(0, 0)
+	tracker := newRetryTracker()
+	tracker.now = func() time.Time { return now }
+	key := types.NamespacedName{Namespace: "default", Name: "ext"}
+
+	_, wait := tracker.wait(key, "1//")
+	assert.False(t, wait)
+
+	assert.Equal(t, 1, tracker.failed(key, "1//"))
+	tracker.retryAfter(key, time.Second)
+	remaining, wait := tracker.wait(key, "1//")
+	assert.True(t, wait)
+	assert.Equal(t, time.Second, remaining)
+
+	now = now.Add(time.Second)
+	_, wait = tracker.wait(key, "1//")
+	assert.False(t, wait)
+	assert.Equal(t, 2, tracker.failed(key, "1//"))
+
+	tracker.stall(key)
+	remaining, wait = tracker.wait(key, "1//")
+	assert.True(t, wait)
+	assert.Zero(t, remaining)
+
+	// changed since the failure
+	_, wait = tracker.wait(key, "2//")
+	assert.False(t, wait)
+	assert.Equal(t, 1, tracker.failed(key, "2//"))
+
+	tracker.reset(key)
+	_, wait = tracker.wait(key, "2//")
+	assert.False(t, wait)
+}
@@ -18,47 +18,105 @@ import (
 	"context"
 	"fmt"
 
+	"github.com/Masterminds/semver/v3"
 	"github.com/go-logr/logr"
 	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/equality"
 	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/event"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
 	"github.com/tetratelabs/wasmxds/wasmxds"
 )
 
 // WasmExtensionReconciler reconciles a WasmExtension object
 type WasmExtensionReconciler struct {
 	client.Client
-	Log          logr.Logger
-	Scheme       *runtime.Scheme
+	Log      logr.Logger
+	Scheme   *runtime.Scheme
+	Recorder record.EventRecorder
+	// Elected is closed when this replica becomes the leader, which writes the status and the finalizers.
+	// Every replica reconciles the extensions to serve them from its own cache. Nil means always the leader.
+	Elected      <-chan struct{}
 	eventHandler wasmxds.EventHandler
+	readiness    wasmxds.ReadinessTracker
+	retries      *retryTracker
+	// ctx is cancelled when the manager stops, which aborts the lookups and the fetches in progress
+	ctx context.Context
 }
 
 const wasmFilterFinalizer = "finalizer.wasmxds.tetrate.io"
 
+// reasons for the Ready condition
+const (
+	reasonPublished          = "Published"
+	reasonConfigurationError = "ConfigurationError"
+	reasonUpdateFailed       = "UpdateFailed"
+	reasonPolicyViolation    = "PolicyViolation"
+	reasonInvalidSpec        = "InvalidSpec"
+)
+
+// reason for the Stalled condition when the transient failures have exhausted spec.image.retry.maxAttempts.
+// The reasons of the permanent failures are their kinds such as Invalid.
+const reasonRetriesExhausted = "RetriesExhausted"
+
+// reasons for the events
+const (
+	reasonTagResolved          = "TagResolved"
+	reasonRolledBack           = "RolledBack"
+	reasonValidationFailed     = "ValidationFailed"
+	reasonConfigurationChanged = "ConfigurationChanged"
+	reasonDeleted              = "Deleted"
+	reasonStalled              = "Stalled"
+)
+
 func (r *WasmExtensionReconciler) SetEventHandler(handler wasmxds.EventHandler) {
 	r.eventHandler = handler
 }
 
+// SetReadinessTracker sets the tracker told the extensions existing once the cache has synced,
+// and each extension reconciled regardless of the result
+func (r *WasmExtensionReconciler) SetReadinessTracker(tracker wasmxds.ReadinessTracker) {
+	r.readiness = tracker
+}
+
 // +kubebuilder:rbac:groups=wasmxds.tetrate.io,resources=wasmextensions,verbs=get;list;watch;create;update;patch;delete
-// +kubebuilder:rbac:groups=wasmxds.tetrate.io,resources=wasmextensions,verbs=get;list;watch;create;update;patch;delete
+// +kubebuilder:rbac:groups=wasmxds.tetrate.io,resources=wasmextensions/status,verbs=get;update;patch
+// +kubebuilder:rbac:groups=wasmxds.tetrate.io,resources=wasmextensionpolicies,verbs=get;list;watch
 // +kubebuilder:rbac:groups="",resources=secrets;configmaps,verbs=get;list;watch
+// +kubebuilder:rbac:groups="",resources=events,verbs=create;patch
 
 func (r *WasmExtensionReconciler) Reconcile(req ctrl.Request) (ctrl.Result, error) {
-	ctx := context.Background()
+	ctx := r.ctx
 	_ = r.Log.WithValues("WasmExtension", req.NamespacedName)
+	if r.readiness != nil {
+		defer r.readiness.Attempted(req.NamespacedName.String())
+	}
 
 	ext := &wasmxdsv1alpha1.WasmExtension{}
 	err := r.Get(ctx, req.NamespacedName, ext)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			r.Log.Info("object already deleted", "name", req.NamespacedName)
+			r.retries.reset(req.NamespacedName)
+			if !r.IsLeader() {
+				// the other replicas may miss the deletion timestamp when the leader removes the finalizer quickly
+				deleted := &wasmxdsv1alpha1.WasmExtension{}
+				deleted.Namespace, deleted.Name = req.Namespace, req.Name
+				r.eventHandler.Delete(deleted)
+			}
 			return ctrl.Result{}, nil
 		}
 		r.Log.Error(err, "failed to get object", "name", req.NamespacedName)
@@ -68,6 +126,11 @@ func (r *WasmExtensionReconciler) Reconcile(req ctrl.Request) (ctrl.Result, erro
 	if ext.GetDeletionTimestamp() != nil {
 		r.Log.Info("deleting filter", "name", req.NamespacedName)
 		r.eventHandler.Delete(ext)
+		r.retries.reset(req.NamespacedName)
+		if !r.IsLeader() {
+			return ctrl.Result{}, nil
+		}
+		r.event(ext, v1.EventTypeNormal, reasonDeleted, "stopped serving the extension")
 		r.Log.Info("remove finalizer", "name", req.NamespacedName)
 		controllerutil.RemoveFinalizer(ext, wasmFilterFinalizer)
 		if err := r.Update(ctx, ext); err != nil {
@@ -77,37 +140,398 @@ func (r *WasmExtensionReconciler) Reconcile(req ctrl.Request) (ctrl.Result, erro
 		return ctrl.Result{}, nil
 	}
 
-	pc, vc, err := r.resolveConfigs(ext)
-	if err != nil {
-		r.Log.Error(err, "resolve configurations", "name", req.NamespacedName)
+	// the admission webhook is optional, so the extensions are defaulted and validated here as well
+	ext.Default()
+	if err := ext.Validate(); err != nil {
+		r.Log.Info("invalid extension", "name", req.NamespacedName, "error", err.Error())
+		r.event(ext, v1.EventTypeWarning, reasonValidationFailed, "%s", err)
+		// not retried until the spec changes, while the extension served before keeps being served
+		r.updateStatus(ctx, ext, reasonInvalidSpec, fetcherr.Invalid(err), true)
+		return ctrl.Result{}, nil
+	}
+
+	// policies are checked before resolving the configurations so that forbidden Secrets are never read
+	var policies wasmxdsv1alpha1.WasmExtensionPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		r.Log.Error(err, "failed to list policies", "name", req.NamespacedName)
 		return ctrl.Result{}, err
 	}
+	if err := wasmxdsv1alpha1.CheckPolicies(policies.Items, ext); err != nil {
+		r.Log.Info("policy violation", "name", req.NamespacedName, "error", err.Error())
+		// stop serving the extension which has become forbidden by a policy change
+		r.eventHandler.Delete(ext)
+		r.event(ext, v1.EventTypeWarning, reasonValidationFailed, "%s", err)
+		r.updateStatus(ctx, ext, reasonPolicyViolation, err, false)
+		// no need to requeue as policy changes trigger the reconciliation
+		return ctrl.Result{}, nil
+	}
 
-	if !contains(ext.GetFinalizers(), wasmFilterFinalizer) {
+	// the finalizer is added before resolving the configurations, since the update replaces the status
+	// with the stored one, which doesn't have the resolved versions yet
+	if !contains(ext.GetFinalizers(), wasmFilterFinalizer) && r.IsLeader() {
 		r.Log.Info("adding finalizer", "name", req.NamespacedName)
 		controllerutil.AddFinalizer(ext, wasmFilterFinalizer)
 		if err := r.Update(ctx, ext); err != nil {
 			r.Log.Error(err, "failed to set finalizer", "name", req.NamespacedName)
 		}
 	}
-	return r.eventHandler.Update(ext, pc, vc)
+
+	previousVersions := [2]string{ext.Status.PluginConfigurationVersion, ext.Status.VMConfigurationVersion}
+	pc, vc, err := r.resolveConfigs(ctx, ext)
+	if err == nil && ext.Status.ObservedGeneration != 0 {
+		r.configurationChanged(ext, "plugin", ext.Spec.PluginConfiguration, previousVersions[0], ext.Status.PluginConfigurationVersion)
+		r.configurationChanged(ext, "vm", ext.Spec.VMConfiguration, previousVersions[1], ext.Status.VMConfigurationVersion)
+	}
+	if err != nil {
+		r.Log.Error(err, "resolve configurations", "name", req.NamespacedName)
+		r.updateStatus(ctx, ext, reasonConfigurationError, err, false)
+		return ctrl.Result{}, err
+	}
+
+	fingerprint := retryFingerprint(ext)
+	if wait, ok := r.retries.wait(req.NamespacedName, fingerprint); ok {
+		// nothing has changed since the last failure, so the update is not attempted until the backoff elapses
+		// or at all if stalled
+		return ctrl.Result{RequeueAfter: wait}, nil
+	}
+
+	previousTag := ext.Status.ResolvedTag
+	res, err := r.eventHandler.Update(ctx, ext, pc, vc)
+	if tag := ext.Status.ResolvedTag; err == nil && tag != "" && tag != previousTag {
+		switch {
+		case previousTag == "":
+			r.event(ext, v1.EventTypeNormal, reasonTagResolved, "version constraint resolved to tag %s", tag)
+		case isLowerVersion(tag, previousTag):
+			// e.g. the highest tag has been deleted from the registry
+			r.event(ext, v1.EventTypeWarning, reasonRolledBack, "resolved tag rolled back from %s to %s", previousTag, tag)
+		default:
+			r.event(ext, v1.EventTypeNormal, reasonTagResolved, "resolved tag changed from %s to %s", previousTag, tag)
+		}
+	}
+	if err == nil {
+		r.retries.reset(req.NamespacedName)
+		r.updateStatus(ctx, ext, reasonUpdateFailed, nil, false)
+		return res, nil
+	}
+
+	// the backoff of spec.image.retry applies instead of the rate limiter of the controller
+	failures := r.retries.failed(req.NamespacedName, fingerprint)
+	if delay, ok := wasmxds.RetryDelay(ext, failures, err); ok {
+		r.Log.Info("update failed, retrying", "name", req.NamespacedName, "failures", failures,
+			"delay", delay, "error", err.Error())
+		r.retries.retryAfter(req.NamespacedName, delay)
+		r.updateStatus(ctx, ext, reasonUpdateFailed, err, false)
+		return ctrl.Result{RequeueAfter: delay}, nil
+	}
+	r.Log.Error(err, "update failed permanently, not retrying until the extension changes",
+		"name", req.NamespacedName, "failures", failures)
+	r.retries.stall(req.NamespacedName)
+	r.event(ext, v1.EventTypeWarning, reasonStalled, "stopped retrying after %d attempts: %s", failures, err)
+	r.updateStatus(ctx, ext, reasonUpdateFailed, err, true)
+	return ctrl.Result{}, nil
+}
+
+// updateStatus sets the Ready condition depending on the result of the reconciliation, and the Stalled condition
+// if the failure is not retried. The status is written back only when it has changed.
+func (r *WasmExtensionReconciler) updateStatus(ctx context.Context,
+	ext *wasmxdsv1alpha1.WasmExtension, failedReason string, reconcileErr error, stalled bool) {
+	original := ext.Status.DeepCopy()
+	ext.Status.ObservedGeneration = ext.Generation
+	if reconcileErr != nil {
+		ext.Status.SetCondition(wasmxdsv1alpha1.ConditionReady, v1.ConditionFalse, failedReason, reconcileErr.Error())
+	} else {
+		ext.Status.SetCondition(wasmxdsv1alpha1.ConditionReady, v1.ConditionTrue, reasonPublished, "")
+	}
+	if stalled {
+		reason := fetcherr.Reason(reconcileErr)
+		if !fetcherr.IsPermanent(reconcileErr) {
+			reason = reasonRetriesExhausted
+		}
+		ext.Status.SetCondition(wasmxdsv1alpha1.ConditionStalled, v1.ConditionTrue, reason, reconcileErr.Error())
+	} else if ext.Status.GetCondition(wasmxdsv1alpha1.ConditionStalled) != nil {
+		ext.Status.SetCondition(wasmxdsv1alpha1.ConditionStalled, v1.ConditionFalse, "", "")
+	}
+
+	if equality.Semantic.DeepEqual(original, &ext.Status) || !r.IsLeader() {
+		return
+	}
+	if err := r.Status().Update(ctx, ext); err != nil {
+		r.Log.Error(err, "failed to update status", "name", ext.Namespaced())
+	}
+}
+
+// event records the event on the extension if this replica is the leader
+func (r *WasmExtensionReconciler) event(ext *wasmxdsv1alpha1.WasmExtension, eventType, reason, messageFmt string, args ...interface{}) {
+	if r.Recorder != nil && r.IsLeader() {
+		r.Recorder.Eventf(ext, eventType, reason, messageFmt, args...)
+	}
+}
+
+// configurationChanged records the event if the configuration is now read from another source,
+// or the referenced ConfigMap or Secret has changed
+func (r *WasmExtensionReconciler) configurationChanged(ext *wasmxdsv1alpha1.WasmExtension, kind string,
+	cv *wasmxdsv1alpha1.WasmExtensionConfigValue, previous, current string) {
+	if previous == current {
+		return
+	}
+	if current == "" {
+		r.event(ext, v1.EventTypeNormal, reasonConfigurationChanged, "%s configuration source changed to %s", kind, configurationSource(cv))
+		return
+	}
+	r.event(ext, v1.EventTypeNormal, reasonConfigurationChanged, "%s configuration changed to resource version %s of %s",
+		kind, current, configurationSource(cv))
+}
+
+// configurationSource describes where the configuration is read from
+func configurationSource(cv *wasmxdsv1alpha1.WasmExtensionConfigValue) string {
+	switch {
+	case cv == nil:
+		return "none"
+	case cv.ValueFrom == nil:
+		return "the inline value"
+	case cv.ValueFrom.ConfigMapKeyRef != nil:
+		ref := cv.ValueFrom.ConfigMapKeyRef
+		return fmt.Sprintf("key %s of ConfigMap %s/%s", ref.Key, ref.Namespace, ref.Name)
+	case cv.ValueFrom.SecretKeyRef != nil:
+		ref := cv.ValueFrom.SecretKeyRef
+		return fmt.Sprintf("key %s of Secret %s/%s", ref.Key, ref.Namespace, ref.Name)
+	}
+	return "unknown"
+}
+
+// isLowerVersion returns true if both tags are semantic versions and the tag is lower than the other
+func isLowerVersion(tag, other string) bool {
+	v, err := semver.NewVersion(tag)
+	if err != nil {
+		return false
+	}
+	o, err := semver.NewVersion(other)
+	if err != nil {
+		return false
+	}
+	return v.LessThan(o)
+}
+
+// IsLeader returns true if this replica is the leader
+func (r *WasmExtensionReconciler) IsLeader() bool {
+	if r.Elected == nil {
+		return true
+	}
+	select {
+	case <-r.Elected:
+		return true
+	default:
+		return false
+	}
 }
 
+// field indexes from WasmExtension to the "<namespace>/<name>" of the ConfigMaps and Secrets it references
+const (
+	configMapRefsIndex = ".spec.configMapRefs"
+	secretRefsIndex    = ".spec.secretRefs"
+)
+
 func (r *WasmExtensionReconciler) SetupWithManager(mgr ctrl.Manager) error {
-	return ctrl.NewControllerManagedBy(mgr).
-		For(&wasmxdsv1alpha1.WasmExtension{}).
-		WithOptions(controller.Options{
-			// TODO: support/verify concurrent access to registry
-			MaxConcurrentReconciles: 1,
-		}).
-		Complete(r)
+	r.retries = newRetryTracker()
+	ctx, cancel := context.WithCancel(context.Background())
+	r.ctx = ctx
+	if err := mgr.Add(allReplicas{manager.RunnableFunc(func(stop <-chan struct{}) error {
+		<-stop
+		cancel()
+		return nil
+	})}); err != nil {
+		return err
+	}
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &wasmxdsv1alpha1.WasmExtension{}, configMapRefsIndex,
+		func(obj runtime.Object) []string {
+			return configurationRefs(obj.(*wasmxdsv1alpha1.WasmExtension), false)
+		}); err != nil {
+		return fmt.Errorf("failed to index configmap references: %w", err)
+	}
+	if err := mgr.GetFieldIndexer().IndexField(ctx, &wasmxdsv1alpha1.WasmExtension{}, secretRefsIndex,
+		func(obj runtime.Object) []string {
+			return configurationRefs(obj.(*wasmxdsv1alpha1.WasmExtension), true)
+		}); err != nil {
+		return fmt.Errorf("failed to index secret references: %w", err)
+	}
+
+	// the controller is not managed by the builder, which would run it only on the leader
+	c, err := controller.NewUnmanaged("wasmextension", mgr, controller.Options{
+		Reconciler: r,
+		// TODO: support/verify concurrent access to registry
+		MaxConcurrentReconciles: 1,
+	})
+	if err != nil {
+		return err
+	}
+	elected := make(chan event.GenericEvent)
+	for _, w := range []struct {
+		src     source.Source
+		handler handler.EventHandler
+	}{
+		{&source.Kind{Type: &wasmxdsv1alpha1.WasmExtension{}}, &handler.EnqueueRequestForObject{}},
+		{&source.Kind{Type: &v1.ConfigMap{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: r.referencingExtensions(configMapRefsIndex)}},
+		{&source.Kind{Type: &v1.Secret{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: r.referencingExtensions(secretRefsIndex)}},
+		{&source.Kind{Type: &wasmxdsv1alpha1.WasmExtensionPolicy{}},
+			&handler.EnqueueRequestsFromMapFunc{ToRequests: handler.ToRequestsFunc(r.governedExtensions)}},
+		{&source.Channel{Source: elected}, &handler.EnqueueRequestForObject{}},
+	} {
+		if err := c.Watch(w.src, w.handler); err != nil {
+			return err
+		}
+	}
+
+	if err := mgr.Add(allReplicas{c}); err != nil {
+		return err
+	}
+	if err := mgr.Add(allReplicas{manager.RunnableFunc(r.expectExtensions)}); err != nil {
+		return err
+	}
+	// the runnables other than allReplicas start on the leader
+	return mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+		return r.resyncOnElection(elected, stop)
+	}))
+}
+
+// allReplicas runs the runnable such as the controller on every replica regardless of the leader election
+type allReplicas struct {
+	manager.Runnable
+}
+
+func (allReplicas) NeedLeaderElection() bool {
+	return false
+}
+
+// expectExtensions tells the readiness tracker the extensions existing once the cache has synced,
+// all of which are reconciled once at startup
+func (r *WasmExtensionReconciler) expectExtensions(<-chan struct{}) error {
+	if r.readiness == nil {
+		return nil
+	}
+	var list wasmxdsv1alpha1.WasmExtensionList
+	if err := r.List(r.ctx, &list); err != nil {
+		return fmt.Errorf("failed to list extensions on startup: %w", err)
+	}
+	names := make([]string, 0, len(list.Items))
+	for _, ext := range list.Items {
+		names = append(names, ext.Namespaced())
+	}
+	r.readiness.ExpectExtensions(names)
+	return nil
+}
+
+// resyncOnElection enqueues all the extensions when this replica becomes the leader,
+// which writes the status and the finalizers skipped while it was not
+func (r *WasmExtensionReconciler) resyncOnElection(elected chan<- event.GenericEvent, stop <-chan struct{}) error {
+	var list wasmxdsv1alpha1.WasmExtensionList
+	if err := r.List(r.ctx, &list); err != nil {
+		return fmt.Errorf("failed to list extensions on election: %w", err)
+	}
+	r.Log.Info("elected as the leader", "extensions", len(list.Items))
+	for i := range list.Items {
+		ext := &list.Items[i]
+		select {
+		case elected <- event.GenericEvent{Meta: ext, Object: ext}:
+		case <-stop:
+			return nil
+		}
+	}
+	return nil
 }
 
-func (r *WasmExtensionReconciler) resolveConfigs(
+// referencingExtensions returns the mapper which enqueues the extensions referencing the changed object via the index
+func (r *WasmExtensionReconciler) referencingExtensions(index string) handler.ToRequestsFunc {
+	return func(obj handler.MapObject) []reconcile.Request {
+		key := types.NamespacedName{Namespace: obj.Meta.GetNamespace(), Name: obj.Meta.GetName()}.String()
+		var list wasmxdsv1alpha1.WasmExtensionList
+		if err := r.List(r.ctx, &list, client.MatchingFields{index: key}); err != nil {
+			r.Log.Error(err, "failed to list referencing extensions", "index", index, "key", key)
+			return nil
+		}
+
+		reqs := make([]reconcile.Request, 0, len(list.Items))
+		for _, ext := range list.Items {
+			r.Log.Info("referenced configuration changed", "name", ext.Namespaced(), "ref", key)
+			reqs = append(reqs, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: ext.Namespace, Name: ext.Name},
+			})
+		}
+		return reqs
+	}
+}
+
+// governedExtensions enqueues the extensions which the changed policy applies to
+func (r *WasmExtensionReconciler) governedExtensions(obj handler.MapObject) []reconcile.Request {
+	policy, ok := obj.Object.(*wasmxdsv1alpha1.WasmExtensionPolicy)
+	if !ok {
+		return nil
+	}
+
+	var list wasmxdsv1alpha1.WasmExtensionList
+	if err := r.List(r.ctx, &list); err != nil {
+		r.Log.Error(err, "failed to list extensions", "policy", policy.Name)
+		return nil
+	}
+
+	var reqs []reconcile.Request
+	for _, ext := range list.Items {
+		if policy.AppliesTo(ext.Namespace) {
+			reqs = append(reqs, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: ext.Namespace, Name: ext.Name},
+			})
+		}
+	}
+	return reqs
+}
+
+// VerifyImage checks the fetched binary against the policies which apply to the extension.
+// This is meant to be passed to wasmxds.Server.SetImageVerifier.
+func (r *WasmExtensionReconciler) VerifyImage(ctx context.Context, ext *wasmxdsv1alpha1.WasmExtension, image []byte) error {
+	var policies wasmxdsv1alpha1.WasmExtensionPolicyList
+	if err := r.List(ctx, &policies); err != nil {
+		return fmt.Errorf("failed to list policies: %w", err)
+	}
+	for i := range policies.Items {
+		if p := &policies.Items[i]; p.AppliesTo(ext.Namespace) {
+			if err := p.CheckBinarySize(len(image)); err != nil {
+				return err
+			}
+			if err := p.CheckSignature(image, ext.Spec.Image.Signature); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// configurationRefs returns the "<namespace>/<name>" of either Secrets or ConfigMaps referenced by the configurations
+func configurationRefs(ext *wasmxdsv1alpha1.WasmExtension, secret bool) []string {
+	var refs []string
+	for _, cv := range []*wasmxdsv1alpha1.WasmExtensionConfigValue{
+		ext.Spec.PluginConfiguration, ext.Spec.VMConfiguration,
+	} {
+		if cv == nil || cv.ValueFrom == nil {
+			continue
+		}
+		attr := cv.ValueFrom.ConfigMapKeyRef
+		if secret {
+			attr = cv.ValueFrom.SecretKeyRef
+		}
+		if attr != nil {
+			refs = append(refs, types.NamespacedName{Namespace: attr.Namespace, Name: attr.Name}.String())
+		}
+	}
+	return refs
+}
+
+func (r *WasmExtensionReconciler) resolveConfigs(ctx context.Context,
 	extension *wasmxdsv1alpha1.WasmExtension) (pluginConfig, vmConfig string, err error) {
+	var pluginVersion, vmVersion string
 	if extension.Spec.PluginConfiguration != nil {
 		r.Log.Info("resolving plugin configuration", "name", extension.Namespaced())
-		pluginConfig, err = r.resolveConfig(extension.Spec.PluginConfiguration)
+		pluginConfig, pluginVersion, err = r.resolveConfig(ctx, extension.Spec.PluginConfiguration)
 		if err != nil {
 			err = fmt.Errorf("failed to resolve plugin configuration: %w", err)
 			return
@@ -117,22 +541,30 @@ func (r *WasmExtensionReconciler) resolveConfigs(
 
 	if extension.Spec.VMConfiguration != nil {
 		r.Log.Info("resolving vm configuration", "name", extension.Namespaced())
-		vmConfig, err = r.resolveConfig(extension.Spec.VMConfiguration)
+		vmConfig, vmVersion, err = r.resolveConfig(ctx, extension.Spec.VMConfiguration)
 		if err != nil {
 			err = fmt.Errorf("failed to resolve vm configuration: %w", err)
+			return
 		}
 		r.Log.Info("vm configuration resolved", "name", extension.Namespaced())
 	}
+
+	extension.Status.PluginConfigurationVersion = pluginVersion
+	extension.Status.VMConfigurationVersion = vmVersion
 	return
 }
 
-func (r *WasmExtensionReconciler) resolveConfig(cv *wasmxdsv1alpha1.WasmExtensionConfigValue) (string, error) {
+// resolveConfig returns the configuration and, if it's read from a ConfigMap or Secret, the resource version of it
+func (r *WasmExtensionReconciler) resolveConfig(ctx context.Context,
+	cv *wasmxdsv1alpha1.WasmExtensionConfigValue) (config, version string, err error) {
 	if cv.Value != nil {
-		return *cv.Value, nil
+		return *cv.Value, "", nil
+	} else if cv.Object != nil {
+		return string(cv.Object.Raw), "", nil
 	}
 
 	if cv.ValueFrom == nil {
-		return "", fmt.Errorf("one of valueFrom and value must be set")
+		return "", "", fmt.Errorf("one of value, object and valueFrom must be set")
 	}
 
 	if cv.ValueFrom.ConfigMapKeyRef != nil {
@@ -141,35 +573,35 @@ func (r *WasmExtensionReconciler) resolveConfig(cv *wasmxdsv1alpha1.WasmExtensio
 			Name:      cv.ValueFrom.ConfigMapKeyRef.Name,
 		}
 		var cm v1.ConfigMap
-		if err := r.Client.Get(context.Background(), ns, &cm); err != nil {
-			return "", fmt.Errorf("error getting configmap %s: %v", ns, err)
+		if err := r.Client.Get(ctx, ns, &cm); err != nil {
+			return "", "", fmt.Errorf("error getting configmap %s: %v", ns, err)
 		}
 
 		key := cv.ValueFrom.ConfigMapKeyRef.Key
-		ret, ok := cm.Data[key]
-		if !ok {
-			return "", fmt.Errorf("key %s not found in configmap %s", key, ns)
+		if ret, ok := cm.Data[key]; ok {
+			return ret, cm.ResourceVersion, nil
+		} else if ret, ok := cm.BinaryData[key]; ok {
+			return string(ret), cm.ResourceVersion, nil
 		}
-		return ret, nil
+		return "", "", fmt.Errorf("key %s not found in configmap %s", key, ns)
 	} else if cv.ValueFrom.SecretKeyRef != nil {
 		ns := types.NamespacedName{
 			Namespace: cv.ValueFrom.SecretKeyRef.Namespace,
 			Name:      cv.ValueFrom.SecretKeyRef.Name,
 		}
 		var sc v1.Secret
-		if err := r.Client.Get(context.Background(), ns, &sc); err != nil {
-			return "", fmt.Errorf("error getting secret %s: %v", ns, err)
+		if err := r.Client.Get(ctx, ns, &sc); err != nil {
+			return "", "", fmt.Errorf("error getting secret %s: %v", ns, err)
 		}
 
 		key := cv.ValueFrom.SecretKeyRef.Key
 		ret, ok := sc.Data[key]
 		if !ok {
-			return "", fmt.Errorf("key %s not found in secret %s", key, ns)
+			return "", "", fmt.Errorf("key %s not found in secret %s", key, ns)
 		}
-		return string(ret), nil
+		return string(ret), sc.ResourceVersion, nil
 	}
-
-	return "", fmt.Errorf("one of secretKeyRef and configMapKeyRef must be set")
+	return "", "", fmt.Errorf("one of secretKeyRef and configMapKeyRef must be set")
 }
 
 func contains(list []string, s string) bool {
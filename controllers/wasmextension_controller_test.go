@@ -9,6 +9,7 @@ import (
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 	v1 "k8s.io/api/core/v1"
+	apiextensionsv1 "k8s.io/apiextensions-apiserver/pkg/apis/apiextensions/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
@@ -27,6 +28,7 @@ var (
 	mgr                ctrl.Manager
 	namespace          = "wasmxds-test-wasmextension-controller"
 	useExistingCluster = true
+	testImage          = "webassemblyhub.io/mathetake/example:v0.1"
 )
 
 func TestMain(m *testing.M) {
@@ -85,7 +87,7 @@ type lastHandled struct {
 	updated, deleted       bool
 }
 
-func (m *lastHandled) Update(extension *wasmxdsv1alpha1.WasmExtension, pluginConfig, vmConfig string) (r ctrl.Result, e error) {
+func (m *lastHandled) Update(_ context.Context, extension *wasmxdsv1alpha1.WasmExtension, pluginConfig, vmConfig string) (r ctrl.Result, e error) {
 	m.extension = extension
 	m.updated = true
 	m.pluginConfig = pluginConfig
@@ -140,7 +142,7 @@ func TestWasmExtensionReconciler_Reconcile(t *testing.T) {
 				Namespace: namespace,
 			},
 			Spec: wasmxdsv1alpha1.WasmExtensionSpec{
-				Image: wasmxdsv1alpha1.WasmExtensionSpecImage{},
+				Image: wasmxdsv1alpha1.WasmExtensionSpecImage{URI: testImage},
 			},
 		}
 
@@ -150,6 +152,14 @@ func TestWasmExtensionReconciler_Reconcile(t *testing.T) {
 		assert.Equal(t, name, handler.extension.Name)
 		handler.reset()
 
+		require.Eventually(t, func() bool {
+			if err := r.Get(ctx, namespaced, crd); err != nil {
+				return false
+			}
+			c := crd.Status.GetCondition(wasmxdsv1alpha1.ConditionReady)
+			return c != nil && c.Status == v1.ConditionTrue && crd.Status.ObservedGeneration == crd.Generation
+		}, 10*time.Second, 100*time.Millisecond)
+
 		require.NoError(t, r.Get(ctx, namespaced, crd))
 		expVMConfig := "this is vm configuration"
 		crd.Spec.VMConfiguration = &wasmxdsv1alpha1.WasmExtensionConfigValue{
@@ -182,6 +192,99 @@ func TestWasmExtensionReconciler_Reconcile(t *testing.T) {
 		require.True(t, errors.IsNotFound(r.Get(ctx, namespaced, crd)))
 	})
 
+	name = "configmap-updated"
+	t.Run(name, func(t *testing.T) {
+		cm := &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Data:       map[string]string{"key": "before"},
+		}
+		require.NoError(t, r.Client.Create(ctx, cm))
+		defer func() {
+			require.NoError(t, r.Client.Delete(ctx, cm))
+		}()
+
+		namespaced := types.NamespacedName{
+			Namespace: namespace,
+			Name:      name,
+		}
+		crd := &wasmxdsv1alpha1.WasmExtension{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Spec: wasmxdsv1alpha1.WasmExtensionSpec{
+				Image: wasmxdsv1alpha1.WasmExtensionSpecImage{URI: testImage},
+				PluginConfiguration: &wasmxdsv1alpha1.WasmExtensionConfigValue{
+					ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{
+						ConfigMapKeyRef: &wasmxdsv1alpha1.WasmExtensionConfigValueRefAttribute{
+							Name: name, Namespace: namespace, Key: "key",
+						},
+					},
+				},
+			},
+		}
+
+		require.NoError(t, r.Client.Create(ctx, crd))
+		handler.wait()
+		assert.Equal(t, "before", handler.pluginConfig)
+		handler.reset()
+
+		cm.Data["key"] = "after"
+		require.NoError(t, r.Client.Update(ctx, cm))
+		handler.wait()
+		assert.Equal(t, "after", handler.pluginConfig)
+		handler.reset()
+
+		require.Eventually(t, func() bool {
+			if err := r.Get(ctx, namespaced, crd); err != nil {
+				return false
+			}
+			return crd.Status.PluginConfigurationVersion == cm.ResourceVersion
+		}, 10*time.Second, 100*time.Millisecond)
+
+		require.NoError(t, r.Client.Delete(ctx, crd))
+		handler.wait()
+		assert.True(t, handler.deleted)
+		handler.reset()
+	})
+
+	name = "invalid"
+	t.Run(name, func(t *testing.T) {
+		namespaced := types.NamespacedName{
+			Namespace: namespace,
+			Name:      name,
+		}
+		sha := "not sha256"
+		crd := &wasmxdsv1alpha1.WasmExtension{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name,
+				Namespace: namespace,
+			},
+			Spec: wasmxdsv1alpha1.WasmExtensionSpec{
+				Image: wasmxdsv1alpha1.WasmExtensionSpecImage{URI: testImage, Sha256: &sha},
+			},
+		}
+
+		// validated by the controller without the admission webhook
+		require.NoError(t, r.Client.Create(ctx, crd))
+		require.Eventually(t, func() bool {
+			if err := r.Get(ctx, namespaced, crd); err != nil {
+				return false
+			}
+			ready := crd.Status.GetCondition(wasmxdsv1alpha1.ConditionReady)
+			stalled := crd.Status.GetCondition(wasmxdsv1alpha1.ConditionStalled)
+			return ready != nil && ready.Status == v1.ConditionFalse && ready.Reason == reasonInvalidSpec &&
+				stalled != nil && stalled.Status == v1.ConditionTrue && stalled.Reason == "Invalid"
+		}, 10*time.Second, 100*time.Millisecond)
+		assert.False(t, handler.updated)
+
+		require.NoError(t, r.Client.Delete(ctx, crd))
+		require.Eventually(t, func() bool {
+			return errors.IsNotFound(r.Get(ctx, namespaced, crd))
+		}, 10*time.Second, 100*time.Millisecond)
+		handler.reset()
+	})
+
 	name = "created-deleted"
 	t.Run(name, func(t *testing.T) {
 		namespaced := types.NamespacedName{
@@ -194,7 +297,7 @@ func TestWasmExtensionReconciler_Reconcile(t *testing.T) {
 				Namespace: namespace,
 			},
 			Spec: wasmxdsv1alpha1.WasmExtensionSpec{
-				Image: wasmxdsv1alpha1.WasmExtensionSpecImage{},
+				Image: wasmxdsv1alpha1.WasmExtensionSpecImage{URI: testImage},
 			},
 		}
 
@@ -225,19 +328,27 @@ func TestWasmExtensionReconciler_resolveConfig(t *testing.T) {
 
 	t.Run("raw", func(t *testing.T) {
 		exp := "exp"
-		actual, err := r.resolveConfig(&wasmxdsv1alpha1.WasmExtensionConfigValue{
+		actual, _, err := r.resolveConfig(context.Background(), &wasmxdsv1alpha1.WasmExtensionConfigValue{
 			Value: &exp,
 		})
 		require.NoError(t, err)
 		assert.Equal(t, exp, actual)
 	})
 
+	t.Run("object", func(t *testing.T) {
+		actual, _, err := r.resolveConfig(context.Background(), &wasmxdsv1alpha1.WasmExtensionConfigValue{
+			Object: &apiextensionsv1.JSON{Raw: []byte(`{"foo":"bar"}`)},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, `{"foo":"bar"}`, actual)
+	})
+
 	t.Run("invalid", func(t *testing.T) {
-		_, err := r.resolveConfig(&wasmxdsv1alpha1.WasmExtensionConfigValue{})
+		_, _, err := r.resolveConfig(context.Background(), &wasmxdsv1alpha1.WasmExtensionConfigValue{})
 		require.Error(t, err)
-		assert.Contains(t, err.Error(), "one of valueFrom and value must be set")
+		assert.Contains(t, err.Error(), "one of value, object and valueFrom must be set")
 
-		_, err = r.resolveConfig(&wasmxdsv1alpha1.WasmExtensionConfigValue{
+		_, _, err = r.resolveConfig(context.Background(), &wasmxdsv1alpha1.WasmExtensionConfigValue{
 			ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{}},
 		)
 		require.Error(t, err)
@@ -252,7 +363,8 @@ func TestWasmExtensionReconciler_resolveConfig(t *testing.T) {
 				Name:      "test1",
 				Namespace: "default",
 			},
-			Data: map[string]string{key: value},
+			Data:       map[string]string{key: value},
+			BinaryData: map[string][]byte{"binary": {0xff, 0x00}},
 		}
 
 		defer func() {
@@ -266,7 +378,7 @@ func TestWasmExtensionReconciler_resolveConfig(t *testing.T) {
 			Namespace: cm.Namespace,
 			Key:       key,
 		}
-		actual, err := r.resolveConfig(&wasmxdsv1alpha1.WasmExtensionConfigValue{
+		actual, _, err := r.resolveConfig(context.Background(), &wasmxdsv1alpha1.WasmExtensionConfigValue{
 			ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{
 				ConfigMapKeyRef: attr,
 			},
@@ -274,8 +386,25 @@ func TestWasmExtensionReconciler_resolveConfig(t *testing.T) {
 		require.NoError(t, err)
 		assert.Equal(t, value, actual)
 
+		_, version, err := r.resolveConfig(context.Background(), &wasmxdsv1alpha1.WasmExtensionConfigValue{
+			ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{
+				ConfigMapKeyRef: attr,
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, cm.ResourceVersion, version)
+
+		attr.Key = "binary"
+		actual, _, err = r.resolveConfig(context.Background(), &wasmxdsv1alpha1.WasmExtensionConfigValue{
+			ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{
+				ConfigMapKeyRef: attr,
+			},
+		})
+		require.NoError(t, err)
+		assert.Equal(t, string([]byte{0xff, 0x00}), actual)
+
 		attr.Key = "non-exist"
-		actual, err = r.resolveConfig(&wasmxdsv1alpha1.WasmExtensionConfigValue{
+		actual, _, err = r.resolveConfig(context.Background(), &wasmxdsv1alpha1.WasmExtensionConfigValue{
 			ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{
 				ConfigMapKeyRef: attr,
 			},
@@ -306,7 +435,7 @@ func TestWasmExtensionReconciler_resolveConfig(t *testing.T) {
 			Namespace: sc.Namespace,
 			Key:       key,
 		}
-		actual, err := r.resolveConfig(&wasmxdsv1alpha1.WasmExtensionConfigValue{
+		actual, _, err := r.resolveConfig(context.Background(), &wasmxdsv1alpha1.WasmExtensionConfigValue{
 			ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{
 				SecretKeyRef: attr,
 			},
@@ -315,7 +444,7 @@ func TestWasmExtensionReconciler_resolveConfig(t *testing.T) {
 		assert.Equal(t, string(exp), actual)
 
 		attr.Key = "non-exist"
-		actual, err = r.resolveConfig(&wasmxdsv1alpha1.WasmExtensionConfigValue{
+		actual, _, err = r.resolveConfig(context.Background(), &wasmxdsv1alpha1.WasmExtensionConfigValue{
 			ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{
 				SecretKeyRef: attr,
 			},
@@ -345,26 +474,75 @@ func TestWasmExtensionReconciler_resolveConfigs(t *testing.T) {
 		},
 	}
 
-	pc, vc, err := r.resolveConfigs(crd)
+	pc, vc, err := r.resolveConfigs(context.Background(), crd)
 	require.NoError(t, err)
 	assert.Equal(t, pluginConfigValue, pc)
 	assert.Equal(t, vmConfigValue, vc)
 
 	crd.Spec.VMConfiguration = nil
-	pc, vc, err = r.resolveConfigs(crd)
+	pc, vc, err = r.resolveConfigs(context.Background(), crd)
 	require.NoError(t, err)
 	assert.Equal(t, pluginConfigValue, pc)
 	assert.Equal(t, "", vc)
 
 	crd.Spec.PluginConfiguration = nil
-	pc, vc, err = r.resolveConfigs(crd)
+	pc, vc, err = r.resolveConfigs(context.Background(), crd)
 	require.NoError(t, err)
 	assert.Equal(t, "", pc)
 	assert.Equal(t, "", vc)
 
 	crd.Spec.VMConfiguration = &wasmxdsv1alpha1.WasmExtensionConfigValue{Value: &vmConfigValue}
-	pc, vc, err = r.resolveConfigs(crd)
+	pc, vc, err = r.resolveConfigs(context.Background(), crd)
 	require.NoError(t, err)
 	assert.Equal(t, "", pc)
 	assert.Equal(t, vmConfigValue, vc)
 }
+
+func TestConfigurationRefs(t *testing.T) {
+	attr := func(name string) *wasmxdsv1alpha1.WasmExtensionConfigValueRefAttribute {
+		return &wasmxdsv1alpha1.WasmExtensionConfigValueRefAttribute{Name: name, Namespace: "default", Key: "key"}
+	}
+	ext := &wasmxdsv1alpha1.WasmExtension{Spec: wasmxdsv1alpha1.WasmExtensionSpec{
+		PluginConfiguration: &wasmxdsv1alpha1.WasmExtensionConfigValue{
+			ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{SecretKeyRef: attr("secret")},
+		},
+		VMConfiguration: &wasmxdsv1alpha1.WasmExtensionConfigValue{
+			ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{ConfigMapKeyRef: attr("configmap")},
+		},
+	}}
+	assert.Equal(t, []string{"default/secret"}, configurationRefs(ext, true))
+	assert.Equal(t, []string{"default/configmap"}, configurationRefs(ext, false))
+
+	ext.Spec.VMConfiguration = nil
+	assert.Empty(t, configurationRefs(ext, false))
+}
+
+func TestWasmExtensionReconciler_IsLeader(t *testing.T) {
+	r := &WasmExtensionReconciler{}
+	assert.True(t, r.IsLeader())
+
+	elected := make(chan struct{})
+	r.Elected = elected
+	assert.False(t, r.IsLeader())
+	close(elected)
+	assert.True(t, r.IsLeader())
+}
+
+func TestIsLowerVersion(t *testing.T) {
+	assert.True(t, isLowerVersion("1.4.2", "v1.5.0"))
+	assert.False(t, isLowerVersion("1.5.0", "1.4.2"))
+	assert.False(t, isLowerVersion("latest", "1.4.2"))
+	assert.False(t, isLowerVersion("1.4.2", "latest"))
+}
+
+func TestConfigurationSource(t *testing.T) {
+	attr := &wasmxdsv1alpha1.WasmExtensionConfigValueRefAttribute{Namespace: "default", Name: "config", Key: "key"}
+	assert.Equal(t, "none", configurationSource(nil))
+	assert.Equal(t, "the inline value", configurationSource(&wasmxdsv1alpha1.WasmExtensionConfigValue{}))
+	assert.Equal(t, "key key of ConfigMap default/config", configurationSource(&wasmxdsv1alpha1.WasmExtensionConfigValue{
+		ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{ConfigMapKeyRef: attr},
+	}))
+	assert.Equal(t, "key key of Secret default/config", configurationSource(&wasmxdsv1alpha1.WasmExtensionConfigValue{
+		ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{SecretKeyRef: attr},
+	}))
+}
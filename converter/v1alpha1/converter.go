@@ -25,63 +25,87 @@ import (
 	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
 	wasm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/wasm/v3"
 	v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/wasm/v3"
-	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes/any"
+	structpb "github.com/golang/protobuf/ptypes/struct"
 	"github.com/golang/protobuf/ptypes/wrappers"
-	"go.uber.org/zap"
 
 	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
 )
 
+const typeURLPrefix = "type.googleapis.com/"
+
+var envoyRuntimes = map[string]string{
+	"":                              "envoy.wasm.runtime.v8",
+	wasmxdsv1alpha1.RuntimeV8:       "envoy.wasm.runtime.v8",
+	wasmxdsv1alpha1.RuntimeWAVM:     "envoy.wasm.runtime.wavm",
+	wasmxdsv1alpha1.RuntimeWasmtime: "envoy.wasm.runtime.wasmtime",
+	wasmxdsv1alpha1.RuntimeNull:     "envoy.wasm.runtime.null",
+}
+
 func Convert(ext *wasmxdsv1alpha1.WasmExtension, binary []byte, pluginConfig, vmConfig string) (*core.TypedExtensionConfig, error) {
-	pc, err := ptypes.MarshalAny(&wrappers.StringValue{Value: pluginConfig})
+	pc, err := encodeConfiguration(ext.Spec.PluginConfiguration, pluginConfig)
 	if err != nil {
 		return nil, fmt.Errorf("marshal plugin configuration failed: %w", err)
 	}
 
-	vc, err := ptypes.MarshalAny(&wrappers.StringValue{Value: vmConfig})
+	vc, err := encodeConfiguration(ext.Spec.VMConfiguration, vmConfig)
 	if err != nil {
 		return nil, fmt.Errorf("marshal vm configuration failed: %w", err)
 	}
 
-	// detect the runtime
-	runtime := "envoy.wasm.runtime.v8"
-	switch strings.ToLower(ext.Spec.Runtime) {
-	case "v8", "":
-		break
-	case "wavm":
-		runtime = "envoy.wasm.runtime.wavm"
-	case "wasmtime":
-		runtime = "envoy.wasm.runtime.wasmtime"
-	default:
-		zap.S().Errorf("unknown runtime %q. fall back to v8", ext.Spec.Runtime)
+	runtime, ok := envoyRuntimes[strings.ToLower(ext.Spec.Runtime)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported runtime: %s", ext.Spec.Runtime)
+	}
+
+	code := &core.DataSource{
+		Specifier: &core.DataSource_InlineBytes{
+			InlineBytes: binary,
+		},
+	}
+	if runtime == envoyRuntimes[wasmxdsv1alpha1.RuntimeNull] {
+		// the null runtime takes the name of the plugin compiled into Envoy instead of the binary
+		code = &core.DataSource{
+			Specifier: &core.DataSource_InlineString{
+				InlineString: ext.Spec.BuiltinPlugin,
+			},
+		}
+	}
+
+	allowPrecompiled := true
+	if ext.Spec.AllowPrecompiled != nil {
+		allowPrecompiled = *ext.Spec.AllowPrecompiled
 	}
 
 	// create plugin config
 	plugin := &wasm.Wasm{
 		Config: &v3.PluginConfig{
+			Name:   ext.Spec.PluginName,
 			RootId: ext.Spec.RootID,
-			VmConfig: &v3.PluginConfig_InlineVmConfig{
-				InlineVmConfig: &v3.VmConfig{
+			Vm: &v3.PluginConfig_VmConfig{
+				VmConfig: &v3.VmConfig{
 					Configuration: vc,
 					VmId:          ext.Spec.VMID,
 					Runtime:       runtime,
 					Code: &core.AsyncDataSource{
 						Specifier: &core.AsyncDataSource_Local{
-							Local: &core.DataSource{
-								Specifier: &core.DataSource_InlineBytes{
-									InlineBytes: binary,
-								},
-							},
+							Local: code,
 						},
 					},
-					AllowPrecompiled: true,
+					AllowPrecompiled:     allowPrecompiled,
+					NackOnCodeCacheMiss:  ext.Spec.NackOnCodeCacheMiss,
+					EnvironmentVariables: convertEnvironmentVariables(ext.Spec.EnvironmentVariables),
 				},
 			},
-			Configuration: pc,
+			Configuration:               pc,
+			FailOpen:                    ext.Spec.FailOpen,
+			CapabilityRestrictionConfig: convertCapabilityRestrictionConfig(ext.Spec.CapabilityRestrictionConfig),
 		},
 	}
 
-	typed, err := ptypes.MarshalAny(plugin)
+	typed, err := marshalAny(plugin)
 	if err != nil {
 		return nil, err
 	}
@@ -90,3 +114,61 @@ func Convert(ext *wasmxdsv1alpha1.WasmExtension, binary []byte, pluginConfig, vm
 		TypedConfig: typed,
 	}, nil
 }
+
+// encodeConfiguration wraps the resolved configuration in the message specified by the encoding
+func encodeConfiguration(cv *wasmxdsv1alpha1.WasmExtensionConfigValue, config string) (*any.Any, error) {
+	var encoding string
+	if cv != nil {
+		encoding = cv.Encoding
+	}
+
+	switch encoding {
+	case wasmxdsv1alpha1.ConfigEncodingString, "":
+		return marshalAny(&wrappers.StringValue{Value: config})
+	case wasmxdsv1alpha1.ConfigEncodingStruct:
+		st := &structpb.Struct{}
+		if config != "" {
+			if err := jsonpb.UnmarshalString(config, st); err != nil {
+				return nil, fmt.Errorf("configuration must be a JSON object for the struct encoding: %w", err)
+			}
+		}
+		return marshalAny(st)
+	case wasmxdsv1alpha1.ConfigEncodingBytes:
+		return marshalAny(&wrappers.BytesValue{Value: []byte(config)})
+	default:
+		return nil, fmt.Errorf("unsupported encoding: %s", encoding)
+	}
+}
+
+// marshalAny is ptypes.MarshalAny with the deterministic serialization of the maps such as the struct configurations
+// and the environment variables, so that the same extension always results in the same bytes which the versions are derived from
+func marshalAny(m proto.Message) (*any.Any, error) {
+	b := proto.NewBuffer(nil)
+	b.SetDeterministic(true)
+	if err := b.Marshal(m); err != nil {
+		return nil, err
+	}
+	return &any.Any{TypeUrl: typeURLPrefix + proto.MessageName(m), Value: b.Bytes()}, nil
+}
+
+func convertEnvironmentVariables(env *wasmxdsv1alpha1.WasmExtensionEnvironmentVariables) *v3.EnvironmentVariables {
+	if env == nil {
+		return nil
+	}
+	return &v3.EnvironmentVariables{
+		HostEnvKeys: env.HostEnvKeys,
+		KeyValues:   env.KeyValues,
+	}
+}
+
+func convertCapabilityRestrictionConfig(
+	config *wasmxdsv1alpha1.WasmExtensionCapabilityRestrictionConfig) *v3.CapabilityRestrictionConfig {
+	if config == nil {
+		return nil
+	}
+	allowed := make(map[string]*v3.SanitizationConfig, len(config.AllowedCapabilities))
+	for _, c := range config.AllowedCapabilities {
+		allowed[c] = &v3.SanitizationConfig{}
+	}
+	return &v3.CapabilityRestrictionConfig{AllowedCapabilities: allowed}
+}
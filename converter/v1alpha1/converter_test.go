@@ -1,3 +1,216 @@
 package v1alpha1
 
-// TODO:
+import (
+	"testing"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	wasm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/wasm/v3"
+	v3 "github.com/envoyproxy/go-control-plane/envoy/extensions/wasm/v3"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	structpb "github.com/golang/protobuf/ptypes/struct"
+	"github.com/golang/protobuf/ptypes/wrappers"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+func TestConvert(t *testing.T) {
+	binary := []byte{0x00, 0x61, 0x73, 0x6d}
+	newExt := func() *wasmxdsv1alpha1.WasmExtension {
+		return &wasmxdsv1alpha1.WasmExtension{
+			ObjectMeta: metav1.ObjectMeta{Name: "name", Namespace: "namespace"},
+			Spec: wasmxdsv1alpha1.WasmExtensionSpec{
+				Image:  wasmxdsv1alpha1.WasmExtensionSpecImage{URI: "filter.wasm", Protocol: "local_fs"},
+				VMID:   "vm",
+				RootID: "root",
+			},
+		}
+	}
+
+	pc, err := ptypes.MarshalAny(&wrappers.StringValue{Value: "plugin"})
+	require.NoError(t, err)
+	vc, err := ptypes.MarshalAny(&wrappers.StringValue{Value: "vm"})
+	require.NoError(t, err)
+	localCode := func(local *core.DataSource) *core.AsyncDataSource {
+		return &core.AsyncDataSource{Specifier: &core.AsyncDataSource_Local{Local: local}}
+	}
+	inlineBytes := &core.DataSource{Specifier: &core.DataSource_InlineBytes{InlineBytes: binary}}
+
+	for _, c := range []struct {
+		name   string
+		mutate func(ext *wasmxdsv1alpha1.WasmExtension)
+		exp    *v3.PluginConfig
+	}{
+		{
+			name:   "default",
+			mutate: func(ext *wasmxdsv1alpha1.WasmExtension) {},
+			exp: &v3.PluginConfig{
+				RootId: "root",
+				Vm: &v3.PluginConfig_VmConfig{VmConfig: &v3.VmConfig{
+					VmId:             "vm",
+					Runtime:          "envoy.wasm.runtime.v8",
+					Code:             localCode(inlineBytes),
+					Configuration:    vc,
+					AllowPrecompiled: true,
+				}},
+				Configuration: pc,
+			},
+		},
+		{
+			name: "all options",
+			mutate: func(ext *wasmxdsv1alpha1.WasmExtension) {
+				allowPrecompiled := false
+				ext.Spec.Runtime = "Wasmtime"
+				ext.Spec.PluginName = "plugin"
+				ext.Spec.FailOpen = true
+				ext.Spec.AllowPrecompiled = &allowPrecompiled
+				ext.Spec.NackOnCodeCacheMiss = true
+				ext.Spec.EnvironmentVariables = &wasmxdsv1alpha1.WasmExtensionEnvironmentVariables{
+					HostEnvKeys: []string{"HOSTNAME"},
+					KeyValues:   map[string]string{"FOO": "bar"},
+				}
+				ext.Spec.CapabilityRestrictionConfig = &wasmxdsv1alpha1.WasmExtensionCapabilityRestrictionConfig{
+					AllowedCapabilities: []string{"proxy_log", "proxy_on_request_headers"},
+				}
+			},
+			exp: &v3.PluginConfig{
+				Name:   "plugin",
+				RootId: "root",
+				Vm: &v3.PluginConfig_VmConfig{VmConfig: &v3.VmConfig{
+					VmId:                "vm",
+					Runtime:             "envoy.wasm.runtime.wasmtime",
+					Code:                localCode(inlineBytes),
+					Configuration:       vc,
+					NackOnCodeCacheMiss: true,
+					EnvironmentVariables: &v3.EnvironmentVariables{
+						HostEnvKeys: []string{"HOSTNAME"},
+						KeyValues:   map[string]string{"FOO": "bar"},
+					},
+				}},
+				Configuration: pc,
+				FailOpen:      true,
+				CapabilityRestrictionConfig: &v3.CapabilityRestrictionConfig{
+					AllowedCapabilities: map[string]*v3.SanitizationConfig{
+						"proxy_log":                {},
+						"proxy_on_request_headers": {},
+					},
+				},
+			},
+		},
+		{
+			name: "null runtime",
+			mutate: func(ext *wasmxdsv1alpha1.WasmExtension) {
+				ext.Spec.Image = wasmxdsv1alpha1.WasmExtensionSpecImage{}
+				ext.Spec.Runtime = wasmxdsv1alpha1.RuntimeNull
+				ext.Spec.BuiltinPlugin = "envoy.wasm.metadata_exchange"
+			},
+			exp: &v3.PluginConfig{
+				RootId: "root",
+				Vm: &v3.PluginConfig_VmConfig{VmConfig: &v3.VmConfig{
+					VmId:    "vm",
+					Runtime: "envoy.wasm.runtime.null",
+					Code: localCode(&core.DataSource{
+						Specifier: &core.DataSource_InlineString{InlineString: "envoy.wasm.metadata_exchange"},
+					}),
+					Configuration:    vc,
+					AllowPrecompiled: true,
+				}},
+				Configuration: pc,
+			},
+		},
+	} {
+		c := c
+		t.Run(c.name, func(t *testing.T) {
+			ext := newExt()
+			c.mutate(ext)
+			actual, err := Convert(ext, binary, "plugin", "vm")
+			require.NoError(t, err)
+			assert.Equal(t, "namespace/name", actual.Name)
+
+			var w wasm.Wasm
+			require.NoError(t, ptypes.UnmarshalAny(actual.TypedConfig, &w))
+			assert.True(t, proto.Equal(&wasm.Wasm{Config: c.exp}, &w), w.String())
+		})
+	}
+
+	t.Run("unsupported runtime", func(t *testing.T) {
+		ext := newExt()
+		ext.Spec.Runtime = "v9"
+		_, err := Convert(ext, binary, "", "")
+		assert.Error(t, err)
+	})
+
+	t.Run("deterministic", func(t *testing.T) {
+		ext := newExt()
+		ext.Spec.EnvironmentVariables = &wasmxdsv1alpha1.WasmExtensionEnvironmentVariables{
+			KeyValues: map[string]string{"A": "1", "B": "2", "C": "3", "D": "4", "E": "5", "F": "6"},
+		}
+		ext.Spec.PluginConfiguration = &wasmxdsv1alpha1.WasmExtensionConfigValue{Encoding: wasmxdsv1alpha1.ConfigEncodingStruct}
+		config := `{"a":1,"b":2,"c":3,"d":4,"e":5,"f":6}`
+
+		expected, err := Convert(ext, binary, config, "")
+		require.NoError(t, err)
+		// the maps are serialized in random orders without the deterministic serialization
+		for i := 0; i < 20; i++ {
+			actual, err := Convert(ext, binary, config, "")
+			require.NoError(t, err)
+			assert.Equal(t, expected.TypedConfig.Value, actual.TypedConfig.Value)
+		}
+	})
+}
+
+func TestEncodeConfiguration(t *testing.T) {
+	t.Run("string", func(t *testing.T) {
+		for _, cv := range []*wasmxdsv1alpha1.WasmExtensionConfigValue{
+			nil,
+			{},
+			{Encoding: wasmxdsv1alpha1.ConfigEncodingString},
+		} {
+			actual, err := encodeConfiguration(cv, `{"foo":"bar"}`)
+			require.NoError(t, err)
+
+			var v wrappers.StringValue
+			require.NoError(t, ptypes.UnmarshalAny(actual, &v))
+			assert.Equal(t, `{"foo":"bar"}`, v.Value)
+		}
+	})
+
+	t.Run("struct", func(t *testing.T) {
+		cv := &wasmxdsv1alpha1.WasmExtensionConfigValue{Encoding: wasmxdsv1alpha1.ConfigEncodingStruct}
+		actual, err := encodeConfiguration(cv, `{"foo":"bar","list":[1,true]}`)
+		require.NoError(t, err)
+
+		var v structpb.Struct
+		require.NoError(t, ptypes.UnmarshalAny(actual, &v))
+		assert.Equal(t, "bar", v.Fields["foo"].GetStringValue())
+		assert.Len(t, v.Fields["list"].GetListValue().GetValues(), 2)
+
+		actual, err = encodeConfiguration(cv, "")
+		require.NoError(t, err)
+		require.NoError(t, ptypes.UnmarshalAny(actual, &v))
+		assert.True(t, proto.Equal(&structpb.Struct{}, &v))
+
+		_, err = encodeConfiguration(cv, `["not", "object"]`)
+		assert.Error(t, err)
+	})
+
+	t.Run("bytes", func(t *testing.T) {
+		// invalid utf-8 which cannot be encoded as StringValue
+		config := string([]byte{0xff, 0xfe, 0x00})
+		cv := &wasmxdsv1alpha1.WasmExtensionConfigValue{Encoding: wasmxdsv1alpha1.ConfigEncodingBytes}
+		actual, err := encodeConfiguration(cv, config)
+		require.NoError(t, err)
+
+		var v wrappers.BytesValue
+		require.NoError(t, ptypes.UnmarshalAny(actual, &v))
+		assert.Equal(t, []byte(config), v.Value)
+	})
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := encodeConfiguration(&wasmxdsv1alpha1.WasmExtensionConfigValue{Encoding: "yaml"}, "")
+		assert.Error(t, err)
+	})
+}
@@ -0,0 +1,35 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package v1alpha2
+
+import (
+	"fmt"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	wasmxdsv1alpha2 "github.com/tetratelabs/wasmxds/api/v1alpha2"
+	v1converter "github.com/tetratelabs/wasmxds/converter/v1alpha1"
+)
+
+// Convert converts the extension through the hub version (v1alpha1) so that
+// the result is always identical to the one of the v1alpha1 converter
+func Convert(ext *wasmxdsv1alpha2.WasmExtension, binary []byte, pluginConfig, vmConfig string) (*core.TypedExtensionConfig, error) {
+	hub := &wasmxdsv1alpha1.WasmExtension{}
+	if err := ext.ConvertTo(hub); err != nil {
+		return nil, fmt.Errorf("failed to convert to %s: %w", wasmxdsv1alpha1.GroupVersion, err)
+	}
+	return v1converter.Convert(hub, binary, pluginConfig, vmConfig)
+}
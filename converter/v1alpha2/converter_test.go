@@ -0,0 +1,47 @@
+package v1alpha2
+
+import (
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	wasmxdsv1alpha2 "github.com/tetratelabs/wasmxds/api/v1alpha2"
+	v1converter "github.com/tetratelabs/wasmxds/converter/v1alpha1"
+)
+
+func TestConvert(t *testing.T) {
+	ext := &wasmxdsv1alpha2.WasmExtension{
+		ObjectMeta: metav1.ObjectMeta{Name: "name", Namespace: "namespace"},
+		Spec: wasmxdsv1alpha2.WasmExtensionSpec{
+			Image: wasmxdsv1alpha2.WasmExtensionImage{
+				HTTP: &wasmxdsv1alpha2.HTTPImageSource{URL: "https://example.com/filter.wasm"},
+			},
+			VM:     wasmxdsv1alpha2.WasmExtensionVM{ID: "vm", Runtime: wasmxdsv1alpha1.RuntimeWAVM},
+			RootID: "root",
+		},
+	}
+
+	binary := []byte{0x00, 0x61, 0x73, 0x6d}
+	actual, err := Convert(ext, binary, "plugin", "vm")
+	require.NoError(t, err)
+
+	expected, err := v1converter.Convert(&wasmxdsv1alpha1.WasmExtension{
+		ObjectMeta: metav1.ObjectMeta{Name: "name", Namespace: "namespace"},
+		Spec: wasmxdsv1alpha1.WasmExtensionSpec{
+			Image:   wasmxdsv1alpha1.WasmExtensionSpecImage{URI: "example.com/filter.wasm", Protocol: "https"},
+			VMID:    "vm",
+			RootID:  "root",
+			Runtime: wasmxdsv1alpha1.RuntimeWAVM,
+		},
+	}, binary, "plugin", "vm")
+	require.NoError(t, err)
+	assert.True(t, proto.Equal(expected, actual))
+
+	ext.Spec.Image = wasmxdsv1alpha2.WasmExtensionImage{}
+	_, err = Convert(ext, binary, "plugin", "vm")
+	assert.Error(t, err)
+}
@@ -112,7 +112,7 @@ func pushOCIImage(t *testing.T) {
 	protocolToSha256[wasmxdsv1alpha1.ProtocolOCIImageRegistry] = hex.EncodeToString(raw[:])
 
 	localRegistry := ociregistory.NewLocalRegistry("", "", "5000")
-	err = localRegistry.Push(contents, localOCIRef)
+	err = localRegistry.Push(contents, localOCIRef, nil)
 	require.NoError(t, err)
 
 	if testAgainstAmazonECR {
@@ -132,7 +132,7 @@ func pushOCIImage(t *testing.T) {
 			t.Fatal("unable to fine image provider for Amazon ECR")
 		}
 		amazonECROCIRef = fmt.Sprintf(amazonECROCIRef, ae.Host())
-		err = ae.Push(contents, amazonECROCIRef)
+		err = ae.Push(contents, amazonECROCIRef, nil)
 		require.NoError(t, err)
 	}
 }
@@ -0,0 +1,306 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package filesource drives the EventHandler from the WasmExtension manifests in a local directory
+// so that ECDS can be served without Kubernetes.
+package filesource
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/equality"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	"github.com/tetratelabs/wasmxds/manifest"
+	"github.com/tetratelabs/wasmxds/wasmxds"
+)
+
+// a burst of file events, e.g. made by an editor saving a file, are coalesced into one sync
+const debouncePeriod = 200 * time.Millisecond
+
+// Source watches a directory of WasmExtension manifests and relays the changes to the EventHandler.
+// The configurations referenced by configMapKeyRef and secretKeyRef are read from
+// "<configDir>/<namespace>/<name>/<key>", which is the same layout as the ConfigMaps and Secrets mounted as volumes.
+type Source struct {
+	dir, configDir string
+	resyncPeriod   time.Duration
+	handler        wasmxds.EventHandler
+	readiness      wasmxds.ReadinessTracker
+	logger         logr.Logger
+
+	// the last applied state keyed by the namespaced name
+	applied map[string]*appliedExtension
+}
+
+type appliedExtension struct {
+	extension *wasmxdsv1alpha1.WasmExtension
+	// path is the file the extension was read from
+	path                   string
+	pluginConfig, vmConfig string
+	// failures is the number of the failed updates in a row, which are retried with the backoff in spec.image.retry
+	failures int
+	// stalled is true if the last update failed permanently, and is not retried until the extension changes
+	stalled bool
+	// recheckAt is when the update is due again even if nothing has changed, e.g. to resolve the version constraint
+	// or to retry the failure
+	recheckAt time.Time
+}
+
+// due returns true if the unchanged extension needs to be updated again
+func (a *appliedExtension) due(now time.Time) bool {
+	return !a.stalled && !a.recheckAt.IsZero() && !now.Before(a.recheckAt)
+}
+
+// NewSource returns the Source which reads the manifests in dir, which are also read again every resyncPeriod.
+func NewSource(dir, configDir string, resyncPeriod time.Duration, handler wasmxds.EventHandler) *Source {
+	return &Source{
+		dir:          dir,
+		configDir:    configDir,
+		resyncPeriod: resyncPeriod,
+		handler:      handler,
+		logger:       ctrl.Log.WithName("FileSource"),
+		applied:      map[string]*appliedExtension{},
+	}
+}
+
+// SetReadinessTracker sets the tracker told when the initial sync has attempted all the extensions in the directory
+func (s *Source) SetReadinessTracker(tracker wasmxds.ReadinessTracker) {
+	s.readiness = tracker
+}
+
+// Start syncs the extensions with the directory and keeps watching it until the context is done
+func (s *Source) Start(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return fmt.Errorf("failed to create watcher: %w", err)
+	}
+	defer watcher.Close()
+
+	if err := watcher.Add(s.dir); err != nil {
+		return fmt.Errorf("failed to watch %s: %w", s.dir, err)
+	}
+	s.watchConfigDir(watcher)
+	s.Sync(ctx)
+	if s.readiness != nil {
+		// every extension has been attempted synchronously, so there's nothing left to wait for
+		s.readiness.ExpectExtensions(nil)
+	}
+
+	resync := time.NewTicker(s.resyncPeriod)
+	defer resync.Stop()
+	var debounce <-chan time.Time
+	for {
+		// the retries and the rechecks may be due before the next resync
+		var recheck <-chan time.Time
+		var timer *time.Timer
+		if at := s.nextRecheck(); !at.IsZero() {
+			timer = time.NewTimer(time.Until(at))
+			recheck = timer.C
+		}
+		select {
+		case <-ctx.Done():
+			stopTimer(timer)
+			return nil
+		case ev := <-watcher.Events:
+			s.logger.V(1).Info("file event", "name", ev.Name, "op", ev.Op.String())
+			if debounce == nil {
+				debounce = time.After(debouncePeriod)
+			}
+		case err := <-watcher.Errors:
+			s.logger.Error(err, "watch error")
+		case <-debounce:
+			debounce = nil
+			// new directories may have been created under the config directory
+			s.watchConfigDir(watcher)
+			s.Sync(ctx)
+		case <-resync.C:
+			s.Sync(ctx)
+		case <-recheck:
+			s.Sync(ctx)
+		}
+		stopTimer(timer)
+	}
+}
+
+func stopTimer(timer *time.Timer) {
+	if timer != nil {
+		timer.Stop()
+	}
+}
+
+// watchConfigDir adds all the directories under the config directory to the watcher as fsnotify is not recursive
+func (s *Source) watchConfigDir(watcher *fsnotify.Watcher) {
+	if s.configDir == "" {
+		return
+	}
+	_ = filepath.Walk(s.configDir, func(path string, info os.FileInfo, err error) error {
+		if err == nil && info.IsDir() {
+			if err := watcher.Add(path); err != nil {
+				s.logger.Error(err, "failed to watch config directory", "path", path)
+			}
+		}
+		return nil
+	})
+}
+
+// Sync reads all the manifests and the configurations, and calls Update for the new or changed extensions
+// and Delete for the removed ones. The updates are aborted when the context is done.
+func (s *Source) Sync(ctx context.Context) {
+	desired, failed, err := s.load()
+	if err != nil {
+		// keep the current state rather than deleting everything because of e.g. a temporary read error
+		s.logger.Error(err, "failed to load extensions", "dir", s.dir)
+		return
+	}
+
+	for key, d := range desired {
+		ext := d.extension
+		pc, vc, err := manifest.ResolveConfigs(s.configDir, ext)
+		if err != nil {
+			s.logger.Error(err, "failed to resolve configurations", "name", key)
+			continue
+		}
+
+		current, ok := s.applied[key]
+		unchanged := ok && current.pluginConfig == pc && current.vmConfig == vc &&
+			equality.Semantic.DeepEqual(current.extension.Spec, ext.Spec)
+		if unchanged && !current.due(time.Now()) {
+			// the extension may have been moved to another file
+			current.path = d.path
+			continue
+		}
+
+		if ok {
+			// carry over what was observed, e.g. the resolved tag, as the status is not persisted in the files
+			current.extension.Status.DeepCopyInto(&ext.Status)
+		}
+		s.logger.Info("updating extension", "name", key)
+		res, err := s.handler.Update(ctx, ext, pc, vc)
+		applied := &appliedExtension{extension: ext, path: d.path, pluginConfig: pc, vmConfig: vc}
+		if err != nil {
+			applied.failures = 1
+			if unchanged {
+				applied.failures += current.failures
+			}
+			if delay, ok := wasmxds.RetryDelay(ext, applied.failures, err); ok {
+				s.logger.Error(err, "failed to update extension, retrying", "name", key,
+					"failures", applied.failures, "delay", delay)
+				applied.recheckAt = time.Now().Add(delay)
+			} else {
+				s.logger.Error(err, "failed to update extension, not retrying until it changes", "name", key,
+					"failures", applied.failures)
+				applied.stalled = true
+			}
+		} else if res.RequeueAfter > 0 {
+			applied.recheckAt = time.Now().Add(res.RequeueAfter)
+		}
+		s.applied[key] = applied
+	}
+
+	for key, current := range s.applied {
+		if failed[current.path] {
+			// keep serving the extensions of the file which fails to load, e.g. because of a typo or a partial write
+			continue
+		}
+		if _, ok := desired[key]; !ok {
+			s.logger.Info("deleting extension", "name", key)
+			s.handler.Delete(current.extension)
+			delete(s.applied, key)
+		}
+	}
+}
+
+// nextRecheck returns the earliest time when an unchanged extension is due, or zero if none is
+func (s *Source) nextRecheck() time.Time {
+	var next time.Time
+	for _, a := range s.applied {
+		if !a.stalled && !a.recheckAt.IsZero() && (next.IsZero() || a.recheckAt.Before(next)) {
+			next = a.recheckAt
+		}
+	}
+	return next
+}
+
+// desiredExtension is the extension read from the file at path
+type desiredExtension struct {
+	extension *wasmxdsv1alpha1.WasmExtension
+	path      string
+}
+
+// load reads the extensions from the YAML or JSON files in the directory, which may contain multiple documents,
+// and returns them along with the paths of the files which have failed to load
+func (s *Source) load() (map[string]*desiredExtension, map[string]bool, error) {
+	files, err := ioutil.ReadDir(s.dir)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ret := map[string]*desiredExtension{}
+	failed := map[string]bool{}
+	for _, f := range files {
+		if f.IsDir() || !isManifest(f.Name()) {
+			continue
+		}
+
+		path := filepath.Join(s.dir, f.Name())
+		exts, err := s.loadFile(path)
+		if err != nil {
+			// skip the file with errors such as the one being written
+			s.logger.Error(err, "failed to load file", "path", path)
+			failed[path] = true
+			continue
+		}
+		for _, ext := range exts {
+			key := ext.Namespaced()
+			if _, ok := ret[key]; ok {
+				s.logger.Info("ignoring duplicated extension", "name", key, "path", path)
+				continue
+			}
+			ret[key] = &desiredExtension{extension: ext, path: path}
+		}
+	}
+	return ret, failed, nil
+}
+
+func (s *Source) loadFile(path string) ([]*wasmxdsv1alpha1.WasmExtension, error) {
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	m, err := manifest.Decode(raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(m.Policies) > 0 {
+		s.logger.Info("ignoring policies as they are not supported", "path", path)
+	}
+	return m.Extensions, nil
+}
+
+func isManifest(name string) bool {
+	switch filepath.Ext(name) {
+	case ".yaml", ".yml", ".json":
+		return true
+	}
+	return false
+}
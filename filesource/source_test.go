@@ -0,0 +1,217 @@
+package filesource
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	ctrl "sigs.k8s.io/controller-runtime"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+)
+
+type update struct {
+	name, image            string
+	pluginConfig, vmConfig string
+}
+
+type fakeHandler struct {
+	updates      []update
+	deletes      []string
+	requeueAfter time.Duration
+	err          error
+}
+
+func (h *fakeHandler) Update(_ context.Context, ext *wasmxdsv1alpha1.WasmExtension, pluginConfig, vmConfig string) (ctrl.Result, error) {
+	h.updates = append(h.updates, update{
+		name: ext.Namespaced(), image: ext.Spec.Image.URI, pluginConfig: pluginConfig, vmConfig: vmConfig,
+	})
+	return ctrl.Result{RequeueAfter: h.requeueAfter}, h.err
+}
+
+func (h *fakeHandler) Delete(ext *wasmxdsv1alpha1.WasmExtension) {
+	h.deletes = append(h.deletes, ext.Namespaced())
+}
+
+const (
+	v1alpha1Manifest = `
+apiVersion: wasmxds.tetrate.io/v1alpha1
+kind: WasmExtension
+metadata:
+  name: v1
+spec:
+  image:
+    uri: filter.wasm
+    protocol: local_fs
+  vm_id: vm
+  root_id: root
+  runtime: v8
+  plugin_configuration:
+    valueFrom:
+      configMapKeyRef:
+        namespace: default
+        name: plugin
+        key: config.json
+`
+	v1alpha2Manifest = `
+apiVersion: wasmxds.tetrate.io/v1alpha2
+kind: WasmExtension
+metadata:
+  name: v2
+  namespace: foo
+spec:
+  rootID: root
+  image:
+    localFS:
+      path: filter.wasm
+  vm:
+    id: vm
+    runtime: v8
+---
+# comment only
+`
+)
+
+func TestSource_Sync(t *testing.T) {
+	dir, err := ioutil.TempDir("", "filesource")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	configDir := filepath.Join(dir, "config")
+	write := func(t *testing.T, path, content string) {
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	configPath := filepath.Join(configDir, "default", "plugin", "config.json")
+
+	h := &fakeHandler{}
+	s := NewSource(dir, configDir, time.Minute, h)
+
+	t.Run("create", func(t *testing.T) {
+		write(t, configPath, `{"a":1}`)
+		write(t, filepath.Join(dir, "v1.yaml"), v1alpha1Manifest)
+		write(t, filepath.Join(dir, "v2.yaml"), v1alpha2Manifest)
+		write(t, filepath.Join(dir, "README.md"), "not a manifest")
+		s.Sync(context.Background())
+		assert.ElementsMatch(t, []update{
+			{name: "default/v1", image: "filter.wasm", pluginConfig: `{"a":1}`},
+			{name: "foo/v2", image: "filter.wasm"},
+		}, h.updates)
+	})
+
+	t.Run("unchanged", func(t *testing.T) {
+		h.updates = nil
+		s.Sync(context.Background())
+		assert.Empty(t, h.updates)
+	})
+
+	t.Run("config modified", func(t *testing.T) {
+		write(t, configPath, `{"a":2}`)
+		s.Sync(context.Background())
+		assert.Equal(t, []update{{name: "default/v1", image: "filter.wasm", pluginConfig: `{"a":2}`}}, h.updates)
+	})
+
+	t.Run("recheck", func(t *testing.T) {
+		h.updates = nil
+		h.requeueAfter = time.Nanosecond
+		write(t, configPath, `{"a":3}`)
+		s.Sync(context.Background())
+		require.Len(t, h.updates, 1)
+
+		// due again although nothing has changed
+		h.requeueAfter = 0
+		s.Sync(context.Background())
+		assert.Len(t, h.updates, 2)
+
+		s.Sync(context.Background())
+		assert.Len(t, h.updates, 2)
+	})
+
+	t.Run("transient failure", func(t *testing.T) {
+		h.updates = nil
+		h.err = fetcherr.Transient(errors.New("timeout"))
+		write(t, configPath, `{"a":4}`)
+		s.Sync(context.Background())
+		require.Len(t, h.updates, 1)
+		assert.Equal(t, 1, s.applied["default/v1"].failures)
+
+		// backing off
+		s.Sync(context.Background())
+		assert.Len(t, h.updates, 1)
+
+		s.applied["default/v1"].recheckAt = time.Now()
+		s.Sync(context.Background())
+		assert.Len(t, h.updates, 2)
+		assert.Equal(t, 2, s.applied["default/v1"].failures)
+	})
+
+	t.Run("missing image", func(t *testing.T) {
+		h.updates = nil
+		h.err = fetcherr.NotFound(errors.New("no such file"))
+		s.applied["default/v1"].recheckAt = time.Now()
+		s.Sync(context.Background())
+		require.Len(t, h.updates, 1)
+		assert.False(t, s.applied["default/v1"].stalled)
+		// retried less often than the transient failures
+		assert.True(t, s.applied["default/v1"].recheckAt.After(time.Now().Add(59*time.Second)))
+
+		s.applied["default/v1"].recheckAt = time.Now()
+		s.Sync(context.Background())
+		assert.Len(t, h.updates, 2)
+	})
+
+	t.Run("permanent failure", func(t *testing.T) {
+		h.updates = nil
+		h.err = fetcherr.Invalid(errors.New("sha256 mismatch"))
+		s.applied["default/v1"].recheckAt = time.Now()
+		s.Sync(context.Background())
+		require.Len(t, h.updates, 1)
+		assert.True(t, s.applied["default/v1"].stalled)
+
+		s.Sync(context.Background())
+		assert.Len(t, h.updates, 1)
+
+		// retried once changed
+		h.err = nil
+		write(t, configPath, `{"a":5}`)
+		s.Sync(context.Background())
+		assert.Len(t, h.updates, 2)
+		assert.Equal(t, 0, s.applied["default/v1"].failures)
+		assert.False(t, s.applied["default/v1"].stalled)
+	})
+
+	t.Run("invalid file", func(t *testing.T) {
+		h.updates = nil
+		// the extensions of the corrupted file keep being served
+		write(t, filepath.Join(dir, "v2.yaml"), "spec: [")
+		s.Sync(context.Background())
+		assert.Empty(t, h.updates)
+		assert.Empty(t, h.deletes)
+		assert.Contains(t, s.applied, "foo/v2")
+
+		write(t, filepath.Join(dir, "v2.yaml"), strings.Replace(v1alpha2Manifest, "runtime: v8", "runtime: unknown", 1))
+		s.Sync(context.Background())
+		assert.Empty(t, h.updates)
+		assert.Empty(t, h.deletes)
+
+		// and are deleted once the file is fixed without them
+		write(t, filepath.Join(dir, "v2.yaml"), "# no extensions")
+		s.Sync(context.Background())
+		assert.Equal(t, []string{"foo/v2"}, h.deletes)
+	})
+
+	t.Run("deleted", func(t *testing.T) {
+		h.deletes = nil
+		require.NoError(t, os.Remove(filepath.Join(dir, "v1.yaml")))
+		s.Sync(context.Background())
+		assert.Equal(t, []string{"default/v1"}, h.deletes)
+	})
+}
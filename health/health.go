@@ -0,0 +1,214 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package health serves the liveness and readiness checks over HTTP for the Kubernetes probes,
+// and the serving status of the gRPC services through grpc.health.v1.Health.
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+
+	// checkTimeout bounds each check run on a request
+	checkTimeout = 5 * time.Second
+)
+
+// Check returns an error if unhealthy
+type Check func(ctx context.Context) error
+
+// Result is the result of a check
+type Result struct {
+	Name    string `json:"name"`
+	Healthy bool   `json:"healthy"`
+	Error   string `json:"error,omitempty"`
+	// Optional is true if the check is reported without failing the endpoint
+	Optional bool `json:"optional,omitempty"`
+}
+
+// Response is the body of the endpoints
+type Response struct {
+	Healthy bool     `json:"healthy"`
+	Checks  []Result `json:"checks"`
+}
+
+type namedCheck struct {
+	name     string
+	check    Check
+	optional bool
+}
+
+// Server serves the liveness checks at /healthz and the readiness checks at /readyz, which respond 200 if all
+// the required checks pass and 503 otherwise, with the results of the checks in JSON.
+// Each check can be run individually at e.g. /readyz/<name>, which fails even if the check is optional.
+// The checks must be added before serving.
+type Server struct {
+	liveness, readiness []namedCheck
+}
+
+func NewServer() *Server {
+	return &Server{}
+}
+
+// AddLivenessCheck adds the check to /healthz
+func (s *Server) AddLivenessCheck(name string, check Check) {
+	s.liveness = append(s.liveness, namedCheck{name: name, check: check})
+}
+
+// AddReadinessCheck adds the check to /readyz
+func (s *Server) AddReadinessCheck(name string, check Check) {
+	s.readiness = append(s.readiness, namedCheck{name: name, check: check})
+}
+
+// AddOptionalReadinessCheck adds the check to /readyz, which is reported without failing the endpoint
+func (s *Server) AddOptionalReadinessCheck(name string, check Check) {
+	s.readiness = append(s.readiness, namedCheck{name: name, check: check, optional: true})
+}
+
+// Handler returns the handler serving the endpoints
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	for path, checks := range map[string][]namedCheck{LivenessPath: s.liveness, ReadinessPath: s.readiness} {
+		h := &handler{path: path, checks: checks}
+		mux.Handle(path, h)
+		mux.Handle(path+"/", h)
+	}
+	return mux
+}
+
+type handler struct {
+	path   string
+	checks []namedCheck
+}
+
+func (h *handler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	checks := h.checks
+	if name := strings.TrimPrefix(strings.TrimPrefix(r.URL.Path, h.path), "/"); name != "" {
+		checks = nil
+		for _, c := range h.checks {
+			if c.name == name {
+				// the check run individually is required
+				checks = []namedCheck{{name: c.name, check: c.check}}
+			}
+		}
+		if checks == nil {
+			http.NotFound(w, r)
+			return
+		}
+	}
+
+	resp := run(r.Context(), checks)
+	w.Header().Set("Content-Type", "application/json")
+	if !resp.Healthy {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+// run runs the checks concurrently, and returns the results in the order of the checks
+func run(ctx context.Context, checks []namedCheck) *Response {
+	ctx, cancel := context.WithTimeout(ctx, checkTimeout)
+	defer cancel()
+
+	resp := &Response{Healthy: true, Checks: make([]Result, len(checks))}
+	var wg sync.WaitGroup
+	for i, c := range checks {
+		wg.Add(1)
+		go func(i int, c namedCheck) {
+			defer wg.Done()
+			res := Result{Name: c.name, Healthy: true, Optional: c.optional}
+			if err := c.check(ctx); err != nil {
+				res.Healthy, res.Error = false, err.Error()
+			}
+			resp.Checks[i] = res
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, res := range resp.Checks {
+		if !res.Healthy && !res.Optional {
+			resp.Healthy = false
+		}
+	}
+	return resp
+}
+
+// Closed returns the check which fails with the message until the channel is closed
+func Closed(ch <-chan struct{}, message string) Check {
+	return func(context.Context) error {
+		select {
+		case <-ch:
+			return nil
+		default:
+			return errors.New(message)
+		}
+	}
+}
+
+// Periodic returns the check reporting the last result of the check run every interval in the background
+// until the context is done, so that the probes don't hit e.g. the remote registries on every request
+func Periodic(ctx context.Context, check Check, interval time.Duration) Check {
+	var mu sync.Mutex
+	last := errors.New("not checked yet")
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			checkCtx, cancel := context.WithTimeout(ctx, checkTimeout)
+			err := check(checkCtx)
+			cancel()
+			mu.Lock()
+			last = err
+			mu.Unlock()
+
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	}()
+	return func(context.Context) error {
+		mu.Lock()
+		defer mu.Unlock()
+		return last
+	}
+}
+
+// ServeWhenReady sets the gRPC services, and the server as a whole, NOT_SERVING until the channel is closed,
+// and SERVING afterwards
+func ServeWhenReady(s *grpchealth.Server, ready <-chan struct{}, services ...string) {
+	services = append([]string{""}, services...)
+	for _, service := range services {
+		s.SetServingStatus(service, healthpb.HealthCheckResponse_NOT_SERVING)
+	}
+	go func() {
+		<-ready
+		for _, service := range services {
+			s.SetServingStatus(service, healthpb.HealthCheckResponse_SERVING)
+		}
+	}()
+}
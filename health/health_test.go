@@ -0,0 +1,104 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func get(t *testing.T, h http.Handler, path string) (int, *Response) {
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+	if rec.Code == http.StatusNotFound {
+		return rec.Code, nil
+	}
+	var resp Response
+	require.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resp))
+	return rec.Code, &resp
+}
+
+func TestServer(t *testing.T) {
+	ready := make(chan struct{})
+	s := NewServer()
+	s.AddLivenessCheck("ping", func(context.Context) error { return nil })
+	s.AddReadinessCheck("extensions", Closed(ready, "not ready"))
+	s.AddOptionalReadinessCheck("provider/oci||localhost:5000", func(context.Context) error {
+		return errors.New("connection refused")
+	})
+	h := s.Handler()
+
+	code, resp := get(t, h, "/healthz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, &Response{Healthy: true, Checks: []Result{{Name: "ping", Healthy: true}}}, resp)
+
+	code, resp = get(t, h, "/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, &Response{Checks: []Result{
+		{Name: "extensions", Error: "not ready"},
+		{Name: "provider/oci||localhost:5000", Error: "connection refused", Optional: true},
+	}}, resp)
+
+	// the optional checks don't fail the endpoint
+	close(ready)
+	code, resp = get(t, h, "/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, resp.Healthy)
+	assert.Len(t, resp.Checks, 2)
+
+	// but fail when run individually
+	code, resp = get(t, h, "/readyz/provider/oci||localhost:5000")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, []Result{{Name: "provider/oci||localhost:5000", Error: "connection refused"}}, resp.Checks)
+	code, _ = get(t, h, "/readyz/extensions")
+	assert.Equal(t, http.StatusOK, code)
+	code, _ = get(t, h, "/readyz/unknown")
+	assert.Equal(t, http.StatusNotFound, code)
+}
+
+func TestPeriodic(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	errs := make(chan error)
+	check := Periodic(ctx, func(ctx context.Context) error {
+		select {
+		case err := <-errs:
+			return err
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}, time.Millisecond)
+	assert.Error(t, check(ctx))
+
+	errs <- nil
+	errs <- errors.New("unreachable")
+	errs <- nil
+	assert.Eventually(t, func() bool { return check(ctx) == nil }, time.Second, time.Millisecond)
+}
+
+func TestServeWhenReady(t *testing.T) {
+	s := grpchealth.NewServer()
+	ready := make(chan struct{})
+	ServeWhenReady(s, ready, "foo")
+
+	status := func(service string) healthpb.HealthCheckResponse_ServingStatus {
+		resp, err := s.Check(context.Background(), &healthpb.HealthCheckRequest{Service: service})
+		require.NoError(t, err)
+		return resp.Status
+	}
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status(""))
+	assert.Equal(t, healthpb.HealthCheckResponse_NOT_SERVING, status("foo"))
+
+	close(ready)
+	assert.Eventually(t, func() bool {
+		return status("") == healthpb.HealthCheckResponse_SERVING && status("foo") == healthpb.HealthCheckResponse_SERVING
+	}, time.Second, time.Millisecond)
+}
@@ -0,0 +1,76 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package health
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+)
+
+// informerSyncTimeout is how long the readiness check waits for the informer caches to sync
+const informerSyncTimeout = time.Second
+
+// managerProbe is run by the manager on every replica to observe whether it has started and stopped.
+// The manager starts it once the informer caches have synced.
+type managerProbe struct {
+	started, stopped chan struct{}
+}
+
+func (p *managerProbe) Start(stop <-chan struct{}) error {
+	close(p.started)
+	<-stop
+	close(p.stopped)
+	return nil
+}
+
+func (*managerProbe) NeedLeaderElection() bool {
+	return false
+}
+
+// AddManagerChecks adds the liveness check "manager", which fails once the manager has stopped,
+// and the readiness check "informers", which passes once the manager has started and the informer caches have synced
+func (s *Server) AddManagerChecks(mgr manager.Manager) error {
+	p := &managerProbe{started: make(chan struct{}), stopped: make(chan struct{})}
+	if err := mgr.Add(p); err != nil {
+		return err
+	}
+
+	s.AddLivenessCheck("manager", func(context.Context) error {
+		select {
+		case <-p.stopped:
+			return errors.New("manager stopped")
+		default:
+			return nil
+		}
+	})
+	s.AddReadinessCheck("informers", func(ctx context.Context) error {
+		select {
+		case <-p.started:
+		default:
+			return errors.New("manager not started")
+		}
+		// the caches created lazily after the start may still be syncing
+		ctx, cancel := context.WithTimeout(ctx, informerSyncTimeout)
+		defer cancel()
+		if !mgr.GetCache().WaitForCacheSync(ctx.Done()) {
+			return errors.New("informer caches not synced")
+		}
+		return nil
+	})
+	return nil
+}
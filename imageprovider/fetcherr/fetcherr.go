@@ -0,0 +1,100 @@
+// Package fetcherr classifies the errors of fetching the images, which the providers share
+// so that the permanent failures are told from the transient ones regardless of the protocol.
+package fetcherr
+
+import (
+	"errors"
+)
+
+// kinds of the failures, which can be tested with errors.Is
+var (
+	// ErrNotFound is returned when the image, the tag or the key doesn't exist
+	ErrNotFound = errors.New("not found")
+	// ErrUnauthorized is returned when the credentials are missing or rejected
+	ErrUnauthorized = errors.New("unauthorized")
+	// ErrTransient is returned when the failure may resolve by itself, e.g. network errors and 5xx responses
+	ErrTransient = errors.New("transient failure")
+	// ErrInvalid is returned when the image or the request is malformed, e.g. a wrong sha256 or a non-Wasm image
+	ErrInvalid = errors.New("invalid")
+)
+
+// Error is the error classified as one of the kinds
+type Error struct {
+	Kind error
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports whether the target is the kind of the error
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+func classify(kind, err error) error {
+	if err == nil {
+		return nil
+	}
+	return &Error{Kind: kind, Err: err}
+}
+
+// NotFound classifies the error as ErrNotFound
+func NotFound(err error) error {
+	return classify(ErrNotFound, err)
+}
+
+// Unauthorized classifies the error as ErrUnauthorized
+func Unauthorized(err error) error {
+	return classify(ErrUnauthorized, err)
+}
+
+// Transient classifies the error as ErrTransient
+func Transient(err error) error {
+	return classify(ErrTransient, err)
+}
+
+// Invalid classifies the error as ErrInvalid
+func Invalid(err error) error {
+	return classify(ErrInvalid, err)
+}
+
+// KindOf returns the outermost classification of the error. The unclassified errors are regarded as transient.
+func KindOf(err error) error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return ErrTransient
+}
+
+// IsPermanent returns true if retrying is pointless until the extension or the image changes, which is the case of
+// ErrInvalid. The missing images and the rejected credentials may recover by themselves, e.g. when the image is pushed
+// or the credentials are rotated.
+func IsPermanent(err error) bool {
+	return err != nil && KindOf(err) == ErrInvalid
+}
+
+// IsTransient returns true if the failure is likely to resolve soon, including the unclassified ones
+func IsTransient(err error) bool {
+	return err != nil && KindOf(err) == ErrTransient
+}
+
+// Reason returns the name of the kind such as "NotFound", which suits the reasons of the conditions and the events
+func Reason(err error) string {
+	switch KindOf(err) {
+	case ErrNotFound:
+		return "NotFound"
+	case ErrUnauthorized:
+		return "Unauthorized"
+	case ErrInvalid:
+		return "Invalid"
+	default:
+		return "Transient"
+	}
+}
@@ -0,0 +1,41 @@
+package fetcherr
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKindOf(t *testing.T) {
+	base := errors.New("error")
+	for _, c := range []struct {
+		err                  error
+		kind                 error
+		reason               string
+		permanent, transient bool
+	}{
+		{err: NotFound(base), kind: ErrNotFound, reason: "NotFound"},
+		{err: Unauthorized(base), kind: ErrUnauthorized, reason: "Unauthorized"},
+		{err: Invalid(base), kind: ErrInvalid, reason: "Invalid", permanent: true},
+		{err: Transient(base), kind: ErrTransient, reason: "Transient", transient: true},
+		{err: base, kind: ErrTransient, reason: "Transient", transient: true},
+		{err: fmt.Errorf("wrapped: %w", Invalid(base)), kind: ErrInvalid, reason: "Invalid", permanent: true},
+		// the outermost classification wins
+		{err: Transient(fmt.Errorf("wrapped: %w", Invalid(base))), kind: ErrTransient, reason: "Transient", transient: true},
+	} {
+		assert.Equal(t, c.kind, KindOf(c.err), c.err.Error())
+		assert.Equal(t, c.reason, Reason(c.err), c.err.Error())
+		assert.Equal(t, c.permanent, IsPermanent(c.err), c.err.Error())
+		assert.Equal(t, c.transient, IsTransient(c.err), c.err.Error())
+		assert.True(t, errors.Is(c.err, base))
+	}
+
+	assert.True(t, errors.Is(NotFound(base), ErrNotFound))
+	assert.False(t, errors.Is(NotFound(base), ErrInvalid))
+	assert.Equal(t, "error", NotFound(base).Error())
+	assert.Nil(t, NotFound(nil))
+	assert.False(t, IsPermanent(nil))
+	assert.False(t, IsTransient(nil))
+}
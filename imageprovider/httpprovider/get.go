@@ -1,17 +1,79 @@
 package httpprovider
 
 import (
+	"context"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+	"github.com/tetratelabs/wasmxds/imageprovider/stream"
 )
 
-func get(client http.Client, url string) ([]byte, error) {
-	resp, err := client.Get(url)
+func get(ctx context.Context, client http.Client, url string) ([]byte, error) {
+	image, err := open(ctx, client, url)
+	if err != nil {
+		return nil, err
+	}
+	return stream.ReadAll(image)
+}
+
+// open returns the image streaming the body of the response
+func open(ctx context.Context, client http.Client, url string) (*stream.Image, error) {
+	resp, err := do(ctx, client, http.MethodGet, url)
 	if err != nil {
-		return nil, fmt.Errorf("error invoking http request: %v", err)
+		return nil, err
 	}
+	return &stream.Image{Info: infoOf(resp), Body: resp.Body}, nil
+}
 
-	defer resp.Body.Close()
-	return ioutil.ReadAll(resp.Body)
+// resolve returns the information of the image with a HEAD request
+func resolve(ctx context.Context, client http.Client, url string) (*stream.Info, error) {
+	resp, err := do(ctx, client, http.MethodHead, url)
+	if err != nil {
+		return nil, err
+	}
+	resp.Body.Close()
+	info := infoOf(resp)
+	return &info, nil
+}
+
+func do(ctx context.Context, client http.Client, method, url string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, url, nil)
+	if err != nil {
+		return nil, fetcherr.Invalid(fmt.Errorf("invalid url %s: %v", url, err))
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fetcherr.Transient(fmt.Errorf("error invoking http request: %w", err))
+	}
+	if err := classifyStatus(resp.StatusCode); err != nil {
+		resp.Body.Close()
+		return nil, err
+	}
+	return resp, nil
+}
+
+func infoOf(resp *http.Response) stream.Info {
+	return stream.Info{
+		ETag:        resp.Header.Get("ETag"),
+		Size:        resp.ContentLength,
+		ContentType: resp.Header.Get("Content-Type"),
+	}
+}
+
+// classifyStatus returns the classified error for the status codes other than 2xx
+func classifyStatus(code int) error {
+	err := fmt.Errorf("unexpected status: %d %s", code, http.StatusText(code))
+	switch {
+	case code >= 200 && code < 300:
+		return nil
+	case code == http.StatusNotFound || code == http.StatusGone:
+		return fetcherr.NotFound(err)
+	case code == http.StatusUnauthorized || code == http.StatusForbidden:
+		return fetcherr.Unauthorized(err)
+	case code == http.StatusRequestTimeout || code == http.StatusTooManyRequests || code >= 500:
+		return fetcherr.Transient(err)
+	default:
+		return fetcherr.Invalid(err)
+	}
 }
@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	"github.com/tetratelabs/wasmxds/imageprovider/stream"
 )
 
 type HttpProvider struct {
@@ -16,8 +17,18 @@ func NewHttpProvider() *HttpProvider {
 	return &HttpProvider{client: http.Client{}}
 }
 
-func (h HttpProvider) Fetch(_ context.Context, uri string) ([]byte, error) {
-	return get(h.client, fmt.Sprintf("http://%s", uri))
+func (h HttpProvider) Fetch(ctx context.Context, uri string) ([]byte, error) {
+	return get(ctx, h.client, fmt.Sprintf("http://%s", uri))
+}
+
+// Open streams the body of the response
+func (h HttpProvider) Open(ctx context.Context, uri string) (*stream.Image, error) {
+	return open(ctx, h.client, fmt.Sprintf("http://%s", uri))
+}
+
+// Resolve returns the entity tag, the length and the type of the content with a HEAD request
+func (h HttpProvider) Resolve(ctx context.Context, uri string) (*stream.Info, error) {
+	return resolve(ctx, h.client, fmt.Sprintf("http://%s", uri))
 }
 
 func (h HttpProvider) ProviderKey() string {
@@ -1,14 +1,21 @@
 package httpprovider
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/bmizerany/assert"
 	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+	"github.com/tetratelabs/wasmxds/imageprovider/stream"
 )
 
 func TestHttpProvider(t *testing.T) {
@@ -21,7 +28,77 @@ func TestHttpProvider(t *testing.T) {
 
 	p := HttpProvider{}
 	fmt.Println(ts.URL)
-	actual, err := p.Fetch(nil, strings.TrimPrefix(ts.URL, "http://"))
+	actual, err := p.Fetch(context.Background(), strings.TrimPrefix(ts.URL, "http://"))
+	require.NoError(t, err)
+	assert.Equal(t, exp, actual)
+}
+
+func TestHttpProvider_Open(t *testing.T) {
+	exp := []byte{1, 2}
+	var heads int
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			heads++
+		}
+		w.Header().Set("ETag", `"v1"`)
+		w.Header().Set("Content-Type", "application/wasm")
+		w.Header().Set("Content-Length", "2")
+		if r.Method == http.MethodGet {
+			w.Write(exp)
+		}
+	}))
+	defer ts.Close()
+
+	p := NewHttpProvider()
+	uri := strings.TrimPrefix(ts.URL, "http://")
+	image, err := p.Open(context.Background(), uri)
+	require.NoError(t, err)
+	actual, err := ioutil.ReadAll(image.Body)
 	require.NoError(t, err)
+	require.NoError(t, image.Body.Close())
 	assert.Equal(t, exp, actual)
+	assert.Equal(t, stream.Info{ETag: `"v1"`, Size: 2, ContentType: "application/wasm"}, image.Info)
+
+	info, err := p.Resolve(context.Background(), uri)
+	require.NoError(t, err)
+	assert.Equal(t, image.Info, *info)
+	assert.Equal(t, 1, heads)
+}
+
+func TestHttpProvider_status(t *testing.T) {
+	for code, kind := range map[int]error{
+		http.StatusNotFound:           fetcherr.ErrNotFound,
+		http.StatusForbidden:          fetcherr.ErrUnauthorized,
+		http.StatusServiceUnavailable: fetcherr.ErrTransient,
+		http.StatusTooManyRequests:    fetcherr.ErrTransient,
+		http.StatusBadRequest:         fetcherr.ErrInvalid,
+	} {
+		code := code
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(code)
+		}))
+
+		p := NewHttpProvider()
+		_, err := p.Fetch(context.Background(), strings.TrimPrefix(ts.URL, "http://"))
+		require.Error(t, err)
+		require.True(t, errors.Is(err, kind), err.Error())
+		ts.Close()
+	}
+}
+
+func TestHttpProvider_timeout(t *testing.T) {
+	// the hung server
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := NewHttpProvider().Fetch(ctx, strings.TrimPrefix(ts.URL, "http://"))
+	require.Error(t, err)
+	require.True(t, errors.Is(err, fetcherr.ErrTransient), err.Error())
+	require.True(t, errors.Is(err, context.DeadlineExceeded), err.Error())
+	require.True(t, time.Since(start) < 5*time.Second)
 }
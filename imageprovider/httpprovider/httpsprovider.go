@@ -7,6 +7,7 @@ import (
 	"net/http"
 
 	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	"github.com/tetratelabs/wasmxds/imageprovider/stream"
 )
 
 type HttpsProvider struct {
@@ -21,8 +22,18 @@ func NewHttpsProvider(insecure bool) *HttpsProvider {
 	return &HttpsProvider{client: client}
 }
 
-func (h HttpsProvider) Fetch(_ context.Context, uri string) ([]byte, error) {
-	return get(h.client, fmt.Sprintf("https://%s", uri))
+func (h HttpsProvider) Fetch(ctx context.Context, uri string) ([]byte, error) {
+	return get(ctx, h.client, fmt.Sprintf("https://%s", uri))
+}
+
+// Open streams the body of the response
+func (h HttpsProvider) Open(ctx context.Context, uri string) (*stream.Image, error) {
+	return open(ctx, h.client, fmt.Sprintf("https://%s", uri))
+}
+
+// Resolve returns the entity tag, the length and the type of the content with a HEAD request
+func (h HttpsProvider) Resolve(ctx context.Context, uri string) (*stream.Info, error) {
+	return resolve(ctx, h.client, fmt.Sprintf("https://%s", uri))
 }
 
 func (h HttpsProvider) ProviderKey() string {
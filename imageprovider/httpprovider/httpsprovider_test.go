@@ -1,6 +1,7 @@
 package httpprovider
 
 import (
+	"context"
 	"crypto/tls"
 	"net/http"
 	"net/http/httptest"
@@ -23,7 +24,7 @@ func TestHttpsProvider(t *testing.T) {
 		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}},
 	}}
 
-	actual, err := p.Fetch(nil, strings.TrimPrefix(ts.URL, "https://"))
+	actual, err := p.Fetch(context.Background(), strings.TrimPrefix(ts.URL, "https://"))
 	require.NoError(t, err)
 	assert.Equal(t, exp, actual)
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/tetratelabs/wasmxds/imageprovider/localfs"
 	"github.com/tetratelabs/wasmxds/imageprovider/ociregistory"
 	"github.com/tetratelabs/wasmxds/imageprovider/s3provider"
+	"github.com/tetratelabs/wasmxds/imageprovider/stream"
 )
 
 type WasmImageProvider interface {
@@ -14,12 +15,65 @@ type WasmImageProvider interface {
 	ProviderKey() string
 }
 
+// StreamingImageProvider is the second version of WasmImageProvider, which streams the binary
+// instead of returning it in memory, along with what the provider knows about the image.
+// Resolve returns the same information without fetching the binary, and is used to detect the changes of the images.
+type StreamingImageProvider interface {
+	WasmImageProvider
+	Open(ctx context.Context, uri string) (*stream.Image, error)
+	Resolve(ctx context.Context, uri string) (*stream.Info, error)
+}
+
+// OCIImageProvider is implemented by the providers of OCI registries,
+// which can return the manifest digest and the metadata published along with the binary,
+// and list the tags of the repositories to resolve version constraints
+type OCIImageProvider interface {
+	FetchImage(ctx context.Context, uri string) (*ociregistory.Image, error)
+	ListTags(ctx context.Context, repository string) ([]string, error)
+}
+
+// Pinger is implemented by the providers which can check whether their backends can be reached
+type Pinger interface {
+	Ping(ctx context.Context) error
+}
+
 var (
 	_ WasmImageProvider = &ociregistory.AmazonECR{}
 	_ WasmImageProvider = ociregistory.WebAssemblyHub{}
 	_ WasmImageProvider = ociregistory.LocalRegistry{}
+	_ WasmImageProvider = ociregistory.Registry{}
 	_ WasmImageProvider = localfs.LocalFilesystem{}
 	_ WasmImageProvider = &s3provider.AmazonS3{}
 	_ WasmImageProvider = &httpprovider.HttpProvider{}
 	_ WasmImageProvider = &httpprovider.HttpsProvider{}
+
+	_ StreamingImageProvider = &ociregistory.AmazonECR{}
+	_ StreamingImageProvider = ociregistory.WebAssemblyHub{}
+	_ StreamingImageProvider = ociregistory.LocalRegistry{}
+	_ StreamingImageProvider = ociregistory.Registry{}
+	_ StreamingImageProvider = localfs.LocalFilesystem{}
+	_ StreamingImageProvider = &s3provider.AmazonS3{}
+	_ StreamingImageProvider = &httpprovider.HttpProvider{}
+	_ StreamingImageProvider = &httpprovider.HttpsProvider{}
+
+	_ OCIImageProvider = &ociregistory.AmazonECR{}
+	_ OCIImageProvider = ociregistory.WebAssemblyHub{}
+	_ OCIImageProvider = ociregistory.LocalRegistry{}
+	_ OCIImageProvider = ociregistory.Registry{}
+
+	_ Pinger = &ociregistory.AmazonECR{}
+	_ Pinger = ociregistory.WebAssemblyHub{}
+	_ Pinger = ociregistory.LocalRegistry{}
+	_ Pinger = ociregistory.Registry{}
 )
+
+// NewDefaultProviders returns the providers which don't require cloud credentials
+func NewDefaultProviders(allowInsecureHttps bool) []WasmImageProvider {
+	return []WasmImageProvider{
+		httpprovider.NewHttpProvider(),
+		httpprovider.NewHttpsProvider(allowInsecureHttps),
+		ociregistory.NewWebAssemblyHub("", ""),
+		ociregistory.NewLocalRegistry("", "", "5000"),
+		localfs.LocalFilesystem{},
+	}
+}
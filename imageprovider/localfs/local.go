@@ -2,15 +2,120 @@ package localfs
 
 import (
 	"context"
+	"fmt"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 
 	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+	"github.com/tetratelabs/wasmxds/imageprovider/stream"
 )
 
 type LocalFilesystem struct{}
 
-func (l LocalFilesystem) Fetch(_ context.Context, uri string) ([]byte, error) {
-	return ioutil.ReadFile(uri)
+// Fetch reads the file, which is abandoned when the context is done as e.g. a stale network mount may block the read
+func (l LocalFilesystem) Fetch(ctx context.Context, uri string) ([]byte, error) {
+	var b []byte
+	err := run(ctx, uri, func() error {
+		f, err := open(uri)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		b, err = ioutil.ReadAll(f)
+		return err
+	})
+	if err != nil {
+		// the abandoned read may still be writing b
+		return nil, err
+	}
+	return b, nil
+}
+
+// Open opens the file, and the caller is responsible for abandoning the reads blocked by stale mounts
+func (l LocalFilesystem) Open(ctx context.Context, uri string) (*stream.Image, error) {
+	var f *os.File
+	var info *stream.Info
+	err := run(ctx, uri, func() (err error) {
+		if f, err = open(uri); err != nil {
+			return err
+		}
+		if info, err = stat(f); err != nil {
+			f.Close()
+		}
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &stream.Image{Info: *info, Body: f}, nil
+}
+
+// Resolve returns the size of the file along with the entity tag derived from the modification time and the size
+func (l LocalFilesystem) Resolve(ctx context.Context, uri string) (*stream.Info, error) {
+	var info *stream.Info
+	err := run(ctx, uri, func() error {
+		f, err := open(uri)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		info, err = stat(f)
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+	return info, nil
+}
+
+// open opens the file the symbolic links point to, which is what the WasmExtensionPolicies check
+func open(uri string) (*os.File, error) {
+	path, err := filepath.EvalSymlinks(uri)
+	if err != nil {
+		return nil, err
+	}
+	return os.Open(path)
+}
+
+func stat(f *os.File) (*stream.Info, error) {
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	return &stream.Info{
+		ETag: fmt.Sprintf(`"%x-%x"`, fi.ModTime().UnixNano(), fi.Size()),
+		Size: fi.Size(),
+	}, nil
+}
+
+// run runs f on the file unless the context is done first, and classifies the error
+func run(ctx context.Context, uri string, f func() error) error {
+	if err := ctx.Err(); err != nil {
+		return fetcherr.Transient(fmt.Errorf("failed to read %s: %w", uri, err))
+	}
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	var err error
+	select {
+	case <-ctx.Done():
+		return fetcherr.Transient(fmt.Errorf("failed to read %s: %w", uri, ctx.Err()))
+	case err = <-done:
+	}
+	switch {
+	case err == nil:
+		return nil
+	case os.IsNotExist(err):
+		return fetcherr.NotFound(err)
+	case os.IsPermission(err):
+		return fetcherr.Unauthorized(err)
+	default:
+		return fetcherr.Transient(err)
+	}
 }
 
 func (l LocalFilesystem) ProviderKey() string {
@@ -0,0 +1,65 @@
+package localfs
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+)
+
+func TestLocalFilesystem_Fetch(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "filter.wasm")
+	require.NoError(t, ioutil.WriteFile(path, []byte{1, 2}, 0644))
+
+	actual, err := LocalFilesystem{}.Fetch(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1, 2}, actual)
+
+	_, err = LocalFilesystem{}.Fetch(context.Background(), filepath.Join(dir, "missing.wasm"))
+	assert.True(t, errors.Is(err, fetcherr.ErrNotFound))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = LocalFilesystem{}.Fetch(ctx, path)
+	assert.True(t, errors.Is(err, context.Canceled))
+}
+
+func TestLocalFilesystem_Open(t *testing.T) {
+	dir, err := ioutil.TempDir("", "localfs")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "filter.wasm")
+	require.NoError(t, ioutil.WriteFile(path, []byte{1, 2}, 0644))
+
+	image, err := LocalFilesystem{}.Open(context.Background(), path)
+	require.NoError(t, err)
+	actual, err := ioutil.ReadAll(image.Body)
+	require.NoError(t, err)
+	require.NoError(t, image.Body.Close())
+	assert.Equal(t, []byte{1, 2}, actual)
+	assert.Equal(t, int64(2), image.Size)
+
+	info, err := LocalFilesystem{}.Resolve(context.Background(), path)
+	require.NoError(t, err)
+	assert.Equal(t, image.Info, *info)
+
+	require.NoError(t, ioutil.WriteFile(path, []byte{1, 2, 3}, 0644))
+	info, err = LocalFilesystem{}.Resolve(context.Background(), path)
+	require.NoError(t, err)
+	assert.True(t, info.Changed(&image.Info))
+
+	_, err = LocalFilesystem{}.Open(context.Background(), filepath.Join(dir, "missing.wasm"))
+	assert.True(t, errors.Is(err, fetcherr.ErrNotFound))
+	_, err = LocalFilesystem{}.Resolve(context.Background(), filepath.Join(dir, "missing.wasm"))
+	assert.True(t, errors.Is(err, fetcherr.ErrNotFound))
+}
@@ -17,7 +17,9 @@ package ociregistory
 import (
 	"context"
 	"fmt"
+	"net"
 	"net/http"
+	"time"
 
 	"github.com/containerd/containerd/remotes"
 	"github.com/deislabs/oras/pkg/auth"
@@ -36,12 +38,27 @@ func NewAuthenticator() auth.Client {
 	return a
 }
 
-func NewResolver(at auth.Client) (remotes.Resolver, error) {
-	client := http.DefaultClient
-	// TODO(mathetake): set timeout
+// httpClient bounds the phases of the requests to the registries which the contexts don't bound by themselves,
+// e.g. a registry accepting the connection but never responding. The requests as a whole are bounded by the contexts,
+// so that the large layers can take as long as the fetch timeout allows.
+var httpClient = &http.Client{
+	Transport: &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ResponseHeaderTimeout: 30 * time.Second,
+		ExpectContinueTimeout: time.Second,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+	},
+}
 
+func NewResolver(ctx context.Context, at auth.Client) (remotes.Resolver, error) {
 	// (mathetake): note that the first argument seems not to be used inside of the library
-	resolver, err := at.Resolver(context.Background(), client, useInsecure)
+	resolver, err := at.Resolver(ctx, httpClient, useInsecure)
 	if err != nil {
 		return nil, fmt.Errorf("error initializing resolver: %v", err)
 	}
@@ -15,6 +15,7 @@
 package ociregistory
 
 import (
+	"context"
 	"testing"
 
 	"github.com/stretchr/testify/require"
@@ -26,6 +27,6 @@ func Test_NewAuthenticator(t *testing.T) {
 
 func Test_NewResolver(t *testing.T) {
 	a := NewAuthenticator()
-	_, err := NewResolver(a)
+	_, err := NewResolver(context.Background(), a)
 	require.NoError(t, err)
 }
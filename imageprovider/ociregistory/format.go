@@ -0,0 +1,174 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ociregistory
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path"
+
+	"github.com/containerd/containerd/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+)
+
+// Format is the format of the image which the Wasm binary is found in
+type Format string
+
+const (
+	// FormatWasmArtifact is the artifact with a single layer of one of AllowedMediaType,
+	// e.g. built by wasm-to-oci, solo-io/wasm or following the CNCF Wasm OCI artifact layout
+	FormatWasmArtifact Format = "wasm-artifact"
+	// FormatDockerImage is the Docker image containing plugin.wasm, as supported by Istio
+	FormatDockerImage Format = "docker-image"
+	// FormatOCIImage is the OCI image containing plugin.wasm, as supported by Istio
+	FormatOCIImage Format = "oci-image"
+)
+
+// pluginFileName is the file in the image layers that Istio compatible images contain the binary as
+const pluginFileName = "plugin.wasm"
+
+var (
+	ErrNoWasmBinary = errors.New("no Wasm binary found in the image")
+
+	manifestMediaTypes = []string{
+		ocispec.MediaTypeImageManifest,
+		images.MediaTypeDockerSchema2Manifest,
+	}
+	dockerLayerMediaTypes = []string{
+		images.MediaTypeDockerSchema2Layer,
+		images.MediaTypeDockerSchema2LayerGzip,
+	}
+	ociLayerMediaTypes = []string{
+		ocispec.MediaTypeImageLayer,
+		ocispec.MediaTypeImageLayerGzip,
+	}
+)
+
+// extractWasm finds the Wasm binary in the layers of the manifest, where get returns the content of the descriptors.
+// The layer of AllowedMediaType is preferred, and then plugin.wasm in the uppermost tar layer containing it.
+func extractWasm(manifest []byte, get func(ocispec.Descriptor) ([]byte, error)) ([]byte, Format, error) {
+	m, err := parseManifest(manifest)
+	if err != nil {
+		return nil, "", err
+	}
+	if layer, err := wasmLayer(m); err != nil {
+		return nil, "", err
+	} else if layer != nil {
+		binary, err := get(*layer)
+		return binary, FormatWasmArtifact, err
+	}
+
+	// the upper layers override the lower ones
+	for i := len(m.Layers) - 1; i >= 0; i-- {
+		l := m.Layers[i]
+		format := FormatOCIImage
+		if contains(dockerLayerMediaTypes, l.MediaType) {
+			format = FormatDockerImage
+		} else if !contains(ociLayerMediaTypes, l.MediaType) {
+			continue
+		}
+
+		layer, err := get(l)
+		if err != nil {
+			return nil, "", err
+		}
+		binary, err := findInTar(layer, pluginFileName)
+		if err != nil {
+			return nil, "", fmt.Errorf("failed to read layer %s: %w", l.Digest, err)
+		} else if binary != nil {
+			return binary, format, nil
+		}
+	}
+	return nil, "", fmt.Errorf("%w: the image must have a single layer of %v, or %s in a tar layer",
+		ErrNoWasmBinary, AllowedMediaType, pluginFileName)
+}
+
+// findWasmLayer returns the layer of AllowedMediaType in the manifest, or nil if the binary is in a tar layer if any
+func findWasmLayer(manifest []byte) (*ocispec.Descriptor, error) {
+	m, err := parseManifest(manifest)
+	if err != nil {
+		return nil, err
+	}
+	return wasmLayer(m)
+}
+
+func parseManifest(manifest []byte) (*ocispec.Manifest, error) {
+	// Docker image manifest V2 schema 2 has the same layout as OCI image manifest
+	var m ocispec.Manifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+	return &m, nil
+}
+
+func wasmLayer(m *ocispec.Manifest) (*ocispec.Descriptor, error) {
+	var wasmLayers []ocispec.Descriptor
+	for _, l := range m.Layers {
+		if contains(AllowedMediaType, l.MediaType) {
+			wasmLayers = append(wasmLayers, l)
+		}
+	}
+	switch len(wasmLayers) {
+	case 0:
+		return nil, nil
+	case 1:
+		return &wasmLayers[0], nil
+	default:
+		return nil, fmt.Errorf("invalid number of Wasm layers: %d", len(wasmLayers))
+	}
+}
+
+// findInTar returns the file in the tar or tar.gz archive, or nil if not found
+func findInTar(archive []byte, name string) ([]byte, error) {
+	var r io.Reader = bytes.NewReader(archive)
+	// detect the compression from the content as the media types don't always tell
+	if len(archive) > 2 && archive[0] == 0x1f && archive[1] == 0x8b {
+		gr, err := gzip.NewReader(r)
+		if err != nil {
+			return nil, err
+		}
+		defer gr.Close()
+		r = gr
+	}
+
+	tr := tar.NewReader(r)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			return nil, nil
+		} else if err != nil {
+			return nil, err
+		}
+		// the entries can be either "plugin.wasm", "./plugin.wasm" or "/plugin.wasm"
+		if h.FileInfo().Mode().IsRegular() && path.Clean("/"+h.Name) == "/"+name {
+			return ioutil.ReadAll(tr)
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
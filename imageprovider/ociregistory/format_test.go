@@ -0,0 +1,114 @@
+package ociregistory
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func tarOf(t *testing.T, compress bool, files map[string]string) []byte {
+	var buf bytes.Buffer
+	var gw *gzip.Writer
+	tw := tar.NewWriter(&buf)
+	if compress {
+		gw = gzip.NewWriter(&buf)
+		tw = tar.NewWriter(gw)
+	}
+	for name, content := range files {
+		require.NoError(t, tw.WriteHeader(&tar.Header{Name: name, Mode: 0644, Size: int64(len(content)), Typeflag: tar.TypeReg}))
+		_, err := tw.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, tw.Close())
+	if gw != nil {
+		require.NoError(t, gw.Close())
+	}
+	return buf.Bytes()
+}
+
+// image returns the manifest and the getter of the layers in the given media types and contents
+func image(t *testing.T, layers ...[2]string) ([]byte, func(ocispec.Descriptor) ([]byte, error)) {
+	blobs := map[digest.Digest][]byte{}
+	m := ocispec.Manifest{}
+	for _, l := range layers {
+		d := digest.FromString(l[1])
+		blobs[d] = []byte(l[1])
+		m.Layers = append(m.Layers, ocispec.Descriptor{MediaType: l[0], Digest: d, Size: int64(len(l[1]))})
+	}
+	raw, err := json.Marshal(m)
+	require.NoError(t, err)
+	return raw, func(desc ocispec.Descriptor) ([]byte, error) {
+		b, ok := blobs[desc.Digest]
+		if !ok {
+			return nil, fmt.Errorf("%s not found", desc.Digest)
+		}
+		return b, nil
+	}
+}
+
+func TestExtractWasm(t *testing.T) {
+	for _, c := range []struct {
+		name   string
+		layers [][2]string
+		binary string
+		format Format
+	}{
+		{
+			name:   "wasm-to-oci",
+			layers: [][2]string{{AllowedMediaType[0], "wasm"}},
+			binary: "wasm",
+			format: FormatWasmArtifact,
+		},
+		{
+			name:   "cncf artifact with other layers",
+			layers: [][2]string{{"application/vnd.foo", "foo"}, {"application/wasm", "wasm"}},
+			binary: "wasm",
+			format: FormatWasmArtifact,
+		},
+		{
+			name: "docker",
+			layers: [][2]string{
+				{images.MediaTypeDockerSchema2LayerGzip, string(tarOf(t, true, map[string]string{"plugin.wasm": "lower"}))},
+				{images.MediaTypeDockerSchema2LayerGzip, string(tarOf(t, true, map[string]string{"./plugin.wasm": "upper"}))},
+				{images.MediaTypeDockerSchema2LayerGzip, string(tarOf(t, true, map[string]string{"etc/foo": "foo"}))},
+			},
+			binary: "upper",
+			format: FormatDockerImage,
+		},
+		{
+			name:   "oci uncompressed",
+			layers: [][2]string{{ocispec.MediaTypeImageLayer, string(tarOf(t, false, map[string]string{"/plugin.wasm": "wasm"}))}},
+			binary: "wasm",
+			format: FormatOCIImage,
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			manifest, get := image(t, c.layers...)
+			binary, format, err := extractWasm(manifest, get)
+			require.NoError(t, err)
+			assert.Equal(t, c.binary, string(binary))
+			assert.Equal(t, c.format, format)
+		})
+	}
+}
+
+func TestExtractWasmError(t *testing.T) {
+	manifest, get := image(t, [2]string{AllowedMediaType[0], "a"}, [2]string{AllowedMediaType[1], "b"})
+	_, _, err := extractWasm(manifest, get)
+	assert.Error(t, err)
+
+	manifest, get = image(t,
+		[2]string{ocispec.MediaTypeImageLayerGzip, string(tarOf(t, true, map[string]string{"foo.wasm": "wasm"}))})
+	_, _, err = extractWasm(manifest, get)
+	assert.True(t, errors.Is(err, ErrNoWasmBinary))
+}
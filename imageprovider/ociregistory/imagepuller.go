@@ -15,19 +15,29 @@
 package ociregistory
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
 
+	"github.com/containerd/containerd/errdefs"
+	"github.com/containerd/containerd/images"
 	"github.com/containerd/containerd/remotes"
 	"github.com/containerd/containerd/remotes/docker"
 	"github.com/deislabs/oras/pkg/auth"
 	"github.com/deislabs/oras/pkg/content"
 	"github.com/deislabs/oras/pkg/oras"
+	digest "github.com/opencontainers/go-digest"
 	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
 	"github.com/sirupsen/logrus"
+	ctrl "sigs.k8s.io/controller-runtime"
 
 	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+	"github.com/tetratelabs/wasmxds/imageprovider/stream"
 )
 
 func init() {
@@ -37,6 +47,8 @@ func init() {
 
 var (
 	ErrAuthenticationFailure = errors.New("authentication failed")
+
+	logger = ctrl.Log.WithName("OCIRegistry")
 )
 
 type credentialProvider = func() (username string, password string, err error)
@@ -46,7 +58,6 @@ func newImagePuller(host string, cp credentialProvider) *imagePuller {
 	return &imagePuller{
 		host:               host,
 		authClient:         authClient,
-		localStore:         content.NewMemoryStore(),
 		credentialProvider: cp,
 	}
 }
@@ -55,9 +66,11 @@ type (
 	imagePuller struct {
 		host               string
 		authClient         auth.Client
-		resolver           remotes.Resolver
-		localStore         *content.Memorystore
 		credentialProvider credentialProvider
+		// mu guards resolver, which is created on login and discarded when the registry rejects the credentials,
+		// as the pulls are run concurrently by the sources of the extensions
+		mu       sync.Mutex
+		resolver remotes.Resolver
 	}
 )
 
@@ -70,19 +83,20 @@ func (p *imagePuller) ProviderKey() string {
 	return fmt.Sprintf("%s||%s", wasmxdsv1alpha1.ProtocolOCIImageRegistry, p.host)
 }
 
-func (p *imagePuller) login() error {
+// login must be called with mu held
+func (p *imagePuller) login(ctx context.Context) error {
 	username, password, err := p.credentialProvider()
 	if err != nil {
 		return fmt.Errorf("error generating credentials for %s: %w", p.host, err)
 	}
 
 	if username != "" && password != "" {
-		if err := p.authClient.Login(context.Background(), p.host, username, password, useInsecure); err != nil {
-			return fmt.Errorf("error login to host %s with username %s: %w", p.host, password, err)
+		if err := p.authClient.Login(ctx, p.host, username, password, useInsecure); err != nil {
+			return fetcherr.Unauthorized(fmt.Errorf("error login to host %s with username %s: %w", p.host, username, err))
 		}
 	}
 
-	r, err := NewResolver(p.authClient)
+	r, err := NewResolver(ctx, p.authClient)
 	if err != nil {
 		return fmt.Errorf("error creating resolver for %s: %w", p.host, err)
 	}
@@ -90,10 +104,157 @@ func (p *imagePuller) login() error {
 	return nil
 }
 
+// getResolver returns the resolver, logging in first if there's none
+func (p *imagePuller) getResolver(ctx context.Context) (remotes.Resolver, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resolver == nil {
+		if err := p.login(ctx); err != nil {
+			return nil, fmt.Errorf("failed to login: %w", err)
+		}
+	}
+	return p.resolver, nil
+}
+
+// discardResolver discards the resolver whose credentials have been rejected, so that the next use logs in again.
+// It's kept if another pull has already replaced it.
+func (p *imagePuller) discardResolver(r remotes.Resolver) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.resolver == r {
+		p.resolver = nil
+	}
+}
+
 func (p *imagePuller) Fetch(ctx context.Context, uri string) ([]byte, error) {
-	return p.pull(ctx, uri, false)
+	image, err := p.pull(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return image.Binary, nil
+}
+
+// Image is the pulled binary along with the information of the image
+type Image struct {
+	Binary []byte
+	// Digest is the digest of the manifest, or the image index, which the uri was resolved to
+	Digest string
+	// Metadata is published along with the image, and is nil if not found
+	Metadata *wasmxdsv1alpha1.ImageMetadata
+}
+
+// FetchImage returns the binary along with the information of the image
+func (p *imagePuller) FetchImage(ctx context.Context, uri string) (*Image, error) {
+	return p.pull(ctx, uri)
+}
+
+// Open returns the binary along with the information of the image. Only the manifests and the configs are pulled,
+// and the layer of the Wasm artifacts is streamed from the registry. plugin.wasm of the Docker and OCI images is
+// extracted from the pulled tar layers as they are compressed as a whole, so it's buffered.
+func (p *imagePuller) Open(ctx context.Context, uri string) (*stream.Image, error) {
+	desc, store, descs, err := p.pullContent(ctx, uri, layoutPullOpts, false)
+	if err != nil {
+		return nil, err
+	}
+
+	get := storeGetter(store)
+	manifest, err := firstManifest(descs, get)
+	if err != nil {
+		return nil, fetcherr.Invalid(err)
+	}
+	metadata, err := extractMetadata(manifest, get)
+	if err != nil {
+		return nil, fetcherr.Invalid(err)
+	}
+	info := stream.Info{Digest: desc.Digest.String(), ContentType: wasmContentType, Metadata: metadata}
+
+	layer, err := findWasmLayer(manifest)
+	if err != nil {
+		return nil, fetcherr.Invalid(err)
+	}
+	if layer == nil {
+		binary, format, err := extractWasm(manifest, get)
+		if err != nil {
+			return nil, fetcherr.Invalid(err)
+		}
+		logger.Info("pulled image", "uri", uri, "digest", desc.Digest, "format", format, "metadata", metadata != nil)
+		info.Size = int64(len(binary))
+		return &stream.Image{Info: info, Body: ioutil.NopCloser(bytes.NewReader(binary))}, nil
+	}
+
+	body, err := p.fetchBlob(ctx, uri, *layer)
+	if err != nil {
+		return nil, err
+	}
+	logger.Info("opened image", "uri", uri, "digest", desc.Digest, "format", FormatWasmArtifact, "metadata", metadata != nil)
+	info.Size = layer.Size
+	return &stream.Image{Info: info, Body: body}, nil
+}
+
+// fetchBlob opens the blob in the repository of uri, which is verified against the digest once read to the end
+func (p *imagePuller) fetchBlob(ctx context.Context, uri string, desc ocispec.Descriptor) (io.ReadCloser, error) {
+	resolver, err := p.getResolver(ctx)
+	if err != nil {
+		return nil, err
+	}
+	fetcher, err := resolver.Fetcher(ctx, uri)
+	if err != nil {
+		return nil, fetcherr.Transient(fmt.Errorf("failed to fetch %s: %v", desc.Digest, err))
+	}
+	rc, err := fetcher.Fetch(ctx, desc)
+	if errdefs.IsNotFound(err) {
+		return nil, fetcherr.NotFound(fmt.Errorf("failed to fetch %s: %v", desc.Digest, err))
+	} else if err != nil {
+		return nil, fetcherr.Transient(fmt.Errorf("failed to fetch %s: %v", desc.Digest, err))
+	}
+	return &verifiedReader{ReadCloser: rc, desc: desc, verifier: desc.Digest.Verifier()}, nil
+}
+
+// verifiedReader fails the read at the end of the blob if its content doesn't match the digest
+type verifiedReader struct {
+	io.ReadCloser
+	desc     ocispec.Descriptor
+	verifier digest.Verifier
 }
 
+func (r *verifiedReader) Read(b []byte) (int, error) {
+	n, err := r.ReadCloser.Read(b)
+	_, _ = r.verifier.Write(b[:n])
+	if err == io.EOF && !r.verifier.Verified() {
+		return n, fmt.Errorf("content of %s doesn't match the digest", r.desc.Digest)
+	}
+	return n, err
+}
+
+// Resolve resolves the uri to the digest of the manifest, or the image index, without pulling the image
+func (p *imagePuller) Resolve(ctx context.Context, uri string) (*stream.Info, error) {
+	return p.resolve(ctx, uri, false)
+}
+
+func (p *imagePuller) resolve(ctx context.Context, uri string, retried bool) (*stream.Info, error) {
+	resolver, err := p.getResolver(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	_, desc, err := resolver.Resolve(ctx, uri)
+	if err == docker.ErrNoToken || err == docker.ErrInvalidAuthorization {
+		if retried {
+			return nil, fetcherr.Unauthorized(fmt.Errorf("%w: %v", ErrAuthenticationFailure, err))
+		}
+		p.discardResolver(resolver)
+		return p.resolve(ctx, uri, true)
+	} else if errdefs.IsNotFound(err) {
+		return nil, fetcherr.NotFound(fmt.Errorf("failed to resolve: %v", err))
+	} else if err != nil {
+		return nil, fetcherr.Transient(fmt.Errorf("failed to resolve: %v", err))
+	}
+	return &stream.Info{Digest: desc.Digest.String(), Size: -1}, nil
+}
+
+// wasmContentType is the content type of the binaries regardless of the media types of the layers
+const wasmContentType = "application/wasm"
+
 var (
 	AllowedMediaType = []string{
 		// https://github.com/engineerd/wasm-to-oci#how-does-this-work
@@ -101,48 +262,124 @@ var (
 
 		// https://github.com/solo-io/wasm-image-spec
 		"application/vnd.wasm.content.layer.v1+wasm",
+
+		// https://tag-runtime.cncf.io/wgs/wasm/deliverables/wasm-oci-artifact/
+		"application/wasm",
 	}
-	pullOpts = []oras.PullOpt{
-		oras.WithAllowedMediaType(AllowedMediaType...),
+	pullOpts = append([]oras.PullOpt{oras.WithAllowedMediaType(AllowedMediaType...)}, layoutPullOpts...)
+	// layoutPullOpts pulls the images without the layers of the Wasm artifacts, which are streamed by Open instead
+	layoutPullOpts = []oras.PullOpt{
+		// the manifests are pulled to find the binary among the layers, see extractWasm
+		oras.WithAllowedMediaType(manifestMediaTypes...),
+		oras.WithAllowedMediaType(images.MediaTypeDockerSchema2ManifestList),
+		oras.WithAllowedMediaType(dockerLayerMediaTypes...),
+		oras.WithAllowedMediaType(ociLayerMediaTypes...),
+		// the image configs and the metadata layers are pulled for the ImageMetadata, see extractMetadata
+		oras.WithAllowedMediaType(configMediaTypes...),
+		oras.WithAllowedMediaType(MetadataMediaType),
 		oras.WithPullEmptyNameAllowed(),
+		// in order for the manifests to be found in the order of the image index
+		oras.WithPullByBFS,
 	}
 )
 
-func (p *imagePuller) pull(ctx context.Context, uri string, retried bool) ([]byte, error) {
-	if p.resolver == nil {
-		if err := p.login(); err != nil {
-			return nil, fmt.Errorf("failed to login: %w", err)
-		}
+func (p *imagePuller) pull(ctx context.Context, uri string) (*Image, error) {
+	desc, store, descs, err := p.pullContent(ctx, uri, pullOpts, false)
+	if err != nil {
+		return nil, err
 	}
 
-	_, layers, err := oras.Pull(ctx, p.resolver, uri, p.localStore, pullOpts...)
+	binary, format, metadata, err := extract(store, descs)
+	if err != nil {
+		// the image has been pulled but is not the one of a Wasm binary
+		return nil, fetcherr.Invalid(err)
+	}
+	logger.Info("pulled image", "uri", uri, "digest", desc.Digest, "format", format, "metadata", metadata != nil)
+	return &Image{Binary: binary, Digest: desc.Digest.String(), Metadata: metadata}, nil
+}
+
+// pullContent pulls the blobs of the media types allowed by opts, and returns the descriptor uri is resolved to
+// along with the store of the blobs and their descriptors
+func (p *imagePuller) pullContent(ctx context.Context, uri string, opts []oras.PullOpt,
+	retried bool) (ocispec.Descriptor, *content.Memorystore, []ocispec.Descriptor, error) {
+	resolver, err := p.getResolver(ctx)
+	if err != nil {
+		return ocispec.Descriptor{}, nil, nil, err
+	}
+
+	// the store only lives as long as the pull, so that the blobs of the images pulled over time don't pile up in memory.
+	// It's also given as the ingester of the OCI manifests, which oras otherwise keeps to itself.
+	store := content.NewMemoryStore()
+	desc, descs, err := oras.Pull(ctx, resolver, uri, store,
+		append([]oras.PullOpt{oras.WithContentProvideIngester(store)}, opts...)...)
 	if err == docker.ErrNoToken || err == docker.ErrInvalidAuthorization {
 		if retried {
-			return nil, fmt.Errorf("%w: %v", ErrAuthenticationFailure, err)
+			return desc, nil, nil, fetcherr.Unauthorized(fmt.Errorf("%w: %v", ErrAuthenticationFailure, err))
 		}
 		// if the authentication fails and this is first try, then login and try again
-		p.resolver = nil
-		return p.pull(ctx, uri, true)
+		p.discardResolver(resolver)
+		return p.pullContent(ctx, uri, opts, true)
+	} else if errdefs.IsNotFound(err) {
+		return desc, nil, nil, fetcherr.NotFound(fmt.Errorf("failed to pull: %v", err))
 	} else if err != nil {
-		return nil, fmt.Errorf("failed to pull: %v", err)
+		return desc, nil, nil, fetcherr.Transient(fmt.Errorf("failed to pull: %v", err))
 	}
+	return desc, store, descs, nil
+}
 
-	if len(layers) != 1 {
-		return nil, fmt.Errorf("invalid number of image layers")
+// extract returns the binary and the metadata in the first manifest
+func extract(store *content.Memorystore, descs []ocispec.Descriptor) ([]byte, Format, *wasmxdsv1alpha1.ImageMetadata, error) {
+	get := storeGetter(store)
+	manifest, err := firstManifest(descs, get)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	image, format, err := extractWasm(manifest, get)
+	if err != nil {
+		return nil, "", nil, err
 	}
+	metadata, err := extractMetadata(manifest, get)
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return image, format, metadata, nil
+}
+
+// storeGetter returns the function to get the content of the descriptors from the store
+func storeGetter(store *content.Memorystore) func(ocispec.Descriptor) ([]byte, error) {
+	return func(desc ocispec.Descriptor) ([]byte, error) {
+		_, b, ok := store.Get(desc)
+		if !ok {
+			return nil, fmt.Errorf("%s not pulled", desc.Digest)
+		}
+		return b, nil
+	}
+}
 
-	_, image, _ := p.localStore.Get(layers[0])
-	return image, nil
+// firstManifest returns the content of the first manifest, which is for the first platform in the case of image index.
+// The platforms don't matter as Wasm binaries are portable.
+func firstManifest(descs []ocispec.Descriptor, get func(ocispec.Descriptor) ([]byte, error)) ([]byte, error) {
+	for _, desc := range descs {
+		if contains(manifestMediaTypes, desc.MediaType) {
+			return get(desc)
+		}
+	}
+	return nil, fmt.Errorf("%w: no image manifest found", ErrNoWasmBinary)
 }
 
-// For e2e testing purpose
-func (p *imagePuller) Push(image []byte, ref string) error {
-	if err := p.login(); err != nil {
+// Push pushes the image to ref with the annotations set to the manifest
+func (p *imagePuller) Push(image []byte, ref string, annotations map[string]string) error {
+	p.mu.Lock()
+	err := p.login(context.Background())
+	resolver := p.resolver
+	p.mu.Unlock()
+	if err != nil {
 		return fmt.Errorf("failed to login: %w", err)
 	}
-	desc := p.localStore.Add(ref, AllowedMediaType[0], image)
-	_, err := oras.Push(context.Background(), p.resolver, ref, p.localStore,
-		[]ocispec.Descriptor{desc})
+	store := content.NewMemoryStore()
+	desc := store.Add(ref, AllowedMediaType[0], image)
+	_, err = oras.Push(context.Background(), resolver, ref, store,
+		[]ocispec.Descriptor{desc}, oras.WithManifestAnnotations(annotations))
 	if err != nil {
 		return fmt.Errorf("failed to push: %v", err)
 	}
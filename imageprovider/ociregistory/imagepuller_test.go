@@ -0,0 +1,247 @@
+package ociregistory
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"runtime"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+)
+
+// testRegistry serves the images pushed to it through the subset of the registry API used for pulls
+type testRegistry struct {
+	*httptest.Server
+	mu sync.Mutex
+	// manifests are keyed by the repository and the tag or the digest
+	manifests map[string][]byte
+	blobs     map[digest.Digest][]byte
+	// gates hold the second halves of the blobs until closed
+	gates map[digest.Digest]chan struct{}
+}
+
+func newTestRegistry(t *testing.T) *testRegistry {
+	r := &testRegistry{
+		manifests: map[string][]byte{}, blobs: map[digest.Digest][]byte{}, gates: map[digest.Digest]chan struct{}{},
+	}
+	// the registries on the loopback addresses are accessed in plain HTTP
+	r.Server = httptest.NewServer(http.HandlerFunc(r.serve))
+	t.Cleanup(r.Close)
+	return r
+}
+
+func (r *testRegistry) host() string {
+	return strings.TrimPrefix(r.URL, "http://")
+}
+
+// push adds the image of the binary tagged in the repository, and returns the digest of the manifest
+func (r *testRegistry) push(t *testing.T, repository, tag string, binary []byte) digest.Digest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	add := func(b []byte) digest.Digest {
+		d := digest.FromBytes(b)
+		r.blobs[d] = b
+		return d
+	}
+	config := []byte("{}")
+	m := ocispec.Manifest{
+		Config: ocispec.Descriptor{MediaType: ocispec.MediaTypeImageConfig, Digest: add(config), Size: int64(len(config))},
+		Layers: []ocispec.Descriptor{{MediaType: AllowedMediaType[0], Digest: add(binary), Size: int64(len(binary))}},
+	}
+	m.SchemaVersion = 2
+	raw, err := json.Marshal(m)
+	require.NoError(t, err)
+	d := digest.FromBytes(raw)
+	r.manifests[repository+":"+tag] = raw
+	r.manifests[repository+":"+d.String()] = raw
+	return d
+}
+
+func (r *testRegistry) serve(w http.ResponseWriter, req *http.Request) {
+	r.mu.Lock()
+	path := strings.TrimPrefix(req.URL.Path, "/v2/")
+	var b []byte
+	var ok bool
+	var gate chan struct{}
+	if i := strings.LastIndex(path, "/manifests/"); i >= 0 {
+		b, ok = r.manifests[path[:i]+":"+path[i+len("/manifests/"):]]
+		w.Header().Set("Content-Type", ocispec.MediaTypeImageManifest)
+	} else if i := strings.LastIndex(path, "/blobs/"); i >= 0 {
+		d := digest.Digest(path[i+len("/blobs/"):])
+		b, ok = r.blobs[d]
+		gate = r.gates[d]
+		w.Header().Set("Content-Type", "application/octet-stream")
+	}
+	r.mu.Unlock()
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Docker-Content-Digest", digest.FromBytes(b).String())
+	w.Header().Set("Content-Length", fmt.Sprint(len(b)))
+	if req.Method == http.MethodHead {
+		return
+	}
+	if gate != nil {
+		_, _ = w.Write(b[:len(b)/2])
+		w.(http.Flusher).Flush()
+		<-gate
+		b = b[len(b)/2:]
+	}
+	_, _ = w.Write(b)
+}
+
+func randomBinary(t *testing.T, size int) []byte {
+	b := make([]byte, size)
+	_, err := rand.Read(b)
+	require.NoError(t, err)
+	return b
+}
+
+func TestImagePuller_Pull(t *testing.T) {
+	registry := newTestRegistry(t)
+	binary := randomBinary(t, 1024)
+	d := registry.push(t, "foo/bar", "v1", binary)
+	p := NewRegistry(registry.host(), "", "")
+	uri := registry.host() + "/foo/bar:v1"
+
+	image, err := p.FetchImage(context.Background(), uri)
+	require.NoError(t, err)
+	assert.Equal(t, binary, image.Binary)
+	assert.Equal(t, d.String(), image.Digest)
+
+	opened, err := p.Open(context.Background(), uri)
+	require.NoError(t, err)
+	actual, err := ioutil.ReadAll(opened.Body)
+	require.NoError(t, err)
+	assert.Equal(t, binary, actual)
+
+	info, err := p.Resolve(context.Background(), uri)
+	require.NoError(t, err)
+	assert.Equal(t, d.String(), info.Digest)
+
+	// the tag moves
+	registry.push(t, "foo/bar", "v1", randomBinary(t, 1024))
+	info, err = p.Resolve(context.Background(), uri)
+	require.NoError(t, err)
+	assert.True(t, info.Changed(&opened.Info))
+
+	_, err = p.Fetch(context.Background(), registry.host()+"/foo/bar:unknown")
+	assert.Error(t, err)
+}
+
+func TestImagePuller_Open(t *testing.T) {
+	registry := newTestRegistry(t)
+	binary := randomBinary(t, 1024)
+	registry.push(t, "foo/bar", "v1", binary)
+	p := NewRegistry(registry.host(), "", "")
+	uri := registry.host() + "/foo/bar:v1"
+
+	// the layer is streamed rather than pulled before Open returns
+	gate := make(chan struct{})
+	registry.mu.Lock()
+	registry.gates[digest.FromBytes(binary)] = gate
+	registry.mu.Unlock()
+	opened, err := p.Open(context.Background(), uri)
+	close(gate)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(binary)), opened.Size)
+	assert.Equal(t, wasmContentType, opened.ContentType)
+	actual, err := ioutil.ReadAll(opened.Body)
+	require.NoError(t, err)
+	require.NoError(t, opened.Body.Close())
+	assert.Equal(t, binary, actual)
+
+	// the blob is verified against the digest
+	registry.mu.Lock()
+	registry.blobs[digest.FromBytes(binary)] = randomBinary(t, len(binary))
+	registry.mu.Unlock()
+	opened, err = p.Open(context.Background(), uri)
+	require.NoError(t, err)
+	_, err = ioutil.ReadAll(opened.Body)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "doesn't match the digest")
+}
+
+func TestImagePuller_login(t *testing.T) {
+	registry := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}))
+	defer registry.Close()
+	p := NewRegistry(strings.TrimPrefix(registry.URL, "http://"), "user", "secret-password")
+
+	_, err := p.Fetch(context.Background(), strings.TrimPrefix(registry.URL, "http://")+"/foo/bar:v1")
+	require.Error(t, err)
+	assert.Equal(t, fetcherr.ErrUnauthorized, fetcherr.KindOf(err))
+	assert.Contains(t, err.Error(), "user")
+	assert.NotContains(t, err.Error(), "secret-password")
+}
+
+func TestImagePuller_concurrent(t *testing.T) {
+	registry := newTestRegistry(t)
+	binary := randomBinary(t, 1024)
+	registry.push(t, "foo/bar", "v1", binary)
+	p := NewRegistry(registry.host(), "", "")
+
+	// the pulls of the extensions share the resolver, which is created on the first of them
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			b, err := p.Fetch(context.Background(), registry.host()+"/foo/bar:v1")
+			assert.NoError(t, err)
+			assert.Equal(t, binary, b)
+		}()
+	}
+	wg.Wait()
+}
+
+func TestImagePuller_memory(t *testing.T) {
+	const (
+		images = 64
+		size   = 1 << 20
+	)
+	registry := newTestRegistry(t)
+	for i := 0; i < images; i++ {
+		registry.push(t, "foo/bar", fmt.Sprintf("v%d", i), randomBinary(t, size))
+	}
+	p := NewRegistry(registry.host(), "", "")
+
+	heap := func() uint64 {
+		runtime.GC()
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return stats.HeapAlloc
+	}
+	// the first pull allocates the clients and the resolver
+	_, err := p.Fetch(context.Background(), registry.host()+"/foo/bar:v0")
+	require.NoError(t, err)
+	before := heap()
+	for i := 0; i < images; i++ {
+		b, err := p.Fetch(context.Background(), fmt.Sprintf("%s/foo/bar:v%d", registry.host(), i))
+		require.NoError(t, err)
+		require.Len(t, b, size)
+	}
+	after := heap()
+	runtime.KeepAlive(p)
+
+	// the blobs of the pulled images would take 64MiB if they were retained
+	var growth uint64
+	if after > before {
+		growth = after - before
+	}
+	assert.Less(t, growth, uint64(8*size), fmt.Sprintf("heap grew by %d bytes", growth))
+}
@@ -0,0 +1,127 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ociregistory
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/containerd/containerd/images"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+// well-known keys of the manifest annotations and the image config labels which provide the ImageMetadata
+const (
+	AnnotationVMID                = "wasmxds.tetrate.io/vm-id"
+	AnnotationRootID              = "wasmxds.tetrate.io/root-id"
+	AnnotationRuntime             = "wasmxds.tetrate.io/runtime"
+	AnnotationPluginConfiguration = "wasmxds.tetrate.io/plugin-configuration"
+)
+
+// MetadataMediaType is the media type of the optional layer containing the ImageMetadata in JSON.
+// Unlike the annotations, plugin_configuration can be given as a JSON object as well as a string.
+const MetadataMediaType = "application/vnd.wasmxds.metadata.v1+json"
+
+var configMediaTypes = []string{
+	ocispec.MediaTypeImageConfig,
+	images.MediaTypeDockerSchema2Config,
+}
+
+// extractMetadata reads the ImageMetadata from the image config labels, the manifest annotations and the metadata layer,
+// where the latter ones take precedence. It returns nil if none of them is found.
+func extractMetadata(manifest []byte, get func(ocispec.Descriptor) ([]byte, error)) (*wasmxdsv1alpha1.ImageMetadata, error) {
+	var m ocispec.Manifest
+	if err := json.Unmarshal(manifest, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse manifest: %w", err)
+	}
+
+	var ret wasmxdsv1alpha1.ImageMetadata
+	if contains(configMediaTypes, m.Config.MediaType) {
+		raw, err := get(m.Config)
+		if err != nil {
+			return nil, err
+		}
+		// Docker image config has the same layout as OCI image config
+		var config ocispec.Image
+		if err := json.Unmarshal(raw, &config); err != nil {
+			return nil, fmt.Errorf("failed to parse image config: %w", err)
+		}
+		applyAnnotations(&ret, config.Config.Labels)
+	}
+
+	applyAnnotations(&ret, m.Annotations)
+
+	for _, l := range m.Layers {
+		if l.MediaType != MetadataMediaType {
+			continue
+		}
+		raw, err := get(l)
+		if err != nil {
+			return nil, err
+		}
+		if err := applyMetadataLayer(&ret, raw); err != nil {
+			return nil, fmt.Errorf("invalid metadata layer %s: %w", l.Digest, err)
+		}
+	}
+
+	if ret == (wasmxdsv1alpha1.ImageMetadata{}) {
+		return nil, nil
+	}
+	return &ret, nil
+}
+
+func applyAnnotations(m *wasmxdsv1alpha1.ImageMetadata, annotations map[string]string) {
+	for key, dst := range map[string]*string{
+		AnnotationVMID:                &m.VMID,
+		AnnotationRootID:              &m.RootID,
+		AnnotationRuntime:             &m.Runtime,
+		AnnotationPluginConfiguration: &m.PluginConfiguration,
+	} {
+		if v, ok := annotations[key]; ok && v != "" {
+			*dst = v
+		}
+	}
+}
+
+func applyMetadataLayer(m *wasmxdsv1alpha1.ImageMetadata, raw []byte) error {
+	var layer struct {
+		VMID                string          `json:"vm_id"`
+		RootID              string          `json:"root_id"`
+		Runtime             string          `json:"runtime"`
+		PluginConfiguration json.RawMessage `json:"plugin_configuration"`
+	}
+	if err := json.Unmarshal(raw, &layer); err != nil {
+		return err
+	}
+
+	pc := string(layer.PluginConfiguration)
+	var s string
+	if len(layer.PluginConfiguration) == 0 || pc == "null" {
+		pc = ""
+	} else if err := json.Unmarshal(layer.PluginConfiguration, &s); err == nil {
+		// given as a string
+		pc = s
+	}
+
+	applyAnnotations(m, map[string]string{
+		AnnotationVMID:                layer.VMID,
+		AnnotationRootID:              layer.RootID,
+		AnnotationRuntime:             layer.Runtime,
+		AnnotationPluginConfiguration: pc,
+	})
+	return nil
+}
@@ -0,0 +1,50 @@
+package ociregistory
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/containerd/containerd/images"
+	"github.com/opencontainers/go-digest"
+	ocispec "github.com/opencontainers/image-spec/specs-go/v1"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+func TestExtractMetadata(t *testing.T) {
+	config := `{"config":{"Labels":{"wasmxds.tetrate.io/vm-id":"label-vm","wasmxds.tetrate.io/root-id":"label-root"}}}`
+	layer := `{"runtime":"wavm","plugin_configuration":{"foo":"bar"}}`
+	blobs := map[digest.Digest][]byte{
+		digest.FromString(config): []byte(config),
+		digest.FromString(layer):  []byte(layer),
+	}
+	get := func(desc ocispec.Descriptor) ([]byte, error) { return blobs[desc.Digest], nil }
+
+	m := ocispec.Manifest{
+		Config: ocispec.Descriptor{MediaType: images.MediaTypeDockerSchema2Config, Digest: digest.FromString(config)},
+		Layers: []ocispec.Descriptor{
+			{MediaType: images.MediaTypeDockerSchema2LayerGzip, Digest: digest.FromString("wasm")},
+			{MediaType: MetadataMediaType, Digest: digest.FromString(layer)},
+		},
+		Annotations: map[string]string{AnnotationRootID: "annotation-root"},
+	}
+	raw, err := json.Marshal(m)
+	require.NoError(t, err)
+
+	actual, err := extractMetadata(raw, get)
+	require.NoError(t, err)
+	assert.Equal(t, &wasmxdsv1alpha1.ImageMetadata{
+		VMID:                "label-vm",
+		RootID:              "annotation-root",
+		Runtime:             "wavm",
+		PluginConfiguration: `{"foo":"bar"}`,
+	}, actual)
+
+	raw, err = json.Marshal(ocispec.Manifest{Layers: []ocispec.Descriptor{{MediaType: AllowedMediaType[0]}}})
+	require.NoError(t, err)
+	actual, err = extractMetadata(raw, get)
+	require.NoError(t, err)
+	assert.Nil(t, actual)
+}
@@ -0,0 +1,25 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ociregistory
+
+// Registry is an OCI registry on an arbitrary host. The credentials in the docker config
+// are used if the username and password are empty.
+type Registry struct{ *imagePuller }
+
+func NewRegistry(host, username, password string) Registry {
+	return Registry{newImagePuller(host, func() (string, string, error) {
+		return username, password, nil
+	})}
+}
@@ -0,0 +1,235 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package ociregistory
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/containerd/containerd/reference"
+	"github.com/containerd/containerd/remotes/docker"
+
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+)
+
+// maxAuthAttempts bounds the requests retried with the credentials answering the challenges
+const maxAuthAttempts = 3
+
+// credentialStore is implemented by the docker auth client of oras, which has the credentials stored on login
+type credentialStore interface {
+	Credential(hostname string) (string, string, error)
+}
+
+// ErrNoMatchingTag is returned when no tag satisfies the version constraint
+var ErrNoMatchingTag = errors.New("no tag satisfies the version constraint")
+
+// HighestMatchingTag returns the tag of the highest semantic version which satisfies the constraint, e.g. "~1.4".
+// The tags which are not semantic versions are ignored, and "v" prefixes are allowed as in "v1.4.2".
+func HighestMatchingTag(tags []string, constraint string) (string, error) {
+	c, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return "", fetcherr.Invalid(fmt.Errorf("invalid version constraint %q: %w", constraint, err))
+	}
+
+	var tag string
+	var highest *semver.Version
+	for _, t := range tags {
+		v, err := semver.NewVersion(t)
+		if err != nil || !c.Check(v) {
+			continue
+		}
+		// prefer the exact form on ties such as "1.4.0" and "v1.4.0"
+		if highest == nil || v.GreaterThan(highest) || (v.Equal(highest) && t < tag) {
+			tag, highest = t, v
+		}
+	}
+	if highest == nil {
+		return "", fetcherr.NotFound(fmt.Errorf("%w %q among %d tags", ErrNoMatchingTag, constraint, len(tags)))
+	}
+	return tag, nil
+}
+
+// ListTags returns all the tags of the repository, e.g. "ghcr.io/foo/bar", via the tag listing API of the registry
+func (p *imagePuller) ListTags(ctx context.Context, repository string) ([]string, error) {
+	return p.listTags(ctx, repository, false)
+}
+
+func (p *imagePuller) listTags(ctx context.Context, repository string, retried bool) ([]string, error) {
+	// the login stores the credentials in the auth client
+	resolver, err := p.getResolver(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	ref, err := reference.Parse(repository)
+	if err != nil {
+		return nil, fetcherr.Invalid(fmt.Errorf("failed to parse repository %s: %w", repository, err))
+	}
+	host := ref.Hostname()
+	name := strings.TrimPrefix(ref.Locator, host+"/")
+	if host == "docker.io" {
+		host = "registry-1.docker.io"
+	}
+	scheme := "https"
+	if useInsecure {
+		scheme = "http"
+	}
+
+	opts := []docker.AuthorizerOpt{docker.WithAuthClient(httpClient)}
+	if cs, ok := p.authClient.(credentialStore); ok {
+		opts = append(opts, docker.WithAuthCreds(cs.Credential))
+	}
+	tags, err := listTags(ctx, httpClient, docker.NewDockerAuthorizer(opts...), scheme+"://"+host, name)
+	if errors.Is(err, docker.ErrNoToken) || errors.Is(err, docker.ErrInvalidAuthorization) {
+		if retried {
+			return nil, fetcherr.Unauthorized(fmt.Errorf("%w: %v", ErrAuthenticationFailure, err))
+		}
+		// the credentials may have expired, so login and try again
+		p.discardResolver(resolver)
+		return p.listTags(ctx, repository, true)
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to list tags of %s: %w", repository, err)
+	}
+	return tags, nil
+}
+
+// Ping checks whether the registry can be reached through the base endpoint of the distribution API
+func (p *imagePuller) Ping(ctx context.Context) error {
+	scheme := "https"
+	if useInsecure {
+		scheme = "http"
+	}
+	return ping(ctx, httpClient, scheme+"://"+p.host)
+}
+
+// ping sends the anonymous request to the base endpoint, which the registries requiring authentication answer with 401
+func ping(ctx context.Context, client *http.Client, endpoint string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint+"/v2/", nil)
+	if err != nil {
+		return err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_ = resp.Body.Close()
+	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusUnauthorized {
+		return fmt.Errorf("unexpected status code from %s: %s", req.URL, resp.Status)
+	}
+	return nil
+}
+
+// listTags follows the pagination of the tag listing API of the OCI distribution spec
+func listTags(ctx context.Context, client *http.Client, authorizer docker.Authorizer, endpoint, name string) ([]string, error) {
+	var ret []string
+	next := fmt.Sprintf("%s/v2/%s/tags/list", endpoint, name)
+	for next != "" {
+		resp, err := doAuthorized(ctx, client, authorizer, next)
+		if err != nil {
+			return nil, err
+		}
+
+		var body struct {
+			Tags []string `json:"tags"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&body)
+		link := resp.Header.Get("Link")
+		_ = resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tag list: %w", err)
+		}
+		ret = append(ret, body.Tags...)
+
+		if next, err = nextPage(next, link); err != nil {
+			return nil, err
+		}
+	}
+	return ret, nil
+}
+
+// doAuthorized sends the GET request, and retries it with the authorization answering the challenge of the registry
+func doAuthorized(ctx context.Context, client *http.Client, authorizer docker.Authorizer, u string) (*http.Response, error) {
+	var responses []*http.Response
+	for i := 0; i < maxAuthAttempts; i++ {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", "application/json")
+		if err := authorizer.Authorize(ctx, req); err != nil {
+			return nil, err
+		}
+
+		resp, err := client.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		switch resp.StatusCode {
+		case http.StatusOK:
+			return resp, nil
+		case http.StatusUnauthorized:
+			_ = resp.Body.Close()
+			responses = append(responses, resp)
+			if err := authorizer.AddResponses(ctx, responses); err != nil {
+				return nil, err
+			}
+		default:
+			_ = resp.Body.Close()
+			return nil, statusError(u, resp)
+		}
+	}
+	return nil, fmt.Errorf("%w: still unauthorized after %d attempts", docker.ErrInvalidAuthorization, maxAuthAttempts)
+}
+
+// statusError classifies the unexpected status of the registry
+func statusError(u string, resp *http.Response) error {
+	err := fmt.Errorf("unexpected status code from %s: %s", u, resp.Status)
+	switch resp.StatusCode {
+	case http.StatusNotFound:
+		return fetcherr.NotFound(err)
+	case http.StatusForbidden:
+		return fetcherr.Unauthorized(err)
+	default:
+		return fetcherr.Transient(err)
+	}
+}
+
+// nextPage returns the url in the Link header with rel="next", or empty if the page is the last
+func nextPage(current, link string) (string, error) {
+	if link == "" {
+		return "", nil
+	}
+	// e.g. `</v2/foo/tags/list?n=100&last=v1.0.0>; rel="next"`
+	start, end := strings.Index(link, "<"), strings.Index(link, ">")
+	if start < 0 || end < start || !strings.Contains(link[end:], `rel="next"`) {
+		return "", nil
+	}
+
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+	u, err := base.Parse(link[start+1 : end])
+	if err != nil {
+		return "", fmt.Errorf("invalid Link header %q: %w", link, err)
+	}
+	return u.String(), nil
+}
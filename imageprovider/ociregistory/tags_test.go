@@ -0,0 +1,126 @@
+package ociregistory
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/containerd/containerd/remotes/docker"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+)
+
+func TestHighestMatchingTag(t *testing.T) {
+	tags := []string{"latest", "1.3.9", "v1.4.0", "1.4.0", "1.4.2", "1.4.10", "1.5.0", "1.5.1-rc.1", "2.0.0"}
+	for _, c := range []struct {
+		constraint, exp string
+	}{
+		{constraint: "~1.4", exp: "1.4.10"},
+		{constraint: "^1.4", exp: "1.5.0"},
+		{constraint: ">= 1.2, < 1.4", exp: "1.3.9"},
+		{constraint: "1.4.0", exp: "1.4.0"},
+		{constraint: "^1.5.1-rc.0", exp: "1.5.1-rc.1"},
+		{constraint: "*", exp: "2.0.0"},
+	} {
+		actual, err := HighestMatchingTag(tags, c.constraint)
+		require.NoError(t, err, c.constraint)
+		assert.Equal(t, c.exp, actual, c.constraint)
+	}
+
+	_, err := HighestMatchingTag(tags, "~3.0")
+	assert.True(t, errors.Is(err, ErrNoMatchingTag))
+	_, err = HighestMatchingTag(tags, "~one")
+	assert.Error(t, err)
+}
+
+func TestListTags(t *testing.T) {
+	ts := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if user, pass, ok := r.BasicAuth(); !ok || user != "user" || pass != "pass" {
+			w.Header().Set("WWW-Authenticate", `Basic realm="registry"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.String() {
+		case "/v2/foo/bar/tags/list":
+			w.Header().Set("Link", `</v2/foo/bar/tags/list?last=1.4.0&n=2>; rel="next"`)
+			_, _ = fmt.Fprint(w, `{"name":"foo/bar","tags":["1.3.0","1.4.0"]}`)
+		case "/v2/foo/bar/tags/list?last=1.4.0&n=2":
+			_, _ = fmt.Fprint(w, `{"name":"foo/bar","tags":["1.4.1"]}`)
+		case "/v2/foo/slow/tags/list":
+			// the hung registry
+			<-r.Context().Done()
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer ts.Close()
+
+	authorizer := func(user, pass string) docker.Authorizer {
+		return docker.NewDockerAuthorizer(docker.WithAuthClient(ts.Client()),
+			docker.WithAuthCreds(func(string) (string, string, error) { return user, pass, nil }))
+	}
+
+	actual, err := listTags(context.Background(), ts.Client(), authorizer("user", "pass"), ts.URL, "foo/bar")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1.3.0", "1.4.0", "1.4.1"}, actual)
+
+	_, err = listTags(context.Background(), ts.Client(), authorizer("user", "wrong"), ts.URL, "foo/bar")
+	assert.Error(t, err)
+
+	_, err = listTags(context.Background(), ts.Client(), authorizer("user", "pass"), ts.URL, "foo/unknown")
+	assert.True(t, errors.Is(err, fetcherr.ErrNotFound))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err = listTags(ctx, ts.Client(), authorizer("user", "pass"), ts.URL, "foo/slow")
+	assert.True(t, errors.Is(err, context.DeadlineExceeded), err)
+	assert.Less(t, int64(time.Since(start)), int64(5*time.Second))
+}
+
+func TestNextPage(t *testing.T) {
+	const current = "https://ghcr.io/v2/foo/tags/list"
+	for _, c := range []struct {
+		link, exp string
+	}{
+		{link: "", exp: ""},
+		{link: `</v2/foo/tags/list?last=v1&n=100>; rel="next"`, exp: "https://ghcr.io/v2/foo/tags/list?last=v1&n=100"},
+		{link: `<https://mirror.example.com/v2/foo/tags/list?last=v1>; rel="next"`, exp: "https://mirror.example.com/v2/foo/tags/list?last=v1"},
+		{link: `</v2/foo/tags/list?last=v1>; rel="prev"`, exp: ""},
+	} {
+		actual, err := nextPage(current, c.link)
+		require.NoError(t, err)
+		assert.Equal(t, c.exp, actual, c.link)
+	}
+}
+
+func TestPing(t *testing.T) {
+	for _, c := range []struct {
+		status int
+		ok     bool
+	}{
+		{status: http.StatusOK, ok: true},
+		{status: http.StatusUnauthorized, ok: true},
+		{status: http.StatusNotFound, ok: false},
+		{status: http.StatusServiceUnavailable, ok: false},
+	} {
+		s := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			assert.Equal(t, "/v2/", r.URL.Path)
+			w.WriteHeader(c.status)
+		}))
+		err := ping(context.Background(), s.Client(), s.URL)
+		s.Close()
+		assert.Equal(t, c.ok, err == nil, c.status)
+	}
+
+	// unreachable
+	s := httptest.NewServer(http.NotFoundHandler())
+	s.Close()
+	assert.Error(t, ping(context.Background(), http.DefaultClient, s.URL))
+}
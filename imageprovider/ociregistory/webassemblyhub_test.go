@@ -17,6 +17,7 @@ package ociregistory
 import (
 	"bytes"
 	"context"
+	"io/ioutil"
 	"os"
 	"strings"
 	"testing"
@@ -46,6 +47,22 @@ func TestWebAssemblyHub_Pull(t *testing.T) {
 		assert.True(t, proxyWasmExported)
 	})
 
+	t.Run("open and resolve", func(t *testing.T) {
+		puller := NewWebAssemblyHub("", "")
+		image, err := puller.Open(context.Background(), "webassemblyhub.io/mathetake/example:v0.1")
+		require.NoError(t, err)
+		binary, err := ioutil.ReadAll(image.Body)
+		require.NoError(t, err)
+		require.NoError(t, image.Body.Close())
+		assert.Equal(t, int64(len(binary)), image.Size)
+		assert.NotEmpty(t, image.Digest)
+
+		info, err := puller.Resolve(context.Background(), "webassemblyhub.io/mathetake/example:v0.1")
+		require.NoError(t, err)
+		assert.Equal(t, image.Digest, info.Digest)
+		assert.False(t, info.Changed(&image.Info))
+	})
+
 	t.Run("with login", func(t *testing.T) {
 		username := os.Getenv("WEBASSEMBLY_HUB_USERNAME")
 		password := os.Getenv("WEBASSEMBLY_HUB_PASSWORD")
@@ -2,13 +2,19 @@ package s3provider
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+	"github.com/tetratelabs/wasmxds/imageprovider/stream"
 )
 
 type AmazonS3 struct {
@@ -20,24 +26,97 @@ func NewAmazonS3(sess *session.Session) (*AmazonS3, error) {
 	return &AmazonS3{client: client}, nil
 }
 
-func (a *AmazonS3) Fetch(_ context.Context, uri string) ([]byte, error) {
-	u := strings.SplitN(uri, "/", 2)
-	if len(u) != 2 {
-		return nil, fmt.Errorf("specified uri is malformed for "+
-			"s3: uri must be in '<s3_bucket_name>/path/to/wasm/binary' but got %s", uri)
+func (a *AmazonS3) Fetch(ctx context.Context, uri string) ([]byte, error) {
+	input, err := objectInput(uri)
+	if err != nil {
+		return nil, err
 	}
 
 	buf := aws.NewWriteAtBuffer(nil)
-	_, err := a.client.Download(buf, &s3.GetObjectInput{
-		Bucket: aws.String(u[0]),
-		Key:    aws.String(u[1]),
-	})
+	_, err = a.client.DownloadWithContext(ctx, buf, input)
 	if err != nil {
-		return nil, fmt.Errorf("error downloading from s3: %v", err)
+		return nil, classify(fmt.Errorf("error downloading from s3: %w", err))
 	}
 	return buf.Bytes(), nil
 }
 
+// Open streams the object instead of downloading it in parallel parts
+func (a *AmazonS3) Open(ctx context.Context, uri string) (*stream.Image, error) {
+	input, err := objectInput(uri)
+	if err != nil {
+		return nil, err
+	}
+	out, err := a.client.S3.GetObjectWithContext(ctx, input)
+	if err != nil {
+		return nil, classify(fmt.Errorf("error getting object from s3: %w", err))
+	}
+	return &stream.Image{
+		Info: objectInfo(out.ETag, out.ContentLength, out.ContentType),
+		Body: out.Body,
+	}, nil
+}
+
+// Resolve returns the entity tag, the length and the type of the object
+func (a *AmazonS3) Resolve(ctx context.Context, uri string) (*stream.Info, error) {
+	input, err := objectInput(uri)
+	if err != nil {
+		return nil, err
+	}
+	out, err := a.client.S3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{Bucket: input.Bucket, Key: input.Key})
+	if err != nil {
+		return nil, classify(fmt.Errorf("error getting object metadata from s3: %w", err))
+	}
+	info := objectInfo(out.ETag, out.ContentLength, out.ContentType)
+	return &info, nil
+}
+
+func objectInput(uri string) (*s3.GetObjectInput, error) {
+	u := strings.SplitN(uri, "/", 2)
+	if len(u) != 2 {
+		return nil, fetcherr.Invalid(fmt.Errorf("specified uri is malformed for "+
+			"s3: uri must be in '<s3_bucket_name>/path/to/wasm/binary' but got %s", uri))
+	}
+	return &s3.GetObjectInput{
+		Bucket: aws.String(u[0]),
+		Key:    aws.String(u[1]),
+	}, nil
+}
+
+func objectInfo(etag *string, length *int64, contentType *string) stream.Info {
+	info := stream.Info{
+		ETag:        aws.StringValue(etag),
+		Size:        -1,
+		ContentType: aws.StringValue(contentType),
+	}
+	if length != nil {
+		info.Size = *length
+	}
+	return info
+}
+
+// classify classifies the error by the error code or the status code of S3
+func classify(err error) error {
+	var awsErr awserr.Error
+	if errors.As(err, &awsErr) {
+		switch awsErr.Code() {
+		case s3.ErrCodeNoSuchKey, s3.ErrCodeNoSuchBucket, "NotFound":
+			return fetcherr.NotFound(err)
+		case "AccessDenied", "Forbidden", "InvalidAccessKeyId", "SignatureDoesNotMatch", "ExpiredToken":
+			return fetcherr.Unauthorized(err)
+		}
+	}
+	var reqErr awserr.RequestFailure
+	if errors.As(err, &reqErr) {
+		switch reqErr.StatusCode() {
+		case http.StatusNotFound:
+			return fetcherr.NotFound(err)
+		case http.StatusUnauthorized, http.StatusForbidden:
+			return fetcherr.Unauthorized(err)
+		}
+	}
+	return fetcherr.Transient(err)
+}
+
 func (*AmazonS3) ProviderKey() string {
 	return "s3"
 }
@@ -2,6 +2,8 @@ package s3provider
 
 import (
 	"bytes"
+	"context"
+	"io/ioutil"
 	"path/filepath"
 	"testing"
 
@@ -20,9 +22,13 @@ func TestAmazonS3_ProviderKey(t *testing.T) {
 
 func TestAmazonS3_Fetch(t *testing.T) {
 	t.Run("invalid uri", func(t *testing.T) {
-		_, err := (&AmazonS3{}).Fetch(nil, "a.wasm")
+		_, err := (&AmazonS3{}).Fetch(context.Background(), "a.wasm")
 		assert.Error(t, err)
 		t.Log(err)
+		_, err = (&AmazonS3{}).Open(context.Background(), "a.wasm")
+		assert.Error(t, err)
+		_, err = (&AmazonS3{}).Resolve(context.Background(), "a.wasm")
+		assert.Error(t, err)
 	})
 	t.Run("ok", func(t *testing.T) {
 
@@ -54,8 +60,22 @@ func TestAmazonS3_Fetch(t *testing.T) {
 		}
 
 		as, _ := NewAmazonS3(sess)
-		actual, err := as.Fetch(nil, filepath.Join(bucket, key))
+		actual, err := as.Fetch(context.Background(), filepath.Join(bucket, key))
+		require.NoError(t, err)
+		assert.Equal(t, exp, actual)
+
+		image, err := as.Open(context.Background(), filepath.Join(bucket, key))
+		require.NoError(t, err)
+		actual, err = ioutil.ReadAll(image.Body)
 		require.NoError(t, err)
+		require.NoError(t, image.Body.Close())
 		assert.Equal(t, exp, actual)
+		assert.Equal(t, int64(len(exp)), image.Size)
+		assert.NotEmpty(t, image.ETag)
+
+		info, err := as.Resolve(context.Background(), filepath.Join(bucket, key))
+		require.NoError(t, err)
+		assert.Equal(t, image.ETag, info.ETag)
+		assert.False(t, info.Changed(&image.Info))
 	})
 }
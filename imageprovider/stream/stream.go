@@ -0,0 +1,57 @@
+package stream
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+)
+
+// Info describes an image without its content. The fields the provider doesn't know are left empty
+type Info struct {
+	// Digest identifies the content at the source, e.g. the digest of the OCI manifest the uri was resolved to
+	Digest string
+	// ETag is the entity tag of HTTP servers and S3, or the equivalent of the other sources
+	ETag string
+	// Size is the size of the binary in bytes, and -1 if unknown
+	Size        int64
+	ContentType string
+	// Metadata is published along with OCI images, and is nil if not found or resolved
+	Metadata *wasmxdsv1alpha1.ImageMetadata
+}
+
+// Image streams the binary along with the information of the image
+type Image struct {
+	Info
+	// Body is the binary, which must be closed by the caller
+	Body io.ReadCloser
+}
+
+// Changed reports whether the image described by in differs from the one described by previous.
+// The digests are compared if both are known, then the entity tags and the sizes.
+// It's false if none of them tells the difference.
+func (in *Info) Changed(previous *Info) bool {
+	switch {
+	case in.Digest != "" && previous.Digest != "":
+		return in.Digest != previous.Digest
+	case in.ETag != "" && previous.ETag != "":
+		// the servers may weaken the tags of the compressed responses
+		return strings.TrimPrefix(in.ETag, "W/") != strings.TrimPrefix(previous.ETag, "W/")
+	case in.Size >= 0 && previous.Size >= 0:
+		return in.Size != previous.Size
+	}
+	return false
+}
+
+// ReadAll reads the binary and closes the body, which is for the providers implementing Fetch with Open
+func ReadAll(image *Image) ([]byte, error) {
+	defer image.Body.Close()
+	b, err := ioutil.ReadAll(image.Body)
+	if err != nil {
+		return nil, fetcherr.Transient(fmt.Errorf("error reading image: %w", err))
+	}
+	return b, nil
+}
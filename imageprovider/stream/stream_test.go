@@ -0,0 +1,45 @@
+package stream
+
+import (
+	"errors"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/iotest"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+)
+
+func TestInfo_Changed(t *testing.T) {
+	for _, c := range []struct {
+		name              string
+		current, previous Info
+		exp               bool
+	}{
+		{name: "same digests", current: Info{Digest: "sha256:1", ETag: "a", Size: -1}, previous: Info{Digest: "sha256:1", ETag: "b", Size: -1}},
+		{name: "different digests", current: Info{Digest: "sha256:1", Size: -1}, previous: Info{Digest: "sha256:2", Size: -1}, exp: true},
+		{name: "same etags", current: Info{ETag: `"a"`, Size: 1}, previous: Info{ETag: `"a"`, Size: 2}},
+		{name: "weakened etag", current: Info{ETag: `W/"a"`, Size: -1}, previous: Info{ETag: `"a"`, Size: 2}},
+		{name: "different etags", current: Info{ETag: `"a"`, Size: 1}, previous: Info{ETag: `"b"`, Size: 1}, exp: true},
+		{name: "digest known to one", current: Info{Digest: "sha256:1", ETag: `"a"`, Size: -1}, previous: Info{ETag: `"a"`, Size: -1}},
+		{name: "different sizes", current: Info{Size: 1}, previous: Info{Size: 2}, exp: true},
+		{name: "unknown", current: Info{Size: -1}, previous: Info{Size: 2}},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			assert.Equal(t, c.exp, c.current.Changed(&c.previous))
+		})
+	}
+}
+
+func TestReadAll(t *testing.T) {
+	b, err := ReadAll(&Image{Body: ioutil.NopCloser(strings.NewReader("abc"))})
+	require.NoError(t, err)
+	assert.Equal(t, []byte("abc"), b)
+
+	_, err = ReadAll(&Image{Body: ioutil.NopCloser(iotest.TimeoutReader(strings.NewReader("abc")))})
+	require.Error(t, err)
+	assert.True(t, errors.Is(err, fetcherr.ErrTransient))
+}
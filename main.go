@@ -21,11 +21,17 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"log"
 	"net"
+	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
@@ -33,18 +39,23 @@ import (
 	discoveryservice "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	extensionservice "github.com/envoyproxy/go-control-plane/envoy/service/extension/v3"
 	"google.golang.org/grpc"
+	grpchealth "google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
 	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
 	_ "k8s.io/client-go/plugin/pkg/client/auth/gcp"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+	"sigs.k8s.io/controller-runtime/pkg/webhook"
 
+	"github.com/tetratelabs/wasmxds/admin"
 	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	wasmxdsv1alpha2 "github.com/tetratelabs/wasmxds/api/v1alpha2"
 	"github.com/tetratelabs/wasmxds/controllers"
+	"github.com/tetratelabs/wasmxds/filesource"
+	"github.com/tetratelabs/wasmxds/health"
 	"github.com/tetratelabs/wasmxds/imageprovider"
-	"github.com/tetratelabs/wasmxds/imageprovider/httpprovider"
-	"github.com/tetratelabs/wasmxds/imageprovider/localfs"
 	"github.com/tetratelabs/wasmxds/imageprovider/ociregistory"
 	"github.com/tetratelabs/wasmxds/imageprovider/s3provider"
 	"github.com/tetratelabs/wasmxds/wasmxds"
@@ -56,14 +67,52 @@ var (
 	watchNamespace                                       string
 	enableAmazonECR, enableAmazonS3, enableAmazonS3Local bool
 	allowInsecureHttps                                   bool
+	enableWebhooks, enableLeaderElection                 bool
+	leaderElectionNamespace                              string
+	source, sourceDir, configDir                         string
+	adminAddress, adminTokenFile, adminStoreDir          string
+	adminConfigDir, adminTLSCertFile, adminTLSKeyFile    string
+	healthProbeAddress                                   string
+	versionCheckInterval, fetchTimeout, readinessTimeout time.Duration
+	registryMirrors                                      = mirrorsFlag{}
 )
 
 func init() {
 	utilruntime.Must(clientgoscheme.AddToScheme(scheme))
 	utilruntime.Must(wasmxdsv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(wasmxdsv1alpha2.AddToScheme(scheme))
 	flag.StringVar(&watchNamespace, "n", "", "namespace for watching. The controller watches all namespaces by default")
 	flag.BoolVar(&enableAmazonECR, "ecr", false, "Enable Amazon ECR provider. Disabled by default")
 	flag.BoolVar(&enableAmazonS3, "s3", false, "Enable Amazon S3 provider. Disabled by default")
+	flag.BoolVar(&enableWebhooks, "webhook", false, "Enable admission and conversion webhooks for WasmExtension. Disabled by default")
+	flag.BoolVar(&enableLeaderElection, "leader-elect", false, "Enable leader election for running multiple replicas. "+
+		"Every replica serves xDS, and only the leader writes the status and the finalizers of WasmExtensions. Disabled by default")
+	flag.StringVar(&leaderElectionNamespace, "leader-election-namespace", "", "namespace of the leader election ConfigMap. "+
+		"Defaults to the namespace of the pod")
+	flag.StringVar(&source, "source", sourceKubernetes, "source of WasmExtensions. One of \"kubernetes\" and \"file\"")
+	flag.StringVar(&sourceDir, "dir", "", "directory of WasmExtension manifests. Used with -source=file")
+	flag.StringVar(&configDir, "config-dir", "", "directory of the configurations referenced by valueFrom, "+
+		"laid out as <namespace>/<name>/<key>. Used with -source=file. Defaults to <dir>/config")
+	flag.StringVar(&adminAddress, "admin-address", "", "address to serve the admin REST API, e.g. \":8611\". "+
+		"The admin gRPC API is served along with xDS. The admin API is disabled by default")
+	flag.StringVar(&adminTokenFile, "admin-token-file", "", "file containing the bearer token of the admin API")
+	flag.StringVar(&adminStoreDir, "admin-store-dir", "", "directory to store the extensions managed by the admin API")
+	flag.StringVar(&adminConfigDir, "admin-config-dir", "", "directory of the configurations referenced by valueFrom "+
+		"of the extensions managed by the admin API, laid out as <namespace>/<name>/<key>. valueFrom is rejected if empty")
+	flag.StringVar(&adminTLSCertFile, "admin-tls-cert-file", "", "certificate file to serve the admin REST API over TLS. "+
+		"Required unless -admin-address is a loopback address")
+	flag.StringVar(&adminTLSKeyFile, "admin-tls-key-file", "", "private key file to serve the admin REST API over TLS")
+	flag.StringVar(&healthProbeAddress, "health-probe-address", ":8612", "address to serve the liveness and readiness "+
+		"probes at /healthz and /readyz. Empty disables them")
+	flag.DurationVar(&versionCheckInterval, "version-check-interval", wasmxds.DefaultVersionCheckInterval,
+		"interval to resolve the version constraints of OCI images again to pick up newly published tags")
+	flag.DurationVar(&fetchTimeout, "fetch-timeout", wasmxds.DefaultFetchTimeout,
+		"timeout of fetching an image from one source before falling back to the next. 0 disables the timeout")
+	flag.DurationVar(&readinessTimeout, "readiness-timeout", wasmxds.DefaultReadinessTimeout,
+		"maximum time to hold back the xDS responses until every existing extension has been reconciled once at startup. "+
+			"0 disables the timeout, and a negative value disables holding back")
+	flag.Var(registryMirrors, "registry-mirror", "mirror of an OCI registry tried before it, "+
+		"as \"<registry host>=<mirror host>[/<repository prefix>]\". Can be specified multiple times")
 
 	// flags only for e2e
 	flag.BoolVar(&enableAmazonS3Local, "s3-local", false, "For e2e only")
@@ -73,6 +122,19 @@ func init() {
 const (
 	grpcMaxConcurrentStreams = 100000
 	serverBindAddress        = ":8610"
+	webhookServerPort        = 9443
+	fileSourceResyncPeriod   = time.Minute
+	leaderElectionID         = "wasmxds-leader"
+	providerCheckInterval    = 30 * time.Second
+
+	// the names of the gRPC services reported by grpc.health.v1.Health
+	ecdsServiceName = "envoy.service.extension.v3.ExtensionConfigDiscoveryService"
+	adsServiceName  = "envoy.service.discovery.v3.AggregatedDiscoveryService"
+)
+
+const (
+	sourceKubernetes = "kubernetes"
+	sourceFile       = "file"
 )
 
 func main() {
@@ -83,6 +145,20 @@ func main() {
 		"-n", watchNamespace,
 		"-ecr", enableAmazonECR,
 		"-s3", enableAmazonS3,
+		"-webhook", enableWebhooks,
+		"-leader-elect", enableLeaderElection,
+		"-leader-election-namespace", leaderElectionNamespace,
+		"-source", source,
+		"-dir", sourceDir,
+		"-config-dir", configDir,
+		"-admin-address", adminAddress,
+		"-admin-config-dir", adminConfigDir,
+		"-admin-tls-cert-file", adminTLSCertFile,
+		"-health-probe-address", healthProbeAddress,
+		"-version-check-interval", versionCheckInterval,
+		"-fetch-timeout", fetchTimeout,
+		"-readiness-timeout", readinessTimeout,
+		"-registry-mirror", registryMirrors.String(),
 	)
 
 	grpcServer := grpc.NewServer(grpc.MaxConcurrentStreams(grpcMaxConcurrentStreams))
@@ -92,13 +168,7 @@ func main() {
 	}
 
 	// TODO: make image providers configurable
-	providers := []imageprovider.WasmImageProvider{
-		httpprovider.NewHttpProvider(),
-		httpprovider.NewHttpsProvider(allowInsecureHttps),
-		ociregistory.NewWebAssemblyHub("", ""),
-		ociregistory.NewLocalRegistry("", "", "5000"),
-		localfs.LocalFilesystem{},
-	}
+	providers := imageprovider.NewDefaultProviders(allowInsecureHttps)
 
 	if enableAmazonECR || enableAmazonS3 || enableAmazonS3Local {
 		sess, err := session.NewSession()
@@ -142,9 +212,56 @@ func main() {
 	if err != nil {
 		log.Fatalf("failed to create wasmxds server: %v", err)
 	}
+	server.SetVersionCheckInterval(versionCheckInterval)
+	server.SetFetchTimeout(fetchTimeout)
+	if err := server.SetRegistryMirrors(registryMirrors); err != nil {
+		log.Fatal(err)
+	}
+	if readinessTimeout >= 0 {
+		server.HoldUntilReady(readinessTimeout)
+	}
 	discoveryservice.RegisterAggregatedDiscoveryServiceServer(grpcServer, server)
 	extensionservice.RegisterExtensionConfigDiscoveryServiceServer(grpcServer, server)
-	runController(server)
+
+	// xDS is reported serving once the existing extensions have been reconciled at startup
+	grpcHealth := grpchealth.NewServer()
+	healthpb.RegisterHealthServer(grpcServer, grpcHealth)
+	health.ServeWhenReady(grpcHealth, server.Ready(), ecdsServiceName, adsServiceName)
+	probes := health.NewServer()
+	probes.AddReadinessCheck("extensions", health.Closed(server.Ready(), "initial reconciliation not completed"))
+	for _, p := range server.ImageProviders() {
+		if pinger, ok := p.(imageprovider.Pinger); ok {
+			// unreachable providers are reported without making the server unready,
+			// as the extensions already fetched are still served
+			probes.AddOptionalReadinessCheck("provider/"+p.ProviderKey(),
+				health.Periodic(context.Background(), pinger.Ping, providerCheckInterval))
+		}
+	}
+
+	if adminAddress != "" {
+		// the extensions restored by the admin API are waited for as well as the ones of the source
+		server.AddReadinessSource()
+	}
+	switch source {
+	case sourceKubernetes:
+		runController(server, probes)
+	case sourceFile:
+		runFileSource(server)
+	default:
+		log.Fatalf("unknown source: %s", source)
+	}
+	if adminAddress != "" {
+		runAdmin(server, grpcServer)
+		grpcHealth.SetServingStatus(admin.ServiceName, healthpb.HealthCheckResponse_SERVING)
+	}
+	if healthProbeAddress != "" {
+		go func() {
+			setupLog.Info("starting health probe server", "address", healthProbeAddress)
+			if err := http.ListenAndServe(healthProbeAddress, probes.Handler()); err != nil {
+				log.Fatalf("failed to start health probe server: %v", err)
+			}
+		}()
+	}
 
 	go func() {
 		setupLog.Info("starting grpc server")
@@ -155,6 +272,7 @@ func main() {
 
 	defer func() {
 		setupLog.Info("stopping grpc server")
+		grpcHealth.Shutdown()
 		grpcServer.GracefulStop()
 	}()
 
@@ -163,11 +281,16 @@ func main() {
 	<-gracefulStop
 }
 
-func runController(handler wasmxds.EventHandler) {
+func runController(server *wasmxds.Server, probes *health.Server) {
 	mgr, err := ctrl.NewManager(ctrl.GetConfigOrDie(), ctrl.Options{
 		Scheme:             scheme,
 		MetricsBindAddress: ":0", // disabled
 		Namespace:          watchNamespace,
+		Port:               webhookServerPort,
+
+		LeaderElection:          enableLeaderElection,
+		LeaderElectionID:        leaderElectionID,
+		LeaderElectionNamespace: leaderElectionNamespace,
 	})
 
 	if err != nil {
@@ -176,19 +299,44 @@ func runController(handler wasmxds.EventHandler) {
 	}
 
 	c := &controllers.WasmExtensionReconciler{
-		Client: mgr.GetClient(),
-		Log:    ctrl.Log.WithName("controllers").WithName("WasmExtension"),
-		Scheme: mgr.GetScheme(),
+		Client:   mgr.GetClient(),
+		Log:      ctrl.Log.WithName("controllers").WithName("WasmExtension"),
+		Scheme:   mgr.GetScheme(),
+		Recorder: mgr.GetEventRecorderFor("wasmxds"),
+		Elected:  mgr.Elected(),
 	}
 
 	// pass handler to k8s controller to relay the CRUD event to xDS server
-	c.SetEventHandler(handler)
+	c.SetEventHandler(server)
+	c.SetReadinessTracker(server)
+	// enforce the limits of WasmExtensionPolicy on the fetched binaries
+	server.SetImageVerifier(c.VerifyImage)
+	// record the lifecycle of the extensions as the events on them
+	server.SetEventRecorder(c.Recorder, c.IsLeader)
 
 	if err = c.SetupWithManager(mgr); err != nil {
 		setupLog.Error(err, "unable to create controller", "controller", "WasmExtension")
 		os.Exit(1)
 	}
 
+	if err := probes.AddManagerChecks(mgr); err != nil {
+		setupLog.Error(err, "unable to add health checks")
+		os.Exit(1)
+	}
+
+	if enableWebhooks {
+		if err = (&wasmxdsv1alpha1.WasmExtension{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "WasmExtension", "version", "v1alpha1")
+			os.Exit(1)
+		}
+		if err = (&wasmxdsv1alpha2.WasmExtension{}).SetupWebhookWithManager(mgr); err != nil {
+			setupLog.Error(err, "unable to create webhook", "webhook", "WasmExtension", "version", "v1alpha2")
+			os.Exit(1)
+		}
+		mgr.GetWebhookServer().Register(controllers.PolicyWebhookPath,
+			&webhook.Admission{Handler: &controllers.PolicyValidator{Client: mgr.GetClient()}})
+	}
+
 	setupLog.Info("starting manager")
 	go func() {
 		if err := mgr.Start(ctrl.SetupSignalHandler()); err != nil {
@@ -197,3 +345,102 @@ func runController(handler wasmxds.EventHandler) {
 		}
 	}()
 }
+
+func runFileSource(server *wasmxds.Server) {
+	if sourceDir == "" {
+		log.Fatal("-dir must be specified with -source=file")
+	}
+	if configDir == "" {
+		configDir = filepath.Join(sourceDir, "config")
+	}
+
+	s := filesource.NewSource(sourceDir, configDir, fileSourceResyncPeriod, server)
+	s.SetReadinessTracker(server)
+	setupLog.Info("starting file source", "dir", sourceDir, "config-dir", configDir)
+	go func() {
+		if err := s.Start(context.Background()); err != nil {
+			setupLog.Error(err, "problem running file source")
+			os.Exit(1)
+		}
+	}()
+}
+
+func runAdmin(server *wasmxds.Server, grpcServer *grpc.Server) {
+	if adminTokenFile == "" || adminStoreDir == "" {
+		log.Fatal("-admin-token-file and -admin-store-dir must be specified with -admin-address")
+	}
+	if (adminTLSCertFile == "") != (adminTLSKeyFile == "") {
+		log.Fatal("-admin-tls-cert-file and -admin-tls-key-file must be specified together")
+	}
+	// the bearer token must not be sent in plaintext over the network
+	if adminTLSCertFile == "" && !isLoopback(adminAddress) {
+		log.Fatal("-admin-tls-cert-file and -admin-tls-key-file must be specified unless -admin-address is a loopback address")
+	}
+	token, err := ioutil.ReadFile(adminTokenFile)
+	if err != nil {
+		log.Fatalf("failed to read admin token: %v", err)
+	}
+	store, err := admin.NewFileStore(adminStoreDir)
+	if err != nil {
+		log.Fatalf("failed to create admin store: %v", err)
+	}
+
+	a := admin.NewAdmin(store, server, strings.TrimSpace(string(token)))
+	a.SetConfigDir(adminConfigDir)
+	a.SetReadinessTracker(server)
+	go func() {
+		if err := a.Restore(context.Background()); err != nil {
+			log.Fatalf("failed to restore extensions: %v", err)
+		}
+	}()
+	a.RegisterGRPC(grpcServer)
+
+	mux := http.NewServeMux()
+	mux.Handle(admin.PathPrefix, a)
+	mux.Handle(admin.PathPrefix+"/", a)
+	go func() {
+		setupLog.Info("starting admin server", "address", adminAddress, "tls", adminTLSCertFile != "")
+		var err error
+		if adminTLSCertFile != "" {
+			err = http.ListenAndServeTLS(adminAddress, adminTLSCertFile, adminTLSKeyFile, mux)
+		} else {
+			err = http.ListenAndServe(adminAddress, mux)
+		}
+		if err != nil {
+			log.Fatalf("failed to start admin server: %v", err)
+		}
+	}()
+}
+
+// isLoopback returns true if the host of the address is a loopback address or localhost
+func isLoopback(address string) bool {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return false
+	}
+	if host == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// mirrorsFlag is the flag of "<registry host>=<mirror>" which can be specified multiple times
+type mirrorsFlag map[string]string
+
+func (m mirrorsFlag) String() string {
+	var ret []string
+	for host, mirror := range m {
+		ret = append(ret, host+"="+mirror)
+	}
+	return strings.Join(ret, ",")
+}
+
+func (m mirrorsFlag) Set(v string) error {
+	kv := strings.SplitN(v, "=", 2)
+	if len(kv) != 2 || kv[0] == "" || kv[1] == "" {
+		return fmt.Errorf("must be in the form of <registry host>=<mirror>: %s", v)
+	}
+	m[kv[0]] = kv[1]
+	return nil
+}
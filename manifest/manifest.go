@@ -0,0 +1,163 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package manifest decodes WasmExtension and WasmExtensionPolicy manifests outside Kubernetes
+package manifest
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/serializer"
+	utilruntime "k8s.io/apimachinery/pkg/util/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	wasmxdsv1alpha2 "github.com/tetratelabs/wasmxds/api/v1alpha2"
+)
+
+// DefaultNamespace is the namespace of the extensions which don't specify it
+const DefaultNamespace = "default"
+
+var (
+	scheme  = runtime.NewScheme()
+	decoder runtime.Decoder
+)
+
+func init() {
+	utilruntime.Must(wasmxdsv1alpha1.AddToScheme(scheme))
+	utilruntime.Must(wasmxdsv1alpha2.AddToScheme(scheme))
+	decoder = serializer.NewCodecFactory(scheme).UniversalDeserializer()
+}
+
+// Manifests are the objects decoded from YAML or JSON documents
+type Manifests struct {
+	// Extensions are defaulted, validated and converted to the hub version
+	Extensions []*wasmxdsv1alpha1.WasmExtension
+	Policies   []*wasmxdsv1alpha1.WasmExtensionPolicy
+}
+
+// Decode decodes the documents separated by "---". Empty documents, including the ones with comments only, are skipped.
+func Decode(raw []byte) (*Manifests, error) {
+	ret := &Manifests{}
+	reader := yaml.NewYAMLReader(bufio.NewReader(bytes.NewReader(raw)))
+	for i := 0; ; i++ {
+		doc, err := reader.Read()
+		if err == io.EOF {
+			return ret, nil
+		} else if err != nil {
+			return nil, err
+		}
+
+		if j, err := yaml.ToJSON(doc); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		} else if bytes.Equal(j, []byte("null")) {
+			continue
+		}
+
+		obj, _, err := decoder.Decode(doc, nil, nil)
+		if err != nil {
+			return nil, fmt.Errorf("document %d: failed to decode: %w", i, err)
+		}
+		if err := ret.add(obj); err != nil {
+			return nil, fmt.Errorf("document %d: %w", i, err)
+		}
+	}
+}
+
+func (m *Manifests) add(obj runtime.Object) error {
+	switch o := obj.(type) {
+	case *wasmxdsv1alpha1.WasmExtension:
+		if o.Namespace == "" {
+			o.Namespace = DefaultNamespace
+		}
+		o.Default()
+		if err := o.Validate(); err != nil {
+			return err
+		}
+		m.Extensions = append(m.Extensions, o)
+	case *wasmxdsv1alpha2.WasmExtension:
+		if o.Namespace == "" {
+			o.Namespace = DefaultNamespace
+		}
+		o.Default()
+		if err := o.Validate(); err != nil {
+			return err
+		}
+		ext := &wasmxdsv1alpha1.WasmExtension{}
+		if err := o.ConvertTo(ext); err != nil {
+			return err
+		}
+		m.Extensions = append(m.Extensions, ext)
+	case *wasmxdsv1alpha1.WasmExtensionPolicy:
+		m.Policies = append(m.Policies, o)
+	default:
+		return fmt.Errorf("unsupported kind: %s", obj.GetObjectKind().GroupVersionKind())
+	}
+	return nil
+}
+
+// ResolveConfigs returns the plugin and vm configurations of the extension. The configurations referenced by
+// configMapKeyRef and secretKeyRef are read from "<configDir>/<namespace>/<name>/<key>",
+// which is the same layout as the ConfigMaps and Secrets mounted as volumes.
+func ResolveConfigs(configDir string, ext *wasmxdsv1alpha1.WasmExtension) (pluginConfig, vmConfig string, err error) {
+	if ext.Spec.PluginConfiguration != nil {
+		if pluginConfig, err = resolveConfig(configDir, ext.Spec.PluginConfiguration); err != nil {
+			return "", "", fmt.Errorf("failed to resolve plugin configuration: %w", err)
+		}
+	}
+	if ext.Spec.VMConfiguration != nil {
+		if vmConfig, err = resolveConfig(configDir, ext.Spec.VMConfiguration); err != nil {
+			return "", "", fmt.Errorf("failed to resolve vm configuration: %w", err)
+		}
+	}
+	return
+}
+
+func resolveConfig(configDir string, cv *wasmxdsv1alpha1.WasmExtensionConfigValue) (string, error) {
+	if cv.Value != nil {
+		return *cv.Value, nil
+	} else if cv.Object != nil {
+		return string(cv.Object.Raw), nil
+	} else if cv.ValueFrom == nil {
+		return "", fmt.Errorf("one of value, object and valueFrom must be set")
+	}
+
+	ref := cv.ValueFrom.ConfigMapKeyRef
+	if ref == nil {
+		ref = cv.ValueFrom.SecretKeyRef
+	}
+	if ref == nil {
+		return "", fmt.Errorf("one of secretKeyRef and configMapKeyRef must be set")
+	} else if configDir == "" {
+		return "", fmt.Errorf("config directory not specified")
+	}
+
+	path := filepath.Join(configDir, ref.Namespace, ref.Name, ref.Key)
+	// reject the references escaping from the config directory such as "../../etc"
+	if rel, err := filepath.Rel(configDir, path); err != nil || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("invalid reference %s/%s/%s", ref.Namespace, ref.Name, ref.Key)
+	}
+	raw, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return string(raw), nil
+}
@@ -0,0 +1,101 @@
+package manifest
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+func TestDecode(t *testing.T) {
+	m, err := Decode([]byte(`
+apiVersion: wasmxds.tetrate.io/v1alpha1
+kind: WasmExtension
+metadata:
+  name: v1
+spec:
+  image:
+    uri: webassemblyhub.io/mathetake/example:v0.1
+  vm_id: vm
+  root_id: root
+---
+apiVersion: wasmxds.tetrate.io/v1alpha2
+kind: WasmExtension
+metadata:
+  name: v2
+  namespace: foo
+spec:
+  rootID: root
+  image:
+    localFS:
+      path: filter.wasm
+  vm:
+    id: vm
+---
+# comment only
+---
+apiVersion: wasmxds.tetrate.io/v1alpha1
+kind: WasmExtensionPolicy
+metadata:
+  name: policy
+spec:
+  allowedProtocols: ["oci"]
+`))
+	require.NoError(t, err)
+	require.Len(t, m.Extensions, 2)
+	assert.Equal(t, "default/v1", m.Extensions[0].Namespaced())
+	// defaulted
+	assert.Equal(t, wasmxdsv1alpha1.ProtocolOCIImageRegistry, m.Extensions[0].Spec.Image.Protocol)
+	assert.Equal(t, "foo/v2", m.Extensions[1].Namespaced())
+	assert.Equal(t, wasmxdsv1alpha1.ProtocolLocalFileSystem, m.Extensions[1].Spec.Image.Protocol)
+	require.Len(t, m.Policies, 1)
+	assert.Equal(t, []string{"oci"}, m.Policies[0].Spec.AllowedProtocols)
+
+	for _, raw := range []string{
+		// invalid
+		`{"apiVersion": "wasmxds.tetrate.io/v1alpha1", "kind": "WasmExtension", "metadata": {"name": "v1"}, "spec": {}}`,
+		// unsupported kind
+		`{"apiVersion": "v1", "kind": "ConfigMap", "metadata": {"name": "v1"}}`,
+		"spec: [",
+	} {
+		_, err = Decode([]byte(raw))
+		assert.Error(t, err, raw)
+	}
+}
+
+func TestResolveConfigs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "manifest")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, os.MkdirAll(filepath.Join(dir, "default", "plugin"), 0755))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "default", "plugin", "config.json"), []byte(`{"a":1}`), 0644))
+
+	ref := func(namespace, name, key string) *wasmxdsv1alpha1.WasmExtensionConfigValue {
+		return &wasmxdsv1alpha1.WasmExtensionConfigValue{ValueFrom: &wasmxdsv1alpha1.WasmExtensionConfigValueRef{
+			ConfigMapKeyRef: &wasmxdsv1alpha1.WasmExtensionConfigValueRefAttribute{Namespace: namespace, Name: name, Key: key},
+		}}
+	}
+	value := "vm"
+	ext := &wasmxdsv1alpha1.WasmExtension{Spec: wasmxdsv1alpha1.WasmExtensionSpec{
+		PluginConfiguration: ref("default", "plugin", "config.json"),
+		VMConfiguration:     &wasmxdsv1alpha1.WasmExtensionConfigValue{Value: &value},
+	}}
+	pc, vc, err := ResolveConfigs(dir, ext)
+	require.NoError(t, err)
+	assert.Equal(t, `{"a":1}`, pc)
+	assert.Equal(t, "vm", vc)
+
+	_, _, err = ResolveConfigs("", ext)
+	assert.Error(t, err)
+
+	ext.Spec.PluginConfiguration = ref("..", "..", "etc/passwd")
+	_, _, err = ResolveConfigs(dir, ext)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "invalid reference")
+}
@@ -0,0 +1,174 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package staticconfig renders WasmExtensions as static Envoy configuration for proxies which can't reach the xDS server.
+// The filters are converted by the same converter as the server, so static and dynamic deployments are identical
+// except for where the binaries are loaded from.
+package staticconfig
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	wasm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/http/wasm/v3"
+	hcm "github.com/envoyproxy/go-control-plane/envoy/extensions/filters/network/http_connection_manager/v3"
+	"github.com/golang/protobuf/jsonpb"
+	"github.com/golang/protobuf/ptypes"
+	"sigs.k8s.io/yaml"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	v1converter "github.com/tetratelabs/wasmxds/converter/v1alpha1"
+	"github.com/tetratelabs/wasmxds/manifest"
+	"github.com/tetratelabs/wasmxds/wasmbinary"
+)
+
+// Fetcher returns the binary of the image
+type Fetcher func(image *wasmxdsv1alpha1.WasmExtensionSpecImage) ([]byte, error)
+
+// Renderer renders the extensions as the http_filters of an HTTP connection manager
+type Renderer struct {
+	Fetcher Fetcher
+	// ConfigDir is where the configurations referenced by valueFrom are read. See manifest.ResolveConfigs.
+	ConfigDir string
+	// BinaryDir is the directory the binaries are written to as "<sha256>.wasm" and referenced by filename.
+	// The binaries are inlined in the configuration if empty.
+	BinaryDir string
+	// BinaryPathPrefix replaces BinaryDir in the filenames referenced in the configuration,
+	// e.g. when the binaries are mounted to a different path in the Envoy container. Defaults to BinaryDir.
+	BinaryPathPrefix string
+}
+
+// Render returns the YAML containing the http_filters converted from the extensions in the given order
+func (r *Renderer) Render(extensions []*wasmxdsv1alpha1.WasmExtension) ([]byte, error) {
+	filters := make([]*hcm.HttpFilter, 0, len(extensions))
+	for _, ext := range extensions {
+		f, err := r.filter(ext)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", ext.Namespaced(), err)
+		}
+		filters = append(filters, f)
+	}
+
+	// marshal each filter with jsonpb, which resolves the Any types, in the snake_case used in Envoy YAML
+	marshaler := &jsonpb.Marshaler{OrigName: true}
+	var buf bytes.Buffer
+	buf.WriteString(`{"http_filters":[`)
+	for i, f := range filters {
+		if i > 0 {
+			buf.WriteByte(',')
+		}
+		if err := marshaler.Marshal(&buf, f); err != nil {
+			return nil, err
+		}
+	}
+	buf.WriteString(`]}`)
+	return yaml.JSONToYAML(buf.Bytes())
+}
+
+func (r *Renderer) filter(ext *wasmxdsv1alpha1.WasmExtension) (*hcm.HttpFilter, error) {
+	pc, vc, err := manifest.ResolveConfigs(r.ConfigDir, ext)
+	if err != nil {
+		return nil, err
+	}
+
+	var binary []byte
+	builtin := strings.ToLower(ext.Spec.Runtime) == wasmxdsv1alpha1.RuntimeNull
+	if !builtin {
+		if binary, err = r.fetch(&ext.Spec.Image); err != nil {
+			return nil, err
+		}
+	}
+
+	tc, err := v1converter.Convert(ext, binary, pc, vc)
+	if err != nil {
+		return nil, err
+	}
+
+	if !builtin && r.BinaryDir != "" {
+		path, err := r.writeBinary(binary)
+		if err != nil {
+			return nil, err
+		}
+		// reference the file instead of inlining the binary
+		plugin := &wasm.Wasm{}
+		if err := ptypes.UnmarshalAny(tc.TypedConfig, plugin); err != nil {
+			return nil, err
+		}
+		plugin.GetConfig().GetVmConfig().Code = &core.AsyncDataSource{
+			Specifier: &core.AsyncDataSource_Local{
+				Local: &core.DataSource{Specifier: &core.DataSource_Filename{Filename: path}},
+			},
+		}
+		if tc.TypedConfig, err = ptypes.MarshalAny(plugin); err != nil {
+			return nil, err
+		}
+	}
+
+	f := &hcm.HttpFilter{
+		Name:       tc.Name,
+		ConfigType: &hcm.HttpFilter_TypedConfig{TypedConfig: tc.TypedConfig},
+	}
+	if err := f.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid filter: %w", err)
+	}
+	return f, nil
+}
+
+// fetch returns the binary after checking its sha256 and format, which would make Envoy fail to start otherwise
+func (r *Renderer) fetch(image *wasmxdsv1alpha1.WasmExtensionSpecImage) ([]byte, error) {
+	binary, err := r.Fetcher(image)
+	if err != nil {
+		return nil, err
+	}
+	if image.Sha256 != nil {
+		if actual := sha256Hex(binary); actual != *image.Sha256 {
+			return nil, fmt.Errorf("the sha256 value of the fetched image "+
+				"differs from the one specified in spec.image.sha256: `%s` != `%s`", actual, *image.Sha256)
+		}
+	}
+	if _, err := wasmbinary.Inspect(binary); err != nil {
+		return nil, fmt.Errorf("invalid binary %s: %w", image.ID(), err)
+	}
+	return binary, nil
+}
+
+// writeBinary writes the binary named after its sha256 so that the same binaries are shared,
+// and returns the path referenced in the configuration
+func (r *Renderer) writeBinary(binary []byte) (string, error) {
+	name := sha256Hex(binary) + ".wasm"
+	if err := os.MkdirAll(r.BinaryDir, 0755); err != nil {
+		return "", err
+	}
+	if err := ioutil.WriteFile(filepath.Join(r.BinaryDir, name), binary, 0644); err != nil {
+		return "", err
+	}
+
+	prefix := r.BinaryPathPrefix
+	if prefix == "" {
+		prefix = r.BinaryDir
+	}
+	return filepath.Join(prefix, name), nil
+}
+
+func sha256Hex(b []byte) string {
+	raw := sha256.Sum256(b)
+	return hex.EncodeToString(raw[:])
+}
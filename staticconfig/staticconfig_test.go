@@ -0,0 +1,85 @@
+package staticconfig
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+// the smallest valid module
+var binary = []byte{0x00, 0x61, 0x73, 0x6D, 0x01, 0x00, 0x00, 0x00}
+
+func newExtension(sha256 *string) *wasmxdsv1alpha1.WasmExtension {
+	ext := &wasmxdsv1alpha1.WasmExtension{
+		Spec: wasmxdsv1alpha1.WasmExtensionSpec{
+			Image:  wasmxdsv1alpha1.WasmExtensionSpecImage{URI: "filter.wasm", Protocol: wasmxdsv1alpha1.ProtocolLocalFileSystem, Sha256: sha256},
+			VMID:   "vm",
+			RootID: "root",
+		},
+	}
+	ext.Name, ext.Namespace = "ext", "default"
+	ext.Default()
+	return ext
+}
+
+func fetcherOf(b []byte, err error) Fetcher {
+	return func(*wasmxdsv1alpha1.WasmExtensionSpecImage) ([]byte, error) { return b, err }
+}
+
+func TestRender(t *testing.T) {
+	r := &Renderer{Fetcher: fetcherOf(binary, nil)}
+	out, err := r.Render([]*wasmxdsv1alpha1.WasmExtension{newExtension(nil)})
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "http_filters:")
+	assert.Contains(t, string(out), "name: default/ext")
+	assert.Contains(t, string(out), "inline_bytes: AGFzbQEAAAA=")
+	assert.Contains(t, string(out), "vm_id: vm")
+}
+
+func TestRenderBinaryDir(t *testing.T) {
+	dir, err := ioutil.TempDir("", "staticconfig")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	sum := sha256Hex(binary)
+	r := &Renderer{Fetcher: fetcherOf(binary, nil), BinaryDir: dir}
+	out, err := r.Render([]*wasmxdsv1alpha1.WasmExtension{newExtension(&sum)})
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "filename: "+filepath.Join(dir, sum+".wasm"))
+	assert.NotContains(t, string(out), "inline_bytes")
+
+	written, err := ioutil.ReadFile(filepath.Join(dir, sum+".wasm"))
+	require.NoError(t, err)
+	assert.Equal(t, binary, written)
+
+	r.BinaryPathPrefix = "/etc/envoy/wasm"
+	out, err = r.Render([]*wasmxdsv1alpha1.WasmExtension{newExtension(nil)})
+	require.NoError(t, err)
+	assert.Contains(t, string(out), "filename: /etc/envoy/wasm/"+sum+".wasm")
+}
+
+func TestRenderError(t *testing.T) {
+	wrong := "0000"
+	for _, c := range []struct {
+		name    string
+		fetcher Fetcher
+		ext     *wasmxdsv1alpha1.WasmExtension
+	}{
+		{name: "fetch", fetcher: fetcherOf(nil, errors.New("not found")), ext: newExtension(nil)},
+		{name: "sha256", fetcher: fetcherOf(binary, nil), ext: newExtension(&wrong)},
+		{name: "binary", fetcher: fetcherOf([]byte("not wasm"), nil), ext: newExtension(nil)},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			r := &Renderer{Fetcher: c.fetcher}
+			_, err := r.Render([]*wasmxdsv1alpha1.WasmExtension{c.ext})
+			assert.Error(t, err)
+		})
+	}
+}
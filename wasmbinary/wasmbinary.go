@@ -0,0 +1,232 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package wasmbinary inspects the imports, exports and custom sections of Wasm binaries.
+// Only the sections needed for that are decoded, so that binaries using post-MVP features can be inspected.
+package wasmbinary
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/mathetake/gasm/wasm/leb128"
+)
+
+var (
+	magic   = []byte{0x00, 0x61, 0x73, 0x6D}
+	version = []byte{0x01, 0x00, 0x00, 0x00}
+
+	ErrInvalidHeader = errors.New("invalid magic number or version")
+)
+
+const (
+	sectionIDCustom = 0
+	sectionIDImport = 2
+	sectionIDExport = 7
+
+	// the prefix of the exports telling the Proxy-Wasm ABI versions the module supports
+	abiVersionExportPrefix = "proxy_abi_version_"
+)
+
+var kindNames = map[byte]string{0x00: "func", 0x01: "table", 0x02: "memory", 0x03: "global"}
+
+type Import struct {
+	Module string `json:"module"`
+	Name   string `json:"name"`
+	Kind   string `json:"kind"`
+}
+
+type Export struct {
+	Name string `json:"name"`
+	Kind string `json:"kind"`
+}
+
+type CustomSection struct {
+	Name string `json:"name"`
+	Size int    `json:"size"`
+}
+
+// Module is the summary of a Wasm binary
+type Module struct {
+	Imports        []Import        `json:"imports"`
+	Exports        []Export        `json:"exports"`
+	CustomSections []CustomSection `json:"customSections"`
+}
+
+// ABIVersions returns the Proxy-Wasm ABI versions declared by the exports, e.g. "0_2_0"
+func (m *Module) ABIVersions() []string {
+	var ret []string
+	for _, e := range m.Exports {
+		if strings.HasPrefix(e.Name, abiVersionExportPrefix) {
+			ret = append(ret, strings.TrimPrefix(e.Name, abiVersionExportPrefix))
+		}
+	}
+	return ret
+}
+
+// Inspect decodes the binary
+func Inspect(binary []byte) (*Module, error) {
+	if len(binary) < 8 || !bytes.Equal(binary[:4], magic) || !bytes.Equal(binary[4:8], version) {
+		return nil, ErrInvalidHeader
+	}
+
+	m := &Module{}
+	r := bytes.NewReader(binary[8:])
+	for {
+		id, err := r.ReadByte()
+		if err == io.EOF {
+			return m, nil
+		} else if err != nil {
+			return nil, err
+		}
+		size, _, err := leb128.DecodeUint32(r)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read size of section %d: %w", id, err)
+		}
+		if int(size) > r.Len() {
+			return nil, fmt.Errorf("section %d: %w", id, io.ErrUnexpectedEOF)
+		}
+		content := make([]byte, size)
+		if _, err := io.ReadFull(r, content); err != nil {
+			return nil, fmt.Errorf("failed to read section %d: %w", id, err)
+		}
+
+		sr := bytes.NewReader(content)
+		switch id {
+		case sectionIDCustom:
+			name, err := readName(sr)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read custom section: %w", err)
+			}
+			m.CustomSections = append(m.CustomSections, CustomSection{Name: name, Size: sr.Len()})
+		case sectionIDImport:
+			if m.Imports, err = readImports(sr); err != nil {
+				return nil, fmt.Errorf("failed to read import section: %w", err)
+			}
+		case sectionIDExport:
+			if m.Exports, err = readExports(sr); err != nil {
+				return nil, fmt.Errorf("failed to read export section: %w", err)
+			}
+		}
+	}
+}
+
+func readImports(r *bytes.Reader) ([]Import, error) {
+	n, _, err := leb128.DecodeUint32(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []Import
+	for i := uint32(0); i < n; i++ {
+		module, err := readName(r)
+		if err != nil {
+			return nil, err
+		}
+		name, err := readName(r)
+		if err != nil {
+			return nil, err
+		}
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if err := skipImportDesc(r, kind); err != nil {
+			return nil, fmt.Errorf("import %s.%s: %w", module, name, err)
+		}
+		ret = append(ret, Import{Module: module, Name: name, Kind: kindName(kind)})
+	}
+	return ret, nil
+}
+
+func skipImportDesc(r *bytes.Reader, kind byte) error {
+	switch kind {
+	case 0x00: // type index
+		_, _, err := leb128.DecodeUint32(r)
+		return err
+	case 0x01: // reference type and limits
+		if _, err := r.ReadByte(); err != nil {
+			return err
+		}
+		return skipLimits(r)
+	case 0x02:
+		return skipLimits(r)
+	case 0x03: // value type and mutability
+		_, err := r.Seek(2, io.SeekCurrent)
+		return err
+	default:
+		return fmt.Errorf("unknown kind %#x", kind)
+	}
+}
+
+func skipLimits(r *bytes.Reader) error {
+	flag, err := r.ReadByte()
+	if err != nil {
+		return err
+	}
+	if _, _, err := leb128.DecodeUint32(r); err != nil {
+		return err
+	}
+	if flag&0x01 != 0 {
+		_, _, err = leb128.DecodeUint32(r)
+	}
+	return err
+}
+
+func readExports(r *bytes.Reader) ([]Export, error) {
+	n, _, err := leb128.DecodeUint32(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret []Export
+	for i := uint32(0); i < n; i++ {
+		name, err := readName(r)
+		if err != nil {
+			return nil, err
+		}
+		kind, err := r.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if _, _, err := leb128.DecodeUint32(r); err != nil {
+			return nil, err
+		}
+		ret = append(ret, Export{Name: name, Kind: kindName(kind)})
+	}
+	return ret, nil
+}
+
+func readName(r *bytes.Reader) (string, error) {
+	n, _, err := leb128.DecodeUint32(r)
+	if err != nil {
+		return "", err
+	}
+	if int(n) > r.Len() {
+		return "", io.ErrUnexpectedEOF
+	}
+	buf := make([]byte, n)
+	_, err = io.ReadFull(r, buf)
+	return string(buf), err
+}
+
+func kindName(kind byte) string {
+	if name, ok := kindNames[kind]; ok {
+		return name
+	}
+	return fmt.Sprintf("%#x", kind)
+}
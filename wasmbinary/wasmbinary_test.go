@@ -0,0 +1,56 @@
+package wasmbinary
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func name(s string) []byte {
+	return append([]byte{byte(len(s))}, s...)
+}
+
+func section(id byte, content ...[]byte) []byte {
+	var c []byte
+	for _, b := range content {
+		c = append(c, b...)
+	}
+	return append([]byte{id, byte(len(c))}, c...)
+}
+
+func TestInspect(t *testing.T) {
+	binary := append(append([]byte{}, magic...), version...)
+	binary = append(binary, section(sectionIDImport,
+		[]byte{3},
+		name("env"), name("proxy_log"), []byte{0x00, 0x00},
+		name("env"), name("memory"), []byte{0x02, 0x01, 0x01, 0x02},
+		name("env"), name("global"), []byte{0x03, 0x7f, 0x00},
+	)...)
+	binary = append(binary, section(sectionIDExport,
+		[]byte{2},
+		name("proxy_abi_version_0_2_0"), []byte{0x00, 0x01},
+		name("memory"), []byte{0x02, 0x00},
+	)...)
+	binary = append(binary, section(sectionIDCustom, name("producers"), []byte{1, 2, 3})...)
+
+	m, err := Inspect(binary)
+	require.NoError(t, err)
+	assert.Equal(t, []Import{
+		{Module: "env", Name: "proxy_log", Kind: "func"},
+		{Module: "env", Name: "memory", Kind: "memory"},
+		{Module: "env", Name: "global", Kind: "global"},
+	}, m.Imports)
+	assert.Equal(t, []Export{
+		{Name: "proxy_abi_version_0_2_0", Kind: "func"},
+		{Name: "memory", Kind: "memory"},
+	}, m.Exports)
+	assert.Equal(t, []CustomSection{{Name: "producers", Size: 3}}, m.CustomSections)
+	assert.Equal(t, []string{"0_2_0"}, m.ABIVersions())
+
+	_, err = Inspect([]byte("not wasm"))
+	assert.Equal(t, ErrInvalidHeader, err)
+
+	_, err = Inspect(binary[:len(binary)-1])
+	assert.Error(t, err)
+}
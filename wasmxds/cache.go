@@ -0,0 +1,206 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wasmxds
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/envoyproxy/go-control-plane/pkg/cache/types"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/server/stream/v3"
+)
+
+// contentCache is the cache of the resources of one type like cache.LinearCache, except that the versions are derived
+// from the content of the resources instead of a counter local to the process. The version of each resource is the hash
+// of it serialized, and the version responded is derived from the versions of the requested resources only.
+// Hence the unchanged resources are never pushed again after a restart, a resync or a failover to another replica,
+// even if the other resources have changed in the meantime.
+type contentCache struct {
+	typeURL string
+
+	mu        sync.Mutex
+	resources map[string]types.Resource
+	// hashes are the sha256 of the deterministically marshaled resources
+	hashes map[string]string
+	// watches are the open watches with the requests, which are responded to when the requested resources change
+	watches map[chan cache.Response]*cache.Request
+	// held is true while the responses are held back, e.g. until the initial reconciliation completes
+	held bool
+}
+
+var _ cache.Cache = &contentCache{}
+
+func newContentCache(typeURL string) *contentCache {
+	return &contentCache{
+		typeURL:   typeURL,
+		resources: map[string]types.Resource{},
+		hashes:    map[string]string{},
+		watches:   map[chan cache.Response]*cache.Request{},
+	}
+}
+
+// UpdateResource sets the resource, and notifies the watches only if the content has changed
+func (c *contentCache) UpdateResource(name string, res types.Resource) error {
+	if res == nil {
+		return errors.New("nil resource")
+	}
+	marshaled, err := cache.MarshalResource(res)
+	if err != nil {
+		return err
+	}
+	hash := cache.HashResource(marshaled)
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.hashes[name] == hash {
+		return nil
+	}
+	c.resources[name] = res
+	c.hashes[name] = hash
+	c.notify(name)
+	return nil
+}
+
+func (c *contentCache) DeleteResource(name string) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if _, ok := c.resources[name]; !ok {
+		return nil
+	}
+	delete(c.resources, name)
+	delete(c.hashes, name)
+	c.notify(name)
+	return nil
+}
+
+// notify responds to the watches of the modified resource. Must be called with the lock held.
+func (c *contentCache) notify(modified string) {
+	if c.held {
+		return
+	}
+	for w, req := range c.watches {
+		if len(req.ResourceNames) == 0 || contains(req.ResourceNames, modified) {
+			c.respond(w, req)
+			delete(c.watches, w)
+		}
+	}
+}
+
+// Version returns the version of the resource, which is empty if not found
+func (c *contentCache) Version(name string) string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.hashes[name]
+}
+
+// versionOf returns the version of the requested resources: the version of the resource if only one is requested,
+// and otherwise the hash of the names and the versions of the resources, or of all the resources if none is requested.
+// Must be called with the lock held.
+func (c *contentCache) versionOf(names []string) string {
+	if len(names) == 1 && c.hashes[names[0]] != "" {
+		return c.hashes[names[0]]
+	}
+	if len(names) == 0 {
+		names = c.allNames()
+	} else {
+		names = append([]string{}, names...)
+		sort.Strings(names)
+	}
+	var b strings.Builder
+	for _, name := range names {
+		b.WriteString(name)
+		b.WriteByte('=')
+		b.WriteString(c.hashes[name])
+		b.WriteByte('\n')
+	}
+	return cache.HashResource([]byte(b.String()))
+}
+
+func (c *contentCache) allNames() []string {
+	names := make([]string, 0, len(c.resources))
+	for name := range c.resources {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (c *contentCache) respond(w chan cache.Response, req *cache.Request) {
+	names := req.ResourceNames
+	if len(names) == 0 {
+		names = c.allNames()
+	}
+	resources := make([]types.ResourceWithTtl, 0, len(names))
+	for _, name := range names {
+		if res, ok := c.resources[name]; ok {
+			resources = append(resources, types.ResourceWithTtl{Resource: res})
+		}
+	}
+	w <- &cache.RawResponse{Request: req, Version: c.versionOf(req.ResourceNames), Resources: resources}
+}
+
+func (c *contentCache) CreateWatch(req *cache.Request) (chan cache.Response, func()) {
+	w := make(chan cache.Response, 1)
+	if req.TypeUrl != c.typeURL {
+		close(w)
+		return w, nil
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// the version of the other replica or before the restart is the same as long as the requested resources are
+	if !c.held && req.VersionInfo != c.versionOf(req.ResourceNames) {
+		c.respond(w, req)
+		return w, nil
+	}
+	c.watches[w] = req
+	return w, func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.watches, w)
+	}
+}
+
+// hold makes the watches wait without being responded to until release is called
+func (c *contentCache) hold() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.held = true
+}
+
+// release responds to the watches held back whose requested resources differ from the versions they have
+func (c *contentCache) release() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.held = false
+	for w, req := range c.watches {
+		if req.VersionInfo != c.versionOf(req.ResourceNames) {
+			c.respond(w, req)
+			delete(c.watches, w)
+		}
+	}
+}
+
+func (c *contentCache) CreateDeltaWatch(*cache.DeltaRequest, *stream.StreamState) (chan cache.DeltaResponse, func()) {
+	return nil, nil
+}
+
+func (c *contentCache) Fetch(context.Context, *cache.Request) (cache.Response, error) {
+	return nil, errors.New("not implemented")
+}
@@ -0,0 +1,112 @@
+package wasmxds
+
+import (
+	"testing"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestContentCache_version(t *testing.T) {
+	// the replicas which have applied the same extensions in different orders
+	c1, c2 := newContentCache(apiType), newContentCache(apiType)
+	require.NoError(t, c1.UpdateResource("default/a", &core.TypedExtensionConfig{Name: "default/a"}))
+	require.NoError(t, c1.UpdateResource("default/b", &core.TypedExtensionConfig{Name: "default/b"}))
+	require.NoError(t, c2.UpdateResource("default/b", &core.TypedExtensionConfig{Name: "default/b"}))
+	require.NoError(t, c2.UpdateResource("default/a", &core.TypedExtensionConfig{Name: "default/a"}))
+	assert.Equal(t, c1.versionOf(nil), c2.versionOf(nil))
+	assert.Equal(t, c1.versionOf([]string{"default/b", "default/a"}), c2.versionOf([]string{"default/a", "default/b"}))
+
+	// the version of a resource is the hash of it serialized
+	marshaled, err := cache.MarshalResource(&core.TypedExtensionConfig{Name: "default/a"})
+	require.NoError(t, err)
+	assert.Equal(t, cache.HashResource(marshaled), c1.Version("default/a"))
+	assert.Equal(t, c1.Version("default/a"), c1.versionOf([]string{"default/a"}))
+	assert.Empty(t, c1.Version("default/unknown"))
+	assert.NotEmpty(t, c1.versionOf([]string{"default/unknown"}))
+
+	// the same content doesn't change the version
+	all, a := c1.versionOf(nil), c1.versionOf([]string{"default/a"})
+	require.NoError(t, c1.UpdateResource("default/a", &core.TypedExtensionConfig{Name: "default/a"}))
+	assert.Equal(t, all, c1.versionOf(nil))
+
+	// the changes of the other resources don't change the versions of the requested ones
+	require.NoError(t, c1.DeleteResource("default/b"))
+	assert.NotEqual(t, all, c1.versionOf(nil))
+	assert.Equal(t, a, c1.versionOf([]string{"default/a"}))
+	require.NoError(t, c1.UpdateResource("default/b", &core.TypedExtensionConfig{Name: "default/b"}))
+	assert.Equal(t, all, c1.versionOf(nil))
+}
+
+func TestContentCache_CreateWatch(t *testing.T) {
+	c := newContentCache(apiType)
+	require.NoError(t, c.UpdateResource("default/a", &core.TypedExtensionConfig{Name: "default/a"}))
+
+	// the initial request is responded immediately
+	w, _ := c.CreateWatch(&cache.Request{TypeUrl: apiType, ResourceNames: []string{"default/a"}})
+	resp := <-w
+	version, err := resp.GetVersion()
+	require.NoError(t, err)
+	assert.Equal(t, c.Version("default/a"), version)
+	assert.Len(t, resp.(*cache.RawResponse).Resources, 1)
+
+	// the up-to-date request waits for the change of the requested resources
+	w, cancel := c.CreateWatch(&cache.Request{TypeUrl: apiType, VersionInfo: version, ResourceNames: []string{"default/a"}})
+	defer cancel()
+	require.NoError(t, c.UpdateResource("default/a", &core.TypedExtensionConfig{Name: "default/a"}))
+	require.NoError(t, c.UpdateResource("default/b", &core.TypedExtensionConfig{Name: "default/b"}))
+	select {
+	case <-w:
+		t.Fatal("responded without the change of the requested resource")
+	default:
+	}
+
+	require.NoError(t, c.DeleteResource("default/a"))
+	resp = <-w
+	assert.Empty(t, resp.(*cache.RawResponse).Resources)
+
+	// the unchanged resources are not responded again after a restart even if the others have changed
+	restarted := newContentCache(apiType)
+	require.NoError(t, restarted.UpdateResource("default/a", &core.TypedExtensionConfig{Name: "default/a"}))
+	require.NoError(t, restarted.UpdateResource("default/c", &core.TypedExtensionConfig{Name: "default/c"}))
+	_, cancel = restarted.CreateWatch(&cache.Request{TypeUrl: apiType, VersionInfo: version, ResourceNames: []string{"default/a"}})
+	defer cancel()
+	assert.Len(t, restarted.watches, 1)
+
+	_, ok := <-func() chan cache.Response {
+		w, _ := c.CreateWatch(&cache.Request{TypeUrl: "unknown"})
+		return w
+	}()
+	assert.False(t, ok)
+}
+
+func TestContentCache_hold(t *testing.T) {
+	c := newContentCache(apiType)
+	c.hold()
+
+	// the watches are held back even if the cache is empty
+	w, cancel := c.CreateWatch(&cache.Request{TypeUrl: apiType, ResourceNames: []string{"default/a"}})
+	defer cancel()
+	require.NoError(t, c.UpdateResource("default/a", &core.TypedExtensionConfig{Name: "default/a"}))
+	upToDate, cancel := c.CreateWatch(&cache.Request{TypeUrl: apiType, VersionInfo: c.Version("default/a"),
+		ResourceNames: []string{"default/a"}})
+	defer cancel()
+	select {
+	case <-w:
+		t.Fatal("responded while held")
+	default:
+	}
+
+	// only the stale watches are responded to on release
+	c.release()
+	resp := <-w
+	assert.Len(t, resp.(*cache.RawResponse).Resources, 1)
+	assert.Len(t, c.watches, 1)
+	select {
+	case <-upToDate:
+		t.Fatal("responded to the up-to-date watch")
+	default:
+	}
+}
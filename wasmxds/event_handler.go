@@ -18,17 +18,29 @@ import (
 	"context"
 	"crypto/sha256"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
 
 	"github.com/go-logr/logr"
+	v1 "k8s.io/api/core/v1"
 	ctrl "sigs.k8s.io/controller-runtime"
 
 	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
 	v1converter "github.com/tetratelabs/wasmxds/converter/v1alpha1"
+	"github.com/tetratelabs/wasmxds/imageprovider"
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+	"github.com/tetratelabs/wasmxds/imageprovider/ociregistory"
+	"github.com/tetratelabs/wasmxds/imageprovider/stream"
 )
 
+// EventHandler relays the events of WasmExtension to the xDS server.
+// Update records what it observed in extension.Status, and persisting it is up to the caller.
+// Fetching the images is aborted when the context is done.
 type EventHandler interface {
-	Update(extension *wasmxdsv1alpha1.WasmExtension, pluginConfig, vmConfig string) (ctrl.Result, error)
+	Update(ctx context.Context, extension *wasmxdsv1alpha1.WasmExtension, pluginConfig, vmConfig string) (ctrl.Result, error)
 	Delete(extension *wasmxdsv1alpha1.WasmExtension)
 }
 
@@ -38,68 +50,453 @@ func (s *Server) handlerLogger() logr.Logger {
 	return s.logger.WithName("EventHandler")
 }
 
-func (s *Server) Update(extension *wasmxdsv1alpha1.WasmExtension, pluginConfig, vmConfig string) (res ctrl.Result, err error) {
+func (s *Server) Update(ctx context.Context, extension *wasmxdsv1alpha1.WasmExtension,
+	pluginConfig, vmConfig string) (res ctrl.Result, err error) {
 	s.handlerLogger().Info("updating extension", "name", extension.Namespaced())
-	image, ok := s.imageCache[extension.Spec.Image.URI]
+	s.rememberExtension(extension)
+	if strings.ToLower(extension.Spec.Runtime) == wasmxdsv1alpha1.RuntimeNull {
+		// the plugin is compiled into Envoy, so there's no image to fetch
+		if err = s.updateResource(extension, nil, pluginConfig, vmConfig); err == nil {
+			extension.Status.Sha256 = ""
+			extension.Status.ImageDigest = ""
+			extension.Status.LockedImage = nil
+			extension.Status.ResolvedTag = ""
+			extension.Status.ServedSource = nil
+			extension.Status.Effective = &wasmxdsv1alpha1.WasmExtensionEffectiveValues{
+				VMID:    extension.Spec.VMID,
+				RootID:  extension.Spec.RootID,
+				Runtime: wasmxdsv1alpha1.RuntimeNull,
+			}
+			if extension.Spec.PluginConfiguration != nil {
+				extension.Status.Effective.PluginConfigurationSource = wasmxdsv1alpha1.PluginConfigurationSourceSpec
+			}
+		}
+		return
+	}
+
+	spec, err := imageToFetch(extension)
+	if err != nil {
+		return
+	}
+	var tag string
+	if repository, constraint, ok := extension.Spec.Image.VersionRange(); ok {
+		if tag, err = s.resolveVersion(ctx, extension, spec, repository, constraint); err != nil {
+			err = fmt.Errorf("failed to resolve version constraint %s of %s: %w", constraint, repository, err)
+			return
+		}
+		spec = spec.WithTag(repository, tag)
+		// check again on a schedule as newer versions may be published
+		res.RequeueAfter = s.versionCheckInterval
+	}
+	spec, image, err := s.fetchFromSources(ctx, extension, spec)
+	if err != nil {
+		return
+	}
+	raw := sha256.Sum256(image.binary)
+	actual := hex.EncodeToString(raw[:])
+
+	if s.imageVerifier != nil {
+		if err = s.imageVerifier(ctx, extension, image.binary); err != nil {
+			err = fetcherr.Invalid(fmt.Errorf("image %s rejected: %w", extension.Spec.Image.ID(), err))
+			s.recordEvent(extension, v1.EventTypeWarning, reasonValidationFailed, "%s", err)
+			return
+		}
+	}
+
+	// the explicit values in the spec take precedence over the image metadata
+	effective, pluginConfig, values, err := applyImageMetadata(extension, image.metadata, pluginConfig)
+	if err != nil {
+		err = fetcherr.Invalid(fmt.Errorf("invalid extension: %w", err))
+		s.recordEvent(extension, v1.EventTypeWarning, reasonValidationFailed, "%s", err)
+		return
+	}
+
+	if err = s.updateResource(effective, image.binary, pluginConfig, vmConfig); err != nil {
+		return
+	}
+	extension.Status.Sha256 = actual
+	extension.Status.Effective = values
+	extension.Status.ImageDigest = image.digest
+	s.updateLockedImage(extension)
+	if tag != extension.Status.ResolvedTag {
+		s.handlerLogger().Info("resolved tag changed", "name", extension.Namespaced(),
+			"from", extension.Status.ResolvedTag, "to", tag)
+	}
+	extension.Status.ResolvedTag = tag
+	extension.Status.ServedSource = &wasmxdsv1alpha1.WasmExtensionImageSource{URI: spec.URI, Protocol: spec.Protocol}
+	return res, nil
+}
+
+// fetchFromSources tries the image sources in order until one of them serves the binary passing the sha256 check,
+// and returns the source along with the image. The failure is transient if that of any of the sources is.
+func (s *Server) fetchFromSources(ctx context.Context, extension *wasmxdsv1alpha1.WasmExtension,
+	spec *wasmxdsv1alpha1.WasmExtensionSpecImage) (*wasmxdsv1alpha1.WasmExtensionSpecImage, *cachedImage, error) {
+	sources := s.imageSources(spec, extension.Spec.Image.Sources)
+	var err error
+	var transient bool
+	for i, source := range sources {
+		var image *cachedImage
+		if image, err = s.fetchFromSource(ctx, extension, source); err == nil {
+			return source, image, nil
+		}
+		if ctx.Err() != nil {
+			// the caller has given up, so the other sources wouldn't be fetched either
+			return nil, nil, fetcherr.Transient(fmt.Errorf("aborted fetching image: %w", err))
+		}
+		transient = transient || fetcherr.IsTransient(err)
+		if i < len(sources)-1 {
+			s.handlerLogger().Info("failed to fetch image, trying the next source", "name", extension.Namespaced(),
+				"uri", source.URI, "protocol", source.Protocol, "error", err.Error())
+		}
+	}
+	if len(sources) > 1 {
+		err = fmt.Errorf("none of the %d sources served the image, the last error: %w", len(sources), err)
+		if transient {
+			err = fetcherr.Transient(err)
+		}
+	}
+	return nil, nil, err
+}
+
+func (s *Server) fetchFromSource(ctx context.Context, extension *wasmxdsv1alpha1.WasmExtension,
+	spec *wasmxdsv1alpha1.WasmExtensionSpecImage) (*cachedImage, error) {
+	image, ok := s.lookupImage(spec.URI)
+	if ok && s.imageChanged(ctx, extension, spec, image.info) {
+		ok = false
+	}
 	if !ok {
 		s.handlerLogger().Info("fetching image", "name", extension.Namespaced(),
-			"uri", extension.Spec.Image.URI, "protocol", extension.Spec.Image.Protocol)
-		image, err = s.fetchImage(&extension.Spec.Image)
+			"uri", spec.URI, "protocol", spec.Protocol)
+		image, actual, err := s.fetchImage(ctx, extension, spec)
 		if err != nil {
-			err = fmt.Errorf("failed to fetch image %s: %w", extension.Spec.Image.ID(), err)
-			return
+			return nil, fmt.Errorf("failed to fetch image %s: %w", spec.ID(), err)
 		}
+		if image.digest != "" {
+			s.recordEvent(extension, v1.EventTypeNormal, reasonImageFetched, "fetched image %s with digest %s (sha256 %s)",
+				spec.URI, image.digest, actual)
+		} else {
+			s.recordEvent(extension, v1.EventTypeNormal, reasonImageFetched, "fetched image %s (sha256 %s)",
+				spec.URI, actual)
+		}
+		s.handlerLogger().Info("image successfully fetched", "name", extension.Namespaced(),
+			"uri", spec.URI, "protocol", spec.Protocol, "digest", image.digest)
+		return image, nil
 	}
 
-	s.handlerLogger().Info("image successfully fetched", "name", extension.Namespaced(),
-		"uri", extension.Spec.Image.URI, "protocol", extension.Spec.Image.Protocol)
+	s.handlerLogger().Info("image found in cache", "name", extension.Namespaced(),
+		"uri", spec.URI, "protocol", spec.Protocol, "digest", image.digest)
+	raw := sha256.Sum256(image.binary)
+	if err := s.checkSha256(extension, spec, hex.EncodeToString(raw[:])); err != nil {
+		return nil, err
+	}
+	return image, nil
+}
 
-	if extension.Spec.Image.Sha256 != nil {
-		raw := sha256.Sum256(image)
-		exp := *extension.Spec.Image.Sha256
-		if actual := hex.EncodeToString(raw[:]); actual != exp {
-			err = fmt.Errorf("the sha256 value of the fetched image "+
-				"differs from the one specified in spec.image.sha256: `%s` != `%s`", actual, exp)
-			return
-		}
-		s.handlerLogger().Info("sha256 check passed", "name", extension.Namespaced())
-	} else {
+// checkSha256 checks the sha256 of the image against spec.image.sha256 if specified
+func (s *Server) checkSha256(extension *wasmxdsv1alpha1.WasmExtension, spec *wasmxdsv1alpha1.WasmExtensionSpecImage, actual string) error {
+	if extension.Spec.Image.Sha256 == nil {
 		s.handlerLogger().Info("spec.image.sha256 not specified", "name", extension.Namespaced())
+		return nil
+	}
+	if exp := *extension.Spec.Image.Sha256; actual != exp {
+		s.recordEvent(extension, v1.EventTypeWarning, reasonSha256Mismatch,
+			"the sha256 of image %s is %s while spec.image.sha256 is %s", spec.URI, actual, exp)
+		return fetcherr.Invalid(fmt.Errorf("the sha256 value of the fetched image "+
+			"differs from the one specified in spec.image.sha256: `%s` != `%s`", actual, exp))
+	}
+	s.handlerLogger().Info("sha256 check passed", "name", extension.Namespaced())
+	return nil
+}
+
+// imageChanged tells whether the cached image has been replaced at the source, which is resolved without fetching it
+// if the image was fetched by a streaming provider, i.e. cached is not nil. The cached image is kept if resolving fails.
+func (s *Server) imageChanged(ctx context.Context, extension *wasmxdsv1alpha1.WasmExtension,
+	spec *wasmxdsv1alpha1.WasmExtensionSpecImage, cached *stream.Info) bool {
+	if cached == nil {
+		return false
+	}
+	key, err := spec.ProviderKey()
+	if err != nil {
+		return false
+	}
+	provider, ok := s.imageProviders[key].(imageprovider.StreamingImageProvider)
+	if !ok {
+		return false
+	}
+
+	ctx, cancel := s.fetchContext(ctx, extension)
+	defer cancel()
+	info, err := provider.Resolve(ctx, spec.URI)
+	if err != nil {
+		s.handlerLogger().Info("failed to resolve image, serving the cached one", "name", extension.Namespaced(),
+			"uri", spec.URI, "protocol", spec.Protocol, "error", err.Error())
+		return false
+	}
+	if !info.Changed(cached) {
+		return false
+	}
+	s.handlerLogger().Info("image changed at the source", "name", extension.Namespaced(),
+		"uri", spec.URI, "protocol", spec.Protocol, "digest", info.Digest, "etag", info.ETag)
+	return true
+}
+
+// resolveVersion returns the highest tag of the repository, or its registry mirror, which satisfies the version constraint
+func (s *Server) resolveVersion(ctx context.Context, extension *wasmxdsv1alpha1.WasmExtension,
+	spec *wasmxdsv1alpha1.WasmExtensionSpecImage, repository, constraint string) (string, error) {
+	var err error
+	for _, source := range s.imageSources(&wasmxdsv1alpha1.WasmExtensionSpecImage{URI: repository, Protocol: spec.Protocol}, nil) {
+		var tags []string
+		if tags, err = s.listTags(ctx, extension, source); err == nil {
+			return ociregistory.HighestMatchingTag(tags, constraint)
+		}
+	}
+	return "", err
+}
+
+func (s *Server) listTags(ctx context.Context, extension *wasmxdsv1alpha1.WasmExtension,
+	repository *wasmxdsv1alpha1.WasmExtensionSpecImage) ([]string, error) {
+	key, err := repository.ProviderKey()
+	if err != nil {
+		return nil, fetcherr.Invalid(err)
+	}
+	provider, ok := s.imageProviders[key].(imageprovider.OCIImageProvider)
+	if !ok {
+		return nil, fetcherr.Invalid(fmt.Errorf("no provider which can list the tags of %s", repository.URI))
+	}
+
+	ctx, cancel := s.fetchContext(ctx, extension)
+	defer cancel()
+	return provider.ListTags(ctx, repository.URI)
+}
+
+// imageToFetch returns the image pinned to the locked digest if the Lock digest policy applies, otherwise the one in the spec
+func imageToFetch(extension *wasmxdsv1alpha1.WasmExtension) (*wasmxdsv1alpha1.WasmExtensionSpecImage, error) {
+	image, lock := &extension.Spec.Image, extension.Status.LockedImage
+	if image.DigestPolicy != wasmxdsv1alpha1.DigestPolicyLock || lock == nil || lock.URI != image.URI {
+		return image, nil
+	}
+	pinned, err := image.PinDigest(lock.Digest)
+	if err != nil {
+		return nil, fetcherr.Invalid(fmt.Errorf("failed to pin digest %s: %w", lock.Digest, err))
+	}
+	return pinned, nil
+}
+
+// updateLockedImage locks the digest currently served if the Lock digest policy is newly applied,
+// and releases the lock if the policy is no longer Lock or the uri has changed
+func (s *Server) updateLockedImage(extension *wasmxdsv1alpha1.WasmExtension) {
+	image, lock := &extension.Spec.Image, extension.Status.LockedImage
+	if image.DigestPolicy != wasmxdsv1alpha1.DigestPolicyLock {
+		extension.Status.LockedImage = nil
+	} else if (lock == nil || lock.URI != image.URI) && extension.Status.ImageDigest != "" {
+		s.handlerLogger().Info("locking digest", "name", extension.Namespaced(),
+			"uri", image.URI, "digest", extension.Status.ImageDigest)
+		extension.Status.LockedImage = &wasmxdsv1alpha1.WasmExtensionLockedImage{
+			URI: image.URI, Digest: extension.Status.ImageDigest,
+		}
 	}
+}
 
+func (s *Server) updateResource(extension *wasmxdsv1alpha1.WasmExtension, image []byte, pluginConfig, vmConfig string) error {
 	s.handlerLogger().Info("converting extension to TypedConfiguration", "name", extension.Namespaced())
 	tc, err := v1converter.Convert(extension, image, pluginConfig, vmConfig)
 	if err != nil {
-		return res, fmt.Errorf("invalid extension: %w", err)
+		err = fetcherr.Invalid(fmt.Errorf("invalid extension: %w", err))
+		s.recordEvent(extension, v1.EventTypeWarning, reasonValidationFailed, "%s", err)
+		return err
+	}
+
+	previous := s.cache.Version(extension.Namespaced())
+	if err = s.cache.UpdateResource(extension.Namespaced(), tc); err != nil {
+		return fmt.Errorf("failed to update cache: %w", err)
 	}
 
-	s.handlerLogger().Info("reconciliation successfully finished", "name", extension.Namespaced())
-	return res, s.cache.UpdateResource(extension.Namespaced(), tc)
+	version := s.cache.Version(extension.Namespaced())
+	s.handlerLogger().Info("reconciliation successfully finished", "name", extension.Namespaced(), "version", version)
+	if version != previous {
+		s.recordEvent(extension, v1.EventTypeNormal, reasonPublished, "published with version %s", version)
+	}
+	return nil
 }
 
 func (s *Server) Delete(extension *wasmxdsv1alpha1.WasmExtension) {
 	s.handlerLogger().Info("deleting extension", "name", extension.Namespaced())
-	delete(s.imageCache, extension.Spec.Image.URI)
+	specs := []*wasmxdsv1alpha1.WasmExtensionSpecImage{&extension.Spec.Image}
+	if pinned, err := imageToFetch(extension); err == nil {
+		specs = append(specs, pinned)
+	}
+	if repository, _, ok := extension.Spec.Image.VersionRange(); ok && extension.Status.ResolvedTag != "" {
+		specs = append(specs, extension.Spec.Image.WithTag(repository, extension.Status.ResolvedTag))
+	}
+	for _, spec := range specs {
+		for _, source := range s.imageSources(spec, extension.Spec.Image.Sources) {
+			s.evictImage(source.URI)
+		}
+	}
 	_ = s.cache.DeleteResource(extension.Namespaced())
+	s.forgetExtension(extension.Namespaced())
 }
 
-func (s *Server) fetchImage(spec *wasmxdsv1alpha1.WasmExtensionSpecImage) ([]byte, error) {
+// fetchImage fetches the image, and caches it once it passes the sha256 check.
+// The binaries streamed by the providers are hashed while they're read.
+func (s *Server) fetchImage(ctx context.Context, extension *wasmxdsv1alpha1.WasmExtension,
+	spec *wasmxdsv1alpha1.WasmExtensionSpecImage) (*cachedImage, string, error) {
 	key, err := spec.ProviderKey()
 	if err != nil {
-		return nil, err
+		return nil, "", fetcherr.Invalid(err)
 	}
 
 	provider, ok := s.imageProviders[key]
 	if !ok {
-		return nil, fmt.Errorf("the provider image spec not supported: [protocol: %s, uri: %s]",
-			spec.Protocol, spec.URI)
+		return nil, "", fetcherr.Invalid(fmt.Errorf("the provider image spec not supported: [protocol: %s, uri: %s]",
+			spec.Protocol, spec.URI))
 	}
 
-	image, err := provider.Fetch(context.Background(), spec.URI)
+	ctx, cancel := s.fetchContext(ctx, extension)
+	defer cancel()
+	var image []byte
+	var actual string
+	var info *stream.Info
+	var metadata *wasmxdsv1alpha1.ImageMetadata
+	var digest string
+	switch p := provider.(type) {
+	case imageprovider.StreamingImageProvider:
+		var opened *stream.Image
+		if opened, err = p.Open(ctx, spec.URI); err == nil {
+			image, actual, err = readImage(ctx, opened)
+			info, metadata, digest = &opened.Info, opened.Metadata, opened.Digest
+		}
+	case imageprovider.OCIImageProvider:
+		var oci *ociregistory.Image
+		if oci, err = p.FetchImage(ctx, spec.URI); err == nil {
+			image, metadata, digest = oci.Binary, oci.Metadata, oci.Digest
+		}
+	default:
+		image, err = provider.Fetch(ctx, spec.URI)
+	}
 	if err != nil {
-		return nil, fmt.Errorf("error fetching image: %w", err)
+		if ctx.Err() != nil && fetcherr.IsTransient(err) {
+			// the providers may not tell the timeouts from the other failures
+			if !errors.Is(err, ctx.Err()) {
+				err = fmt.Errorf("%v: %w", ctx.Err(), err)
+			}
+			err = fetcherr.Transient(err)
+		}
+		return nil, "", fmt.Errorf("error fetching image: %w", err)
+	}
+	if actual == "" {
+		raw := sha256.Sum256(image)
+		actual = hex.EncodeToString(raw[:])
+	}
+	if err = s.checkSha256(extension, spec, actual); err != nil {
+		return nil, "", err
 	}
 
-	s.imageCache[spec.URI] = image
-	return image, nil
+	cached := &cachedImage{binary: image, metadata: metadata, digest: digest, info: info}
+	s.cacheImage(spec.URI, cached)
+	return cached, actual, nil
+}
+
+// cachedImage is the image cached by uri along with what's known about it
+type cachedImage struct {
+	binary   []byte
+	metadata *wasmxdsv1alpha1.ImageMetadata
+	digest   string
+	// info is nil unless the image was fetched by a streaming provider
+	info *stream.Info
+}
+
+func (s *Server) lookupImage(uri string) (*cachedImage, bool) {
+	s.imagesMu.RLock()
+	defer s.imagesMu.RUnlock()
+	binary, ok := s.imageCache[uri]
+	if !ok {
+		return nil, false
+	}
+	return &cachedImage{
+		binary: binary, metadata: s.imageMetadata[uri], digest: s.imageDigests[uri], info: s.imageInfos[uri],
+	}, true
+}
+
+func (s *Server) cacheImage(uri string, image *cachedImage) {
+	s.imagesMu.Lock()
+	defer s.imagesMu.Unlock()
+	s.imageCache[uri] = image.binary
+	s.imageMetadata[uri] = image.metadata
+	s.imageDigests[uri] = image.digest
+	if image.info != nil {
+		s.imageInfos[uri] = image.info
+	} else {
+		delete(s.imageInfos, uri)
+	}
+}
+
+func (s *Server) evictImage(uri string) {
+	s.imagesMu.Lock()
+	defer s.imagesMu.Unlock()
+	delete(s.imageCache, uri)
+	delete(s.imageMetadata, uri)
+	delete(s.imageDigests, uri)
+	delete(s.imageInfos, uri)
+}
+
+// MaxImageSize caps the binaries read from the sources, so that e.g. a uri of a wrong file doesn't exhaust the memory
+const MaxImageSize = 256 << 20
+
+// readImage reads the streamed binary straight into the buffer which becomes the cache entry, hashing it on the way,
+// and returns it along with its sha256. The buffer is allocated once if the source tells the size.
+// The read is abandoned when the context is done as e.g. stale network mounts may block it.
+func readImage(ctx context.Context, image *stream.Image) ([]byte, string, error) {
+	defer image.Body.Close()
+	if image.Size > MaxImageSize {
+		return nil, "", fetcherr.Invalid(fmt.Errorf("the image of %d bytes exceeds the limit of %d bytes", image.Size, MaxImageSize))
+	}
+
+	type result struct {
+		b []byte
+		// extra is the number of the bytes read beyond the size the source told
+		extra int
+		err   error
+	}
+	hash := sha256.New()
+	// one byte beyond the limit is read to tell the oversized images of unknown sizes
+	body := io.TeeReader(io.LimitReader(image.Body, MaxImageSize+1), hash)
+	done := make(chan result, 1)
+	go func() {
+		var r result
+		if image.Size < 0 {
+			r.b, r.err = ioutil.ReadAll(body)
+			done <- r
+			return
+		}
+		r.b = make([]byte, image.Size)
+		n, err := io.ReadFull(body, r.b)
+		r.b = r.b[:n]
+		if err == nil {
+			var extra [1]byte
+			r.extra, err = io.ReadFull(body, extra[:])
+		}
+		if err != io.EOF && err != io.ErrUnexpectedEOF {
+			r.err = err
+		}
+		done <- r
+	}()
+
+	var r result
+	select {
+	case <-ctx.Done():
+		return nil, "", fmt.Errorf("abandoned reading image: %w", ctx.Err())
+	case r = <-done:
+	}
+	if r.err != nil {
+		return nil, "", fetcherr.Transient(fmt.Errorf("error reading image: %w", r.err))
+	}
+	if len(r.b) > MaxImageSize {
+		return nil, "", fetcherr.Invalid(fmt.Errorf("the image exceeds the limit of %d bytes", MaxImageSize))
+	}
+	if image.Size >= 0 && (int64(len(r.b)) != image.Size || r.extra > 0) {
+		return nil, "", fetcherr.Transient(fmt.Errorf("read %d bytes while the size of the image is %d",
+			len(r.b)+r.extra, image.Size))
+	}
+	return r.b, hex.EncodeToString(hash.Sum(nil)), nil
 }
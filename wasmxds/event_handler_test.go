@@ -1,16 +1,25 @@
 package wasmxds
 
 import (
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io/ioutil"
+	"sync"
 	"testing"
+	"time"
 
-	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 
 	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
 	"github.com/tetratelabs/wasmxds/imageprovider"
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+	"github.com/tetratelabs/wasmxds/imageprovider/ociregistory"
+	"github.com/tetratelabs/wasmxds/imageprovider/stream"
 )
 
 func strPtr(s string) *string {
@@ -19,32 +28,82 @@ func strPtr(s string) *string {
 
 func TestServer_Update(t *testing.T) {
 	s := Server{
-		imageCache: map[string][]byte{"url": {1, 2, 3}},
-		cache:      cache.NewLinearCache(apiType),
-		logger:     zap.New(),
+		imageCache:    map[string][]byte{"url": {1, 2, 3}},
+		imageMetadata: map[string]*wasmxdsv1alpha1.ImageMetadata{},
+		cache:         newContentCache(apiType),
+		logger:        zap.New(),
 	}
 
 	ext := &wasmxdsv1alpha1.WasmExtension{}
 	ext.Spec.Image = wasmxdsv1alpha1.WasmExtensionSpecImage{
 		URI: "url", Sha256: strPtr("039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81"),
 	}
-	_, err := s.Update(ext, "", "")
+	ext.Spec.VMID, ext.Spec.RootID = "vm", "root"
+	_, err := s.Update(context.Background(), ext, "", "")
 	assert.NoError(t, err)
+	assert.Equal(t, "039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81", ext.Status.Sha256)
+	assert.Equal(t, &wasmxdsv1alpha1.WasmExtensionEffectiveValues{
+		VMID: "vm", RootID: "root", Runtime: wasmxdsv1alpha1.RuntimeV8,
+	}, ext.Status.Effective)
 
 	ext.Spec.Image.Sha256 = strPtr("not match")
-	_, err = s.Update(ext, "", "")
+	_, err = s.Update(context.Background(), ext, "", "")
 	assert.Error(t, err)
 
 	ext.Spec.Image.Sha256 = nil
-	_, err = s.Update(ext, "", "")
+	_, err = s.Update(context.Background(), ext, "", "")
 	assert.NoError(t, err)
+
+	t.Run("image verifier", func(t *testing.T) {
+		s.SetImageVerifier(func(_ context.Context, _ *wasmxdsv1alpha1.WasmExtension, image []byte) error {
+			if len(image) > 2 {
+				return errors.New("too large")
+			}
+			return nil
+		})
+		defer s.SetImageVerifier(nil)
+
+		_, err := s.Update(context.Background(), ext, "", "")
+		assert.Error(t, err)
+		assert.Contains(t, err.Error(), "too large")
+	})
+
+	t.Run("image metadata", func(t *testing.T) {
+		s.imageMetadata["url"] = &wasmxdsv1alpha1.ImageMetadata{
+			VMID: "image-vm", RootID: "image-root", Runtime: wasmxdsv1alpha1.RuntimeWasmtime, PluginConfiguration: `{"a":1,"b":2}`,
+		}
+		defer delete(s.imageMetadata, "url")
+
+		ext := ext.DeepCopy()
+		ext.Spec.VMID, ext.Spec.RootID = "", "root"
+		ext.Spec.PluginConfiguration = &wasmxdsv1alpha1.WasmExtensionConfigValue{Value: strPtr(`{"b":3}`)}
+		_, err := s.Update(context.Background(), ext, `{"b":3}`, "")
+		assert.NoError(t, err)
+		assert.Equal(t, &wasmxdsv1alpha1.WasmExtensionEffectiveValues{
+			VMID: "image-vm", RootID: "root", Runtime: wasmxdsv1alpha1.RuntimeWasmtime,
+			PluginConfigurationSource: wasmxdsv1alpha1.PluginConfigurationSourceMerged,
+		}, ext.Status.Effective)
+		// the spec itself is left as is
+		assert.Empty(t, ext.Spec.VMID)
+	})
+
+	t.Run("null runtime", func(t *testing.T) {
+		ext := &wasmxdsv1alpha1.WasmExtension{}
+		ext.Spec.Runtime = wasmxdsv1alpha1.RuntimeNull
+		ext.Spec.BuiltinPlugin = "envoy.wasm.stats"
+		ext.Status.Sha256 = "stale"
+		_, err := s.Update(context.Background(), ext, "", "")
+		assert.NoError(t, err)
+		assert.Empty(t, ext.Status.Sha256)
+		assert.Equal(t, wasmxdsv1alpha1.RuntimeNull, ext.Status.Effective.Runtime)
+	})
 }
 
 func TestServer_Delete(t *testing.T) {
 	key := "cached"
 	s := Server{
 		imageCache: map[string][]byte{key: {}},
-		cache:      cache.NewLinearCache(apiType),
+		cache:      newContentCache(apiType),
 		logger:     zap.New(),
 	}
 
@@ -59,6 +118,8 @@ func TestServer_Delete(t *testing.T) {
 type fakeProvider struct {
 	binaries    map[string][]byte
 	providerKey string
+	// err is returned instead of not found if set
+	err error
 }
 
 var ErrFakeNotFound = errors.New("not found by fake")
@@ -68,13 +129,39 @@ func (f *fakeProvider) Fetch(_ context.Context, uri string) ([]byte, error) {
 	if ok {
 		return b, nil
 	}
-	return nil, ErrFakeNotFound
+	if f.err != nil {
+		return nil, f.err
+	}
+	return nil, fetcherr.NotFound(ErrFakeNotFound)
 }
 
 func (f *fakeProvider) ProviderKey() string {
 	return f.providerKey
 }
 
+type fakeOCIProvider struct {
+	fakeProvider
+	digests  map[string]string
+	metadata *wasmxdsv1alpha1.ImageMetadata
+	tags     map[string][]string
+}
+
+func (f *fakeOCIProvider) FetchImage(ctx context.Context, uri string) (*ociregistory.Image, error) {
+	b, err := f.Fetch(ctx, uri)
+	if err != nil {
+		return nil, err
+	}
+	return &ociregistory.Image{Binary: b, Digest: f.digests[uri], Metadata: f.metadata}, nil
+}
+
+func (f *fakeOCIProvider) ListTags(_ context.Context, repository string) ([]string, error) {
+	tags, ok := f.tags[repository]
+	if !ok {
+		return nil, ErrFakeNotFound
+	}
+	return tags, nil
+}
+
 func TestServer_fetchImage(t *testing.T) {
 	foundURI := "webassemblyhub.com/tetrate.io/sample-filter:v2"
 	providers := []imageprovider.WasmImageProvider{
@@ -84,7 +171,13 @@ func TestServer_fetchImage(t *testing.T) {
 		}, providerKey: "oci||webassemblyhub.com"},
 	}
 
-	s := Server{imageCache: map[string][]byte{}, imageProviders: map[string]imageprovider.WasmImageProvider{}}
+	s := Server{
+		imageCache:     map[string][]byte{},
+		imageMetadata:  map[string]*wasmxdsv1alpha1.ImageMetadata{},
+		imageDigests:   map[string]string{},
+		logger:         zap.New(),
+		imageProviders: map[string]imageprovider.WasmImageProvider{},
+	}
 	for _, p := range providers {
 		s.imageProviders[p.ProviderKey()] = p
 	}
@@ -93,7 +186,7 @@ func TestServer_fetchImage(t *testing.T) {
 		{uri: "aaa.wasm", protocol: "unsupported_protocol"},
 		{uri: "nonexist.com/tetrate.io/sample-filter:v1", protocol: "oci"}, // provider not registered
 	} {
-		_, err := s.fetchImage(&wasmxdsv1alpha1.WasmExtensionSpecImage{
+		_, _, err := s.fetchImage(context.Background(), &wasmxdsv1alpha1.WasmExtension{}, &wasmxdsv1alpha1.WasmExtensionSpecImage{
 			URI: c.uri, Protocol: c.protocol,
 		})
 		assert.Error(t, err)
@@ -104,16 +197,503 @@ func TestServer_fetchImage(t *testing.T) {
 		{uri: "aaa.wasm", protocol: "local_fs"},
 		{uri: "webassemblyhub.com/tetrate.io/sample-filter:v1", protocol: "oci"},
 	} {
-		_, err := s.fetchImage(&wasmxdsv1alpha1.WasmExtensionSpecImage{
+		_, _, err := s.fetchImage(context.Background(), &wasmxdsv1alpha1.WasmExtension{}, &wasmxdsv1alpha1.WasmExtensionSpecImage{
 			URI: c.uri, Protocol: c.protocol,
 		})
 		assert.True(t, errors.Is(err, ErrFakeNotFound), err.Error())
 	}
 
-	actual, err := s.fetchImage(&wasmxdsv1alpha1.WasmExtensionSpecImage{
+	actual, _, err := s.fetchImage(context.Background(), &wasmxdsv1alpha1.WasmExtension{}, &wasmxdsv1alpha1.WasmExtensionSpecImage{
 		URI: foundURI, Protocol: "oci",
 	})
 	assert.NoError(t, err)
-	assert.Equal(t, []byte{1, 2, 3}, actual)
+	assert.Equal(t, []byte{1, 2, 3}, actual.binary)
 	assert.Equal(t, []byte{1, 2, 3}, s.imageCache[foundURI])
+
+	metadata := &wasmxdsv1alpha1.ImageMetadata{RootID: "root"}
+	s.imageProviders["oci||example.com"] = &fakeOCIProvider{
+		fakeProvider: fakeProvider{binaries: map[string][]byte{"example.com/filter:v1": {4}}, providerKey: "oci||example.com"},
+		digests:      map[string]string{"example.com/filter:v1": "sha256:1234"},
+		metadata:     metadata,
+	}
+	actual, _, err = s.fetchImage(context.Background(), &wasmxdsv1alpha1.WasmExtension{}, &wasmxdsv1alpha1.WasmExtensionSpecImage{URI: "example.com/filter:v1", Protocol: "oci"})
+	assert.NoError(t, err)
+	assert.Equal(t, []byte{4}, actual.binary)
+	assert.Equal(t, metadata, s.imageMetadata["example.com/filter:v1"])
+	assert.Equal(t, "sha256:1234", s.imageDigests["example.com/filter:v1"])
+}
+
+func TestServer_UpdateDigestPolicy(t *testing.T) {
+	const (
+		tag     = "example.com/filter:v1"
+		digest1 = "sha256:0000000000000000000000000000000000000000000000000000000000000001"
+		digest2 = "sha256:0000000000000000000000000000000000000000000000000000000000000002"
+	)
+	provider := &fakeOCIProvider{
+		fakeProvider: fakeProvider{binaries: map[string][]byte{
+			tag: {1}, "example.com/filter@" + digest1: {1},
+		}, providerKey: "oci||example.com"},
+		digests: map[string]string{tag: digest1, "example.com/filter@" + digest1: digest1},
+	}
+	s := Server{
+		imageCache:     map[string][]byte{},
+		imageMetadata:  map[string]*wasmxdsv1alpha1.ImageMetadata{},
+		imageDigests:   map[string]string{},
+		imageProviders: map[string]imageprovider.WasmImageProvider{provider.ProviderKey(): provider},
+		cache:          newContentCache(apiType),
+		logger:         zap.New(),
+	}
+
+	ext := &wasmxdsv1alpha1.WasmExtension{}
+	ext.Spec.VMID, ext.Spec.RootID = "vm", "root"
+	ext.Spec.Image = wasmxdsv1alpha1.WasmExtensionSpecImage{
+		URI: tag, Protocol: wasmxdsv1alpha1.ProtocolOCIImageRegistry, DigestPolicy: wasmxdsv1alpha1.DigestPolicyLock,
+	}
+	_, err := s.Update(context.Background(), ext, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, digest1, ext.Status.ImageDigest)
+	assert.Equal(t, &wasmxdsv1alpha1.WasmExtensionLockedImage{URI: tag, Digest: digest1}, ext.Status.LockedImage)
+
+	// the tag moves, but the locked digest is still served
+	delete(s.imageCache, tag)
+	provider.binaries[tag], provider.digests[tag] = []byte{2}, digest2
+	_, err = s.Update(context.Background(), ext, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, digest1, ext.Status.ImageDigest)
+	assert.Equal(t, "4bf5122f344554c53bde2ebb8cd2b7e3d1600ad631c385a5d7cce23c7785459a", ext.Status.Sha256)
+
+	// unlocked
+	ext.Spec.Image.DigestPolicy = ""
+	_, err = s.Update(context.Background(), ext, "", "")
+	assert.NoError(t, err)
+	assert.Equal(t, digest2, ext.Status.ImageDigest)
+	assert.Nil(t, ext.Status.LockedImage)
+}
+
+func TestServer_UpdateVersionConstraint(t *testing.T) {
+	const repository = "example.com/filter"
+	provider := &fakeOCIProvider{
+		fakeProvider: fakeProvider{binaries: map[string][]byte{
+			repository + ":1.4.0": {1}, repository + ":1.4.1": {2},
+		}, providerKey: "oci||example.com"},
+		tags: map[string][]string{repository: {"latest", "1.3.0", "1.4.0", "2.0.0"}},
+	}
+	s := Server{
+		imageCache:           map[string][]byte{},
+		imageMetadata:        map[string]*wasmxdsv1alpha1.ImageMetadata{},
+		imageDigests:         map[string]string{},
+		imageProviders:       map[string]imageprovider.WasmImageProvider{provider.ProviderKey(): provider},
+		cache:                newContentCache(apiType),
+		logger:               zap.New(),
+		versionCheckInterval: time.Minute,
+	}
+
+	ext := &wasmxdsv1alpha1.WasmExtension{}
+	ext.Spec.VMID, ext.Spec.RootID = "vm", "root"
+	ext.Spec.Image = wasmxdsv1alpha1.WasmExtensionSpecImage{
+		URI: repository + ":~1.4", Protocol: wasmxdsv1alpha1.ProtocolOCIImageRegistry,
+	}
+	res, err := s.Update(context.Background(), ext, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, time.Minute, res.RequeueAfter)
+	assert.Equal(t, "1.4.0", ext.Status.ResolvedTag)
+
+	// a newer patch release is published
+	provider.tags[repository] = append(provider.tags[repository], "1.4.1")
+	_, err = s.Update(context.Background(), ext, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, "1.4.1", ext.Status.ResolvedTag)
+	assert.Equal(t, "dbc1b4c900ffe48d575b5da5c638040125f65db0fe3e24494b76ea986457d986", ext.Status.Sha256)
+
+	// no tag satisfies the constraint
+	ext.Spec.Image.URI = repository
+	ext.Spec.Image.VersionConstraint = "~3.0"
+	_, err = s.Update(context.Background(), ext, "", "")
+	require.True(t, errors.Is(err, ociregistory.ErrNoMatchingTag))
+	assert.Equal(t, "1.4.1", ext.Status.ResolvedTag)
+
+	ext.Spec.Image.VersionConstraint = "~1.4"
+	s.Delete(ext)
+	assert.Empty(t, s.imageCache[repository+":1.4.1"])
+
+	// the fixed tag
+	ext.Spec.Image = wasmxdsv1alpha1.WasmExtensionSpecImage{
+		URI: repository + ":1.4.0", Protocol: wasmxdsv1alpha1.ProtocolOCIImageRegistry,
+	}
+	res, err = s.Update(context.Background(), ext, "", "")
+	require.NoError(t, err)
+	assert.Zero(t, res.RequeueAfter)
+	assert.Empty(t, ext.Status.ResolvedTag)
+}
+
+func TestServer_UpdateSources(t *testing.T) {
+	const (
+		primary = "example.com/filter:v1"
+		mirror  = "mirror.internal/example.com/filter:v1"
+		sha     = "4bf5122f344554c53bde2ebb8cd2b7e3d1600ad631c385a5d7cce23c7785459a"
+	)
+	oci := &fakeProvider{binaries: map[string][]byte{}, providerKey: "oci||example.com"}
+	mirrorProvider := &fakeProvider{binaries: map[string][]byte{}, providerKey: "oci||mirror.internal"}
+	s3 := &fakeProvider{binaries: map[string][]byte{
+		"bucket-a/filter.wasm": {2}, "bucket-b/filter.wasm": {1},
+	}, providerKey: "s3"}
+	s := Server{
+		imageCache:    map[string][]byte{},
+		imageMetadata: map[string]*wasmxdsv1alpha1.ImageMetadata{},
+		imageDigests:  map[string]string{},
+		imageProviders: map[string]imageprovider.WasmImageProvider{
+			oci.ProviderKey(): oci, mirrorProvider.ProviderKey(): mirrorProvider, s3.ProviderKey(): s3,
+		},
+		cache:  newContentCache(apiType),
+		logger: zap.New(),
+	}
+
+	ext := &wasmxdsv1alpha1.WasmExtension{}
+	ext.Spec.VMID, ext.Spec.RootID = "vm", "root"
+	ext.Spec.Image = wasmxdsv1alpha1.WasmExtensionSpecImage{
+		URI: primary, Protocol: wasmxdsv1alpha1.ProtocolOCIImageRegistry, Sha256: strPtr(sha),
+		Sources: []wasmxdsv1alpha1.WasmExtensionImageSource{
+			// the binary differs from sha256
+			{URI: "bucket-a/filter.wasm", Protocol: wasmxdsv1alpha1.ProtocolS3},
+			{URI: "bucket-b/filter.wasm", Protocol: wasmxdsv1alpha1.ProtocolS3},
+		},
+	}
+
+	t.Run("fallback", func(t *testing.T) {
+		_, err := s.Update(context.Background(), ext, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, sha, ext.Status.Sha256)
+		assert.Equal(t, &wasmxdsv1alpha1.WasmExtensionImageSource{
+			URI: "bucket-b/filter.wasm", Protocol: wasmxdsv1alpha1.ProtocolS3,
+		}, ext.Status.ServedSource)
+	})
+
+	t.Run("mirror", func(t *testing.T) {
+		s.registryMirrors = map[string]string{"example.com": "mirror.internal/example.com"}
+		mirrorProvider.binaries[mirror] = []byte{1}
+		_, err := s.Update(context.Background(), ext, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, &wasmxdsv1alpha1.WasmExtensionImageSource{
+			URI: mirror, Protocol: wasmxdsv1alpha1.ProtocolOCIImageRegistry,
+		}, ext.Status.ServedSource)
+	})
+
+	t.Run("all failed", func(t *testing.T) {
+		s.Delete(ext)
+		assert.Empty(t, s.imageCache)
+		delete(mirrorProvider.binaries, mirror)
+		delete(s3.binaries, "bucket-b/filter.wasm")
+		_, err := s.Update(context.Background(), ext, "", "")
+		require.True(t, errors.Is(err, ErrFakeNotFound))
+		assert.Contains(t, err.Error(), "none of the 4 sources")
+		assert.Equal(t, fetcherr.ErrNotFound, fetcherr.KindOf(err))
+		assert.False(t, fetcherr.IsPermanent(err))
+		// the missing images are retried with the long backoff
+		delay, retry := RetryDelay(ext, 1, err)
+		assert.True(t, retry)
+		assert.Equal(t, time.Minute, delay)
+		delay, _ = RetryDelay(ext, 10, err)
+		assert.Equal(t, 30*time.Minute, delay)
+
+		_, retry = RetryDelay(ext, 1, fetcherr.Invalid(err))
+		assert.False(t, retry)
+	})
+
+	t.Run("transient failure of a source", func(t *testing.T) {
+		s3.err = fetcherr.Transient(errors.New("timeout"))
+		defer func() { s3.err = nil }()
+		_, err := s.Update(context.Background(), ext, "", "")
+		require.Error(t, err)
+		assert.False(t, fetcherr.IsPermanent(err))
+		delay, retry := RetryDelay(ext, 2, err)
+		assert.True(t, retry)
+		assert.Equal(t, 2*time.Second, delay)
+	})
+}
+
+func TestServer_imageSources(t *testing.T) {
+	s := Server{registryMirrors: map[string]string{"webassemblyhub.io": "mirror.internal:5000"}}
+	spec := &wasmxdsv1alpha1.WasmExtensionSpecImage{
+		URI: "webassemblyhub.io/foo/bar:v1", Protocol: wasmxdsv1alpha1.ProtocolOCIImageRegistry,
+		Sources: []wasmxdsv1alpha1.WasmExtensionImageSource{
+			{URI: "ghcr.io/foo/bar:v1", Protocol: wasmxdsv1alpha1.ProtocolOCIImageRegistry},
+			{URI: "webassemblyhub.io/foo/bar.wasm", Protocol: wasmxdsv1alpha1.ProtocolHttps},
+		},
+	}
+	var actual []string
+	for _, source := range s.imageSources(spec, spec.Sources) {
+		actual = append(actual, source.ID())
+	}
+	assert.Equal(t, []string{
+		"oci://mirror.internal:5000/foo/bar:v1",
+		"oci://webassemblyhub.io/foo/bar:v1",
+		"oci://ghcr.io/foo/bar:v1",
+		"https://webassemblyhub.io/foo/bar.wasm",
+	}, actual)
+}
+
+// hungProvider never responds until the context is done
+type hungProvider struct {
+	providerKey string
+}
+
+func (h *hungProvider) Fetch(ctx context.Context, _ string) ([]byte, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (h *hungProvider) ProviderKey() string {
+	return h.providerKey
+}
+
+func TestServer_UpdateFetchTimeout(t *testing.T) {
+	const sha = "4bf5122f344554c53bde2ebb8cd2b7e3d1600ad631c385a5d7cce23c7785459a"
+	hung := &hungProvider{providerKey: "oci||hung.example.com"}
+	s3 := &fakeProvider{binaries: map[string][]byte{"bucket/filter.wasm": {1}}, providerKey: "s3"}
+	newServer := func(fetchTimeout time.Duration) *Server {
+		return &Server{
+			imageCache:     map[string][]byte{},
+			imageMetadata:  map[string]*wasmxdsv1alpha1.ImageMetadata{},
+			imageDigests:   map[string]string{},
+			imageProviders: map[string]imageprovider.WasmImageProvider{hung.ProviderKey(): hung, s3.ProviderKey(): s3},
+			cache:          newContentCache(apiType),
+			logger:         zap.New(),
+			fetchTimeout:   fetchTimeout,
+		}
+	}
+	newExtension := func() *wasmxdsv1alpha1.WasmExtension {
+		ext := &wasmxdsv1alpha1.WasmExtension{}
+		ext.Spec.VMID, ext.Spec.RootID = "vm", "root"
+		ext.Spec.Image = wasmxdsv1alpha1.WasmExtensionSpecImage{
+			URI: "hung.example.com/filter:v1", Protocol: wasmxdsv1alpha1.ProtocolOCIImageRegistry, Sha256: strPtr(sha),
+			Sources: []wasmxdsv1alpha1.WasmExtensionImageSource{
+				{URI: "bucket/filter.wasm", Protocol: wasmxdsv1alpha1.ProtocolS3},
+			},
+		}
+		return ext
+	}
+
+	t.Run("global", func(t *testing.T) {
+		ext := newExtension()
+		_, err := newServer(50*time.Millisecond).Update(context.Background(), ext, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, "bucket/filter.wasm", ext.Status.ServedSource.URI)
+	})
+
+	t.Run("per extension", func(t *testing.T) {
+		ext := newExtension()
+		ext.Spec.Image.FetchTimeout = &metav1.Duration{Duration: 50 * time.Millisecond}
+		_, err := newServer(time.Hour).Update(context.Background(), ext, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, "bucket/filter.wasm", ext.Status.ServedSource.URI)
+	})
+
+	t.Run("timed out", func(t *testing.T) {
+		ext := newExtension()
+		ext.Spec.Image.Sources = nil
+		_, err := newServer(50*time.Millisecond).Update(context.Background(), ext, "", "")
+		require.True(t, errors.Is(err, context.DeadlineExceeded), err)
+		assert.False(t, fetcherr.IsPermanent(err))
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+		defer cancel()
+		ext := newExtension()
+		// the other sources are not tried once the caller has given up
+		_, err := newServer(time.Hour).Update(ctx, ext, "", "")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "aborted")
+		assert.False(t, fetcherr.IsPermanent(err))
+		assert.Nil(t, ext.Status.ServedSource)
+	})
+}
+
+type fakeStreamingProvider struct {
+	fakeProvider
+	etags map[string]string
+	// size is told instead of the actual one if set
+	size            int64
+	opens, resolves int
+}
+
+func (f *fakeStreamingProvider) Open(_ context.Context, uri string) (*stream.Image, error) {
+	f.opens++
+	info, err := f.info(uri)
+	if err != nil {
+		return nil, err
+	}
+	return &stream.Image{Info: *info, Body: ioutil.NopCloser(bytes.NewReader(f.binaries[uri]))}, nil
+}
+
+func (f *fakeStreamingProvider) Resolve(_ context.Context, uri string) (*stream.Info, error) {
+	f.resolves++
+	return f.info(uri)
+}
+
+func (f *fakeStreamingProvider) info(uri string) (*stream.Info, error) {
+	b, ok := f.binaries[uri]
+	if !ok {
+		return nil, fetcherr.NotFound(ErrFakeNotFound)
+	}
+	size := int64(len(b))
+	if f.size != 0 {
+		size = f.size
+	}
+	return &stream.Info{ETag: f.etags[uri], Size: size}, nil
+}
+
+func TestServer_UpdateStreaming(t *testing.T) {
+	const (
+		uri  = "example.com/filter.wasm"
+		sha1 = "4bf5122f344554c53bde2ebb8cd2b7e3d1600ad631c385a5d7cce23c7785459a"
+		sha2 = "dbc1b4c900ffe48d575b5da5c638040125f65db0fe3e24494b76ea986457d986"
+	)
+	provider := &fakeStreamingProvider{
+		fakeProvider: fakeProvider{binaries: map[string][]byte{uri: {1}}, providerKey: wasmxdsv1alpha1.ProtocolHttps},
+		etags:        map[string]string{uri: `"v1"`},
+	}
+	s := Server{
+		imageCache:     map[string][]byte{},
+		imageMetadata:  map[string]*wasmxdsv1alpha1.ImageMetadata{},
+		imageDigests:   map[string]string{},
+		imageInfos:     map[string]*stream.Info{},
+		imageProviders: map[string]imageprovider.WasmImageProvider{provider.ProviderKey(): provider},
+		cache:          newContentCache(apiType),
+		logger:         zap.New(),
+	}
+
+	ext := &wasmxdsv1alpha1.WasmExtension{}
+	ext.Spec.VMID, ext.Spec.RootID = "vm", "root"
+	ext.Spec.Image = wasmxdsv1alpha1.WasmExtensionSpecImage{URI: uri, Protocol: wasmxdsv1alpha1.ProtocolHttps}
+	_, err := s.Update(context.Background(), ext, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, sha1, ext.Status.Sha256)
+	assert.Equal(t, 1, provider.opens)
+	assert.Equal(t, &stream.Info{ETag: `"v1"`, Size: 1}, s.imageInfos[uri])
+
+	// unchanged at the source
+	_, err = s.Update(context.Background(), ext, "", "")
+	require.NoError(t, err)
+	assert.Equal(t, 1, provider.opens)
+	assert.Equal(t, 1, provider.resolves)
+
+	t.Run("changed", func(t *testing.T) {
+		provider.binaries[uri], provider.etags[uri] = []byte{2}, `"v2"`
+		defer func() { provider.binaries[uri], provider.etags[uri] = []byte{1}, `"v1"` }()
+
+		// the changed image is neither served nor cached if it fails the sha256 check
+		ext.Spec.Image.Sha256 = strPtr(sha1)
+		_, err = s.Update(context.Background(), ext, "", "")
+		require.Error(t, err)
+		assert.True(t, fetcherr.IsPermanent(err))
+		assert.Equal(t, []byte{1}, s.imageCache[uri])
+		assert.Equal(t, `"v1"`, s.imageInfos[uri].ETag)
+
+		ext.Spec.Image.Sha256 = strPtr(sha2)
+		_, err = s.Update(context.Background(), ext, "", "")
+		require.NoError(t, err)
+		assert.Equal(t, sha2, ext.Status.Sha256)
+		assert.Equal(t, []byte{2}, s.imageCache[uri])
+		assert.Equal(t, `"v2"`, s.imageInfos[uri].ETag)
+		ext.Spec.Image.Sha256 = nil
+	})
+
+	t.Run("truncated", func(t *testing.T) {
+		s.Delete(ext)
+		assert.Empty(t, s.imageInfos)
+		provider.size = 2
+		defer func() { provider.size = 0 }()
+		_, err = s.Update(context.Background(), ext, "", "")
+		require.Error(t, err)
+		assert.False(t, fetcherr.IsPermanent(err))
+		assert.Empty(t, s.imageCache)
+	})
+}
+
+type hungBody struct{ closed chan struct{} }
+
+func (b *hungBody) Read([]byte) (int, error) {
+	<-b.closed
+	return 0, errors.New("closed")
+}
+
+func (b *hungBody) Close() error {
+	close(b.closed)
+	return nil
+}
+
+func TestServer_UpdateConcurrently(t *testing.T) {
+	binaries := map[string][]byte{}
+	for i := 0; i < 4; i++ {
+		binaries[fmt.Sprintf("example.com/filter%d.wasm", i)] = []byte{byte(i)}
+	}
+	s, err := NewServer(context.Background(), &fakeProvider{binaries: binaries, providerKey: wasmxdsv1alpha1.ProtocolHttps})
+	require.NoError(t, err)
+
+	// the Kubernetes reconciler, the file source and the admin API update the extensions concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 16; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			ext := &wasmxdsv1alpha1.WasmExtension{}
+			ext.Namespace, ext.Name = "default", fmt.Sprintf("filter%d", i)
+			ext.Spec.VMID, ext.Spec.RootID = "vm", "root"
+			ext.Spec.Image = wasmxdsv1alpha1.WasmExtensionSpecImage{
+				URI: fmt.Sprintf("example.com/filter%d.wasm", i%4), Protocol: wasmxdsv1alpha1.ProtocolHttps,
+			}
+			for j := 0; j < 8; j++ {
+				_, err := s.Update(context.Background(), ext, "", "")
+				assert.NoError(t, err)
+				s.Delete(ext)
+			}
+		}(i)
+	}
+	wg.Wait()
+	assert.Empty(t, s.imageCache)
+}
+
+func TestReadImage(t *testing.T) {
+	b, sha, err := readImage(context.Background(), &stream.Image{
+		Info: stream.Info{Size: -1}, Body: ioutil.NopCloser(bytes.NewReader([]byte{1})),
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []byte{1}, b)
+	assert.Equal(t, "4bf5122f344554c53bde2ebb8cd2b7e3d1600ad631c385a5d7cce23c7785459a", sha)
+
+	for _, c := range []struct {
+		name string
+		size int64
+		err  bool
+	}{
+		{name: "sized", size: 3},
+		{name: "shorter", size: 4, err: true},
+		{name: "longer", size: 2, err: true},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			b, _, err := readImage(context.Background(), &stream.Image{
+				Info: stream.Info{Size: c.size}, Body: ioutil.NopCloser(bytes.NewReader([]byte{1, 2, 3})),
+			})
+			if c.err {
+				require.Error(t, err)
+				assert.True(t, fetcherr.IsTransient(err))
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, []byte{1, 2, 3}, b)
+		})
+	}
+
+	// the oversized images are not read
+	_, _, err = readImage(context.Background(), &stream.Image{
+		Info: stream.Info{Size: MaxImageSize + 1}, Body: &hungBody{closed: make(chan struct{})},
+	})
+	assert.True(t, fetcherr.IsPermanent(err))
+
+	// the blocked read is abandoned
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, _, err = readImage(ctx, &stream.Image{Info: stream.Info{Size: -1}, Body: &hungBody{closed: make(chan struct{})}})
+	assert.True(t, errors.Is(err, context.DeadlineExceeded))
 }
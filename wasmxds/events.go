@@ -0,0 +1,142 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wasmxds
+
+import (
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/golang/protobuf/ptypes"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+// reasons of the events recorded on the extensions
+const (
+	reasonImageFetched     = "ImageFetched"
+	reasonSha256Mismatch   = "Sha256Mismatch"
+	reasonValidationFailed = "ValidationFailed"
+	reasonPublished        = "Published"
+	reasonRejectedByEnvoy  = "RejectedByEnvoy"
+)
+
+// SetEventRecorder sets the recorder of the events on the extensions from the Kubernetes API, i.e. the ones with UIDs.
+// The events which every replica observes alike, such as the fetches, are recorded only while isLeader returns true,
+// whereas the rejections by the Envoys are recorded by the replica which they are connected to.
+func (s *Server) SetEventRecorder(recorder record.EventRecorder, isLeader func() bool) {
+	s.recorder = recorder
+	s.isLeader = isLeader
+}
+
+// recordEvent records the event observed alike by every replica
+func (s *Server) recordEvent(extension *wasmxdsv1alpha1.WasmExtension, eventType, reason, messageFmt string, args ...interface{}) {
+	if s.recorder == nil || extension.UID == "" || (s.isLeader != nil && !s.isLeader()) {
+		return
+	}
+	s.recorder.Eventf(extension, eventType, reason, messageFmt, args...)
+}
+
+// rememberExtension keeps the reference to the extension to record the rejections by Envoy on it
+func (s *Server) rememberExtension(extension *wasmxdsv1alpha1.WasmExtension) {
+	if extension.UID == "" {
+		return
+	}
+	s.refsMu.Lock()
+	defer s.refsMu.Unlock()
+	s.extensionRefs[extension.Namespaced()] = &v1.ObjectReference{
+		APIVersion: wasmxdsv1alpha1.GroupVersion.String(),
+		Kind:       "WasmExtension",
+		Namespace:  extension.Namespace,
+		Name:       extension.Name,
+		UID:        extension.UID,
+	}
+}
+
+func (s *Server) forgetExtension(name string) {
+	s.refsMu.Lock()
+	defer s.refsMu.Unlock()
+	delete(s.extensionRefs, name)
+}
+
+// maxSentResponses caps the responses remembered per stream in case Envoy doesn't acknowledge them
+const maxSentResponses = 16
+
+// sentResponse is the response sent on a stream along with the extensions in it
+type sentResponse struct {
+	nonce string
+	names []string
+}
+
+// OnStreamResponse remembers the extensions in the response, so that its rejection is recorded only on them
+func (s *Server) OnStreamResponse(streamID int64, _ *discovery.DiscoveryRequest, resp *discovery.DiscoveryResponse) {
+	if s.recorder == nil {
+		return
+	}
+	names := make([]string, 0, len(resp.Resources))
+	for _, res := range resp.Resources {
+		var tc core.TypedExtensionConfig
+		if err := ptypes.UnmarshalAny(res, &tc); err == nil {
+			names = append(names, tc.Name)
+		}
+	}
+
+	s.refsMu.Lock()
+	defer s.refsMu.Unlock()
+	sent := append(s.sentResponses[streamID], sentResponse{nonce: resp.Nonce, names: names})
+	if len(sent) > maxSentResponses {
+		sent = sent[len(sent)-maxSentResponses:]
+	}
+	s.sentResponses[streamID] = sent
+}
+
+func (s *Server) OnStreamClosed(streamID int64) {
+	s.refsMu.Lock()
+	defer s.refsMu.Unlock()
+	delete(s.sentResponses, streamID)
+}
+
+// OnStreamRequest records the rejection on the extensions in the response which Envoy reports the error of by the nonce.
+// Nothing is recorded if the response is unknown, e.g. sent by another replica before Envoy reconnected to this one.
+func (s *Server) OnStreamRequest(streamID int64, req *discovery.DiscoveryRequest) error {
+	if s.recorder == nil || req.ResponseNonce == "" {
+		return nil
+	}
+
+	s.refsMu.Lock()
+	defer s.refsMu.Unlock()
+	// Envoy processes the responses in order, so the ones up to the referred one are no longer needed
+	var names []string
+	sent := s.sentResponses[streamID]
+	for i, r := range sent {
+		if r.nonce == req.ResponseNonce {
+			names = r.names
+			s.sentResponses[streamID] = sent[i+1:]
+			break
+		}
+	}
+	if req.ErrorDetail == nil {
+		return nil
+	}
+	s.logger.Info("rejected by Envoy", "node", req.GetNode().GetId(), "version", req.VersionInfo,
+		"nonce", req.ResponseNonce, "resources", names, "error", req.ErrorDetail.Message)
+	for _, name := range names {
+		if ref, ok := s.extensionRefs[name]; ok {
+			s.recorder.Eventf(ref, v1.EventTypeWarning, reasonRejectedByEnvoy, "rejected by Envoy %s: %s",
+				req.GetNode().GetId(), req.ErrorDetail.Message)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,125 @@
+package wasmxds
+
+import (
+	"context"
+	"testing"
+
+	core "github.com/envoyproxy/go-control-plane/envoy/config/core/v3"
+	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	"github.com/tetratelabs/wasmxds/imageprovider"
+)
+
+// events returns the events recorded so far
+func events(r *record.FakeRecorder) []string {
+	var ret []string
+	for {
+		select {
+		case e := <-r.Events:
+			ret = append(ret, e)
+		default:
+			return ret
+		}
+	}
+}
+
+func TestServer_events(t *testing.T) {
+	const uri = "example.com/filter:v1"
+	provider := &fakeOCIProvider{
+		fakeProvider: fakeProvider{binaries: map[string][]byte{uri: {1, 2, 3}}, providerKey: "oci||example.com"},
+		digests:      map[string]string{uri: "sha256:digest"},
+	}
+	s := Server{
+		imageCache:     map[string][]byte{},
+		imageMetadata:  map[string]*wasmxdsv1alpha1.ImageMetadata{},
+		imageDigests:   map[string]string{},
+		imageProviders: map[string]imageprovider.WasmImageProvider{provider.ProviderKey(): provider},
+		extensionRefs:  map[string]*v1.ObjectReference{},
+		sentResponses:  map[int64][]sentResponse{},
+		cache:          newContentCache(apiType),
+		logger:         zap.New(),
+	}
+	recorder := record.NewFakeRecorder(100)
+	leader := true
+	s.SetEventRecorder(recorder, func() bool { return leader })
+
+	ext := &wasmxdsv1alpha1.WasmExtension{}
+	ext.Namespace, ext.Name, ext.UID = "default", "filter", "uid"
+	ext.Spec.VMID, ext.Spec.RootID = "vm", "root"
+	ext.Spec.Image = wasmxdsv1alpha1.WasmExtensionSpecImage{URI: uri, Protocol: wasmxdsv1alpha1.ProtocolOCIImageRegistry}
+	_, err := s.Update(context.Background(), ext, "", "")
+	require.NoError(t, err)
+	version := s.cache.Version("default/filter")
+	assert.Equal(t, []string{
+		"Normal ImageFetched fetched image example.com/filter:v1 with digest sha256:digest " +
+			"(sha256 039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81)",
+		"Normal Published published with version " + version,
+	}, events(recorder))
+
+	// nothing is recorded if nothing has changed
+	_, err = s.Update(context.Background(), ext, "", "")
+	require.NoError(t, err)
+	assert.Empty(t, events(recorder))
+
+	ext.Spec.Image.Sha256 = strPtr("mismatch")
+	_, err = s.Update(context.Background(), ext, "", "")
+	require.Error(t, err)
+	assert.Equal(t, []string{"Warning Sha256Mismatch the sha256 of image example.com/filter:v1 is " +
+		"039058c6f2c0cb492c533b0a4d14ef77cc0f78abccced5287d84a1a2011cfb81 while spec.image.sha256 is mismatch"}, events(recorder))
+	ext.Spec.Image.Sha256 = nil
+
+	// the other replicas observe the same
+	leader = false
+	ext.Spec.RootID = "other"
+	_, err = s.Update(context.Background(), ext, "", "")
+	require.NoError(t, err)
+	assert.Empty(t, events(recorder))
+
+	// but only the replica which Envoy is connected to observes the rejection
+	respond := func(nonce string, names ...string) {
+		resp := &discovery.DiscoveryResponse{Nonce: nonce}
+		for _, name := range names {
+			res, err := ptypes.MarshalAny(&core.TypedExtensionConfig{Name: name})
+			require.NoError(t, err)
+			resp.Resources = append(resp.Resources, res)
+		}
+		s.OnStreamResponse(1, &discovery.DiscoveryRequest{}, resp)
+	}
+	s.extensionRefs["default/other"] = &v1.ObjectReference{Namespace: "default", Name: "other", UID: "other"}
+	respond("1", "default/filter", "default/unknown")
+	respond("2", "default/other")
+	require.NoError(t, s.OnStreamRequest(1, &discovery.DiscoveryRequest{
+		Node: &core.Node{Id: "envoy"}, ResponseNonce: "1", ErrorDetail: &status.Status{Message: "invalid wasm"},
+	}))
+	require.NoError(t, s.OnStreamRequest(1, &discovery.DiscoveryRequest{ResponseNonce: "2"}))
+	assert.Equal(t, []string{"Warning RejectedByEnvoy rejected by Envoy envoy: invalid wasm"}, events(recorder))
+	assert.Empty(t, s.sentResponses[1])
+
+	// the wildcard request rejecting the unknown response tells nothing about the extensions
+	require.NoError(t, s.OnStreamRequest(1, &discovery.DiscoveryRequest{
+		ResponseNonce: "3", ErrorDetail: &status.Status{Message: "invalid wasm"},
+	}))
+	assert.Empty(t, events(recorder))
+	s.OnStreamClosed(1)
+	assert.NotContains(t, s.sentResponses, int64(1))
+
+	// the extensions not from the Kubernetes API have nothing to record on
+	leader = true
+	s.Delete(ext)
+	ext.UID = ""
+	_, err = s.Update(context.Background(), ext, "", "")
+	require.NoError(t, err)
+	respond("4", "default/filter")
+	require.NoError(t, s.OnStreamRequest(1, &discovery.DiscoveryRequest{
+		ResponseNonce: "4", ErrorDetail: &status.Status{Message: "invalid wasm"},
+	}))
+	assert.Empty(t, events(recorder))
+}
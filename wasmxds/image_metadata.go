@@ -0,0 +1,132 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wasmxds
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+// applyImageMetadata returns the copy of the extension with the defaults in the image metadata applied,
+// the plugin configuration merged into the base one in the metadata, and the effective values to be recorded in status
+func applyImageMetadata(extension *wasmxdsv1alpha1.WasmExtension, metadata *wasmxdsv1alpha1.ImageMetadata,
+	pluginConfig string) (*wasmxdsv1alpha1.WasmExtension, string, *wasmxdsv1alpha1.WasmExtensionEffectiveValues, error) {
+	ret := extension.DeepCopy()
+	ret.Spec.ApplyImageMetadata(metadata)
+	if ret.Spec.Runtime == "" {
+		ret.Spec.Runtime = wasmxdsv1alpha1.RuntimeV8
+	} else if ret.Spec.Runtime == wasmxdsv1alpha1.RuntimeNull || !contains(wasmxdsv1alpha1.SupportedRuntimes, ret.Spec.Runtime) {
+		// the null runtime in the metadata makes no sense as the image is ignored
+		return nil, "", nil, fmt.Errorf("unsupported runtime in the image metadata: %s", ret.Spec.Runtime)
+	}
+	if ret.Spec.VMID == "" {
+		return nil, "", nil, fmt.Errorf("vm_id is set neither in the spec nor in the image metadata")
+	}
+	if ret.Spec.RootID == "" {
+		return nil, "", nil, fmt.Errorf("root_id is set neither in the spec nor in the image metadata")
+	}
+
+	var source string
+	switch {
+	case metadata == nil || metadata.PluginConfiguration == "":
+		if extension.Spec.PluginConfiguration != nil {
+			source = wasmxdsv1alpha1.PluginConfigurationSourceSpec
+		}
+	case extension.Spec.PluginConfiguration == nil:
+		pluginConfig = metadata.PluginConfiguration
+		source = wasmxdsv1alpha1.PluginConfigurationSourceImage
+	default:
+		if merged, ok := mergeJSONObjects(metadata.PluginConfiguration, pluginConfig); ok {
+			pluginConfig = merged
+			source = wasmxdsv1alpha1.PluginConfigurationSourceMerged
+		} else {
+			// the configuration in the spec replaces the base one if either is not a JSON object
+			source = wasmxdsv1alpha1.PluginConfigurationSourceSpec
+		}
+	}
+
+	return ret, pluginConfig, &wasmxdsv1alpha1.WasmExtensionEffectiveValues{
+		VMID:                      ret.Spec.VMID,
+		RootID:                    ret.Spec.RootID,
+		Runtime:                   ret.Spec.Runtime,
+		PluginConfigurationSource: source,
+	}, nil
+}
+
+// ApplyImageMetadata returns the copy of the extension with the image metadata applied and the plugin configuration
+// merged in the same way as the server does, for the tools resolving the extensions without the server such as bundles
+func ApplyImageMetadata(extension *wasmxdsv1alpha1.WasmExtension, metadata *wasmxdsv1alpha1.ImageMetadata,
+	pluginConfig string) (*wasmxdsv1alpha1.WasmExtension, string, error) {
+	ret, pluginConfig, _, err := applyImageMetadata(extension, metadata, pluginConfig)
+	return ret, pluginConfig, err
+}
+
+// mergeJSONObjects merges the overrides into the base recursively, and returns false if either is not a JSON object
+func mergeJSONObjects(base, overrides string) (string, bool) {
+	b, ok := decodeJSONObject(base)
+	if !ok {
+		return "", false
+	}
+	o, ok := decodeJSONObject(overrides)
+	if !ok {
+		return "", false
+	}
+	merged, err := json.Marshal(mergeMaps(b, o))
+	if err != nil {
+		return "", false
+	}
+	return string(merged), true
+}
+
+// decodeJSONObject decodes the numbers as json.Number, which keeps e.g. the integers beyond 2^53 intact
+func decodeJSONObject(raw string) (map[string]interface{}, bool) {
+	var ret map[string]interface{}
+	d := json.NewDecoder(strings.NewReader(raw))
+	d.UseNumber()
+	if d.Decode(&ret) != nil || ret == nil {
+		return nil, false
+	}
+	// nothing but whitespace may follow the object, as json.Unmarshal requires
+	if _, err := d.Token(); err != io.EOF {
+		return nil, false
+	}
+	return ret, true
+}
+
+func mergeMaps(base, overrides map[string]interface{}) map[string]interface{} {
+	for k, v := range overrides {
+		bv, ok := base[k].(map[string]interface{})
+		ov, isMap := v.(map[string]interface{})
+		if ok && isMap {
+			base[k] = mergeMaps(bv, ov)
+		} else {
+			base[k] = v
+		}
+	}
+	return base
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,109 @@
+package wasmxds
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+)
+
+func TestApplyImageMetadata(t *testing.T) {
+	newExt := func(vmID, rootID, runtime string, pluginConfig *string) *wasmxdsv1alpha1.WasmExtension {
+		ext := &wasmxdsv1alpha1.WasmExtension{}
+		ext.Spec.VMID, ext.Spec.RootID, ext.Spec.Runtime = vmID, rootID, runtime
+		if pluginConfig != nil {
+			ext.Spec.PluginConfiguration = &wasmxdsv1alpha1.WasmExtensionConfigValue{Value: pluginConfig}
+		}
+		return ext
+	}
+	metadata := &wasmxdsv1alpha1.ImageMetadata{
+		VMID: "image-vm", RootID: "image-root", Runtime: "WAVM", PluginConfiguration: `{"a":{"b":1,"c":2},"d":3}`,
+	}
+
+	for _, c := range []struct {
+		name         string
+		ext          *wasmxdsv1alpha1.WasmExtension
+		metadata     *wasmxdsv1alpha1.ImageMetadata
+		pluginConfig string
+		expConfig    string
+		exp          wasmxdsv1alpha1.WasmExtensionEffectiveValues
+	}{
+		{
+			name: "no metadata",
+			ext:  newExt("vm", "root", "", nil),
+			exp:  wasmxdsv1alpha1.WasmExtensionEffectiveValues{VMID: "vm", RootID: "root", Runtime: wasmxdsv1alpha1.RuntimeV8},
+		},
+		{
+			name:      "defaults",
+			ext:       newExt("", "", "", nil),
+			metadata:  metadata,
+			expConfig: metadata.PluginConfiguration,
+			exp: wasmxdsv1alpha1.WasmExtensionEffectiveValues{
+				VMID: "image-vm", RootID: "image-root", Runtime: wasmxdsv1alpha1.RuntimeWAVM,
+				PluginConfigurationSource: wasmxdsv1alpha1.PluginConfigurationSourceImage,
+			},
+		},
+		{
+			name:         "explicit values take precedence",
+			ext:          newExt("vm", "root", wasmxdsv1alpha1.RuntimeV8, strPtr("")),
+			metadata:     metadata,
+			pluginConfig: `{"a":{"b":10},"e":4}`,
+			expConfig:    `{"a":{"b":10,"c":2},"d":3,"e":4}`,
+			exp: wasmxdsv1alpha1.WasmExtensionEffectiveValues{
+				VMID: "vm", RootID: "root", Runtime: wasmxdsv1alpha1.RuntimeV8,
+				PluginConfigurationSource: wasmxdsv1alpha1.PluginConfigurationSourceMerged,
+			},
+		},
+		{
+			name:         "non-object configuration replaces base",
+			ext:          newExt("vm", "root", "", strPtr("")),
+			metadata:     metadata,
+			pluginConfig: "plain",
+			expConfig:    "plain",
+			exp: wasmxdsv1alpha1.WasmExtensionEffectiveValues{
+				VMID: "vm", RootID: "root", Runtime: wasmxdsv1alpha1.RuntimeWAVM,
+				PluginConfigurationSource: wasmxdsv1alpha1.PluginConfigurationSourceSpec,
+			},
+		},
+	} {
+		t.Run(c.name, func(t *testing.T) {
+			effective, pc, values, err := applyImageMetadata(c.ext, c.metadata, c.pluginConfig)
+			require.NoError(t, err)
+			assert.Equal(t, c.expConfig, pc)
+			assert.Equal(t, &c.exp, values)
+			assert.Equal(t, c.exp.VMID, effective.Spec.VMID)
+			assert.Equal(t, c.exp.RootID, effective.Spec.RootID)
+			assert.Equal(t, c.exp.Runtime, effective.Spec.Runtime)
+		})
+	}
+
+	_, _, _, err := applyImageMetadata(newExt("", "root", "", nil), nil, "")
+	assert.Error(t, err)
+	_, _, _, err = applyImageMetadata(newExt("vm", "", "", nil), &wasmxdsv1alpha1.ImageMetadata{VMID: "vm"}, "")
+	assert.Error(t, err)
+	_, _, _, err = applyImageMetadata(newExt("vm", "root", "", nil), &wasmxdsv1alpha1.ImageMetadata{Runtime: "null"}, "")
+	assert.Error(t, err)
+}
+
+func TestMergeJSONObjects(t *testing.T) {
+	merged, ok := mergeJSONObjects(`{"id":9007199254740993,"ratio":0.5,"nested":{"big":18446744073709551615}}`,
+		`{"nested":{"small":1}}`)
+	require.True(t, ok)
+	assert.JSONEq(t, `{"id":9007199254740993,"ratio":0.5,"nested":{"big":18446744073709551615,"small":1}}`, merged)
+	assert.Contains(t, merged, "9007199254740993")
+	assert.Contains(t, merged, "18446744073709551615")
+
+	for _, c := range [][2]string{
+		{`{"a":1}`, `plain`},
+		{`[1]`, `{"a":1}`},
+		{`null`, `{"a":1}`},
+		{`{"a":1} {"b":2}`, `{"a":1}`},
+	} {
+		_, ok := mergeJSONObjects(c[0], c[1])
+		assert.False(t, ok, c)
+	}
+	_, ok = mergeJSONObjects("{\"a\":1}\n", `{"b":2}`)
+	assert.True(t, ok)
+}
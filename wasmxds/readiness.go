@@ -0,0 +1,164 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wasmxds
+
+import (
+	"sync"
+	"time"
+)
+
+// DefaultReadinessTimeout is how long the responses are held back at most by default
+// until the initial reconciliation completes
+const DefaultReadinessTimeout = 2 * time.Minute
+
+// ReadinessTracker is told by the source of the extensions which extensions exist at startup,
+// and which of them have been attempted to be updated or deleted, whether or not it has succeeded
+type ReadinessTracker interface {
+	// ExpectExtensions sets the namespaced names of the extensions existing at startup
+	ExpectExtensions(names []string)
+	// Attempted records that the extension of the namespaced name has been reconciled once
+	Attempted(name string)
+}
+
+var _ ReadinessTracker = &Server{}
+
+// readiness becomes ready once every source has told the expected extensions and all of them have been attempted
+type readiness struct {
+	mu sync.Mutex
+	// sources is the number of the sources yet to tell the expected extensions
+	sources int
+	// attempted is the extensions attempted before all the expected ones are known
+	attempted map[string]bool
+	pending   map[string]bool
+	ready     chan struct{}
+}
+
+func newReadiness() *readiness {
+	return &readiness{sources: 1, attempted: map[string]bool{}, pending: map[string]bool{}, ready: make(chan struct{})}
+}
+
+// addSource makes the readiness wait for one more source
+func (r *readiness) addSource() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.sources++
+}
+
+// expect adds the pending extensions except the ones already attempted, and returns the number of the pending ones.
+// The calls beyond the number of the sources are ignored.
+func (r *readiness) expect(names []string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.sources == 0 {
+		return len(r.pending)
+	}
+	r.sources--
+	for _, name := range names {
+		if !r.attempted[name] {
+			r.pending[name] = true
+		}
+	}
+	if r.sources == 0 {
+		r.attempted = nil
+	}
+	r.markReady()
+	return len(r.pending)
+}
+
+func (r *readiness) attempt(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.pending[name] {
+		delete(r.pending, name)
+		r.markReady()
+	} else if r.attempted != nil {
+		r.attempted[name] = true
+	}
+}
+
+// markReady closes the ready channel if every source has told and nothing is pending. Must be called with the lock held.
+func (r *readiness) markReady() {
+	if r.sources == 0 && len(r.pending) == 0 && !r.isReady() {
+		close(r.ready)
+	}
+}
+
+func (r *readiness) isReady() bool {
+	select {
+	case <-r.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// giveUp marks ready regardless of the pending extensions, and returns the number of them
+func (r *readiness) giveUp() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	pending := len(r.pending)
+	r.sources = 0
+	r.pending = map[string]bool{}
+	r.attempted = nil
+	r.markReady()
+	return pending
+}
+
+// HoldUntilReady makes the server hold back the xDS responses until every extension passed to ExpectExtensions
+// has been attempted, so that Envoys connecting right after startup don't warm without their filters.
+// The responses are released after the timeout even if some are still pending. Zero waits indefinitely.
+// This must be called before the server starts serving.
+func (s *Server) HoldUntilReady(timeout time.Duration) {
+	s.cache.hold()
+	go func() {
+		var expired <-chan time.Time
+		if timeout > 0 {
+			timer := time.NewTimer(timeout)
+			defer timer.Stop()
+			expired = timer.C
+		}
+		select {
+		case <-s.readiness.ready:
+			s.logger.Info("initial reconciliation completed, releasing responses")
+		case <-expired:
+			s.logger.Info("readiness timeout expired, releasing responses", "timeout", timeout,
+				"pending", s.readiness.giveUp())
+		}
+		s.cache.release()
+	}()
+}
+
+// Ready returns the channel closed when every extension existing at startup has been attempted,
+// or when the timeout of HoldUntilReady has expired
+func (s *Server) Ready() <-chan struct{} {
+	return s.readiness.ready
+}
+
+// AddReadinessSource makes the server wait for one more source of the extensions, such as the admin API,
+// to call ExpectExtensions in addition to the Kubernetes or file source. This must be called before any of them calls it.
+func (s *Server) AddReadinessSource() {
+	s.readiness.addSource()
+}
+
+// ExpectExtensions implements ReadinessTracker. Only the first call of each source takes effect.
+func (s *Server) ExpectExtensions(names []string) {
+	pending := s.readiness.expect(names)
+	s.logger.Info("initial extensions known", "extensions", len(names), "pending", pending)
+}
+
+// Attempted implements ReadinessTracker
+func (s *Server) Attempted(name string) {
+	s.readiness.attempt(name)
+}
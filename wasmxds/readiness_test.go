@@ -0,0 +1,81 @@
+package wasmxds
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+)
+
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestReadiness(t *testing.T) {
+	r := newReadiness()
+	// attempted before the cache has synced
+	r.attempt("default/a")
+	assert.False(t, isClosed(r.ready))
+
+	assert.Equal(t, 2, r.expect([]string{"default/a", "default/b", "default/c"}))
+	r.attempt("default/b")
+	r.attempt("default/unknown")
+	assert.False(t, isClosed(r.ready))
+	// only the first call takes effect
+	assert.Equal(t, 1, r.expect(nil))
+
+	r.attempt("default/c")
+	assert.True(t, isClosed(r.ready))
+	r.attempt("default/c")
+
+	r = newReadiness()
+	assert.Equal(t, 0, r.expect(nil))
+	assert.True(t, isClosed(r.ready))
+
+	r = newReadiness()
+	r.expect([]string{"default/a"})
+	assert.Equal(t, 1, r.giveUp())
+	assert.True(t, isClosed(r.ready))
+
+	// ready once every source has told the expected extensions
+	r = newReadiness()
+	r.addSource()
+	r.attempt("admin/default/a")
+	assert.Equal(t, 1, r.expect([]string{"default/b"}))
+	r.attempt("default/b")
+	assert.False(t, isClosed(r.ready))
+	assert.Equal(t, 1, r.expect([]string{"admin/default/a", "admin/default/c"}))
+	assert.False(t, isClosed(r.ready))
+	r.attempt("admin/default/c")
+	assert.True(t, isClosed(r.ready))
+}
+
+func TestServer_HoldUntilReady(t *testing.T) {
+	s := &Server{cache: newContentCache(apiType), readiness: newReadiness(), logger: zap.New()}
+	s.HoldUntilReady(0)
+	s.ExpectExtensions([]string{"default/a"})
+	s.Attempted("default/a")
+	<-s.Ready()
+	assert.Eventually(t, func() bool {
+		s.cache.mu.Lock()
+		defer s.cache.mu.Unlock()
+		return !s.cache.held
+	}, time.Second, 10*time.Millisecond)
+
+	// released on the timeout even if some are pending
+	s = &Server{cache: newContentCache(apiType), readiness: newReadiness(), logger: zap.New()}
+	s.HoldUntilReady(10 * time.Millisecond)
+	s.ExpectExtensions([]string{"default/a"})
+	<-s.Ready()
+	assert.Eventually(t, func() bool {
+		s.cache.mu.Lock()
+		defer s.cache.mu.Unlock()
+		return !s.cache.held
+	}, time.Second, 10*time.Millisecond)
+}
@@ -21,21 +21,30 @@ package wasmxds
 import (
 	"context"
 	"errors"
+	"sort"
+	"sync"
+	"time"
 
 	discovery "github.com/envoyproxy/go-control-plane/envoy/service/discovery/v3"
 	extensionservice "github.com/envoyproxy/go-control-plane/envoy/service/extension/v3"
-	"github.com/envoyproxy/go-control-plane/pkg/cache/v3"
 	"github.com/envoyproxy/go-control-plane/pkg/server/v3"
 	"github.com/go-logr/logr"
 	"google.golang.org/grpc/codes"
 	"google.golang.org/grpc/status"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/client-go/tools/record"
 	ctrl "sigs.k8s.io/controller-runtime"
 
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
 	"github.com/tetratelabs/wasmxds/imageprovider"
+	"github.com/tetratelabs/wasmxds/imageprovider/stream"
 )
 
 const (
 	apiType = "type.googleapis.com/envoy.config.core.v3.TypedExtensionConfig"
+
+	// DefaultVersionCheckInterval is how often the version constraints are resolved again by default
+	DefaultVersionCheckInterval = 5 * time.Minute
 )
 
 type Server struct {
@@ -43,11 +52,37 @@ type Server struct {
 	server.CallbackFuncs
 	logger logr.Logger
 
-	cache          *cache.LinearCache
+	cache          *contentCache
+	readiness      *readiness
 	imageProviders map[string]imageprovider.WasmImageProvider
-	imageCache     map[string][]byte
+	// imagesMu guards the images cached by uri, as the extensions of every source are updated concurrently
+	imagesMu      sync.RWMutex
+	imageCache    map[string][]byte
+	imageMetadata map[string]*wasmxdsv1alpha1.ImageMetadata
+	imageDigests  map[string]string
+	// imageInfos describe the images fetched by the streaming providers, and are compared to detect their changes
+	imageInfos    map[string]*stream.Info
+	imageVerifier ImageVerifier
+
+	recorder record.EventRecorder
+	isLeader func() bool
+	// extensionRefs are the references to the extensions from the Kubernetes API keyed by the namespaced names,
+	// which the rejections by Envoy are recorded on
+	refsMu        sync.Mutex
+	extensionRefs map[string]*v1.ObjectReference
+	// sentResponses are the responses not yet acknowledged by Envoy keyed by the streams
+	sentResponses map[int64][]sentResponse
+
+	versionCheckInterval time.Duration
+	fetchTimeout         time.Duration
+	// registryMirrors maps the OCI registry hosts to the mirrors tried first
+	registryMirrors map[string]string
 }
 
+// ImageVerifier checks the fetched binary of the extension before it's served,
+// and rejects it by returning an error
+type ImageVerifier func(ctx context.Context, extension *wasmxdsv1alpha1.WasmExtension, image []byte) error
+
 func NewServer(ctx context.Context, providers ...imageprovider.WasmImageProvider) (*Server, error) {
 	if len(providers) == 0 {
 		return nil, errors.New("at least one image providers must be given")
@@ -56,8 +91,17 @@ func NewServer(ctx context.Context, providers ...imageprovider.WasmImageProvider
 	svr := &Server{
 		imageProviders: make(map[string]imageprovider.WasmImageProvider, len(providers)),
 		imageCache:     map[string][]byte{},
-		cache:          cache.NewLinearCache(apiType),
+		imageMetadata:  map[string]*wasmxdsv1alpha1.ImageMetadata{},
+		imageDigests:   map[string]string{},
+		imageInfos:     map[string]*stream.Info{},
+		extensionRefs:  map[string]*v1.ObjectReference{},
+		sentResponses:  map[int64][]sentResponse{},
+		cache:          newContentCache(apiType),
+		readiness:      newReadiness(),
 		logger:         ctrl.Log.WithName("Server"),
+
+		versionCheckInterval: DefaultVersionCheckInterval,
+		fetchTimeout:         DefaultFetchTimeout,
 	}
 	svr.Server = server.NewServer(ctx, svr.cache, svr)
 	for _, p := range providers {
@@ -68,6 +112,25 @@ func NewServer(ctx context.Context, providers ...imageprovider.WasmImageProvider
 	return svr, nil
 }
 
+// ImageProviders returns the configured image providers including the ones of the registry mirrors
+func (s *Server) ImageProviders() []imageprovider.WasmImageProvider {
+	ret := make([]imageprovider.WasmImageProvider, 0, len(s.imageProviders))
+	for _, p := range s.imageProviders {
+		ret = append(ret, p)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].ProviderKey() < ret[j].ProviderKey() })
+	return ret
+}
+
+func (s *Server) SetImageVerifier(verifier ImageVerifier) {
+	s.imageVerifier = verifier
+}
+
+// SetVersionCheckInterval sets how often the tags of the extensions with version constraints are resolved again
+func (s *Server) SetVersionCheckInterval(interval time.Duration) {
+	s.versionCheckInterval = interval
+}
+
 func (s *Server) StreamExtensionConfigs(stream extensionservice.ExtensionConfigDiscoveryService_StreamExtensionConfigsServer) error {
 	return s.Server.StreamHandler(stream, apiType)
 }
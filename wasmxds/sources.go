@@ -0,0 +1,139 @@
+// Copyright 2020 Tetrate
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+// http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package wasmxds
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/containerd/containerd/reference"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	wasmxdsv1alpha1 "github.com/tetratelabs/wasmxds/api/v1alpha1"
+	"github.com/tetratelabs/wasmxds/imageprovider/fetcherr"
+	"github.com/tetratelabs/wasmxds/imageprovider/ociregistory"
+)
+
+// DefaultFetchTimeout is how long fetching an image from one source can take before falling back to the next by default
+const DefaultFetchTimeout = time.Minute
+
+// SetFetchTimeout sets how long fetching an image from one source can take unless spec.image.fetchTimeout is set.
+// Zero disables the timeout.
+func (s *Server) SetFetchTimeout(timeout time.Duration) {
+	s.fetchTimeout = timeout
+}
+
+// SetRegistryMirrors sets the mirrors of the OCI registries, keyed by the registry host, e.g.
+// "webassemblyhub.io": "mirror.internal:5000" or "ghcr.io": "mirror.internal:5000/ghcr".
+// The mirrors are tried before the registries, which the credentials in the docker config are used for.
+func (s *Server) SetRegistryMirrors(mirrors map[string]string) error {
+	for host, mirror := range mirrors {
+		ref, err := reference.Parse(mirror)
+		if err != nil || ref.Object != "" {
+			return fmt.Errorf("invalid mirror %s of %s: must be either the host or the repository prefix", mirror, host)
+		}
+		p := ociregistory.NewRegistry(registryHost(ref), "", "")
+		if _, ok := s.imageProviders[p.ProviderKey()]; !ok {
+			s.imageProviders[p.ProviderKey()] = p
+			s.logger.Info("image provider configured", "key", p.ProviderKey())
+		}
+	}
+	s.registryMirrors = mirrors
+	return nil
+}
+
+// imageSources returns the images to fetch in order: the one in the spec followed by the fallback sources,
+// each of which is preceded by the registry mirror if any
+func (s *Server) imageSources(spec *wasmxdsv1alpha1.WasmExtensionSpecImage,
+	fallbacks []wasmxdsv1alpha1.WasmExtensionImageSource) []*wasmxdsv1alpha1.WasmExtensionSpecImage {
+	images := []*wasmxdsv1alpha1.WasmExtensionSpecImage{spec}
+	for _, f := range fallbacks {
+		image := *spec
+		image.URI, image.Protocol, image.Sources = f.URI, f.Protocol, nil
+		images = append(images, &image)
+	}
+
+	var ret []*wasmxdsv1alpha1.WasmExtensionSpecImage
+	for _, image := range images {
+		if mirror, ok := s.mirrorOf(image); ok {
+			ret = append(ret, mirror)
+		}
+		ret = append(ret, image)
+	}
+	return ret
+}
+
+// mirrorOf returns the copy of the OCI image with the registry host rewritten to the mirror
+func (s *Server) mirrorOf(image *wasmxdsv1alpha1.WasmExtensionSpecImage) (*wasmxdsv1alpha1.WasmExtensionSpecImage, bool) {
+	if len(s.registryMirrors) == 0 ||
+		(image.Protocol != wasmxdsv1alpha1.ProtocolOCIImageRegistry && image.Protocol != "") {
+		return nil, false
+	}
+	ref, err := reference.Parse(image.URI)
+	if err != nil {
+		return nil, false
+	}
+	host := registryHost(ref)
+	mirror, ok := s.registryMirrors[host]
+	if !ok {
+		return nil, false
+	}
+	ret := *image
+	ret.URI = mirror + strings.TrimPrefix(image.URI, host)
+	return &ret, true
+}
+
+// registryHost returns the host of the reference. Unlike reference.Spec.Hostname, it doesn't panic without the repository.
+func registryHost(ref reference.Spec) string {
+	return strings.SplitN(ref.Locator, "/", 2)[0]
+}
+
+// fetchContext returns the context of fetching from one source which times out after the fetch timeout
+// of the extension, or the one of the server by default
+func (s *Server) fetchContext(ctx context.Context, extension *wasmxdsv1alpha1.WasmExtension) (context.Context, context.CancelFunc) {
+	timeout := s.fetchTimeout
+	if t := extension.Spec.Image.FetchTimeout; t != nil {
+		timeout = t.Duration
+	}
+	if timeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, timeout)
+}
+
+// recoverableRetry is the backoff of the missing images and the rejected credentials, which are retried less often
+// than the transient failures as they rarely recover soon, e.g. until the image is pushed or the credentials are rotated
+var recoverableRetry = &wasmxdsv1alpha1.WasmExtensionImageRetry{
+	InitialInterval: &metav1.Duration{Duration: time.Minute},
+	MaxInterval:     &metav1.Duration{Duration: 30 * time.Minute},
+}
+
+// RetryDelay returns how long to wait before retrying the update of the extension which has failed the given number
+// of times in a row with the error, and false if the failure is permanent or the attempts in spec.image.retry are exhausted
+func RetryDelay(extension *wasmxdsv1alpha1.WasmExtension, failures int, err error) (time.Duration, bool) {
+	if fetcherr.IsPermanent(err) {
+		return 0, false
+	}
+	delay, ok := extension.Spec.Image.Retry.Backoff(failures)
+	if !ok || fetcherr.IsTransient(err) {
+		return delay, ok
+	}
+	if long, _ := recoverableRetry.Backoff(failures); long > delay {
+		delay = long
+	}
+	return delay, true
+}